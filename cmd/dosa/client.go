@@ -33,6 +33,20 @@ type ShellQueryClient interface {
 	Range(ctx context.Context, ops []*queryObj, fields []string, limit int) ([]map[string]dosa.FieldValue, error)
 	// Read fetches a row by primary key
 	Read(ctx context.Context, ops []*queryObj, fields []string, limit int) ([]map[string]dosa.FieldValue, error)
+	// Scan fetches a page of entities starting at the continuation token, and returns the token of the next page
+	Scan(ctx context.Context, fields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error)
+	// Upsert creates or updates a row, the values must contain the primary key
+	Upsert(ctx context.Context, values []*queryObj) error
+	// CreateIfNotExists creates a row, the values must contain the primary key
+	CreateIfNotExists(ctx context.Context, values []*queryObj) error
+	// Remove removes a row by primary key
+	Remove(ctx context.Context, keys []*queryObj) error
+	// MultiUpsert creates or updates rows, given by field name
+	MultiUpsert(ctx context.Context, rows []map[string]dosa.FieldValue) error
+	// RemoveRange removes the entities within a range
+	RemoveRange(ctx context.Context, ops []*queryObj) error
+	// CountRange counts the entities within a range
+	CountRange(ctx context.Context, ops []*queryObj) (int, error)
 	// GetRegistrar returns the registrar
 	GetRegistrar() dosa.Registrar
 	// Shutdown gracefully shuts down the shell query client
@@ -0,0 +1,406 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// DataOptions contains configuration for data command flags
+type DataOptions struct{}
+
+// DataCmd contains the options shared by the data commands
+type DataCmd struct {
+	Scope         scopeFlag `short:"s" long:"scope" description:"Storage scope for the given operation." required:"true"`
+	NamePrefix    string    `short:"n" long:"namePrefix" description:"Name prefix for schema types."`
+	Prefix        string    `short:"p" long:"prefix" description:"Name prefix for schema types." hidden:"true"`
+	Path          string    `long:"path" description:"Path to source." required:"true"`
+	Dir           string    `short:"d" long:"dir" description:"Directory of the data files." required:"true"`
+	provideClient queryClientProvider
+}
+
+func (c *DataCmd) newClient(entityName string) (ShellQueryClient, error) {
+	if options.ServiceName == "" {
+		options.ServiceName = _defServiceName
+	}
+
+	prefix, err := getNamePrefix(c.NamePrefix, c.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.provideClient(options, c.Scope.String(), prefix, c.Path, entityName)
+}
+
+// entityNames returns the given entity names, or every entity found in the path
+func (c *DataCmd) entityNames(names []string) ([]string, error) {
+	if len(names) > 0 {
+		return names, nil
+	}
+	names, err := dosa.FindEntityNames(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.Errorf("no entity found in the path %s", c.Path)
+	}
+	return names, nil
+}
+
+// DataExport holds the options for 'data export'
+type DataExport struct {
+	*DataCmd
+	Format string `short:"f" long:"format" default:"jsonl" choice:"jsonl" choice:"avro" description:"Format of the data files."`
+	Limit  int    `short:"l" long:"limit" default:"1000" description:"Number of rows read by each scan."`
+	Resume bool   `long:"resume" description:"Resume the interrupted exports from their checkpoints."`
+	Args   struct {
+		Entities []string `positional-arg-name:"entities" description:"Entity names, every entity found in the path by default."`
+	} `positional-args:"yes"`
+}
+
+func newDataExport(provideClient queryClientProvider) *DataExport {
+	return &DataExport{
+		DataCmd: &DataCmd{
+			provideClient: provideClient,
+		},
+	}
+}
+
+// Execute executes a data export command
+func (c *DataExport) Execute(args []string) error {
+	names, err := c.entityNames(c.Args.Entities)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range names {
+		if err := c.exportEntity(name); err != nil {
+			return errors.Wrapf(err, "cannot export %s", name)
+		}
+	}
+	return nil
+}
+
+// exportEntity scans the entity to a new part of its export, and saves a
+// checkpoint after each page
+func (c *DataExport) exportEntity(entityName string) error {
+	cp, err := loadCheckpoint(c.Dir, entityName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case cp == nil:
+		cp = &checkpoint{Format: c.Format}
+	case !c.Resume:
+		return errors.Errorf("it was already exported to %s, use --resume to resume its export", c.Dir)
+	case cp.Done:
+		fmt.Printf("%s was already exported, %d rows\n", entityName, cp.Rows)
+		return nil
+	case cp.Format != c.Format:
+		return errors.Errorf("its export was started in %s", cp.Format)
+	default:
+		// drop what was written after the checkpoint
+		if err := os.Truncate(dataFileName(c.Dir, entityName, cp.Part, cp.Format), cp.Size); err != nil {
+			return errors.WithStack(err)
+		}
+		cp.Part++
+	}
+
+	client, err := c.newClient(entityName)
+	if err != nil {
+		return err
+	}
+	defer shutdownQueryClient(client)
+
+	re, err := client.GetRegistrar().Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dataFileName(c.Dir, entityName, cp.Part, cp.Format))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	w, err := newRowWriter(f, cp.Format, re.Table())
+	if err != nil {
+		return err
+	}
+
+	for !cp.Done {
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+		rows, next, err := client.Scan(ctx, nil, cp.Token, c.Limit)
+		cancel()
+		if err != nil && !dosa.ErrorIsNotFound(err) {
+			return errors.Wrap(err, "the export can be resumed with --resume")
+		}
+		if err := w.Write(rows); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return errors.WithStack(err)
+		}
+		size, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		cp.Token, cp.Rows, cp.Size, cp.Done = next, cp.Rows+len(rows), size, next == ""
+		if err := cp.save(c.Dir, entityName); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s: exported %d rows\n", entityName, cp.Rows)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d rows of %s to %s\n", cp.Rows, entityName, c.Dir)
+	return nil
+}
+
+// DataImport holds the options for 'data import'
+type DataImport struct {
+	*DataCmd
+	BatchSize       int  `short:"b" long:"batch-size" default:"100" description:"Number of rows written by each MultiUpsert."`
+	Concurrency     int  `short:"c" long:"concurrency" default:"4" description:"Number of concurrent MultiUpserts."`
+	Rate            int  `short:"r" long:"rate" default:"0" description:"Max number of rows written per second, 0 for no limit."`
+	Yes             bool `short:"y" long:"yes" description:"Do not ask for confirmation on production scopes."`
+	provideMDClient mdClientProvider
+	in              io.Reader
+	Args            struct {
+		Entities []string `positional-arg-name:"entities" description:"Entity names, every entity found in the path by default."`
+	} `positional-args:"yes"`
+}
+
+func newDataImport(provideClient queryClientProvider, provideMDClient mdClientProvider) *DataImport {
+	return &DataImport{
+		DataCmd: &DataCmd{
+			provideClient: provideClient,
+		},
+		provideMDClient: provideMDClient,
+		in:              os.Stdin,
+	}
+}
+
+// Execute executes a data import command
+func (c *DataImport) Execute(args []string) error {
+	if c.BatchSize <= 0 || c.Concurrency <= 0 {
+		return errors.New("the batch size and the concurrency should be positive")
+	}
+	names, err := c.entityNames(c.Args.Entities)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string][]string)
+	var imported []string
+	for _, name := range names {
+		cp, err := loadCheckpoint(c.Dir, name)
+		if err != nil {
+			return err
+		}
+		if cp != nil && !cp.Done {
+			return errors.Errorf("the export of %s is not complete, resume it first", name)
+		}
+		if files[name], err = dataFiles(c.Dir, name); err != nil {
+			return err
+		}
+		if len(files[name]) == 0 {
+			if len(c.Args.Entities) > 0 {
+				return errors.Errorf("no data files of %s found in %s", name, c.Dir)
+			}
+			continue
+		}
+		imported = append(imported, name)
+	}
+	if len(imported) == 0 {
+		return errors.Errorf("no data files found in %s", c.Dir)
+	}
+
+	if !c.Yes {
+		if err := confirmOnProduction(c.provideMDClient, c.in, c.Scope.String(), "import "+strings.Join(imported, ", ")); err != nil {
+			return err
+		}
+	}
+
+	limiter := newRateLimiter(c.Rate)
+	for _, name := range imported {
+		if err := c.importEntity(name, files[name], limiter); err != nil {
+			return errors.Wrapf(err, "cannot import %s", name)
+		}
+	}
+	return nil
+}
+
+// importEntity reads the data files in batches, which are upserted by
+// concurrent workers until the end of the files or the first error
+func (c *DataImport) importEntity(entityName string, files []string, limiter *rateLimiter) error {
+	client, err := c.newClient(entityName)
+	if err != nil {
+		return err
+	}
+	defer shutdownQueryClient(client)
+
+	re, err := client.GetRegistrar().Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	var (
+		once     sync.Once
+		firstErr error
+		failed   = make(chan struct{})
+		rows     int64
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(failed)
+		})
+	}
+
+	batches := make(chan []map[string]dosa.FieldValue)
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				limiter.wait(len(batch))
+				ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+				err := client.MultiUpsert(ctx, batch)
+				cancel()
+				if err != nil {
+					fail(err)
+					return
+				}
+				fmt.Fprintf(os.Stderr, "%s: imported %d rows\n", entityName, atomic.AddInt64(&rows, int64(len(batch))))
+			}
+		}()
+	}
+
+	send := func(batch []map[string]dosa.FieldValue) bool {
+		select {
+		case batches <- batch:
+			return true
+		case <-failed:
+			return false
+		}
+	}
+	if err := readBatches(files, re.Table(), c.BatchSize, send); err != nil {
+		fail(err)
+	}
+	close(batches)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	fmt.Printf("imported %d rows of %s from %d files\n", rows, entityName, len(files))
+	return nil
+}
+
+// readBatches reads the rows of the files in batches of the given size, and
+// stops when send returns false
+func readBatches(files []string, t *dosa.Table, size int, send func([]map[string]dosa.FieldValue) bool) error {
+	batch := make([]map[string]dosa.FieldValue, 0, size)
+	for _, name := range files {
+		r, closer, err := openRowReader(name, t)
+		if err != nil {
+			return err
+		}
+		for {
+			row, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				closer.Close()
+				return errors.Wrapf(err, "cannot read %s", name)
+			}
+			batch = append(batch, row)
+			if len(batch) == size {
+				if !send(batch) {
+					closer.Close()
+					return nil
+				}
+				batch = make([]map[string]dosa.FieldValue, 0, size)
+			}
+		}
+		closer.Close()
+	}
+	if len(batch) > 0 {
+		send(batch)
+	}
+	return nil
+}
+
+// rateLimiter paces the writes to a number of rows per second
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// newRateLimiter returns a rate limiter, which does not limit anything when
+// rowsPerSecond is not positive
+func newRateLimiter(rowsPerSecond int) *rateLimiter {
+	l := &rateLimiter{now: time.Now, sleep: time.Sleep}
+	if rowsPerSecond > 0 {
+		l.interval = time.Second / time.Duration(rowsPerSecond)
+	}
+	return l
+}
+
+// wait blocks until n more rows can be written
+func (l *rateLimiter) wait(n int) {
+	if l.interval == 0 {
+		return
+	}
+	l.mu.Lock()
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * l.interval)
+	l.mu.Unlock()
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/testentity"
+)
+
+// dataTestEntities returns n entities using every type, with nil and non nil pointers
+func dataTestEntities(n int) []*testentity.TestEntity {
+	entities := make([]*testentity.TestEntity, n)
+	for i := range entities {
+		e := &testentity.TestEntity{
+			UUIDKey:  dosa.UUID(fmt.Sprintf("3e4befa0-69d3-11e8-95b0-%012d", i)),
+			StrKey:   fmt.Sprintf("key \"%d\"\n", i),
+			Int64Key: int64(i) << 40,
+			UUIDV:    dosa.UUID("a1b2c3d4-0000-0000-0000-000000000000"),
+			StrV:     "value,with,commas",
+			Int64V:   -9007199254740993,
+			Int32V:   int32(i),
+			DoubleV:  float64(i) / 3,
+			BoolV:    i%2 == 0,
+			TSV:      time.Date(2020, 6, 1, 12, 30, 0, i*1000, time.UTC),
+		}
+		if i%2 == 0 {
+			s, i32, ts := "pointed", int32(-i), time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)
+			e.BlobV = []byte{0, 1, 2, byte(i)}
+			e.StrVP, e.Int32VP, e.TSVP = &s, &i32, &ts
+		}
+		entities[i] = e
+	}
+	return entities
+}
+
+// dataTestClient returns a client of TestEntity in the "data" scope of the connector
+func dataTestClient(t *testing.T, conn dosa.Connector) dosa.Client {
+	reg, err := dosa.NewRegistrar("data", "prefix", &testentity.TestEntity{})
+	require.NoError(t, err)
+	client := dosa.NewClient(reg, conn)
+	require.NoError(t, client.Initialize(context.Background()))
+	return client
+}
+
+// keptConnector ignores the shutdown of the clients, which would delete the
+// data of the memory connector between commands
+type keptConnector struct {
+	dosa.Connector
+}
+
+func (keptConnector) Shutdown() error {
+	return nil
+}
+
+// dataTestProvider returns a provider of shell query clients of the connector
+func dataTestProvider(t *testing.T, conn dosa.Connector) queryClientProvider {
+	return func(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+		assert.Equal(t, "data", scope)
+		table, err := dosa.FindEntityByName(path, structName)
+		if err != nil {
+			return nil, err
+		}
+		reg, err := newSimpleRegistrar(scope, prefix, table)
+		if err != nil {
+			return nil, err
+		}
+		return newShellQueryClient(reg, keptConnector{conn}), nil
+	}
+}
+
+func dataTestCmd(provideClient queryClientProvider, dir string) *DataCmd {
+	return &DataCmd{
+		Scope:         scopeFlag("data"),
+		NamePrefix:    "prefix",
+		Path:          "../../testentity",
+		Dir:           dir,
+		provideClient: provideClient,
+	}
+}
+
+func scanAll(t *testing.T, client dosa.Client) []dosa.DomainObject {
+	var all []dosa.DomainObject
+	op := dosa.NewScanOp(&testentity.TestEntity{}).Limit(100)
+	for {
+		objects, token, err := client.ScanEverything(context.Background(), op)
+		if dosa.ErrorIsNotFound(err) {
+			return all
+		}
+		require.NoError(t, err)
+		all = append(all, objects...)
+		if token == "" {
+			return all
+		}
+		op.Offset(token)
+	}
+}
+
+func TestData_ExportImport(t *testing.T) {
+	for _, format := range []string{formatJSONL, formatAvro} {
+		dir, err := ioutil.TempDir("", "dosa-data")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		src := memory.NewConnector()
+		srcClient := dataTestClient(t, src)
+		for _, e := range dataTestEntities(25) {
+			require.NoError(t, srcClient.Upsert(context.Background(), dosa.All(), e))
+		}
+
+		export := newDataExport(dataTestProvider(t, src))
+		export.DataCmd = dataTestCmd(export.provideClient, dir)
+		export.Format = format
+		export.Limit = 10
+		export.Args.Entities = []string{"TestEntity"}
+		c := StartCapture()
+		err = export.Execute(nil)
+		stdout, stderr := c.stop(false), ""
+		require.NoError(t, err, format)
+		assert.Equal(t, fmt.Sprintf("exported 25 rows of TestEntity to %s\n", dir), stdout)
+
+		cp, err := loadCheckpoint(dir, "TestEntity")
+		require.NoError(t, err)
+		assert.Equal(t, &checkpoint{Format: format, Rows: 25, Part: 0, Size: cp.Size, Done: true}, cp)
+
+		dst := memory.NewConnector()
+		imp := newDataImport(dataTestProvider(t, dst), nil)
+		imp.DataCmd = dataTestCmd(imp.provideClient, dir)
+		imp.Yes = true
+		imp.BatchSize = 4
+		imp.Concurrency = 3
+		c = StartCapture()
+		err = imp.Execute(nil)
+		stdout, stderr = c.stop(false), c.errbuf.String()
+		require.NoError(t, err, format)
+		assert.Equal(t, "imported 25 rows of TestEntity from 1 files\n", stdout)
+		assert.Contains(t, stderr, "TestEntity: imported 25 rows")
+
+		assert.Equal(t, scanAll(t, srcClient), scanAll(t, dataTestClient(t, dst)), format)
+	}
+}
+
+// failingScanConnector fails the scans after the first ones
+type failingScanConnector struct {
+	dosa.Connector
+	scans int
+}
+
+func (c *failingScanConnector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	c.scans--
+	if c.scans < 0 {
+		return nil, "", errors.New("scan failed")
+	}
+	return c.Connector.Scan(ctx, ei, minimumFields, token, limit)
+}
+
+func TestData_ExportResume(t *testing.T) {
+	for _, format := range []string{formatJSONL, formatAvro} {
+		dir, err := ioutil.TempDir("", "dosa-data")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		src := memory.NewConnector()
+		srcClient := dataTestClient(t, src)
+		for _, e := range dataTestEntities(25) {
+			require.NoError(t, srcClient.Upsert(context.Background(), dosa.All(), e))
+		}
+
+		// the export fails after 2 pages
+		failing := &failingScanConnector{Connector: src, scans: 2}
+		export := newDataExport(dataTestProvider(t, failing))
+		export.DataCmd = dataTestCmd(export.provideClient, dir)
+		export.Format = format
+		export.Limit = 10
+		export.Args.Entities = []string{"TestEntity"}
+		c := StartCapture()
+		err = export.Execute(nil)
+		c.stop(false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot export TestEntity: the export can be resumed with --resume: scan failed")
+		cp, err := loadCheckpoint(dir, "TestEntity")
+		require.NoError(t, err)
+		assert.Equal(t, 20, cp.Rows)
+		assert.False(t, cp.Done)
+
+		// what is written after the checkpoint is dropped
+		f, err := os.OpenFile(dataFileName(dir, "TestEntity", 0, format), os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"partial`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		// incomplete exports are not imported
+		dst := memory.NewConnector()
+		imp := newDataImport(dataTestProvider(t, dst), nil)
+		imp.DataCmd = dataTestCmd(imp.provideClient, dir)
+		imp.Yes = true
+		imp.BatchSize = 7
+		imp.Concurrency = 2
+		assert.EqualError(t, imp.Execute(nil), "the export of TestEntity is not complete, resume it first")
+
+		// it needs --resume to continue
+		c = StartCapture()
+		err = export.Execute(nil)
+		c.stop(false)
+		assert.EqualError(t, err, fmt.Sprintf("cannot export TestEntity: it was already exported to %s, use --resume to resume its export", dir))
+
+		failing.scans = 100
+		export.Resume = true
+		c = StartCapture()
+		err = export.Execute(nil)
+		stdout := c.stop(false)
+		require.NoError(t, err, format)
+		assert.Equal(t, fmt.Sprintf("exported 25 rows of TestEntity to %s\n", dir), stdout)
+		files, err := dataFiles(dir, "TestEntity")
+		require.NoError(t, err)
+		assert.Equal(t, []string{dataFileName(dir, "TestEntity", 0, format), dataFileName(dir, "TestEntity", 1, format)}, files)
+
+		// resuming a complete export does nothing
+		c = StartCapture()
+		err = export.Execute(nil)
+		stdout = c.stop(false)
+		require.NoError(t, err)
+		assert.Equal(t, "TestEntity was already exported, 25 rows\n", stdout)
+
+		c = StartCapture()
+		err = imp.Execute(nil)
+		stdout = c.stop(false)
+		require.NoError(t, err, format)
+		assert.Equal(t, "imported 25 rows of TestEntity from 2 files\n", stdout)
+		assert.Equal(t, scanAll(t, srcClient), scanAll(t, dataTestClient(t, dst)), format)
+	}
+}
+
+func TestData_ExportAllEntities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dosa-data")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	export := newDataExport(dataTestProvider(t, memory.NewConnector()))
+	export.DataCmd = dataTestCmd(export.provideClient, filepath.Join(dir, "backup"))
+	export.Format = formatJSONL
+	export.Limit = 10
+	c := StartCapture()
+	err = export.Execute(nil)
+	stdout := c.stop(false)
+	require.NoError(t, err)
+
+	// every entity of the path is exported, even when empty
+	names, err := dosa.FindEntityNames("../../testentity")
+	require.NoError(t, err)
+	assert.Equal(t, len(names), strings.Count(stdout, "exported 0 rows of"))
+	for _, name := range names {
+		files, err := dataFiles(filepath.Join(dir, "backup"), name)
+		require.NoError(t, err)
+		assert.Len(t, files, 1, name)
+	}
+}
+
+func TestData_ImportErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dosa-data")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := memory.NewConnector()
+	imp := newDataImport(dataTestProvider(t, src), nil)
+	imp.DataCmd = dataTestCmd(imp.provideClient, dir)
+	imp.Yes = true
+	imp.BatchSize = 10
+	imp.Concurrency = 2
+
+	assert.EqualError(t, imp.Execute(nil), fmt.Sprintf("no data files found in %s", dir))
+	imp.Args.Entities = []string{"TestEntity"}
+	assert.EqualError(t, imp.Execute(nil), fmt.Sprintf("no data files of TestEntity found in %s", dir))
+
+	// a missing primary key is reported with its line
+	require.NoError(t, dataTestClient(t, src).Upsert(context.Background(), dosa.All(), dataTestEntities(1)[0]))
+	export := newDataExport(dataTestProvider(t, src))
+	export.DataCmd = dataTestCmd(export.provideClient, dir)
+	export.Format = formatJSONL
+	export.Limit = 10
+	c := StartCapture()
+	require.NoError(t, export.Execute(nil))
+	c.stop(false)
+	f, err := os.OpenFile(dataFileName(dir, "TestEntity", 0, formatJSONL), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("\n" + `{"StrKey":"b"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	err = imp.Execute(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot import TestEntity: cannot read")
+	assert.Contains(t, err.Error(), "line 3: field UUIDKey: cannot be null")
+
+	imp.BatchSize = 0
+	assert.EqualError(t, imp.Execute(nil), "the batch size and the concurrency should be positive")
+}
+
+func TestData_ImportConfirmation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dosa-data")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(dataFileName(dir, "TestEntity", 0, formatJSONL), nil, 0644))
+
+	// the metadata cannot be read, so the scope is assumed to be a production scope
+	imp := newDataImport(dataTestProvider(t, memory.NewConnector()), func(opts GlobalOptions) (dosa.Client, error) {
+		return nil, errors.New("no metadata client")
+	})
+	imp.DataCmd = dataTestCmd(imp.provideClient, dir)
+	imp.BatchSize = 10
+	imp.Concurrency = 1
+	imp.in = strings.NewReader("no\n")
+
+	c := StartCapture()
+	err = imp.Execute(nil)
+	stdout := c.stop(false)
+	assert.EqualError(t, err, "aborted")
+	assert.Contains(t, stdout, `import TestEntity in production scope "data", continue? [y/N]`)
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	var sleeps []time.Duration
+	l := newRateLimiter(100)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+	// 100 rows per second is 10ms per row
+	l.wait(10)
+	l.wait(10)
+	l.wait(5)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, sleeps)
+
+	// the unused time is not saved up
+	now = now.Add(time.Second)
+	l.wait(10)
+	assert.Len(t, sleeps, 2)
+
+	unlimited := newRateLimiter(0)
+	unlimited.sleep = func(d time.Duration) { t.Fatal("unexpected sleep") }
+	unlimited.wait(1000)
+}
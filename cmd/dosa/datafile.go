@@ -0,0 +1,493 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	gv "github.com/elodina/go-avro"
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// Formats of the data files
+const (
+	formatJSONL = "jsonl"
+	formatAvro  = "avro"
+)
+
+// rowWriter writes the rows of an entity, by field name, to a data file
+type rowWriter interface {
+	// Write buffers the rows until the next Flush
+	Write(rows []map[string]dosa.FieldValue) error
+	// Flush writes the buffered rows to the file
+	Flush() error
+	// Close flushes the buffered rows and ends the file
+	Close() error
+}
+
+// rowReader reads the rows of an entity, by field name, from a data file
+type rowReader interface {
+	// Next returns the next row, or io.EOF after the last one
+	Next() (map[string]dosa.FieldValue, error)
+}
+
+// dataFileName returns the name of a part of the export of an entity
+func dataFileName(dir, entityName string, part int, format string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%04d.%s", entityName, part, format))
+}
+
+// dataFiles returns the parts of the export of an entity in the directory, in order
+func dataFiles(dir, entityName string) ([]string, error) {
+	var files []string
+	for _, format := range []string{formatJSONL, formatAvro} {
+		matches, err := filepath.Glob(filepath.Join(dir, entityName+"-[0-9][0-9][0-9][0-9]."+format))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// tableFields returns the field names of the table in the order of its columns
+func tableFields(t *dosa.Table) []string {
+	fields := make([]string, len(t.Columns))
+	for idx, cd := range t.Columns {
+		fields[idx] = t.ColToField[cd.Name]
+	}
+	return fields
+}
+
+// checkpoint records the progress of the export of an entity, so an
+// interrupted export can resume from the last page written to its files
+type checkpoint struct {
+	Format string
+	// Token is the continuation token of the next page
+	Token string
+	// Rows is the number of rows written
+	Rows int
+	// Part is the number of the last part, and Size its size after the last
+	// page; anything after it was written after the checkpoint
+	Part int
+	Size int64
+	// Done is set when every page is written
+	Done bool
+}
+
+func checkpointFileName(dir, entityName string) string {
+	return filepath.Join(dir, entityName+".checkpoint")
+}
+
+// loadCheckpoint returns the checkpoint of an entity, or nil when there is none
+func loadCheckpoint(dir, entityName string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(checkpointFileName(dir, entityName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint for %s", entityName)
+	}
+	return cp, nil
+}
+
+// save replaces the checkpoint of the entity, atomically
+func (cp *checkpoint) save(dir, entityName string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	name := checkpointFileName(dir, entityName)
+	if err := ioutil.WriteFile(name+".tmp", data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(name+".tmp", name))
+}
+
+// newRowWriter returns a writer of the rows of the table in the given format
+func newRowWriter(w io.Writer, format string, t *dosa.Table) (rowWriter, error) {
+	switch format {
+	case formatJSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w), fields: tableFields(t)}, nil
+	case formatAvro:
+		return newAvroWriter(w, t)
+	}
+	return nil, errors.Errorf("unknown data format %q, supported: jsonl, avro", format)
+}
+
+// openRowReader opens a data file of the table, in the format of its extension
+func openRowReader(name string, t *dosa.Table) (rowReader, io.Closer, error) {
+	switch strings.TrimPrefix(filepath.Ext(name), ".") {
+	case formatJSONL:
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		return &jsonlReader{r: bufio.NewReader(f), t: t}, f, nil
+	case formatAvro:
+		r, err := newAvroReader(name, t)
+		return r, ioutil.NopCloser(nil), err
+	}
+	return nil, nil, errors.Errorf("unknown data format of %s, supported: jsonl, avro", name)
+}
+
+// jsonlWriter writes one JSON object per row, like the jsonl output format
+type jsonlWriter struct {
+	w      *bufio.Writer
+	fields []string
+}
+
+func (w *jsonlWriter) Write(rows []map[string]dosa.FieldValue) error {
+	return writeJSON(w.w, w.fields, rows, true)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return errors.WithStack(w.w.Flush())
+}
+
+func (w *jsonlWriter) Close() error {
+	return w.Flush()
+}
+
+type jsonlReader struct {
+	r    *bufio.Reader
+	t    *dosa.Table
+	line int
+}
+
+func (r *jsonlReader) Next() (map[string]dosa.FieldValue, error) {
+	for {
+		data, err := r.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		if len(data) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		r.line++
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		var values map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&values); err != nil {
+			return nil, errors.Wrapf(err, "line %d", r.line)
+		}
+		row, err := decodeRow(r.t, values, jsonToFieldValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", r.line)
+		}
+		return row, nil
+	}
+}
+
+// decodeRow converts the values of a row, by field name, to the types of
+// their columns; the fields missing from the row are null
+func decodeRow(t *dosa.Table, values map[string]interface{}, decode func(*dosa.ColumnDefinition, interface{}) (dosa.FieldValue, error)) (map[string]dosa.FieldValue, error) {
+	for field := range values {
+		if _, ok := t.FieldToCol[field]; !ok {
+			return nil, errors.Errorf("%s is not a valid field for %s", field, t.StructName)
+		}
+	}
+	row := make(map[string]dosa.FieldValue, len(t.Columns))
+	for _, cd := range t.Columns {
+		field := t.ColToField[cd.Name]
+		var fv dosa.FieldValue
+		var err error
+		if value := values[field]; value == nil {
+			fv, err = nullValue(cd)
+		} else if fv, err = decode(cd, value); err == nil && cd.IsPointer {
+			fv = pointerTo(fv)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", field)
+		}
+		row[field] = fv
+	}
+	return row, nil
+}
+
+// nullValue returns the value of a null column, which must be a pointer or a blob
+func nullValue(cd *dosa.ColumnDefinition) (dosa.FieldValue, error) {
+	if cd.Type == dosa.Blob {
+		return []byte(nil), nil
+	}
+	if !cd.IsPointer {
+		return nil, errors.New("cannot be null")
+	}
+	switch cd.Type {
+	case dosa.Int32:
+		return (*int32)(nil), nil
+	case dosa.Int64:
+		return (*int64)(nil), nil
+	case dosa.Double:
+		return (*float64)(nil), nil
+	case dosa.Bool:
+		return (*bool)(nil), nil
+	case dosa.String:
+		return (*string)(nil), nil
+	case dosa.TUUID:
+		return (*dosa.UUID)(nil), nil
+	case dosa.Timestamp:
+		return (*time.Time)(nil), nil
+	}
+	return nil, errors.Errorf("unsupported type %s", cd.Type)
+}
+
+// pointerTo returns a pointer to a copy of v, as held by nullable fields
+func pointerTo(v dosa.FieldValue) dosa.FieldValue {
+	switch v := v.(type) {
+	case string:
+		return &v
+	case dosa.UUID:
+		return &v
+	case int32:
+		return &v
+	case int64:
+		return &v
+	case float64:
+		return &v
+	case bool:
+		return &v
+	case time.Time:
+		return &v
+	}
+	return v
+}
+
+// jsonToFieldValue converts a JSON value, as written by jsonValue, to the type of the column
+func jsonToFieldValue(cd *dosa.ColumnDefinition, value interface{}) (dosa.FieldValue, error) {
+	switch v := value.(type) {
+	case json.Number:
+		switch cd.Type {
+		case dosa.Int32, dosa.Int64, dosa.Double:
+			return strToFieldValue(cd.Type, v.String())
+		}
+	case bool:
+		if cd.Type == dosa.Bool {
+			return v, nil
+		}
+	case string:
+		switch cd.Type {
+		case dosa.String, dosa.TUUID, dosa.Timestamp:
+			return strToFieldValue(cd.Type, v)
+		case dosa.Blob:
+			b, err := base64.StdEncoding.DecodeString(v)
+			return b, errors.WithStack(err)
+		}
+	}
+	return nil, errors.Errorf("%v is not a valid %s", value, cd.Type)
+}
+
+// avroSchema returns the schema of the data files of the table: a record of
+// its columns, where pointers and blobs are nullable and timestamps are
+// microseconds since the Unix epoch
+func avroSchema(t *dosa.Table) (gv.Schema, error) {
+	types := map[dosa.Type]string{
+		dosa.Int32:     "int",
+		dosa.Int64:     "long",
+		dosa.Double:    "double",
+		dosa.Bool:      "boolean",
+		dosa.String:    "string",
+		dosa.TUUID:     "string",
+		dosa.Timestamp: "long",
+		dosa.Blob:      "bytes",
+	}
+	fields := make([]map[string]interface{}, len(t.Columns))
+	for idx, cd := range t.Columns {
+		typ, ok := types[cd.Type]
+		if !ok {
+			return nil, errors.Errorf("unsupported type %s of column %s", cd.Type, cd.Name)
+		}
+		field := map[string]interface{}{"name": cd.Name, "type": typ}
+		if cd.IsPointer || cd.Type == dosa.Blob {
+			field["type"] = []string{"null", typ}
+		}
+		fields[idx] = field
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"type":   "record",
+		"name":   t.Name,
+		"fields": fields,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	schema, err := gv.ParseSchema(string(data))
+	return schema, errors.Wrapf(err, "invalid avro schema for %s", t.StructName)
+}
+
+type avroWriter struct {
+	w      *gv.DataFileWriter
+	schema gv.Schema
+	t      *dosa.Table
+}
+
+func newAvroWriter(w io.Writer, t *dosa.Table) (*avroWriter, error) {
+	schema, err := avroSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	dfw, err := gv.NewDataFileWriter(w, schema, gv.NewGenericDatumWriter())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &avroWriter{w: dfw, schema: schema, t: t}, nil
+}
+
+func (w *avroWriter) Write(rows []map[string]dosa.FieldValue) error {
+	for _, row := range rows {
+		record := gv.NewGenericRecord(w.schema)
+		for _, cd := range w.t.Columns {
+			field := w.t.ColToField[cd.Name]
+			value, err := avroValue(row[field])
+			if err != nil {
+				return errors.Wrapf(err, "field %s", field)
+			}
+			record.Set(cd.Name, value)
+		}
+		if err := w.w.Write(record); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func (w *avroWriter) Flush() error {
+	return errors.WithStack(w.w.Flush())
+}
+
+// Close only flushes the rows: the empty block ending the file written by
+// DataFileWriter.Close cannot be read back by the DataFileReader
+func (w *avroWriter) Close() error {
+	return w.Flush()
+}
+
+// avroValue converts a value to its avro type
+func avroValue(fv dosa.FieldValue) (interface{}, error) {
+	v := reflect.ValueOf(fv)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil, nil
+	}
+	switch value := reflect.Indirect(v).Interface().(type) {
+	case time.Time:
+		return value.Unix()*1000000 + int64(value.Nanosecond()/1000), nil
+	case dosa.UUID:
+		return string(value), nil
+	case []byte:
+		if value == nil {
+			return nil, nil
+		}
+		return value, nil
+	case int32, int64, float64, bool, string:
+		return value, nil
+	}
+	return nil, errors.Errorf("unsupported value %v", fv)
+}
+
+type avroReader struct {
+	r *gv.DataFileReader
+	t *dosa.Table
+}
+
+func newAvroReader(name string, t *dosa.Table) (*avroReader, error) {
+	r, err := gv.NewDataFileReader(name, gv.NewGenericDatumReader())
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", name)
+	}
+	return &avroReader{r: r, t: t}, nil
+}
+
+func (r *avroReader) Next() (map[string]dosa.FieldValue, error) {
+	record := gv.NewGenericRecord(nil)
+	ok, err := r.r.Next(record)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !ok {
+		return nil, io.EOF
+	}
+	values := make(map[string]interface{}, len(r.t.Columns))
+	for col, value := range record.Map() {
+		field, ok := r.t.ColToField[col]
+		if !ok {
+			return nil, errors.Errorf("%s is not a valid column for %s", col, r.t.StructName)
+		}
+		values[field] = value
+	}
+	return decodeRow(r.t, values, avroToFieldValue)
+}
+
+// avroToFieldValue converts an avro value, as written by avroValue, to the type of the column
+func avroToFieldValue(cd *dosa.ColumnDefinition, value interface{}) (dosa.FieldValue, error) {
+	switch v := value.(type) {
+	case int64:
+		switch cd.Type {
+		case dosa.Int64:
+			return v, nil
+		case dosa.Timestamp:
+			return time.Unix(v/1000000, v%1000000*1000).UTC(), nil
+		}
+	case string:
+		switch cd.Type {
+		case dosa.String:
+			return v, nil
+		case dosa.TUUID:
+			return dosa.UUID(v), nil
+		}
+	case int32:
+		if cd.Type == dosa.Int32 {
+			return v, nil
+		}
+	case float64:
+		if cd.Type == dosa.Double {
+			return v, nil
+		}
+	case bool:
+		if cd.Type == dosa.Bool {
+			return v, nil
+		}
+	case []byte:
+		if cd.Type == dosa.Blob {
+			return v, nil
+		}
+	}
+	return nil, errors.Errorf("%v is not a valid %s", value, cd.Type)
+}
@@ -40,6 +40,12 @@ Provide a custom timeout of 20 seconds for RPCs to gateway:
 
 	$ dosa --timeout 20s <cmd>
 
+Print query results and scope metadata as JSON, one JSON object per line, or CSV (default is table):
+
+	$ dosa --output json <cmd>
+	$ dosa --output jsonl <cmd>
+	$ dosa --output csv <cmd>
+
 
 Managing Scopes:
 
@@ -75,6 +81,86 @@ Upsert schema with prefix "oss.user" to the "infra_dev" scope:
 	$ dosa schema upsert -s infra_dev -np oss.user
 
 
+Inspecting Routing Configs:
+
+Show the routing rules in precedence order and the ones serving reads and writes of the
+"orders" entity with prefix "oss.user" in the "production" scope:
+
+	$ dosa route explain -c routing.yaml -s production -n oss.user -e orders
+
+Report the unreachable and shadowed rules of a routing config:
+
+	$ dosa route lint -c routing.yaml
+
+
+Querying Entities:
+
+Scan the first 20 rows of the "TestEntity" entity with prefix "oss.user" in the "infra_dev" scope:
+
+	$ dosa query scan -s infra_dev -n oss.user --path ./entities --limit 20 TestEntity
+
+Resume the scan from the continuation token printed by the previous scan:
+
+	$ dosa query scan -s infra_dev -n oss.user --path ./entities --limit 20 --token <token> TestEntity
+
+Scan every row, following the continuation tokens to the end:
+
+	$ dosa query scan -s infra_dev -n oss.user --path ./entities --all TestEntity
+
+Upsert a row, setting its primary key fields and any other fields to update. Writes to production
+scopes are confirmed interactively unless --yes is given:
+
+	$ dosa query upsert -s production -n oss.user --path ./entities TestEntity ID:42 Name:fixed
+
+Create a row if it does not exist, or remove a row by primary key:
+
+	$ dosa query create -s infra_dev -n oss.user --path ./entities TestEntity ID:42 Name:new
+	$ dosa query remove -s infra_dev -n oss.user --path ./entities TestEntity ID:42
+
+Count the rows a remove range would remove, then remove them:
+
+	$ dosa query remove-range -s infra_dev -n oss.user --path ./entities --dry-run TestEntity ID:eq:42 TS:lt:1590000000000
+	$ dosa query remove-range -s infra_dev -n oss.user --path ./entities TestEntity ID:eq:42 TS:lt:1590000000000
+
+
+Exporting and Importing Data:
+
+Export every entity found in the path from the "production" scope to JSONL files in ./backup, one
+set of files and a checkpoint per entity:
+
+	$ dosa data export -s production -n oss.user --path ./entities -d ./backup
+
+Export a single entity to Avro files, resuming an interrupted export from its checkpoint:
+
+	$ dosa data export -s production -n oss.user --path ./entities -d ./backup --format avro --resume TestEntity
+
+Import the exported files into the "infra_staging" scope, with 8 concurrent batches of 100 rows and at
+most 1000 rows per second:
+
+	$ dosa data import -s infra_staging -n oss.user --path ./entities -d ./backup -c 8 -b 100 -r 1000
+
+
+Interactive Shell:
+
+Start a shell connected once to the gateway, with the entities of ./entities loaded once for every
+command. The up and down arrows browse the history, kept in ~/.dosa_history, and tab completes the
+commands, entity names and field names:
+
+	$ dosa shell -s infra_dev -n oss.user --path ./entities
+	dosa infra_dev> read TestEntity ID:eq:42
+	dosa infra_dev> set output json
+	dosa infra_dev> set limit 20
+	dosa infra_dev> range TestEntity ID:eq:42 TS:lt:1590000000000
+	dosa infra_dev> scan --all TestEntity
+	dosa infra_dev> upsert TestEntity ID:42 Name:fixed
+	dosa infra_dev> set scope infra_staging
+	dosa infra_staging> exit
+
+Commands can also be piped to the shell, one per line:
+
+	$ echo "scan TestEntity" | dosa shell -s infra_dev -n oss.user --path ./entities
+
+
 Code Generation:
 
 TODO
@@ -0,0 +1,443 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// errInterrupted is returned when the line is abandoned with Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines typed in the shell
+type lineReader interface {
+	// ReadLine reads a line, showing the prompt on terminals
+	ReadLine(prompt string) (string, error)
+}
+
+// newLineReader returns a line editor when the input is a terminal, and a
+// plain reader otherwise, e.g. when commands are piped to the shell
+func newLineReader(in io.Reader, out io.Writer, h *history, complete completer) lineReader {
+	if f, ok := in.(*os.File); ok {
+		if makeRaw := sttyRaw(f); makeRaw != nil {
+			return newTermLineReader(in, out, h, complete, makeRaw)
+		}
+	}
+	return &plainLineReader{in: bufio.NewReader(in)}
+}
+
+// plainLineReader reads lines from a non interactive input, without prompts
+type plainLineReader struct {
+	in *bufio.Reader
+}
+
+func (r *plainLineReader) ReadLine(prompt string) (string, error) {
+	line, err := r.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// _historySize is the number of lines kept in the history
+const _historySize = 1000
+
+// history holds the lines entered in the shell, and appends them to a file
+// when there is one
+type history struct {
+	lines []string
+	file  string
+}
+
+// loadHistory loads the last lines of the history file, which may not exist yet
+func loadHistory(file string) (*history, error) {
+	h := &history{file: file}
+	if file == "" {
+		return h, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return h, errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > _historySize {
+		h.lines = h.lines[len(h.lines)-_historySize:]
+	}
+	return h, nil
+}
+
+// add adds the line to the history, unless it is blank or repeats the last one
+func (h *history) add(line string) error {
+	if strings.TrimSpace(line) == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return nil
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > _historySize {
+		h.lines = h.lines[len(h.lines)-_historySize:]
+	}
+	if h.file == "" {
+		return nil
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		_ = f.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(f.Close())
+}
+
+// completer returns the word ending the line and the words it may be
+// completed to
+type completer func(line string) (word string, candidates []string)
+
+// keys handled by the line editor
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// termLineReader edits lines on a terminal in raw mode, browsing the history
+// with the up and down arrows and completing words with tab
+type termLineReader struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  *history
+	complete completer
+	// makeRaw puts the terminal in raw mode and returns the function
+	// restoring it
+	makeRaw func() (func() error, error)
+}
+
+func newTermLineReader(in io.Reader, out io.Writer, h *history, complete completer, makeRaw func() (func() error, error)) *termLineReader {
+	return &termLineReader{
+		in:       bufio.NewReader(in),
+		out:      out,
+		history:  h,
+		complete: complete,
+		makeRaw:  makeRaw,
+	}
+}
+
+// lineEdit is the state of the line being edited
+type lineEdit struct {
+	prompt []rune
+	buf    []rune
+	pos    int
+	// cursor is the column of the cursor on the screen, from the start of the prompt
+	cursor int
+	// hist is the index of the history line shown, and saved the line
+	// typed before browsing the history
+	hist  int
+	saved []rune
+}
+
+func (r *termLineReader) ReadLine(prompt string) (string, error) {
+	restore, err := r.makeRaw()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = restore()
+	}()
+
+	e := &lineEdit{prompt: []rune(prompt), hist: len(r.history.lines)}
+	r.refresh(e)
+	for {
+		c, _, err := r.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '\r', '\n':
+			fmt.Fprint(r.out, "\r\n")
+			return string(e.buf), nil
+		case keyCtrlC:
+			fmt.Fprint(r.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				fmt.Fprint(r.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete()
+		case keyBackspace, keyCtrlH:
+			if e.pos > 0 {
+				e.pos--
+				e.delete()
+			}
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.buf)
+		case keyCtrlB:
+			e.left()
+		case keyCtrlF:
+			e.right()
+		case keyCtrlK:
+			e.buf = e.buf[:e.pos]
+		case keyCtrlU:
+			e.buf = append([]rune{}, e.buf[e.pos:]...)
+			e.pos = 0
+		case keyCtrlW:
+			e.deleteWord()
+		case keyCtrlP:
+			r.previous(e)
+		case keyCtrlN:
+			r.next(e)
+		case keyTab:
+			r.completeWord(e)
+		case keyEscape:
+			if err := r.escape(e); err != nil {
+				return "", err
+			}
+		default:
+			if unicode.IsPrint(c) {
+				e.insert(c)
+			}
+		}
+		r.refresh(e)
+	}
+}
+
+// escape handles the escape sequences of the arrow, home, end and delete keys
+func (r *termLineReader) escape(e *lineEdit) error {
+	c, _, err := r.in.ReadRune()
+	if err != nil {
+		return err
+	}
+	if c != '[' && c != 'O' {
+		return nil
+	}
+	var param []rune
+	for {
+		if c, _, err = r.in.ReadRune(); err != nil {
+			return err
+		}
+		if c < '0' || c > '9' {
+			break
+		}
+		param = append(param, c)
+	}
+	switch {
+	case c == 'A':
+		r.previous(e)
+	case c == 'B':
+		r.next(e)
+	case c == 'C':
+		e.right()
+	case c == 'D':
+		e.left()
+	case c == 'H' || c == '~' && (string(param) == "1" || string(param) == "7"):
+		e.pos = 0
+	case c == 'F' || c == '~' && (string(param) == "4" || string(param) == "8"):
+		e.pos = len(e.buf)
+	case c == '~' && string(param) == "3":
+		e.delete()
+	}
+	return nil
+}
+
+func (e *lineEdit) insert(c rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = c
+	e.pos++
+}
+
+// delete deletes the rune under the cursor
+func (e *lineEdit) delete() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+// deleteWord deletes the word before the cursor, and the spaces after it
+func (e *lineEdit) deleteWord() {
+	start := e.pos
+	for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+		start--
+	}
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+func (e *lineEdit) left() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *lineEdit) right() {
+	if e.pos < len(e.buf) {
+		e.pos++
+	}
+}
+
+// set replaces the line being edited, moving the cursor to its end
+func (e *lineEdit) set(line []rune) {
+	e.buf = append([]rune{}, line...)
+	e.pos = len(e.buf)
+}
+
+// previous shows the previous line of the history
+func (r *termLineReader) previous(e *lineEdit) {
+	if e.hist == 0 {
+		return
+	}
+	if e.hist == len(r.history.lines) {
+		e.saved = e.buf
+	}
+	e.hist--
+	e.set([]rune(r.history.lines[e.hist]))
+}
+
+// next shows the next line of the history, or the line being typed after the last one
+func (r *termLineReader) next(e *lineEdit) {
+	if e.hist == len(r.history.lines) {
+		return
+	}
+	e.hist++
+	if e.hist == len(r.history.lines) {
+		e.set(e.saved)
+		return
+	}
+	e.set([]rune(r.history.lines[e.hist]))
+}
+
+// completeWord completes the word before the cursor when it has a single
+// completion, or up to the longest prefix of its completions, and lists
+// them when there is nothing to add
+func (r *termLineReader) completeWord(e *lineEdit) {
+	if r.complete == nil {
+		return
+	}
+	word, candidates := r.complete(string(e.buf[:e.pos]))
+	if len(candidates) == 0 {
+		return
+	}
+	completion := candidates[0]
+	for _, candidate := range candidates[1:] {
+		completion = commonPrefix(completion, candidate)
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(completion, ":") {
+		completion += " "
+	}
+	if strings.HasPrefix(completion, word) && len(completion) > len(word) {
+		for _, c := range completion[len(word):] {
+			e.insert(c)
+		}
+		return
+	}
+	sort.Strings(candidates)
+	fmt.Fprintf(r.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	e.cursor = 0
+}
+
+func commonPrefix(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	n := 0
+	for n < len(ra) && n < len(rb) && ra[n] == rb[n] {
+		n++
+	}
+	return string(ra[:n])
+}
+
+// refresh redraws the prompt and the line, moving back from the cursor to
+// the start of the prompt so the text before the prompt is kept
+func (r *termLineReader) refresh(e *lineEdit) {
+	var b bytes.Buffer
+	if e.cursor > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", e.cursor)
+	}
+	b.WriteString(string(e.prompt))
+	b.WriteString(string(e.buf))
+	b.WriteString("\x1b[K")
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", back)
+	}
+	e.cursor = len(e.prompt) + e.pos
+	fmt.Fprint(r.out, b.String())
+}
+
+// sttyRaw returns a function putting the terminal of f in raw mode and
+// returning the function restoring it, or nil when f is not a terminal
+func sttyRaw(f *os.File) func() (func() error, error) {
+	if _, err := stty(f, "-g"); err != nil {
+		return nil
+	}
+	return func() (func() error, error) {
+		state, err := stty(f, "-g")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := stty(f, "raw", "-echo"); err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := stty(f, state)
+			return err
+		}, nil
+	}
+}
+
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), errors.Wrap(err, "stty")
+}
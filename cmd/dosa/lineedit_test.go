@@ -0,0 +1,182 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTermLineReader(t *testing.T) {
+	complete := func(line string) (string, []string) {
+		words := strings.Split(line, " ")
+		word := words[len(words)-1]
+		var candidates []string
+		for _, name := range []string{"read", "range", "Name:"} {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name)
+			}
+		}
+		return word, candidates
+	}
+	h := &history{lines: []string{"scan TestEntity", "read TestEntity"}}
+
+	data := []struct {
+		input string
+		line  string
+		err   error
+	}{
+		{input: "abc\r", line: "abc"},
+		{input: "abc\n", line: "abc"},
+		{input: "ab\x7fc\r", line: "ac"},
+		{input: "ac\x1b[Db\r", line: "abc"},
+		{input: "ac\x02b\x06d\r", line: "abcd"},
+		{input: "abc\x01X\r", line: "Xabc"},
+		{input: "abc\x01\x05X\r", line: "abcX"},
+		{input: "abc\x1b[H\x1b[CX\x1b[FY\r", line: "aXbcY"},
+		{input: "abc def\x17\r", line: "abc "},
+		{input: "abc\x1b[D\x15\r", line: "c"},
+		{input: "abc\x1b[D\x0b\r", line: "ab"},
+		{input: "abc\x1b[D\x1b[D\x1b[3~\r", line: "ac"},
+		{input: "ab\x1b[D\x04\r", line: "a"},
+		{input: "ü\x1b[Dé\r", line: "éü"},
+		{input: "\x1b[A\r", line: "read TestEntity"},
+		{input: "\x1b[A\x1b[A\r", line: "scan TestEntity"},
+		{input: "\x1b[A\x1b[A\x1b[A\x1b[B\r", line: "read TestEntity"},
+		{input: "typed\x1b[A\x1b[B\r", line: "typed"},
+		{input: "\x10\x10\x0e\x0e\r", line: ""},
+		{input: "rea\t\r", line: "read "},
+		{input: "ra\tTestEntity\r", line: "range TestEntity"},
+		{input: "r\t\r", line: "r"},
+		{input: "read N\tvalue\r", line: "read Name:value"},
+		{input: "x\t\r", line: "x"},
+		{input: "ab\x03", err: errInterrupted},
+		{input: "\x04", err: io.EOF},
+		{input: "abc", err: io.EOF},
+	}
+	for _, d := range data {
+		raw, restored := 0, 0
+		var out bytes.Buffer
+		r := newTermLineReader(strings.NewReader(d.input), &out, h, complete, func() (func() error, error) {
+			raw++
+			return func() error {
+				restored++
+				return nil
+			}, nil
+		})
+		line, err := r.ReadLine("> ")
+		assert.Equal(t, d.err, err, "%q", d.input)
+		assert.Equal(t, d.line, line, "%q", d.input)
+		assert.Equal(t, 1, raw, "%q", d.input)
+		assert.Equal(t, 1, restored, "%q", d.input)
+		assert.True(t, strings.HasPrefix(out.String(), "> "), "%q", d.input)
+	}
+
+	// the completions are listed when none can be chosen
+	var out bytes.Buffer
+	r := newTermLineReader(strings.NewReader("r\t\r"), &out, h, complete, func() (func() error, error) {
+		return func() error { return nil }, nil
+	})
+	_, err := r.ReadLine("> ")
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "\r\nrange  read\r\n")
+
+	// the editor does not read a line when the terminal cannot be set in raw mode
+	r = newTermLineReader(strings.NewReader("abc\r"), &out, h, complete, func() (func() error, error) {
+		return nil, fmt.Errorf("not a terminal")
+	})
+	_, err = r.ReadLine("> ")
+	assert.EqualError(t, err, "not a terminal")
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dosa-history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history")
+
+	h, err := loadHistory(file)
+	require.NoError(t, err)
+	assert.Empty(t, h.lines)
+
+	// blank lines and repeats are skipped
+	for _, line := range []string{"read a", "read a", " ", "scan b"} {
+		require.NoError(t, h.add(line))
+	}
+	assert.Equal(t, []string{"read a", "scan b"}, h.lines)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "read a\nscan b\n", string(data))
+
+	// the last lines are kept
+	for i := 0; i < _historySize; i++ {
+		require.NoError(t, h.add(fmt.Sprintf("line %d", i)))
+	}
+	assert.Len(t, h.lines, _historySize)
+	assert.Equal(t, "line 0", h.lines[0])
+	h, err = loadHistory(file)
+	require.NoError(t, err)
+	assert.Len(t, h.lines, _historySize)
+	assert.Equal(t, "line 0", h.lines[0])
+	assert.Equal(t, fmt.Sprintf("line %d", _historySize-1), h.lines[_historySize-1])
+
+	// without a file, the history is only kept in memory
+	h, err = loadHistory("")
+	require.NoError(t, err)
+	require.NoError(t, h.add("read a"))
+	assert.Equal(t, []string{"read a"}, h.lines)
+
+	_, err = loadHistory(dir)
+	assert.Error(t, err)
+	h = &history{file: filepath.Join(dir, "missing", "history")}
+	assert.Error(t, h.add("read a"))
+}
+
+func TestPlainLineReader(t *testing.T) {
+	// inputs which are not terminals are read without editing
+	f, err := ioutil.TempFile("", "dosa-input")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("read a\r\n\nscan b")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r := newLineReader(f, ioutil.Discard, &history{}, nil)
+	require.IsType(t, &plainLineReader{}, r)
+	for _, expected := range []string{"read a", "", "scan b"} {
+		line, err := r.ReadLine("> ")
+		require.NoError(t, err)
+		assert.Equal(t, expected, line)
+	}
+	_, err = r.ReadLine("> ")
+	assert.Equal(t, io.EOF, err)
+}
@@ -38,6 +38,7 @@ var exit = os.Exit
 type adminClientProvider func(opts GlobalOptions) (dosa.AdminClient, error)
 type mdClientProvider func(opts GlobalOptions) (dosa.Client, error)
 type queryClientProvider func(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error)
+type connectorProvider func(opts GlobalOptions) (dosa.Connector, error)
 
 // these are overridden at build-time w/ the -ldflags -X option
 var (
@@ -75,6 +76,7 @@ type GlobalOptions struct {
 	CallerName  callerFlag `long:"caller" default:"dosacli-$USER" description:"The RPC Caller name."`
 	Timeout     timeFlag   `long:"timeout" default:"60s" description:"The timeout for gateway requests. E.g., 100ms, 0.5s, 1s. If no unit is specified, milliseconds are assumed."`
 	Version     bool       `long:"version" description:"Display version info"`
+	Output      string     `long:"output" default:"table" choice:"table" choice:"json" choice:"jsonl" choice:"csv" description:"The output format of query results and scope metadata."`
 }
 
 var (
@@ -110,6 +112,21 @@ dosa manages your schema both in production and development scopes`
 	c, _ = OptionsParser.AddCommand("query", "commands to do query", "fetch one or multiple rows", &QueryOptions{})
 	_, _ = c.AddCommand("read", "Read query", "read a row by primary keys", newQueryRead(provideShellQueryClient))
 	_, _ = c.AddCommand("range", "Range query", "read rows with range of primary keys and indexes", newQueryRange(provideShellQueryClient))
+	_, _ = c.AddCommand("scan", "Scan query", "read all rows page by page", newQueryScan(provideShellQueryClient))
+	_, _ = c.AddCommand("upsert", "Upsert query", "create or update a row", newQueryUpsert(provideShellQueryClient, provideMDClient))
+	_, _ = c.AddCommand("create", "Create query", "create a row if it does not exist", newQueryCreate(provideShellQueryClient, provideMDClient))
+	_, _ = c.AddCommand("remove", "Remove query", "remove a row by primary keys", newQueryRemove(provideShellQueryClient, provideMDClient))
+	_, _ = c.AddCommand("remove-range", "Remove range query", "remove rows with range of primary keys", newQueryRemoveRange(provideShellQueryClient, provideMDClient))
+
+	c, _ = OptionsParser.AddCommand("data", "commands to export and import data", "export entities to data files and import them back", &DataOptions{})
+	_, _ = c.AddCommand("export", "Export data", "export entities to JSONL or Avro files, resuming from checkpoints", newDataExport(provideShellQueryClient))
+	_, _ = c.AddCommand("import", "Import data", "import entities from JSONL or Avro files", newDataImport(provideShellQueryClient, provideMDClient))
+
+	_, _ = OptionsParser.AddCommand("shell", "interactive shell", "connect once and run queries interactively, with history and completion of entity and field names", newShell(provideConnector, provideMDClient))
+
+	c, _ = OptionsParser.AddCommand("route", "commands to inspect routing configs", "explain or lint routing connector configs", &RouteOptions{})
+	_, _ = c.AddCommand("explain", "Explain routing", "show the rules of a routing config in precedence order and the ones serving a scope, name prefix and entity", newRouteExplain())
+	_, _ = c.AddCommand("lint", "Lint routing config", "report unreachable and shadowed rules of a routing config", newRouteLint())
 
 	// TODO: implement admin subcommand
 	// c, _ = OptionsParser.AddCommand("admin", "commands to administrate", "", &AdminOptions{})
@@ -153,7 +153,7 @@ func shutdownMDClient(client dosa.Client) {
 	}
 }
 
-func provideShellQueryClient(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+func provideConnector(opts GlobalOptions) (dosa.Connector, error) {
 	// from YARPC: "must begin with a letter and consist only of dash-delimited
 	// lower-case ASCII alphanumeric words" -- we do this here because YARPC
 	// will panic if caller name is invalid.
@@ -170,7 +170,11 @@ func provideShellQueryClient(opts GlobalOptions, scope, prefix, path, structName
 		ExtraHeaders: getAuthHeaders(),
 	}
 
-	conn, err := yarpc.NewConnector(ycfg)
+	return yarpc.NewConnector(ycfg)
+}
+
+func provideShellQueryClient(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+	conn, err := provideConnector(opts)
 	if err != nil {
 		return nil, err
 	}
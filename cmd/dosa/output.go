@@ -0,0 +1,227 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// Output formats of the results of queries and scope commands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+)
+
+// outputFormats lists the output formats
+var outputFormats = []string{outputTable, outputJSON, outputJSONL, outputCSV}
+
+// orderFields returns the fields of the results in the order of the columns
+// of the entity definition, followed by any unknown field in lexical order
+func orderFields(results []map[string]dosa.FieldValue, t *dosa.Table) []string {
+	present := make(map[string]bool)
+	for _, field := range getFields(results) {
+		present[field] = true
+	}
+	var fields []string
+	if t != nil {
+		for _, cd := range t.Columns {
+			field := t.ColToField[cd.Name]
+			if present[field] {
+				fields = append(fields, field)
+				delete(present, field)
+			}
+		}
+	}
+	for _, field := range getFields(results) {
+		if present[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// objectToResult returns the field values of a domain object, by field name
+func objectToResult(t *dosa.Table, object dosa.DomainObject) map[string]dosa.FieldValue {
+	v := reflect.Indirect(reflect.ValueOf(object))
+	result := make(map[string]dosa.FieldValue)
+	for _, field := range t.ColToField {
+		result[field] = v.FieldByName(field).Interface()
+	}
+	return result
+}
+
+// printResults prints the results to stdout in the output format of the
+// global options, with the fields in the order of the entity definition
+func printResults(results []map[string]dosa.FieldValue, t *dosa.Table) error {
+	return writeResults(os.Stdout, options.Output, orderFields(results, t), results)
+}
+
+// writeResults writes the given fields of the results in the given format
+func writeResults(w io.Writer, format string, fields []string, results []map[string]dosa.FieldValue) error {
+	switch format {
+	case outputTable, "":
+		return writeTable(w, fields, results)
+	case outputJSON:
+		return writeJSON(w, fields, results, false)
+	case outputJSONL:
+		return writeJSON(w, fields, results, true)
+	case outputCSV:
+		return writeCSV(w, fields, results)
+	}
+	return errors.Errorf("unknown output format %q, supported: table, json, jsonl, csv", format)
+}
+
+// writeTable writes the results as a table, with a header of the fields; without results only the
+// header is written
+func writeTable(w io.Writer, fields []string, results []map[string]dosa.FieldValue) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', tabwriter.Debug|tabwriter.StripEscape)
+	if _, err := fmt.Fprintln(tw, strings.Join(fields, "\t")); err != nil {
+		return errors.WithStack(err)
+	}
+	values := make([]string, len(fields))
+	for _, result := range results {
+		for idx, field := range fields {
+			value := "nil"
+			if fv, ok := result[field]; ok {
+				if s, ok := formatValue(fv); ok {
+					value = fmt.Sprintf("%s%s%s", []byte{tabwriter.Escape}, s, []byte{tabwriter.Escape})
+				}
+			}
+			values[idx] = value
+		}
+		if _, err := fmt.Fprintln(tw, strings.Join(values, "\t")); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tw.Flush())
+}
+
+// writeJSON writes the results as an array of objects, or as one object per
+// line, keeping the order of the fields; missing and nil values are null
+func writeJSON(w io.Writer, fields []string, results []map[string]dosa.FieldValue, lines bool) error {
+	var buf bytes.Buffer
+	if !lines {
+		buf.WriteString("[")
+	}
+	for idx, result := range results {
+		if idx > 0 && !lines {
+			buf.WriteString(",")
+		}
+		buf.WriteString("{")
+		for i, field := range fields {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			name, _ := json.Marshal(field)
+			value, err := jsonValue(result[field])
+			if err != nil {
+				return errors.Wrapf(err, "cannot encode field %s", field)
+			}
+			buf.Write(name)
+			buf.WriteString(":")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+		if lines {
+			buf.WriteString("\n")
+		}
+	}
+	if !lines {
+		buf.WriteString("]\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return errors.WithStack(err)
+}
+
+func writeCSV(w io.Writer, fields []string, results []map[string]dosa.FieldValue) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return errors.WithStack(err)
+	}
+	values := make([]string, len(fields))
+	for _, result := range results {
+		for idx, field := range fields {
+			// nil and missing values are empty
+			values[idx], _ = formatValue(result[field])
+		}
+		if err := cw.Write(values); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	cw.Flush()
+	return errors.WithStack(cw.Error())
+}
+
+// formatValue renders a value as text: timestamps in RFC3339Nano, UUIDs in
+// their canonical form and blobs in base64. It returns false for nil values.
+func formatValue(fv dosa.FieldValue) (string, bool) {
+	v := reflect.ValueOf(fv)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return "", false
+	}
+	switch value := reflect.Indirect(v).Interface().(type) {
+	case time.Time:
+		return value.Format(time.RFC3339Nano), true
+	case []byte:
+		if value == nil {
+			return "", false
+		}
+		return base64.StdEncoding.EncodeToString(value), true
+	case dosa.UUID:
+		return string(value), true
+	default:
+		return fmt.Sprint(value), true
+	}
+}
+
+// jsonValue renders a value as JSON: numbers and booleans as themselves,
+// timestamps, UUIDs and blobs as strings formatted like formatValue
+func jsonValue(fv dosa.FieldValue) ([]byte, error) {
+	v := reflect.ValueOf(fv)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return []byte("null"), nil
+	}
+	switch value := reflect.Indirect(v).Interface().(type) {
+	case time.Time, []byte, dosa.UUID:
+		s, ok := formatValue(value)
+		if !ok {
+			return []byte("null"), nil
+		}
+		return json.Marshal(s)
+	default:
+		return json.Marshal(value)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+)
+
+func outputTestResults() []map[string]dosa.FieldValue {
+	ts := time.Date(2020, 6, 1, 12, 30, 0, 500, time.UTC)
+	str := "pointed"
+	return []map[string]dosa.FieldValue{
+		{
+			"TSV":     ts,
+			"UUIDKey": dosa.UUID("3e4befa0-69d3-11e8-95b0-d55aa227a290"),
+			"StrKey":  "a,b",
+			"BlobV":   []byte("hello"),
+			"Int64V":  int64(9007199254740993),
+			"StrVP":   &str,
+			"Int32VP": (*int32)(nil),
+		},
+		{
+			"UUIDKey": dosa.UUID("a1b2c3d4-0000-0000-0000-000000000000"),
+			"StrKey":  "c",
+			"BoolV":   true,
+			"DoubleV": 1.5,
+			"Extra":   "unknown",
+		},
+	}
+}
+
+func TestOrderFields(t *testing.T) {
+	table, err := dosa.FindEntityByName("../../testentity", "TestEntity")
+	require.NoError(t, err)
+
+	// entity definition order, then unknown fields
+	assert.Equal(t, []string{"UUIDKey", "StrKey", "Int64V", "DoubleV", "BoolV", "BlobV", "TSV", "StrVP", "Int32VP", "Extra"},
+		orderFields(outputTestResults(), table))
+	// without a definition, the fields are sorted
+	assert.Equal(t, []string{"b", "c"}, orderFields([]map[string]dosa.FieldValue{{"c": 1}, {"b": 2}}, nil))
+}
+
+func TestWriteResults(t *testing.T) {
+	fields := []string{"UUIDKey", "StrKey", "Int64V", "BoolV", "BlobV", "TSV", "StrVP", "Int32VP"}
+	tcs := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: outputJSON,
+			expected: `[{"UUIDKey":"3e4befa0-69d3-11e8-95b0-d55aa227a290","StrKey":"a,b","Int64V":9007199254740993,"BoolV":null,"BlobV":"aGVsbG8=","TSV":"2020-06-01T12:30:00.0000005Z","StrVP":"pointed","Int32VP":null},` +
+				`{"UUIDKey":"a1b2c3d4-0000-0000-0000-000000000000","StrKey":"c","Int64V":null,"BoolV":true,"BlobV":null,"TSV":null,"StrVP":null,"Int32VP":null}]` + "\n",
+		},
+		{
+			format: outputJSONL,
+			expected: `{"UUIDKey":"3e4befa0-69d3-11e8-95b0-d55aa227a290","StrKey":"a,b","Int64V":9007199254740993,"BoolV":null,"BlobV":"aGVsbG8=","TSV":"2020-06-01T12:30:00.0000005Z","StrVP":"pointed","Int32VP":null}` + "\n" +
+				`{"UUIDKey":"a1b2c3d4-0000-0000-0000-000000000000","StrKey":"c","Int64V":null,"BoolV":true,"BlobV":null,"TSV":null,"StrVP":null,"Int32VP":null}` + "\n",
+		},
+		{
+			format: outputCSV,
+			expected: "UUIDKey,StrKey,Int64V,BoolV,BlobV,TSV,StrVP,Int32VP\n" +
+				`3e4befa0-69d3-11e8-95b0-d55aa227a290,"a,b",9007199254740993,,aGVsbG8=,2020-06-01T12:30:00.0000005Z,pointed,` + "\n" +
+				"a1b2c3d4-0000-0000-0000-000000000000,c,,true,,,,\n",
+		},
+		{
+			format: outputTable,
+			expected: "UUIDKey                                |StrKey   |Int64V             |BoolV   |BlobV      |TSV                            |StrVP     |Int32VP\n" +
+				"3e4befa0-69d3-11e8-95b0-d55aa227a290   |a,b      |9007199254740993   |nil     |aGVsbG8=   |2020-06-01T12:30:00.0000005Z   |pointed   |nil\n" +
+				"a1b2c3d4-0000-0000-0000-000000000000   |c        |nil                |true    |nil        |nil                            |nil       |nil\n",
+		},
+	}
+	for _, tc := range tcs {
+		var buf bytes.Buffer
+		assert.NoError(t, writeResults(&buf, tc.format, fields, outputTestResults()), tc.format)
+		assert.Equal(t, tc.expected, buf.String(), tc.format)
+	}
+}
+
+func TestWriteResultsEmpty(t *testing.T) {
+	expected := map[string]string{
+		outputJSON:  "[]\n",
+		outputJSONL: "",
+		outputCSV:   "ID,Name\n",
+		outputTable: "ID   |Name\n",
+	}
+	for format, output := range expected {
+		var buf bytes.Buffer
+		assert.NoError(t, writeResults(&buf, format, []string{"ID", "Name"}, nil), format)
+		assert.Equal(t, output, buf.String(), format)
+	}
+
+	var buf bytes.Buffer
+	assert.EqualError(t, writeResults(&buf, "xml", []string{"ID"}, nil), `unknown output format "xml", supported: table, json, jsonl, csv`)
+}
+
+func TestObjectToResult(t *testing.T) {
+	table, err := dosa.TableFromInstance(&dosa.ScopeMetadata{})
+	require.NoError(t, err)
+
+	result := objectToResult(table, &dosa.ScopeMetadata{Name: "infra_dev", Owner: "infra", Type: int32(dosa.Development)})
+	assert.Equal(t, dosa.FieldValue("infra_dev"), result["Name"])
+	assert.Equal(t, dosa.FieldValue("infra"), result["Owner"])
+	assert.Equal(t, dosa.FieldValue(int32(dosa.Development)), result["Type"])
+	assert.Equal(t, dosa.FieldValue((*time.Time)(nil)), result["ExpiresOn"])
+	assert.NotContains(t, result, "Prefixes")
+
+	fields := orderFields([]map[string]dosa.FieldValue{result}, table)
+	assert.Equal(t, []string{"Name", "Owner", "Type"}, fields[:3])
+}
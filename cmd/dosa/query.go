@@ -21,10 +21,14 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
 )
 
@@ -61,7 +65,7 @@ type QueryCmd struct {
 	provideClient queryClientProvider
 }
 
-func (c *QueryCmd) doQueryOp(f func(ShellQueryClient, context.Context, []*queryObj, []string, int) ([]map[string]dosa.FieldValue, error), entityName string, queries []string, limit int) error {
+func (c *QueryCmd) newClient(entityName string) (ShellQueryClient, error) {
 	if c.JarPath != "" {
 		fmt.Println("This operation has not been implemented in Java yet.")
 	}
@@ -72,10 +76,21 @@ func (c *QueryCmd) doQueryOp(f func(ShellQueryClient, context.Context, []*queryO
 
 	prefix, err := getNamePrefix(c.NamePrefix, c.Prefix)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return c.provideClient(options, c.Scope.String(), prefix, c.Path, entityName)
+}
+
+func (c *QueryCmd) fields() []string {
+	if c.Fields == "" {
+		return nil
 	}
+	return strings.Split(c.Fields, ",")
+}
 
-	client, err := c.provideClient(options, c.Scope.String(), prefix, c.Path, entityName)
+func (c *QueryCmd) doQueryOp(f func(ShellQueryClient, context.Context, []*queryObj, []string, int) ([]map[string]dosa.FieldValue, error), entityName string, queries []string, limit int) error {
+	client, err := c.newClient(entityName)
 	if err != nil {
 		return err
 	}
@@ -100,17 +115,12 @@ func (c *QueryCmd) doQueryOp(f func(ShellQueryClient, context.Context, []*queryO
 	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
 	defer cancel()
 
-	var fields []string
-	if c.Fields != "" {
-		fields = strings.Split(c.Fields, ",")
-	}
-
-	results, err := f(client, ctx, fvs, fields, limit)
+	results, err := f(client, ctx, fvs, c.fields(), limit)
 	if err != nil {
 		return err
 	}
 
-	return printResults(results)
+	return printResults(results, re.Table())
 }
 
 // QueryRead holds the options for 'query read'
@@ -157,3 +167,319 @@ func newQueryRange(provideClient queryClientProvider) *QueryRange {
 func (c *QueryRange) Execute(args []string) error {
 	return c.doQueryOp(ShellQueryClient.Range, c.Args.EntityName, c.Args.Queries, c.Limit)
 }
+
+// QueryScan holds the options for 'query scan'
+type QueryScan struct {
+	*QueryCmd
+	Limit int    `short:"l" long:"limit" default:"100" description:"Max number of results to return, per page with --all."`
+	Token string `short:"t" long:"token" description:"Continuation token of the page to start from."`
+	All   bool   `short:"a" long:"all" description:"Follow continuation tokens until every row is returned."`
+	Args  struct {
+		EntityName string `positional-arg-name:"entity" description:"Entity name."`
+	} `positional-args:"yes"`
+}
+
+func newQueryScan(provideClient queryClientProvider) *QueryScan {
+	return &QueryScan{
+		QueryCmd: &QueryCmd{
+			provideClient: provideClient,
+		},
+	}
+}
+
+// Execute executes a scan query command
+func (c *QueryScan) Execute(args []string) error {
+	client, err := c.newClient(c.Args.EntityName)
+	if err != nil {
+		return err
+	}
+	defer shutdownQueryClient(client)
+
+	re, err := client.GetRegistrar().Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	var results []map[string]dosa.FieldValue
+	token := c.Token
+	for {
+		// each page gets the whole timeout, a full scan may take much longer
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+		page, next, err := client.Scan(ctx, c.fields(), token, c.Limit)
+		cancel()
+		if err != nil {
+			return err
+		}
+		results = append(results, page...)
+		token = next
+		if !c.All || token == "" {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "scanned %d rows\n", len(results))
+	}
+
+	if err := printResults(results, re.Table()); err != nil {
+		return err
+	}
+	if token != "" {
+		fmt.Fprintf(os.Stderr, "more rows available, resume with --token %s\n", token)
+	}
+	return nil
+}
+
+// QueryWriteCmd is a placeholder for all query commands changing rows
+type QueryWriteCmd struct {
+	*QueryCmd
+	Yes             bool `short:"y" long:"yes" description:"Do not ask for confirmation on production scopes."`
+	provideMDClient mdClientProvider
+	in              io.Reader
+}
+
+func newQueryWriteCmd(provideClient queryClientProvider, provideMDClient mdClientProvider) *QueryWriteCmd {
+	return &QueryWriteCmd{
+		QueryCmd: &QueryCmd{
+			provideClient: provideClient,
+		},
+		provideMDClient: provideMDClient,
+		in:              os.Stdin,
+	}
+}
+
+func (c *QueryWriteCmd) doWriteOp(f func(ShellQueryClient, context.Context, []*queryObj) error, check func([]*queryObj, *dosa.RegisteredEntity) error, action, entityName string, kvs []*queryObj) error {
+	client, err := c.newClient(entityName)
+	if err != nil {
+		return err
+	}
+	defer shutdownQueryClient(client)
+
+	re, err := client.GetRegistrar().Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	fvs, err := setQueryFieldValues(kvs, re)
+	if err != nil {
+		return err
+	}
+
+	// reject invalid arguments before asking for a confirmation
+	if err := check(fvs, re); err != nil {
+		return err
+	}
+	if err := c.confirm(fmt.Sprintf("%s %s", action, entityName)); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+	defer cancel()
+
+	return f(client, ctx, fvs)
+}
+
+// confirm asks for a confirmation of the action when the scope is a
+// production scope, or when its type cannot be found
+func (c *QueryWriteCmd) confirm(action string) error {
+	if c.Yes {
+		return nil
+	}
+	return confirmOnProduction(c.provideMDClient, c.in, c.Scope.String(), action)
+}
+
+// confirmOnProduction asks for a confirmation of the action on stdout, and
+// reads it from in, when the scope is a production scope or when its type
+// cannot be found
+func confirmOnProduction(provideMDClient mdClientProvider, in io.Reader, scope, action string) error {
+	if !isProduction(provideMDClient, scope) {
+		return nil
+	}
+	fmt.Printf("%s in production scope %q, continue? [y/N] ", action, scope)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.WithStack(err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("aborted")
+}
+
+func isProduction(provideMDClient mdClientProvider, scope string) bool {
+	client, err := provideMDClient(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read the metadata of scope %q, assuming it is a production scope: %v\n", scope, err)
+		return true
+	}
+	defer shutdownMDClient(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+	defer cancel()
+
+	md := &dosa.ScopeMetadata{Name: scope}
+	if err := client.Read(ctx, dosa.All(), md); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read the metadata of scope %q, assuming it is a production scope: %v\n", scope, err)
+		return true
+	}
+	return md.Type == int32(dosa.Production)
+}
+
+// QueryUpsert holds the options for 'query upsert'
+type QueryUpsert struct {
+	*QueryWriteCmd
+	Args struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name."`
+		Values     []string `positional-arg-name:"values" description:"Values should be in the form field:value, the primary key fields are required."`
+	} `positional-args:"yes"`
+}
+
+func newQueryUpsert(provideClient queryClientProvider, provideMDClient mdClientProvider) *QueryUpsert {
+	return &QueryUpsert{
+		QueryWriteCmd: newQueryWriteCmd(provideClient, provideMDClient),
+	}
+}
+
+// Execute executes an upsert query command
+func (c *QueryUpsert) Execute(args []string) error {
+	kvs, err := parseValues(c.Args.Values)
+	if err != nil {
+		return err
+	}
+	if err := c.doWriteOp(ShellQueryClient.Upsert, checkWriteArgs, "upsert a row of", c.Args.EntityName, kvs); err != nil {
+		return err
+	}
+	fmt.Println("upserted 1 row")
+	return nil
+}
+
+// QueryCreate holds the options for 'query create'
+type QueryCreate struct {
+	*QueryWriteCmd
+	Args struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name."`
+		Values     []string `positional-arg-name:"values" description:"Values should be in the form field:value, the primary key fields are required."`
+	} `positional-args:"yes"`
+}
+
+func newQueryCreate(provideClient queryClientProvider, provideMDClient mdClientProvider) *QueryCreate {
+	return &QueryCreate{
+		QueryWriteCmd: newQueryWriteCmd(provideClient, provideMDClient),
+	}
+}
+
+// Execute executes a create query command
+func (c *QueryCreate) Execute(args []string) error {
+	kvs, err := parseValues(c.Args.Values)
+	if err != nil {
+		return err
+	}
+	if err := c.doWriteOp(ShellQueryClient.CreateIfNotExists, checkWriteArgs, "create a row of", c.Args.EntityName, kvs); err != nil {
+		return err
+	}
+	fmt.Println("created 1 row")
+	return nil
+}
+
+// QueryRemove holds the options for 'query remove'
+type QueryRemove struct {
+	*QueryWriteCmd
+	Args struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name."`
+		Keys       []string `positional-arg-name:"keys" description:"Keys should be in the form field:value, every primary key field is required."`
+	} `positional-args:"yes"`
+}
+
+func newQueryRemove(provideClient queryClientProvider, provideMDClient mdClientProvider) *QueryRemove {
+	return &QueryRemove{
+		QueryWriteCmd: newQueryWriteCmd(provideClient, provideMDClient),
+	}
+}
+
+// Execute executes a remove query command
+func (c *QueryRemove) Execute(args []string) error {
+	kvs, err := parseValues(c.Args.Keys)
+	if err != nil {
+		return err
+	}
+	if err := c.doWriteOp(ShellQueryClient.Remove, checkRemoveArgs, "remove a row of", c.Args.EntityName, kvs); err != nil {
+		return err
+	}
+	fmt.Println("removed 1 row")
+	return nil
+}
+
+// QueryRemoveRange holds the options for 'query remove-range'
+type QueryRemoveRange struct {
+	*QueryWriteCmd
+	DryRun bool `long:"dry-run" description:"Count the rows that would be removed without removing them."`
+	Args   struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name."`
+		Queries    []string `positional-arg-name:"queries" description:"Queries should be in the form field:operator:value, supported operators: eq,lt,le,gt,ge."`
+	} `positional-args:"yes"`
+}
+
+func newQueryRemoveRange(provideClient queryClientProvider, provideMDClient mdClientProvider) *QueryRemoveRange {
+	return &QueryRemoveRange{
+		QueryWriteCmd: newQueryWriteCmd(provideClient, provideMDClient),
+	}
+}
+
+// Execute executes a remove range query command
+func (c *QueryRemoveRange) Execute(args []string) error {
+	kvs, err := parseQuery(c.Args.Queries)
+	if err != nil {
+		return err
+	}
+
+	if !c.DryRun {
+		if err := c.doWriteOp(ShellQueryClient.RemoveRange, checkRemoveRangeArgs, "remove a range of", c.Args.EntityName, kvs); err != nil {
+			return err
+		}
+		fmt.Println("removed range")
+		return nil
+	}
+
+	// a dry run only reads, so it neither needs nor asks for a confirmation
+	client, err := c.newClient(c.Args.EntityName)
+	if err != nil {
+		return err
+	}
+	defer shutdownQueryClient(client)
+
+	re, err := client.GetRegistrar().Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	fvs, err := setQueryFieldValues(kvs, re)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout.Duration())
+	defer cancel()
+
+	count, err := client.CountRange(ctx, fvs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d rows would be removed\n", count)
+	return nil
+}
+
+func checkWriteArgs(kvs []*queryObj, re *dosa.RegisteredEntity) error {
+	_, err := buildWriteArgs(kvs, re, false)
+	return err
+}
+
+func checkRemoveArgs(kvs []*queryObj, re *dosa.RegisteredEntity) error {
+	_, err := buildWriteArgs(kvs, re, true)
+	return err
+}
+
+func checkRemoveRangeArgs(kvs []*queryObj, re *dosa.RegisteredEntity) error {
+	_, err := buildRemoveRangeConditions(kvs, re)
+	return err
+}
@@ -148,6 +148,106 @@ func TestQuery_Range_Happy(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestQuery_Scan_Happy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Scan(gomock.Any(), gomock.Any(), []string{"strkey", "int64key"}, "", 2).
+		Return([]map[string]dosa.FieldValue{{"strkey": "a"}, {"strkey": "b"}}, "next", nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	table, err := dosa.FindEntityByName("../../testentity", "TestEntity")
+	assert.NoError(t, err)
+	reg, err := newSimpleRegistrar(scope, namePrefix, table)
+	assert.NoError(t, err)
+
+	provideClient := func(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+		return newShellQueryClient(reg, mc), nil
+	}
+
+	queryScan := newQueryScan(provideClient)
+	queryScan.QueryOptions = &QueryOptions{Fields: "StrKey,Int64Key"}
+	queryScan.Scope = scopeFlag("scope")
+	queryScan.NamePrefix = "foo"
+	queryScan.Path = "../../testentity"
+	queryScan.Limit = 2
+	queryScan.Args.EntityName = "TestEntity"
+
+	c := StartCapture()
+	err = queryScan.Execute([]string{})
+	stderr := c.stop(true)
+	assert.NoError(t, err)
+	assert.Contains(t, stderr, "resume with --token next")
+}
+
+func TestQuery_Scan_All(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	gomock.InOrder(
+		mc.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any(), "start", 2).
+			Return([]map[string]dosa.FieldValue{{"strkey": "a"}, {"strkey": "b"}}, "page2", nil),
+		mc.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any(), "page2", 2).
+			Return([]map[string]dosa.FieldValue{{"strkey": "c"}, {"strkey": "d"}}, "page3", nil),
+		mc.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any(), "page3", 2).
+			Return([]map[string]dosa.FieldValue{{"strkey": "e"}}, "", nil),
+	)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	table, err := dosa.FindEntityByName("../../testentity", "TestEntity")
+	assert.NoError(t, err)
+	reg, err := newSimpleRegistrar(scope, namePrefix, table)
+	assert.NoError(t, err)
+
+	provideClient := func(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+		return newShellQueryClient(reg, mc), nil
+	}
+
+	queryScan := newQueryScan(provideClient)
+	queryScan.QueryOptions = &QueryOptions{}
+	queryScan.Scope = scopeFlag("scope")
+	queryScan.NamePrefix = "foo"
+	queryScan.Path = "../../testentity"
+	queryScan.Limit = 2
+	queryScan.Token = "start"
+	queryScan.All = true
+	queryScan.Args.EntityName = "TestEntity"
+
+	c := StartCapture()
+	err = queryScan.Execute([]string{})
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		assert.Contains(t, stdout, key)
+	}
+}
+
+func TestQuery_Scan_Error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any(), "", 100).Return(nil, "", &dosa.ErrNotFound{})
+	mc.EXPECT().Shutdown().Return(nil)
+
+	table, err := dosa.FindEntityByName("../../testentity", "TestEntity")
+	assert.NoError(t, err)
+	reg, err := newSimpleRegistrar(scope, namePrefix, table)
+	assert.NoError(t, err)
+
+	queryScan := newQueryScan(func(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+		return newShellQueryClient(reg, mc), nil
+	})
+	queryScan.QueryOptions = &QueryOptions{}
+	queryScan.Scope = scopeFlag("scope")
+	queryScan.NamePrefix = "foo"
+	queryScan.Limit = 100
+	queryScan.Args.EntityName = "TestEntity"
+	assert.True(t, dosa.ErrorIsNotFound(queryScan.Execute([]string{})))
+}
+
 func TestQuery_NewQueryObj(t *testing.T) {
 	qo := newQueryObj("StrKey", "eq", "foo")
 	assert.NotNil(t, qo)
@@ -157,7 +257,7 @@ func TestQuery_NewQueryObj(t *testing.T) {
 }
 
 func TestQuery_ScopeRequired(t *testing.T) {
-	for _, cmd := range []string{"read", "range"} {
+	for _, cmd := range []string{"read", "range", "scan", "upsert", "create", "remove", "remove-range"} {
 		c := StartCapture()
 		exit = func(r int) {}
 		os.Args = []string{
@@ -175,7 +275,7 @@ func TestQuery_ScopeRequired(t *testing.T) {
 }
 
 func TestQuery_PrefixRequired(t *testing.T) {
-	for _, cmd := range []string{"read", "range"} {
+	for _, cmd := range []string{"read", "range", "scan", "upsert", "create", "remove", "remove-range"} {
 		c := StartCapture()
 		exit = func(r int) {}
 		os.Args = []string{
@@ -193,7 +293,7 @@ func TestQuery_PrefixRequired(t *testing.T) {
 }
 
 func TestQuery_PathRequired(t *testing.T) {
-	for _, cmd := range []string{"read", "range"} {
+	for _, cmd := range []string{"read", "range", "scan", "upsert", "create", "remove", "remove-range"} {
 		c := StartCapture()
 		exit = func(r int) {}
 		os.Args = []string{
@@ -210,7 +310,7 @@ func TestQuery_PathRequired(t *testing.T) {
 }
 
 func TestQuery_NoEntityFound(t *testing.T) {
-	for _, cmd := range []string{"read", "range"} {
+	for _, cmd := range []string{"read", "range", "scan", "upsert", "create", "remove", "remove-range"} {
 		c := StartCapture()
 		exit = func(r int) {}
 		os.Args = []string{
@@ -0,0 +1,288 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+// writeTestCmd returns a QueryWriteCmd for TestEntity in the "scope" scope, whose
+// scope metadata is read from a mock client returning the given type or error
+func writeTestCmd(t *testing.T, ctrl *gomock.Controller, mc dosa.Connector, typ dosa.ScopeType, mdErr error, answer string) *QueryWriteCmd {
+	table, err := dosa.FindEntityByName("../../testentity", "TestEntity")
+	require.NoError(t, err)
+	reg, err := newSimpleRegistrar(scope, namePrefix, table)
+	require.NoError(t, err)
+
+	provideClient := func(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+		return newShellQueryClient(reg, mc), nil
+	}
+	provideMDClient := func(opts GlobalOptions) (dosa.Client, error) {
+		mdc := mocks.NewMockClient(ctrl)
+		mdc.EXPECT().Read(gomock.Any(), dosa.All(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ []string, md dosa.DomainObject) error {
+				assert.Equal(t, "scope", md.(*dosa.ScopeMetadata).Name)
+				md.(*dosa.ScopeMetadata).Type = int32(typ)
+				return mdErr
+			})
+		mdc.EXPECT().Shutdown().Return(nil)
+		return mdc, nil
+	}
+
+	cmd := newQueryWriteCmd(provideClient, provideMDClient)
+	cmd.QueryOptions = &QueryOptions{}
+	cmd.Scope = scopeFlag("scope")
+	cmd.NamePrefix = "foo"
+	cmd.Path = "../../testentity"
+	cmd.in = strings.NewReader(answer)
+	return cmd
+}
+
+var testEntityKeys = []string{"UUIDKey:a1b2c3d4-0000-0000-0000-000000000000", "StrKey:foo", "Int64Key:42"}
+
+func TestQuery_Upsert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Upsert(gomock.Any(), gomock.Any(), map[string]dosa.FieldValue{
+		"an_uuid_key": dosa.UUID("a1b2c3d4-0000-0000-0000-000000000000"),
+		"strkey":      "foo",
+		"int64key":    int64(42),
+		"strv":        "a:b",
+	}).Return(nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	// development scopes are not confirmed
+	queryUpsert := &QueryUpsert{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Development, nil, "")}
+	queryUpsert.Args.EntityName = "TestEntity"
+	queryUpsert.Args.Values = append(testEntityKeys, "StrV:a:b")
+
+	c := StartCapture()
+	err := queryUpsert.Execute([]string{})
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "upserted 1 row\n", stdout)
+}
+
+func TestQuery_Create_Confirmed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().CreateIfNotExists(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	queryCreate := &QueryCreate{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Production, nil, "y\n")}
+	queryCreate.Args.EntityName = "TestEntity"
+	queryCreate.Args.Values = testEntityKeys
+
+	c := StartCapture()
+	err := queryCreate.Execute([]string{})
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	assert.Contains(t, stdout, `create a row of TestEntity in production scope "scope", continue? [y/N]`)
+	assert.Contains(t, stdout, "created 1 row")
+}
+
+func TestQuery_Remove_Aborted(t *testing.T) {
+	for _, answer := range []string{"n\n", "\n", ""} {
+		ctrl := gomock.NewController(t)
+
+		// nothing is removed
+		mc := mocks.NewMockConnector(ctrl)
+		mc.EXPECT().Shutdown().Return(nil)
+
+		queryRemove := &QueryRemove{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Production, nil, answer)}
+		queryRemove.Args.EntityName = "TestEntity"
+		queryRemove.Args.Keys = testEntityKeys
+
+		c := StartCapture()
+		err := queryRemove.Execute([]string{})
+		c.stop(false)
+		assert.EqualError(t, err, "aborted")
+		ctrl.Finish()
+	}
+}
+
+func TestQuery_Remove_UnknownScopeType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Remove(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	// the scope is assumed to be a production scope
+	queryRemove := &QueryRemove{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Development, errors.New("no metadata"), "yes\n")}
+	queryRemove.Args.EntityName = "TestEntity"
+	queryRemove.Args.Keys = testEntityKeys
+
+	c := StartCapture()
+	err := queryRemove.Execute([]string{})
+	stderr := c.stop(true)
+	assert.NoError(t, err)
+	assert.Contains(t, stderr, `cannot read the metadata of scope "scope", assuming it is a production scope: no metadata`)
+}
+
+func TestQuery_Remove_Yes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Remove(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	// the scope metadata is not read
+	queryRemove := &QueryRemove{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Production, nil, "")}
+	queryRemove.provideMDClient = nil
+	queryRemove.Yes = true
+	queryRemove.Args.EntityName = "TestEntity"
+	queryRemove.Args.Keys = testEntityKeys
+
+	c := StartCapture()
+	err := queryRemove.Execute([]string{})
+	c.stop(false)
+	assert.NoError(t, err)
+}
+
+func TestQuery_Write_InvalidArgs(t *testing.T) {
+	tcs := []struct {
+		cmd      func(*QueryWriteCmd) error
+		expected string
+	}{
+		{
+			cmd: func(w *QueryWriteCmd) error {
+				q := &QueryUpsert{QueryWriteCmd: w}
+				q.Args.EntityName = "TestEntity"
+				q.Args.Values = []string{"StrKey"}
+				return q.Execute(nil)
+			},
+			expected: "value expression should be in the form field:value",
+		},
+		{
+			cmd: func(w *QueryWriteCmd) error {
+				q := &QueryUpsert{QueryWriteCmd: w}
+				q.Args.EntityName = "TestEntity"
+				q.Args.Values = []string{"StrKey:foo"}
+				return q.Execute(nil)
+			},
+			expected: "missing value for primary key field UUIDKey",
+		},
+		{
+			cmd: func(w *QueryWriteCmd) error {
+				q := &QueryCreate{QueryWriteCmd: w}
+				q.Args.EntityName = "TestEntity"
+				q.Args.Values = append(testEntityKeys, "Int32V:abc")
+				return q.Execute(nil)
+			},
+			expected: `parsing "abc": invalid syntax`,
+		},
+		{
+			cmd: func(w *QueryWriteCmd) error {
+				q := &QueryRemove{QueryWriteCmd: w}
+				q.Args.EntityName = "TestEntity"
+				q.Args.Keys = append(testEntityKeys, "StrV:foo")
+				return q.Execute(nil)
+			},
+			expected: "field StrV is not part of the primary key",
+		},
+		{
+			cmd: func(w *QueryWriteCmd) error {
+				q := &QueryRemoveRange{QueryWriteCmd: w}
+				q.Args.EntityName = "TestEntity"
+				q.Args.Queries = []string{"StrKey:eq:foo"}
+				return q.Execute(nil)
+			},
+			expected: "missing Eq condition on partition keys: [UUIDKey]",
+		},
+	}
+	for _, tc := range tcs {
+		ctrl := gomock.NewController(t)
+		mc := mocks.NewMockConnector(ctrl)
+		mc.EXPECT().Shutdown().Return(nil).AnyTimes()
+
+		// invalid arguments are rejected before asking for a confirmation
+		w := writeTestCmd(t, ctrl, mc, dosa.Production, nil, "")
+		w.provideMDClient = nil
+		err := tc.cmd(w)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), tc.expected)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestQuery_RemoveRange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().RemoveRange(gomock.Any(), gomock.Any(), map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: dosa.UUID("a1b2c3d4-0000-0000-0000-000000000000")}},
+		"strkey":      {{Op: dosa.Eq, Value: "foo"}},
+		"int64key":    {{Op: dosa.Lt, Value: int64(42)}},
+	}).Return(nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	queryRemoveRange := &QueryRemoveRange{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Production, nil, "y\n")}
+	queryRemoveRange.Args.EntityName = "TestEntity"
+	queryRemoveRange.Args.Queries = []string{"UUIDKey:eq:a1b2c3d4-0000-0000-0000-000000000000", "StrKey:eq:foo", "Int64Key:lt:42"}
+
+	c := StartCapture()
+	err := queryRemoveRange.Execute([]string{})
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	assert.Contains(t, stdout, `remove a range of TestEntity in production scope "scope", continue? [y/N]`)
+	assert.Contains(t, stdout, "removed range")
+}
+
+func TestQuery_RemoveRange_DryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mocks.NewMockConnector(ctrl)
+	mc.EXPECT().Range(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "", _countPageSize).
+		Return([]map[string]dosa.FieldValue{{}, {}}, "", nil)
+	mc.EXPECT().Shutdown().Return(nil)
+
+	// a dry run removes nothing and is not confirmed
+	queryRemoveRange := &QueryRemoveRange{QueryWriteCmd: writeTestCmd(t, ctrl, mc, dosa.Production, nil, "")}
+	queryRemoveRange.provideMDClient = nil
+	queryRemoveRange.DryRun = true
+	queryRemoveRange.Args.EntityName = "TestEntity"
+	queryRemoveRange.Args.Queries = []string{"UUIDKey:eq:a1b2c3d4-0000-0000-0000-000000000000"}
+
+	c := StartCapture()
+	err := queryRemoveRange.Execute([]string{})
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "2 rows would be removed\n", stdout)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// _countPageSize is the number of rows fetched by each call to Range when
+// counting the rows affected by a remove range
+const _countPageSize = 1000
+
+func (c *shellQueryClient) RemoveRange(ctx context.Context, ops []*queryObj) error {
+	// look up the entity in the registry
+	re, err := c.registrar.Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	columnConditions, err := buildRemoveRangeConditions(ops, re)
+	if err != nil {
+		return err
+	}
+
+	return c.connector.RemoveRange(ctx, re.EntityInfo(), columnConditions)
+}
+
+func (c *shellQueryClient) CountRange(ctx context.Context, ops []*queryObj) (int, error) {
+	// look up the entity in the registry
+	re, err := c.registrar.Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return 0, err
+	}
+
+	columnConditions, err := buildRemoveRangeConditions(ops, re)
+	if err != nil {
+		return 0, err
+	}
+
+	// only read the primary key, the values are not needed to count
+	var columns []string
+	for col := range re.EntityDefinition().KeySet() {
+		columns = append(columns, col)
+	}
+
+	count := 0
+	token := ""
+	for {
+		values, next, err := c.connector.Range(ctx, re.EntityInfo(), columnConditions, columns, token, _countPageSize)
+		if err != nil {
+			return 0, err
+		}
+		count += len(values)
+		if next == "" {
+			return count, nil
+		}
+		token = next
+	}
+}
+
+// buildRemoveRangeConditions converts the queries to the column conditions of
+// a remove range, and checks they are valid range conditions on the primary key
+func buildRemoveRangeConditions(ops []*queryObj, re *dosa.RegisteredEntity) (map[string][]*dosa.Condition, error) {
+	r := dosa.NewRemoveRangeOp(&dosa.Entity{})
+
+	// apply the queries
+	for _, op := range ops {
+		switch op.op {
+		case "eq":
+			r = r.Eq(op.fieldName, op.value)
+		case "lt":
+			r = r.Lt(op.fieldName, op.value)
+		case "le":
+			r = r.LtOrEq(op.fieldName, op.value)
+		case "gt":
+			r = r.Gt(op.fieldName, op.value)
+		case "ge":
+			r = r.GtOrEq(op.fieldName, op.value)
+		default:
+			// only eq, lt, le, gt, ge allowed for remove range
+			return nil, errors.Errorf("wrong operator used for remove-range, supported: eq, lt, le, gt, ge")
+		}
+	}
+
+	// now convert the client range columns to server side column conditions structure
+	columnConditions, err := dosa.ConvertConditions(r.Conditions(), re.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	colToField := func(col string) string { return re.Table().ColToField[col] }
+	if err := dosa.EnsureValidRangeConditions(re.EntityDefinition(), re.EntityDefinition().Key, columnConditions, colToField); err != nil {
+		return nil, err
+	}
+	return columnConditions, nil
+}
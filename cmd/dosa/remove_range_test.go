@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestClient_RemoveRange(t *testing.T) {
+	reg, _ := newSimpleRegistrar(scope, namePrefix, table)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().RemoveRange(ctx, gomock.Any(), map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: int64(10)}},
+	}).Return(nil)
+	c := newShellQueryClient(reg, mockConn)
+
+	assert.NoError(t, c.RemoveRange(ctx, []*queryObj{query1}))
+
+	// the partition key needs exactly one eq condition
+	err := c.RemoveRange(ctx, []*queryObj{query2})
+	assert.Contains(t, err.Error(), "invalid conditions for partition key: ID")
+	err = c.RemoveRange(ctx, []*queryObj{query3})
+	assert.Contains(t, err.Error(), "wrong operator used for remove-range")
+}
+
+func TestClient_CountRange(t *testing.T) {
+	reg, _ := newSimpleRegistrar(scope, namePrefix, table)
+	row := map[string]dosa.FieldValue{"id": int64(10)}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	gomock.InOrder(
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id"}, "", _countPageSize).
+			Return([]map[string]dosa.FieldValue{row, row}, "next", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), []string{"id"}, "next", _countPageSize).
+			Return([]map[string]dosa.FieldValue{row}, "", nil),
+		mockConn.EXPECT().Range(ctx, gomock.Any(), gomock.Any(), gomock.Any(), "", _countPageSize).
+			Return(nil, "", errors.New("range failed")),
+	)
+	c := newShellQueryClient(reg, mockConn)
+
+	count, err := c.CountRange(ctx, []*queryObj{query1})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, err = c.CountRange(ctx, []*queryObj{query1})
+	assert.EqualError(t, err, "range failed")
+	_, err = c.CountRange(ctx, []*queryObj{query2})
+	assert.Contains(t, err.Error(), "invalid conditions for partition key: ID")
+}
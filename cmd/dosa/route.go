@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa/connectors/routing"
+)
+
+// RouteOptions contains configuration for route command flags
+type RouteOptions struct{}
+
+// RouteCmd contains the options shared by the route commands
+type RouteCmd struct {
+	Config string `short:"c" long:"config" description:"Path to the routing config YAML file." required:"true"`
+}
+
+func (c *RouteCmd) loadConfig() (*routing.Config, error) {
+	return routing.LoadConfigFile(c.Config)
+}
+
+// RouteExplain contains data for executing the route explain command.
+type RouteExplain struct {
+	*RouteCmd
+	Scope      scopeFlag `short:"s" long:"scope" description:"Storage scope to route." required:"true"`
+	NamePrefix string    `short:"n" long:"namePrefix" description:"Name prefix to route."`
+	Prefix     string    `short:"p" long:"prefix" description:"Name prefix to route." hidden:"true"`
+	Entity     string    `short:"e" long:"entity" description:"Entity to route reads and writes of. Without it, the rule for schema and scope operations is shown."`
+}
+
+func newRouteExplain() *RouteExplain {
+	return &RouteExplain{RouteCmd: &RouteCmd{}}
+}
+
+// Execute executes a route explain command
+func (c *RouteExplain) Execute(args []string) error {
+	prefix, err := getNamePrefix(c.NamePrefix, c.Prefix)
+	if err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// The rules matched, and the operations each one serves.
+	served := make(map[int][]string)
+	var matches []string
+	if c.Entity == "" {
+		i, err := cfg.Match(c.Scope.String(), prefix, "", "")
+		if err != nil {
+			return err
+		}
+		served[i] = append(served[i], "schema")
+		matches = append(matches, describeMatch("schema and scope operations", cfg, i))
+	} else {
+		for _, op := range []string{"read", "write"} {
+			i, err := cfg.Match(c.Scope.String(), prefix, c.Entity, op)
+			if err != nil {
+				return err
+			}
+			served[i] = append(served[i], op)
+			matches = append(matches, describeMatch(op+"s", cfg, i))
+		}
+	}
+
+	fmt.Println("Rules in precedence order:")
+	for i, r := range cfg.Routers {
+		line := fmt.Sprintf("  #%-3d %s", i+1, r)
+		if ops, ok := served[i]; ok {
+			line += "  <- " + strings.Join(ops, ", ")
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+	if c.Entity == "" {
+		fmt.Printf("Scope %q, name prefix %q:\n", c.Scope.String(), prefix)
+	} else {
+		fmt.Printf("Scope %q, name prefix %q, entity %q:\n", c.Scope.String(), prefix, c.Entity)
+	}
+	for _, m := range matches {
+		fmt.Println("  " + m)
+	}
+	return nil
+}
+
+func describeMatch(what string, cfg *routing.Config, i int) string {
+	r := cfg.Routers[i]
+	destinations := r.Destinations()
+	destination := destinations[0]
+	if len(destinations) > 1 {
+		destination = fmt.Sprintf("split by partition key between %s (%s when there is no partition key)",
+			strings.Join(destinations, ", "), r.Destination())
+	}
+	return fmt.Sprintf("%s: rule #%d %s routes to %s", what, i+1, r, destination)
+}
+
+// RouteLint contains data for executing the route lint command.
+type RouteLint struct {
+	*RouteCmd
+}
+
+func newRouteLint() *RouteLint {
+	return &RouteLint{RouteCmd: &RouteCmd{}}
+}
+
+// Execute executes a route lint command
+func (c *RouteLint) Execute(args []string) error {
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	issues := cfg.Lint()
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		return errors.Errorf("found %d problems in routing config %s", len(issues), c.Config)
+	}
+	fmt.Printf("routing config %s: OK\n", c.Config)
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const routeConfig = `
+routers:
+- production:
+    serviceA:
+    - entity: orders
+      operations: write
+      split:
+        cassandra: 90
+        schemaless: 10
+    - connector: cassandra
+- "*":
+    serviceA: schemaless
+    serviceB: schemaless
+- production:
+    "*": dosa
+- default:
+    default: dosa
+`
+
+func writeRouteConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "routing")
+	require.NoError(t, err)
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestRoute_Explain(t *testing.T) {
+	path := writeRouteConfig(t, routeConfig)
+	defer os.Remove(path)
+
+	cmd := newRouteExplain()
+	cmd.Config = path
+	cmd.Scope = "production"
+	cmd.NamePrefix = "serviceA"
+	cmd.Entity = "orders"
+	c := StartCapture()
+	err := cmd.Execute([]string{})
+	output := c.stop(false)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Rules in precedence order:\n"+
+		"  #1   {production.serviceA entity=orders operations=write -> cassandra:90%,schemaless:10%}  <- write\n"+
+		"  #2   {production.serviceA -> cassandra}  <- read\n"+
+		"  #3   {production.* -> dosa}\n"+
+		"  #4   {*.serviceA -> schemaless}\n"+
+		"  #5   {*.serviceB -> schemaless}\n"+
+		"  #6   {*.* -> dosa}\n")
+	assert.Contains(t, output, `Scope "production", name prefix "serviceA", entity "orders":`)
+	assert.Contains(t, output, "reads: rule #2 {production.serviceA -> cassandra} routes to cassandra\n")
+	assert.Contains(t, output, "writes: rule #1 {production.serviceA entity=orders operations=write -> cassandra:90%,schemaless:10%} "+
+		"routes to split by partition key between cassandra, schemaless (cassandra when there is no partition key)\n")
+
+	cmd.Entity = ""
+	cmd.Scope = "staging"
+	c = StartCapture()
+	err = cmd.Execute([]string{})
+	output = c.stop(false)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "  #4   {*.serviceA -> schemaless}  <- schema\n")
+	assert.Contains(t, output, "schema and scope operations: rule #4 {*.serviceA -> schemaless} routes to schemaless\n")
+
+	cmd.NamePrefix = ""
+	assert.Contains(t, cmd.Execute([]string{}).Error(), "namePrefix")
+	cmd.NamePrefix = "serviceA"
+	cmd.Config = path + ".missing"
+	assert.Contains(t, cmd.Execute([]string{}).Error(), "cannot read routing config")
+}
+
+func TestRoute_Lint(t *testing.T) {
+	path := writeRouteConfig(t, routeConfig)
+	defer os.Remove(path)
+
+	cmd := newRouteLint()
+	cmd.Config = path
+	c := StartCapture()
+	err := cmd.Execute([]string{})
+	output := c.stop(false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "found 1 problems")
+	assert.Equal(t, "rule #5 {*.serviceB -> schemaless} is shadowed for production.serviceB: "+
+		"rule #3 {production.* -> dosa} takes precedence\n", output)
+
+	clean := writeRouteConfig(t, "routers:\n- default:\n    default: dosa\n")
+	defer os.Remove(clean)
+	cmd.Config = clean
+	c = StartCapture()
+	err = cmd.Execute([]string{})
+	output = c.stop(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "routing config "+clean+": OK\n", output)
+}
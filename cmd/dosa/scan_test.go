@@ -0,0 +1,67 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestClient_Scan(t *testing.T) {
+	reg, _ := newSimpleRegistrar(scope, namePrefix, table)
+	fieldsToRead := []string{"ID", "Email"}
+	results := map[string]dosa.FieldValue{
+		"id":    int64(2),
+		"name":  "bar",
+		"email": "bar@email.com",
+	}
+
+	// success case
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Scan(ctx, gomock.Any(), []string{"id", "email"}, "token", 10).
+		Return([]map[string]dosa.FieldValue{results}, "next", nil)
+	c := newShellQueryClient(reg, mockConn)
+	fvs, next, err := c.Scan(ctx, fieldsToRead, "token", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "next", next)
+	assert.Equal(t, 1, len(fvs))
+	assert.Equal(t, results["id"], fvs[0]["ID"])
+	assert.Equal(t, results["name"], fvs[0]["Name"])
+	assert.Equal(t, results["email"], fvs[0]["Email"])
+
+	// error in column name converting
+	fvs, _, err = c.Scan(ctx, []string{"badcol"}, "", 10)
+	assert.Nil(t, fvs)
+	assert.Contains(t, err.Error(), "badcol")
+
+	// error from the connector
+	mockConn.EXPECT().Scan(ctx, gomock.Any(), gomock.Any(), "", 10).Return(nil, "", errors.New("scan failed"))
+	fvs, _, err = c.Scan(ctx, nil, "", 10)
+	assert.Nil(t, fvs)
+	assert.EqualError(t, err, "scan failed")
+}
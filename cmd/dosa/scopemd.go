@@ -23,6 +23,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
@@ -50,13 +51,20 @@ func (c *ScopeList) Execute(args []string) error {
 	defer shutdownMDClient(client)
 
 	var scopes []string
-	if scopes, err = c.getScopes(client); err == nil {
+	if scopes, err = c.getScopes(client); err != nil {
 		return err
 	}
-	for _, sp := range scopes {
-		fmt.Println(sp)
+	if options.Output == outputTable {
+		for _, sp := range scopes {
+			fmt.Println(sp)
+		}
+		return nil
+	}
+	results := make([]map[string]dosa.FieldValue, len(scopes))
+	for idx, sp := range scopes {
+		results[idx] = map[string]dosa.FieldValue{"Name": sp}
 	}
-	return nil
+	return writeResults(os.Stdout, options.Output, []string{"Name"}, results)
 }
 
 func (c *ScopeList) getScopes(client dosa.Client) ([]string, error) {
@@ -82,6 +90,9 @@ func (c *ScopeList) getScopes(client dosa.Client) ([]string, error) {
 			md := e.(*dosa.ScopeMetadata)
 			scopeList = append(scopeList, md.Name)
 		}
+		if token == "" {
+			break
+		}
 	}
 	return scopeList, nil
 }
@@ -110,14 +121,23 @@ func (c *ScopeShow) Execute(args []string) error {
 	}
 	defer shutdownMDClient(client)
 
-	for _, scope := range args {
+	table, err := dosa.TableFromInstance(&dosa.ScopeMetadata{})
+	if err != nil {
+		return err
+	}
+
+	var results []map[string]dosa.FieldValue
+	for _, scope := range c.Args.Scopes {
 		if md, err := c.getMetadata(client, scope); err != nil {
-			fmt.Printf("Could not read scope metadata for %q: %v\n", scope, err)
+			fmt.Fprintf(os.Stderr, "Could not read scope metadata for %q: %v\n", scope, err)
 		} else {
-			fmt.Printf("%+v\n", md)
+			results = append(results, objectToResult(table, md))
 		}
 	}
-	return nil
+	if len(results) == 0 {
+		return errors.New("could not read the metadata of any scope")
+	}
+	return printResults(results, table)
 }
 
 // getMetadata returns the MD for a scope. Currently prefixes (for prod) are not handled; the intent
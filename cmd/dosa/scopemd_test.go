@@ -22,6 +22,7 @@ package main
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -104,3 +105,72 @@ func TestScopeList(t *testing.T) {
 	assert.Nil(t, e3)
 	assert.Equal(t, scopes, slst)
 }
+
+func TestScopeShowOutput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reg, err := dosa.NewRegistrar("production", "prefix", &dosa.ScopeMetadata{})
+	assert.NoError(t, err)
+
+	conn := mocks.NewMockConnector(ctrl)
+	conn.EXPECT().CheckSchema(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+	conn.EXPECT().Read(gomock.Any(), gomock.Any(), map[string]dosa.FieldValue{"name": "test_dev"}, gomock.Any()).
+		Return(map[string]dosa.FieldValue{"name": "test_dev", "owner": "tester", "type": int32(dosa.Development)}, nil)
+	conn.EXPECT().Read(gomock.Any(), gomock.Any(), map[string]dosa.FieldValue{"name": "missing"}, gomock.Any()).
+		Return(nil, &dosa.ErrNotFound{})
+	conn.EXPECT().Shutdown().Return(nil)
+
+	show := newScopeShow(func(opts GlobalOptions) (dosa.Client, error) {
+		client := dosa.NewClient(reg, conn)
+		return client, client.Initialize(context.Background())
+	})
+	show.Args.Scopes = []string{"test_dev", "missing"}
+
+	options.Output = outputJSONL
+	defer func() { options.Output = outputTable }()
+	c := StartCapture()
+	err = show.Execute(nil)
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	assert.Contains(t, stdout, `{"Name":"test_dev","Owner":"tester","Type":0,`)
+	assert.Equal(t, 1, strings.Count(stdout, "\n"))
+}
+
+func TestScopeListOutput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reg, err := dosa.NewRegistrar("production", "prefix", &dosa.ScopeMetadata{})
+	assert.NoError(t, err)
+
+	conn := mocks.NewMockConnector(ctrl)
+	conn.EXPECT().CheckSchema(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+	conn.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any(), "", 100).
+		Return([]map[string]dosa.FieldValue{{"name": "foo"}, {"name": "bar"}}, "", nil)
+	conn.EXPECT().Shutdown().Return(nil).Times(2)
+
+	lst := newScopeList(func(opts GlobalOptions) (dosa.Client, error) {
+		client := dosa.NewClient(reg, conn)
+		return client, client.Initialize(context.Background())
+	})
+
+	options.Output = outputCSV
+	defer func() { options.Output = outputTable }()
+	c := StartCapture()
+	err = lst.Execute(nil)
+	stdout := c.stop(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Name\nfoo\nbar\n", stdout)
+
+	// the table output is one scope per line
+	conn.EXPECT().CheckSchema(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+	conn.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any(), "", 100).
+		Return([]map[string]dosa.FieldValue{{"name": "foo"}}, "", nil)
+	options.Output = outputTable
+	c = StartCapture()
+	err = lst.Execute(nil)
+	stdout = c.stop(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo\n", stdout)
+}
@@ -0,0 +1,658 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// errExitShell is returned by the exit command to end the shell
+var errExitShell = errors.New("exit")
+
+// _shellSettings are the settings changed with 'set'
+var _shellSettings = []string{"scope", "prefix", "output", "limit", "fields"}
+
+// _shellValueFlags are the flags of the shell query commands taking a value
+var _shellValueFlags = map[string]bool{"-f": true, "--fields": true, "-l": true, "--limit": true, "-t": true, "--token": true}
+
+// ShellCmd holds the options for 'shell'
+type ShellCmd struct {
+	Scope            scopeFlag `short:"s" long:"scope" description:"Storage scope of the session, it can be changed with 'set scope'."`
+	NamePrefix       string    `short:"n" long:"namePrefix" description:"Name prefix for schema types, it can be changed with 'set prefix'."`
+	Prefix           string    `short:"p" long:"prefix" description:"Name prefix for schema types." hidden:"true"`
+	Path             string    `long:"path" description:"Path to source." required:"true"`
+	Limit            int       `short:"l" long:"limit" default:"100" description:"Max number of results of range and scan, it can be changed with 'set limit'."`
+	History          string    `long:"history" description:"File keeping the command history, $HOME/.dosa_history by default."`
+	provideConnector connectorProvider
+	provideMDClient  mdClientProvider
+	in               io.Reader
+}
+
+func newShell(provideConnector connectorProvider, provideMDClient mdClientProvider) *ShellCmd {
+	return &ShellCmd{
+		provideConnector: provideConnector,
+		provideMDClient:  provideMDClient,
+		in:               os.Stdin,
+	}
+}
+
+// Execute executes a shell command
+func (c *ShellCmd) Execute(args []string) error {
+	if options.ServiceName == "" {
+		options.ServiceName = _defServiceName
+	}
+	if c.Limit <= 0 {
+		return errors.New("the limit should be positive")
+	}
+
+	// the sources are parsed once, for every command of the session
+	entities, err := dosa.FindEntities(c.Path)
+	if err != nil {
+		return err
+	}
+	if len(entities) == 0 {
+		return errors.Errorf("no entity found in the path %s", c.Path)
+	}
+
+	historyFile := c.History
+	if historyFile == "" && os.Getenv("HOME") != "" {
+		historyFile = filepath.Join(os.Getenv("HOME"), ".dosa_history")
+	}
+	h, err := loadHistory(historyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot load the history: %v\n", err)
+	}
+
+	conn, err := c.provideConnector(options)
+	if err != nil {
+		return err
+	}
+
+	s := &shellSession{
+		scope:       c.Scope.String(),
+		namePrefix:  c.NamePrefix,
+		path:        c.Path,
+		limit:       c.Limit,
+		entities:    entities,
+		conn:        conn,
+		clients:     make(map[shellClientKey]ShellQueryClient),
+		newMDClient: c.provideMDClient,
+		history:     h,
+	}
+	if s.namePrefix == "" {
+		s.namePrefix = c.Prefix
+	}
+	s.lines = newLineReader(c.in, os.Stdout, h, s.complete)
+	defer s.close()
+
+	fmt.Fprintf(os.Stderr, "loaded %d entities from %s, type 'help' to list the commands\n", len(s.entityNames()), c.Path)
+	return s.run()
+}
+
+// shellSession is the state of a shell: the connection to the gateway, the
+// entities of the path, the clients of the entities and the settings
+type shellSession struct {
+	scope      string
+	namePrefix string
+	path       string
+	limit      int
+	fields     string
+	entities   []*dosa.Table
+	conn       dosa.Connector
+	// clients are initialized on first use of an entity in a scope, which
+	// checks its schema, and kept for the next commands
+	clients     map[shellClientKey]ShellQueryClient
+	newMDClient mdClientProvider
+	mdClient    dosa.Client
+	lines       lineReader
+	history     *history
+}
+
+type shellClientKey struct {
+	scope, namePrefix, entity string
+}
+
+// sessionQueryClient is a client kept by the session, which the commands do
+// not shut down
+type sessionQueryClient struct {
+	ShellQueryClient
+}
+
+func (sessionQueryClient) Shutdown() error {
+	return nil
+}
+
+// sessionMDClient is the metadata client kept by the session, which the
+// commands do not shut down
+type sessionMDClient struct {
+	dosa.Client
+}
+
+func (sessionMDClient) Shutdown() error {
+	return nil
+}
+
+// run executes the commands read until the end of the input or exit
+func (s *shellSession) run() error {
+	for {
+		line, err := s.lines.ReadLine(s.prompt())
+		switch {
+		case err == errInterrupted:
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.history.add(line); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot save the history: %v\n", err)
+		}
+		err = s.execute(line)
+		if err == errExitShell {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
+	}
+}
+
+func (s *shellSession) prompt() string {
+	if s.scope == "" {
+		return "dosa> "
+	}
+	return fmt.Sprintf("dosa %s> ", s.scope)
+}
+
+// execute parses and executes a line
+func (s *shellSession) execute(line string) error {
+	args, err := splitLine(line)
+	if err != nil {
+		return err
+	}
+	_, err = s.parser().ParseArgs(args)
+	if fe, ok := err.(*flags.Error); ok && fe.Type == flags.ErrHelp {
+		fmt.Println(fe.Message)
+		return nil
+	}
+	return err
+}
+
+// parser returns the parser of the commands of the session, a new one for
+// each line so the options given to a command are not kept for the next one
+func (s *shellSession) parser() *flags.Parser {
+	p := flags.NewNamedParser("", flags.HelpFlag|flags.PassDoubleDash)
+	_, _ = p.AddCommand("read", "Read query", "read a row by primary keys", &shellRead{session: s})
+	_, _ = p.AddCommand("range", "Range query", "read rows with range of primary keys and indexes", &shellRange{session: s})
+	_, _ = p.AddCommand("scan", "Scan query", "read all rows page by page", &shellScan{session: s})
+	_, _ = p.AddCommand("upsert", "Upsert query", "create or update a row", &shellUpsert{session: s})
+	_, _ = p.AddCommand("set", "Change a setting", "change the scope, prefix, output, limit or fields of the session", &shellSet{session: s})
+	_, _ = p.AddCommand("show", "Show the settings", "show the settings of the session", &shellShow{session: s})
+	_, _ = p.AddCommand("entities", "List the entities", "list the entities found in the path", &shellEntities{session: s})
+	_, _ = p.AddCommand("help", "List the commands", "list the commands", &shellHelp{session: s})
+	_, _ = p.AddCommand("exit", "Exit the shell", "exit the shell", &shellExit{})
+	return p
+}
+
+// queryCmd returns a query command using the settings and the clients of the session
+func (s *shellSession) queryCmd(fields string) (*QueryCmd, error) {
+	if s.scope == "" {
+		return nil, errors.New("no scope is set, set it with 'set scope <scope>'")
+	}
+	if s.namePrefix == "" {
+		return nil, errors.New("no name prefix is set, set it with 'set prefix <prefix>'")
+	}
+	if fields == "" {
+		fields = s.fields
+	}
+	return &QueryCmd{
+		QueryOptions:  &QueryOptions{Fields: fields},
+		Scope:         scopeFlag(s.scope),
+		NamePrefix:    s.namePrefix,
+		Path:          s.path,
+		provideClient: s.provideClient,
+	}, nil
+}
+
+// provideClient returns the client of the entity in the scope, connecting it
+// on first use with the connector of the session
+func (s *shellSession) provideClient(opts GlobalOptions, scope, prefix, path, structName string) (ShellQueryClient, error) {
+	key := shellClientKey{scope: scope, namePrefix: prefix, entity: structName}
+	if client, ok := s.clients[key]; ok {
+		return client, nil
+	}
+
+	table, err := s.findEntity(structName)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := newSimpleRegistrar(scope, prefix, table)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout.Duration())
+	defer cancel()
+
+	client := newShellQueryClient(reg, s.conn)
+	if err := client.Initialize(ctx); err != nil {
+		return nil, err
+	}
+	s.clients[key] = sessionQueryClient{client}
+	return s.clients[key], nil
+}
+
+// provideMDClient returns the metadata client of the session, connecting it on first use
+func (s *shellSession) provideMDClient(opts GlobalOptions) (dosa.Client, error) {
+	if s.mdClient == nil {
+		client, err := s.newMDClient(opts)
+		if err != nil {
+			return nil, err
+		}
+		s.mdClient = client
+	}
+	return sessionMDClient{s.mdClient}, nil
+}
+
+// findEntity returns the entity with the given struct name
+func (s *shellSession) findEntity(structName string) (*dosa.Table, error) {
+	var found *dosa.Table
+	for _, table := range s.entities {
+		if table.StructName != structName {
+			continue
+		}
+		if found != nil {
+			return nil, errors.Errorf("more than one entities named %s found in the path %s", structName, s.path)
+		}
+		found = table
+	}
+	if found == nil {
+		return nil, errors.Errorf("no entity named %s found in the path %s", structName, s.path)
+	}
+	return found, nil
+}
+
+// entityNames returns the struct names of the entities, sorted
+func (s *shellSession) entityNames() []string {
+	var names []string
+	for _, table := range s.entities {
+		if len(names) == 0 || names[len(names)-1] != table.StructName {
+			names = append(names, table.StructName)
+		}
+	}
+	return names
+}
+
+func (s *shellSession) set(name, value string) error {
+	switch name {
+	case "scope":
+		var scope scopeFlag
+		scope.setString(value)
+		s.scope = scope.String()
+	case "prefix":
+		if err := dosa.IsValidNamePrefix(value); err != nil {
+			return err
+		}
+		s.namePrefix = value
+	case "output":
+		for _, format := range outputFormats {
+			if value == format {
+				options.Output = value
+				return nil
+			}
+		}
+		return errors.Errorf("invalid output %q, the outputs are: %s", value, strings.Join(outputFormats, ", "))
+	case "limit":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return errors.Errorf("invalid limit %q, it should be a positive number", value)
+		}
+		s.limit = limit
+	case "fields":
+		s.fields = value
+	default:
+		return errors.Errorf("unknown setting %q, the settings are: %s", name, strings.Join(_shellSettings, ", "))
+	}
+	return nil
+}
+
+// complete returns the word ending the line and its completions: the
+// commands, the settings and outputs of set, and the entities and then
+// their fields in the queries
+func (s *shellSession) complete(line string) (string, []string) {
+	words := strings.Fields(line)
+	word := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		word = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	var names []string
+	switch {
+	case len(words) == 0:
+		for _, cmd := range s.parser().Commands() {
+			names = append(names, cmd.Name)
+		}
+	case words[0] == "set" && len(words) == 1:
+		names = _shellSettings
+	case words[0] == "set" && len(words) == 2 && words[1] == "output":
+		names = outputFormats
+	case words[0] == "read", words[0] == "range", words[0] == "scan", words[0] == "upsert":
+		if strings.HasPrefix(word, "-") {
+			return word, nil
+		}
+		args := positionalArgs(words[1:])
+		if len(args) == 0 {
+			names = s.entityNames()
+			break
+		}
+		table, err := s.findEntity(args[0])
+		if err != nil || strings.Contains(word, ":") {
+			break
+		}
+		for _, cd := range table.Columns {
+			names = append(names, table.ColToField[cd.Name]+":")
+		}
+	}
+
+	var candidates []string
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	return word, candidates
+}
+
+// positionalArgs returns the words of a query command which are not flags
+// or values of flags
+func positionalArgs(words []string) []string {
+	var args []string
+	for idx := 0; idx < len(words); idx++ {
+		switch {
+		case _shellValueFlags[words[idx]]:
+			idx++
+		case strings.HasPrefix(words[idx], "-"):
+		default:
+			args = append(args, words[idx])
+		}
+	}
+	return args
+}
+
+func (s *shellSession) close() {
+	if s.conn.Shutdown() != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to properly shutdown client")
+	}
+	if s.mdClient != nil {
+		shutdownMDClient(s.mdClient)
+	}
+}
+
+// splitLine splits a line into words separated by spaces, unless they are
+// quoted with ' or " or escaped with \
+func splitLine(line string) ([]string, error) {
+	var words []string
+	var word []rune
+	var quote rune
+	inWord, escaped := false, false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word = append(word, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word = append(word, c)
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = word[:0], false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("missing closing quote %c", quote)
+	}
+	if escaped {
+		return nil, errors.New("missing character after \\")
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// lineInput reads the answers to the confirmations from the lines of the shell
+type lineInput struct {
+	lines lineReader
+	buf   []byte
+}
+
+func (in *lineInput) Read(p []byte) (int, error) {
+	if len(in.buf) == 0 {
+		line, err := in.lines.ReadLine("")
+		if err == errInterrupted {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		in.buf = []byte(line + "\n")
+	}
+	n := copy(p, in.buf)
+	in.buf = in.buf[n:]
+	return n, nil
+}
+
+// shellRead holds the options for 'read' in the shell
+type shellRead struct {
+	session *shellSession
+	Fields  string `short:"f" long:"fields" description:"Fields of results to return, separated by comma, the ones of 'set fields' by default."`
+	Args    struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name." required:"yes"`
+		Queries    []string `positional-arg-name:"queries" description:"Queries should be in the form field:operator:value, supported operator: eq."`
+	} `positional-args:"yes"`
+}
+
+// Execute executes a read in the shell
+func (c *shellRead) Execute(args []string) error {
+	cmd, err := c.session.queryCmd(c.Fields)
+	if err != nil {
+		return err
+	}
+	read := &QueryRead{QueryCmd: cmd}
+	read.Args.EntityName, read.Args.Queries = c.Args.EntityName, c.Args.Queries
+	return read.Execute(nil)
+}
+
+// shellRange holds the options for 'range' in the shell
+type shellRange struct {
+	session *shellSession
+	Fields  string `short:"f" long:"fields" description:"Fields of results to return, separated by comma, the ones of 'set fields' by default."`
+	Limit   int    `short:"l" long:"limit" description:"Max number of results to return, the one of 'set limit' by default."`
+	Args    struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name." required:"yes"`
+		Queries    []string `positional-arg-name:"queries" description:"Queries should be in the form field:operator:value, supported operators: eq,lt,le,gt,ge."`
+	} `positional-args:"yes"`
+}
+
+// Execute executes a range in the shell
+func (c *shellRange) Execute(args []string) error {
+	cmd, err := c.session.queryCmd(c.Fields)
+	if err != nil {
+		return err
+	}
+	rng := &QueryRange{QueryCmd: cmd, Limit: c.session.limitOr(c.Limit)}
+	rng.Args.EntityName, rng.Args.Queries = c.Args.EntityName, c.Args.Queries
+	return rng.Execute(nil)
+}
+
+// shellScan holds the options for 'scan' in the shell
+type shellScan struct {
+	session *shellSession
+	Fields  string `short:"f" long:"fields" description:"Fields of results to return, separated by comma, the ones of 'set fields' by default."`
+	Limit   int    `short:"l" long:"limit" description:"Max number of results to return, per page with --all, the one of 'set limit' by default."`
+	Token   string `short:"t" long:"token" description:"Continuation token of the page to start from."`
+	All     bool   `short:"a" long:"all" description:"Follow continuation tokens until every row is returned."`
+	Args    struct {
+		EntityName string `positional-arg-name:"entity" description:"Entity name." required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// Execute executes a scan in the shell
+func (c *shellScan) Execute(args []string) error {
+	cmd, err := c.session.queryCmd(c.Fields)
+	if err != nil {
+		return err
+	}
+	scan := &QueryScan{QueryCmd: cmd, Limit: c.session.limitOr(c.Limit), Token: c.Token, All: c.All}
+	scan.Args.EntityName = c.Args.EntityName
+	return scan.Execute(nil)
+}
+
+// limitOr returns the limit when it is given, and the one of the session otherwise
+func (s *shellSession) limitOr(limit int) int {
+	if limit > 0 {
+		return limit
+	}
+	return s.limit
+}
+
+// shellUpsert holds the options for 'upsert' in the shell
+type shellUpsert struct {
+	session *shellSession
+	Yes     bool `short:"y" long:"yes" description:"Do not ask for confirmation on production scopes."`
+	Args    struct {
+		EntityName string   `positional-arg-name:"entity" description:"Entity name." required:"yes"`
+		Values     []string `positional-arg-name:"values" description:"Values should be in the form field:value, the primary key fields are required."`
+	} `positional-args:"yes"`
+}
+
+// Execute executes an upsert in the shell
+func (c *shellUpsert) Execute(args []string) error {
+	cmd, err := c.session.queryCmd("")
+	if err != nil {
+		return err
+	}
+	upsert := &QueryUpsert{QueryWriteCmd: &QueryWriteCmd{
+		QueryCmd:        cmd,
+		Yes:             c.Yes,
+		provideMDClient: c.session.provideMDClient,
+		in:              &lineInput{lines: c.session.lines},
+	}}
+	upsert.Args.EntityName, upsert.Args.Values = c.Args.EntityName, c.Args.Values
+	return upsert.Execute(nil)
+}
+
+// shellSet holds the arguments of 'set' in the shell
+type shellSet struct {
+	session *shellSession
+	Args    struct {
+		Name  string `positional-arg-name:"setting" description:"Setting name: scope, prefix, output, limit or fields." required:"yes"`
+		Value string `positional-arg-name:"value" description:"Setting value, none to clear the fields."`
+	} `positional-args:"yes"`
+}
+
+// Execute executes a set in the shell
+func (c *shellSet) Execute(args []string) error {
+	return c.session.set(c.Args.Name, c.Args.Value)
+}
+
+// shellShow shows the settings of the shell
+type shellShow struct {
+	session *shellSession
+}
+
+// Execute executes a show in the shell
+func (c *shellShow) Execute(args []string) error {
+	s := c.session
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "scope\t%s\n", s.scope)
+	fmt.Fprintf(w, "prefix\t%s\n", s.namePrefix)
+	fmt.Fprintf(w, "output\t%s\n", options.Output)
+	fmt.Fprintf(w, "limit\t%d\n", s.limit)
+	fmt.Fprintf(w, "fields\t%s\n", s.fields)
+	return w.Flush()
+}
+
+// shellEntities lists the entities of the shell
+type shellEntities struct {
+	session *shellSession
+}
+
+// Execute executes an entities in the shell
+func (c *shellEntities) Execute(args []string) error {
+	for _, name := range c.session.entityNames() {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// shellHelp lists the commands of the shell
+type shellHelp struct {
+	session *shellSession
+}
+
+// Execute executes a help in the shell
+func (c *shellHelp) Execute(args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, cmd := range c.session.parser().Commands() {
+		fmt.Fprintf(w, "%s\t%s\n", cmd.Name, cmd.LongDescription)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("Run '<command> --help' to show the options of a command.")
+	return nil
+}
+
+// shellExit exits the shell
+type shellExit struct{}
+
+// Execute executes an exit in the shell
+func (c *shellExit) Execute(args []string) error {
+	return errExitShell
+}
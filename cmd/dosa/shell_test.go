@@ -0,0 +1,249 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+)
+
+// checkCountingConnector counts the schema checks, made when a client is
+// initialized, and records the fields read
+type checkCountingConnector struct {
+	dosa.Connector
+	checks     map[string]int
+	readFields [][]string
+	shutdown   bool
+}
+
+func (c *checkCountingConnector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	c.readFields = append(c.readFields, minimumFields)
+	return c.Connector.Read(ctx, ei, keys, minimumFields)
+}
+
+func (c *checkCountingConnector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	c.checks[scope]++
+	return c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+}
+
+func (c *checkCountingConnector) Shutdown() error {
+	c.shutdown = true
+	return nil
+}
+
+// runShellTest runs the shell on the script, and returns its output, or its
+// errors when stderr is set
+func runShellTest(t *testing.T, shell *ShellCmd, script string, stderr bool) (string, error) {
+	dir, err := ioutil.TempDir("", "dosa-shell")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	shell.Path = "../../testentity"
+	shell.Limit = 100
+	shell.History = filepath.Join(dir, "history")
+	shell.in = strings.NewReader(script)
+	defer func(output string) {
+		options.Output = output
+	}(options.Output)
+	options.Output = outputTable
+
+	c := StartCapture()
+	err = shell.Execute(nil)
+	return c.stop(stderr), err
+}
+
+func TestShell_Session(t *testing.T) {
+	conn := &checkCountingConnector{Connector: memory.NewConnector(), checks: map[string]int{}}
+	connections := 0
+	shell := newShell(func(opts GlobalOptions) (dosa.Connector, error) {
+		connections++
+		return conn, nil
+	}, func(opts GlobalOptions) (dosa.Client, error) {
+		return nil, errors.New("no metadata client")
+	})
+
+	script := `
+# the scope and prefix are needed by the queries
+read TestEntity UUIDKey:eq:3e4befa0-69d3-11e8-95b0-000000000001
+set scope data
+set prefix prefix
+upsert -y TestEntity UUIDKey:3e4befa0-69d3-11e8-95b0-000000000001 StrKey:a Int64Key:1 StrV:"hello world"
+upsert TestEntity UUIDKey:3e4befa0-69d3-11e8-95b0-000000000001 StrKey:b Int64Key:2 StrV:second
+y
+set fields StrKey,StrV
+read TestEntity UUIDKey:eq:3e4befa0-69d3-11e8-95b0-000000000001 StrKey:eq:a Int64Key:eq:1
+set output jsonl
+set limit 1
+range TestEntity UUIDKey:eq:3e4befa0-69d3-11e8-95b0-000000000001
+scan -l 5 -f StrKey TestEntity
+set output xml
+bogus
+show
+exit
+read TestEntity UUIDKey:eq:3e4befa0-69d3-11e8-95b0-000000000001 StrKey:eq:a Int64Key:eq:1
+`
+	stdout, err := runShellTest(t, shell, script, false)
+	require.NoError(t, err)
+	assert.Equal(t, `upserted 1 row
+upsert a row of TestEntity in production scope "data", continue? [y/N] upserted 1 row
+UUIDKey                                |StrKey   |Int64Key   |StrV
+3e4befa0-69d3-11e8-95b0-000000000001   |a        |1          |hello world
+{"UUIDKey":"3e4befa0-69d3-11e8-95b0-000000000001","StrKey":"a","Int64Key":1,"StrV":"hello world"}
+{"UUIDKey":"3e4befa0-69d3-11e8-95b0-000000000001","StrKey":"a","Int64Key":1,"StrV":"hello world"}
+{"UUIDKey":"3e4befa0-69d3-11e8-95b0-000000000001","StrKey":"b","Int64Key":2,"StrV":"second"}
+scope   data
+prefix  prefix
+output  jsonl
+limit   1
+fields  StrKey,StrV
+`, stdout)
+
+	// the connection is made once, and the client of the entity is initialized once per scope
+	assert.Equal(t, 1, connections)
+	assert.Equal(t, map[string]int{"data": 1}, conn.checks)
+	assert.True(t, conn.shutdown)
+	// the fields set are read, the memory connector returns every field anyway
+	assert.Equal(t, [][]string{{"strkey", "strv"}}, conn.readFields)
+}
+
+func TestShell_Errors(t *testing.T) {
+	shell := newShell(func(opts GlobalOptions) (dosa.Connector, error) {
+		return memory.NewConnector(), nil
+	}, nil)
+	shell.Scope = "data"
+
+	script := `read TestEntity
+set prefix prefix
+read
+read UnknownEntity UUIDKey:eq:x
+set output xml
+set limit none
+set color blue
+upsert TestEntity UUIDKey:"unterminated
+bogus
+`
+	// the errors are reported and the shell goes on until the end of the input
+	stderr, err := runShellTest(t, shell, script, true)
+	require.NoError(t, err)
+	for _, msg := range []string{
+		"Error: no name prefix is set, set it with 'set prefix <prefix>'",
+		"Error: the required argument `entity` was not provided",
+		"Error: no entity named UnknownEntity found in the path ../../testentity",
+		`Error: invalid output "xml", the outputs are: table, json, jsonl, csv`,
+		`Error: invalid limit "none", it should be a positive number`,
+		`Error: unknown setting "color", the settings are: scope, prefix, output, limit, fields`,
+		"Error: missing closing quote \"",
+		"Error: Unknown command `bogus'",
+	} {
+		assert.Contains(t, stderr, msg)
+	}
+
+	shell.Path = "../../testentity/nonexistent"
+	assert.Error(t, shell.Execute(nil))
+	shell.Path = "../../testentity"
+	shell.Limit = 0
+	assert.EqualError(t, shell.Execute(nil), "the limit should be positive")
+	shell.Limit = 100
+	shell.provideConnector = func(opts GlobalOptions) (dosa.Connector, error) {
+		return nil, errors.New("cannot connect")
+	}
+	_, err = runShellTest(t, shell, "", true)
+	assert.EqualError(t, err, "cannot connect")
+}
+
+func TestShell_Complete(t *testing.T) {
+	entities, err := dosa.FindEntities("../../testentity")
+	require.NoError(t, err)
+	s := &shellSession{entities: entities, path: "../../testentity"}
+
+	data := []struct {
+		line       string
+		word       string
+		candidates []string
+	}{
+		{line: "", candidates: []string{"read", "range", "scan", "upsert", "set", "show", "entities", "help", "exit"}},
+		{line: "r", word: "r", candidates: []string{"read", "range"}},
+		{line: "set ", candidates: _shellSettings},
+		{line: "set o", word: "o", candidates: []string{"output"}},
+		{line: "set output j", word: "j", candidates: []string{"json", "jsonl"}},
+		{line: "set limit ", candidates: nil},
+		{line: "read Test", word: "Test", candidates: []string{"TestEntity", "TestNamedImportEntity"}},
+		{line: "scan -l 10 -f StrV TestE", word: "TestE", candidates: []string{"TestEntity"}},
+		{line: "range TestEntity StrK", word: "StrK", candidates: []string{"StrKey:"}},
+		{line: "range -l 10 TestEntity UUIDKey:eq:x Int64", word: "Int64", candidates: []string{"Int64Key:", "Int64V:", "Int64VP:"}},
+		{line: "range TestEntity StrKey:", word: "StrKey:", candidates: nil},
+		{line: "range TestEntity --li", word: "--li", candidates: nil},
+		{line: "read Unknown ", candidates: nil},
+		{line: "help ", candidates: nil},
+	}
+	for _, d := range data {
+		word, candidates := s.complete(d.line)
+		assert.Equal(t, d.word, word, d.line)
+		assert.Equal(t, d.candidates, candidates, d.line)
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	data := []struct {
+		line  string
+		words []string
+		err   string
+	}{
+		{line: "", words: nil},
+		{line: "  read  TestEntity\tID:eq:1 ", words: []string{"read", "TestEntity", "ID:eq:1"}},
+		{line: `upsert E Name:"hello world" Note:'it''s' Path:a\ b`, words: []string{"upsert", "E", "Name:hello world", "Note:its", "Path:a b"}},
+		{line: `set fields ""`, words: []string{"set", "fields", ""}},
+		{line: `a "b\"c" 'd\e'`, words: []string{"a", `b"c`, `d\e`}},
+		{line: `a "b`, err: `missing closing quote "`},
+		{line: `a b\`, err: `missing character after \`},
+	}
+	for _, d := range data {
+		words, err := splitLine(d.line)
+		if d.err != "" {
+			assert.EqualError(t, err, d.err, d.line)
+			continue
+		}
+		assert.NoError(t, err, d.line)
+		assert.Equal(t, d.words, words, d.line)
+	}
+}
+
+func TestLineInput(t *testing.T) {
+	in := &lineInput{lines: &plainLineReader{in: bufio.NewReader(strings.NewReader("yes\nno\n"))}}
+	buf := make([]byte, 2)
+	n, err := in.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ye", string(buf[:n]))
+	n, err = in.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "s\n", string(buf[:n]))
+}
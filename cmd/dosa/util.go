@@ -21,13 +21,9 @@
 package main
 
 import (
-	"fmt"
-	"os"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
-	"text/tabwriter"
 	"time"
 
 	"github.com/pkg/errors"
@@ -47,6 +43,20 @@ func parseQuery(exps []string) ([]*queryObj, error) {
 	return queries, nil
 }
 
+// parseValues parses the input value expressions, the values are assigned
+// so each of them becomes an eq query
+func parseValues(exps []string) ([]*queryObj, error) {
+	values := make([]*queryObj, len(exps))
+	for idx, exp := range exps {
+		strs := strings.SplitN(exp, ":", 2)
+		if len(strs) != 2 {
+			return nil, errors.Errorf("value expression should be in the form field:value")
+		}
+		values[idx] = newQueryObj(strs[0], "eq", strs[1])
+	}
+	return values, nil
+}
+
 // setQueryFieldValues sets the value field of queryObj
 func setQueryFieldValues(queries []*queryObj, re *dosa.RegisteredEntity) ([]*queryObj, error) {
 	cts := re.EntityDefinition().ColumnTypes()
@@ -139,35 +149,3 @@ func getFields(results []map[string]dosa.FieldValue) []string {
 	sort.Strings(fields)
 	return fields
 }
-
-func printResults(results []map[string]dosa.FieldValue) error {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.Debug|tabwriter.StripEscape)
-	if len(results) == 0 {
-		return errors.New("Empty results")
-	}
-	fields := getFields(results)
-	if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
-		return errors.WithStack(err)
-	}
-	values := make([]string, len(fields))
-	for _, result := range results {
-		for idx, field := range fields {
-			var value string
-			if _, ok := result[field]; ok {
-				value = fmt.Sprintf(
-					"%s%v%s",
-					[]byte{tabwriter.Escape},
-					reflect.Indirect(reflect.ValueOf(result[field])),
-					[]byte{tabwriter.Escape},
-				)
-			} else {
-				value = "nil"
-			}
-			values[idx] = value
-		}
-		if _, err := fmt.Fprintln(w, strings.Join(values, "\t")); err != nil {
-			return errors.WithStack(err)
-		}
-	}
-	return w.Flush()
-}
@@ -97,14 +97,14 @@ func TestPrintResults(t *testing.T) {
 			"uuid": dosa.FieldValue(dosa.UUID("3e4befa0-69d3-11e8-95b0-d55aa227a290")),
 		},
 	}
-	err := printResults(results)
+	err := printResults(results, nil)
 	assert.NoError(t, err)
 }
 
 func TestPrintResultsEmpty(t *testing.T) {
 	results := []map[string]dosa.FieldValue{}
-	err := printResults(results)
-	assert.Error(t, err)
+	err := printResults(results, nil)
+	assert.NoError(t, err)
 }
 
 func TestStrToFieldValue(t *testing.T) {
@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+func (c *shellQueryClient) Upsert(ctx context.Context, values []*queryObj) error {
+	// look up entity in the registry
+	re, err := c.registrar.Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	fvs, err := buildWriteArgs(values, re, false)
+	if err != nil {
+		return err
+	}
+
+	return c.connector.Upsert(ctx, re.EntityInfo(), fvs)
+}
+
+func (c *shellQueryClient) CreateIfNotExists(ctx context.Context, values []*queryObj) error {
+	// look up entity in the registry
+	re, err := c.registrar.Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	fvs, err := buildWriteArgs(values, re, false)
+	if err != nil {
+		return err
+	}
+
+	return c.connector.CreateIfNotExists(ctx, re.EntityInfo(), fvs)
+}
+
+func (c *shellQueryClient) Remove(ctx context.Context, keys []*queryObj) error {
+	// look up entity in the registry
+	re, err := c.registrar.Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	fvs, err := buildWriteArgs(keys, re, true)
+	if err != nil {
+		return err
+	}
+
+	return c.connector.Remove(ctx, re.EntityInfo(), fvs)
+}
+
+// buildWriteArgs builds the column values of a write, which must set every
+// primary key column, and nothing else when keysOnly is set
+func buildWriteArgs(ops []*queryObj, re *dosa.RegisteredEntity, keysOnly bool) (map[string]dosa.FieldValue, error) {
+	keys := re.EntityDefinition().KeySet()
+	res := make(map[string]dosa.FieldValue)
+	for _, op := range ops {
+		// sanity check, values are always assigned
+		if op.op != "eq" {
+			return nil, errors.Errorf("wrong operator used for %s, values should be in the form field:value", op.fieldName)
+		}
+		if _, ok := res[op.colName]; ok {
+			return nil, errors.Errorf("field %s is set more than once", op.fieldName)
+		}
+		if _, ok := keys[op.colName]; !ok && keysOnly {
+			return nil, errors.Errorf("field %s is not part of the primary key", op.fieldName)
+		}
+		res[op.colName] = op.value
+	}
+
+	// report the missing keys in the order they are declared
+	for _, col := range re.EntityDefinition().Key.PartitionKeys {
+		if _, ok := res[col]; !ok {
+			return nil, errors.Errorf("missing value for primary key field %s", re.Table().ColToField[col])
+		}
+	}
+	for _, ck := range re.EntityDefinition().Key.ClusteringKeys {
+		if _, ok := res[ck.Name]; !ok {
+			return nil, errors.Errorf("missing value for primary key field %s", re.Table().ColToField[ck.Name])
+		}
+	}
+	return res, nil
+}
+
+func (c *shellQueryClient) MultiUpsert(ctx context.Context, rows []map[string]dosa.FieldValue) error {
+	// look up entity in the registry
+	re, err := c.registrar.Find(&dosa.Entity{})
+	// this error should never happen for CLI query cases
+	if err != nil {
+		return err
+	}
+
+	// convert the field names to column names
+	multiValues := make([]map[string]dosa.FieldValue, len(rows))
+	for idx, row := range rows {
+		values := make(map[string]dosa.FieldValue, len(row))
+		for field, value := range row {
+			col, ok := re.Table().FieldToCol[field]
+			if !ok {
+				return errors.Errorf("%s is not a valid field for %s", field, re.Table().StructName)
+			}
+			values[col] = value
+		}
+		multiValues[idx] = values
+	}
+
+	results, err := c.connector.MultiUpsert(ctx, re.EntityInfo(), multiValues)
+	if err != nil {
+		return err
+	}
+	for idx, err := range results {
+		if err != nil {
+			return errors.Wrapf(err, "row %d", idx)
+		}
+	}
+	return nil
+}
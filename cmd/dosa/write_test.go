@@ -0,0 +1,79 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+)
+
+func TestClient_Upsert(t *testing.T) {
+	reg, _ := newSimpleRegistrar(scope, namePrefix, table)
+	name := &queryObj{fieldName: "Name", colName: "name", op: "eq", valueStr: "bar", value: dosa.FieldValue("bar")}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Upsert(ctx, gomock.Any(), map[string]dosa.FieldValue{"id": int64(10), "name": "bar"}).Return(nil)
+	mockConn.EXPECT().CreateIfNotExists(ctx, gomock.Any(), map[string]dosa.FieldValue{"id": int64(10)}).Return(&dosa.ErrAlreadyExists{})
+	c := newShellQueryClient(reg, mockConn)
+
+	assert.NoError(t, c.Upsert(ctx, []*queryObj{query1, name}))
+	assert.True(t, dosa.ErrorIsAlreadyExists(c.CreateIfNotExists(ctx, []*queryObj{query1})))
+
+	// the primary key is required
+	assert.EqualError(t, c.Upsert(ctx, []*queryObj{name}), "missing value for primary key field ID")
+	assert.EqualError(t, c.CreateIfNotExists(ctx, []*queryObj{name}), "missing value for primary key field ID")
+}
+
+func TestClient_Remove(t *testing.T) {
+	reg, _ := newSimpleRegistrar(scope, namePrefix, table)
+	name := &queryObj{fieldName: "Name", colName: "name", op: "eq", valueStr: "bar", value: dosa.FieldValue("bar")}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+	mockConn.EXPECT().Remove(ctx, gomock.Any(), map[string]dosa.FieldValue{"id": int64(10)}).Return(nil)
+	c := newShellQueryClient(reg, mockConn)
+
+	assert.NoError(t, c.Remove(ctx, []*queryObj{query1}))
+	assert.EqualError(t, c.Remove(ctx, []*queryObj{query1, name}), "field Name is not part of the primary key")
+	assert.EqualError(t, c.Remove(ctx, nil), "missing value for primary key field ID")
+}
+
+func TestClient_BuildWriteArgs(t *testing.T) {
+	reg, _ := newSimpleRegistrar(scope, namePrefix, table)
+	re, err := reg.Find(&dosa.Entity{})
+	assert.NoError(t, err)
+
+	fvs, err := buildWriteArgs([]*queryObj{query1}, re, true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dosa.FieldValue{"id": int64(10)}, fvs)
+
+	_, err = buildWriteArgs([]*queryObj{query1, query1}, re, false)
+	assert.EqualError(t, err, "field ID is set more than once")
+	_, err = buildWriteArgs([]*queryObj{query2}, re, false)
+	assert.Contains(t, err.Error(), "wrong operator used for ID")
+}
@@ -27,6 +27,11 @@ type conditioner struct {
 	conditions map[string][]*Condition
 }
 
+// Conditions returns all conditions embedded in the operator
+func (c *conditioner) Conditions() map[string][]*Condition {
+	return c.conditions
+}
+
 func (c *conditioner) appendOp(op Operator, fieldName string, value interface{}) {
 	c.conditions[fieldName] = append(c.conditions[fieldName], &Condition{Op: op, Value: value})
 }
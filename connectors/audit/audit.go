@@ -0,0 +1,335 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/metrics"
+)
+
+// Operation names used in audit records
+const (
+	OpCreateIfNotExists = "CreateIfNotExists"
+	OpUpsert            = "Upsert"
+	OpMultiUpsert       = "MultiUpsert"
+	OpRemove            = "Remove"
+	OpMultiRemove       = "MultiRemove"
+	OpRemoveRange       = "RemoveRange"
+)
+
+// Outcomes of an audited write
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Redacted replaces the value of every column tagged as sensitive
+const Redacted = "<redacted>"
+
+// DefaultSensitiveTag is the ColumnDefinition tag that marks a column as sensitive
+const DefaultSensitiveTag = "sensitive"
+
+// Record is a structured audit record describing a single write
+type Record struct {
+	Time       time.Time                  `json:"time"`
+	Caller     string                     `json:"caller,omitempty"`
+	Scope      string                     `json:"scope"`
+	NamePrefix string                     `json:"namePrefix"`
+	Entity     string                     `json:"entity"`
+	Operation  string                     `json:"operation"`
+	Keys       map[string]dosa.FieldValue `json:"keys,omitempty"`
+	Columns    []string                   `json:"columns,omitempty"`
+	Values     map[string]dosa.FieldValue `json:"values,omitempty"`
+	Conditions []*dosa.ColumnCondition    `json:"conditions,omitempty"`
+	Outcome    string                     `json:"outcome"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+// Sink receives audit records. Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(ctx context.Context, record *Record) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, record *Record) error
+
+// Write calls f(ctx, record)
+func (f SinkFunc) Write(ctx context.Context, record *Record) error {
+	return f(ctx, record)
+}
+
+// NewJSONSink returns a sink that writes every record as a single line of JSON to w
+func NewJSONSink(w io.Writer) Sink {
+	return &jsonSink{encoder: json.NewEncoder(w)}
+}
+
+type jsonSink struct {
+	sync.Mutex
+	encoder *json.Encoder
+}
+
+// Write encodes the record followed by a newline
+func (s *jsonSink) Write(_ context.Context, record *Record) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.encoder.Encode(record)
+}
+
+// contextKey used by SetContextCaller to set the caller name
+type contextKey string
+
+// contextCaller allows users to pass in the name of the caller of a write
+var contextCaller contextKey = "caller"
+
+// SetContextCaller sets the caller recorded in audit records for writes using ctx
+func SetContextCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, contextCaller, caller)
+}
+
+// GetContextCaller gets the caller from context
+func GetContextCaller(ctx context.Context) string {
+	caller, _ := ctx.Value(contextCaller).(string)
+	return caller
+}
+
+// Options returns a function that's being used for connector initialization
+type Options func(*Connector) error
+
+// WithCaller sets the caller recorded when none is present in the context
+func WithCaller(caller string) Options {
+	return func(c *Connector) error {
+		c.caller = caller
+		return nil
+	}
+}
+
+// WithSensitiveTags replaces the set of column tags that cause a column to be redacted
+func WithSensitiveTags(tags ...string) Options {
+	return func(c *Connector) error {
+		c.sensitiveTags = tags
+		return nil
+	}
+}
+
+// WithAuditedEntities restricts auditing to the given entities. By default every entity is audited.
+func WithAuditedEntities(entities ...dosa.DomainObject) Options {
+	return func(c *Connector) error {
+		audited := make(map[string]bool, len(entities))
+		for _, e := range entities {
+			t, err := dosa.TableFromInstance(e)
+			if err != nil {
+				return err
+			}
+			audited[t.EntityDefinition.Name] = true
+		}
+		c.auditedEntities = audited
+		return nil
+	}
+}
+
+// Connector is a connector that writes an audit record for every write to a sink
+// and passes all calls through to the next connector
+type Connector struct {
+	base.Connector
+	sink            Sink
+	stats           metrics.Scope
+	caller          string
+	sensitiveTags   []string
+	auditedEntities map[string]bool
+	now             func() time.Time
+}
+
+// NewConnector creates an audit connector that wraps next and writes records to sink.
+// An invalid option is returned as an error rather than ignored, since a misconfigured
+// audit connector would otherwise silently stop auditing.
+func NewConnector(next dosa.Connector, sink Sink, scope metrics.Scope, options ...Options) (*Connector, error) {
+	c := &Connector{
+		Connector:     base.Connector{Next: next},
+		sink:          sink,
+		stats:         metrics.CheckIfNilStats(scope),
+		sensitiveTags: []string{DefaultSensitiveTag},
+		now:           time.Now,
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, errors.Wrap(err, "invalid audit connector option")
+		}
+	}
+	return c, nil
+}
+
+// CreateIfNotExists audits the creation of a row
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.CreateIfNotExists(ctx, ei, values)
+	c.auditRow(ctx, ei, OpCreateIfNotExists, values, err)
+	return err
+}
+
+// Upsert audits the update of a row
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.Upsert(ctx, ei, values)
+	c.auditRow(ctx, ei, OpUpsert, values, err)
+	return err
+}
+
+// MultiUpsert audits the update of each row with its individual outcome
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Next.MultiUpsert(ctx, ei, multiValues)
+	for idx, values := range multiValues {
+		c.auditRow(ctx, ei, OpMultiUpsert, values, resultError(results, idx, err))
+	}
+	return results, err
+}
+
+// Remove audits the removal of a row
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	err := c.Next.Remove(ctx, ei, keys)
+	c.auditRow(ctx, ei, OpRemove, keys, err)
+	return err
+}
+
+// MultiRemove audits the removal of each row with its individual outcome
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Next.MultiRemove(ctx, ei, multiKeys)
+	for idx, keys := range multiKeys {
+		c.auditRow(ctx, ei, OpMultiRemove, keys, resultError(results, idx, err))
+	}
+	return results, err
+}
+
+// RemoveRange audits the removal of a range of rows; the record carries the conditions instead of keys
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	err := c.Next.RemoveRange(ctx, ei, columnConditions)
+	if !c.isAudited(ei) {
+		return err
+	}
+	sensitive := c.sensitiveColumns(ei)
+	record := c.newRecord(ctx, ei, OpRemoveRange, err)
+	for _, cc := range dosa.NormalizeConditions(columnConditions) {
+		if sensitive[cc.Name] {
+			cc = &dosa.ColumnCondition{
+				Name:      cc.Name,
+				Condition: &dosa.Condition{Op: cc.Condition.Op, Value: Redacted},
+			}
+		}
+		record.Conditions = append(record.Conditions, cc)
+	}
+	c.write(ctx, record)
+	return err
+}
+
+func (c *Connector) auditRow(ctx context.Context, ei *dosa.EntityInfo, op string, values map[string]dosa.FieldValue, err error) {
+	if !c.isAudited(ei) {
+		return
+	}
+	sensitive := c.sensitiveColumns(ei)
+	keySet := ei.Def.KeySet()
+	record := c.newRecord(ctx, ei, op, err)
+	record.Keys = map[string]dosa.FieldValue{}
+	for name, value := range values {
+		if sensitive[name] {
+			value = Redacted
+		}
+		if _, ok := keySet[name]; ok {
+			record.Keys[name] = value
+			continue
+		}
+		if record.Values == nil {
+			record.Values = map[string]dosa.FieldValue{}
+		}
+		record.Columns = append(record.Columns, name)
+		record.Values[name] = value
+	}
+	sort.Strings(record.Columns)
+	c.write(ctx, record)
+}
+
+func (c *Connector) newRecord(ctx context.Context, ei *dosa.EntityInfo, op string, err error) *Record {
+	caller := GetContextCaller(ctx)
+	if caller == "" {
+		caller = c.caller
+	}
+	record := &Record{
+		Time:       c.now(),
+		Caller:     caller,
+		Scope:      ei.Ref.Scope,
+		NamePrefix: ei.Ref.NamePrefix,
+		Entity:     ei.Def.Name,
+		Operation:  op,
+		Outcome:    OutcomeSuccess,
+	}
+	if err != nil {
+		record.Outcome = OutcomeFailure
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// write hands the record to the sink. A failing sink never fails the write itself, it is only counted.
+func (c *Connector) write(ctx context.Context, record *Record) {
+	s := c.stats.SubScope("audit").Tagged(map[string]string{"operation": record.Operation, "entityName": record.Entity})
+	if err := c.sink.Write(ctx, record); err != nil {
+		s.Counter("sink_failure").Inc(1)
+		return
+	}
+	s.Counter(record.Outcome).Inc(1)
+}
+
+func (c *Connector) isAudited(ei *dosa.EntityInfo) bool {
+	if c.auditedEntities == nil {
+		return true
+	}
+	return c.auditedEntities[ei.Def.Name]
+}
+
+// sensitiveColumns returns the set of columns carrying one of the sensitive tags
+func (c *Connector) sensitiveColumns(ei *dosa.EntityInfo) map[string]bool {
+	sensitive := map[string]bool{}
+	for _, col := range ei.Def.Columns {
+		for _, tag := range c.sensitiveTags {
+			if _, ok := col.Tags[tag]; ok {
+				sensitive[col.Name] = true
+				break
+			}
+		}
+	}
+	return sensitive
+}
+
+// resultError returns the outcome of the idx'th row of a Multi* call
+func resultError(results []error, idx int, err error) error {
+	if err != nil {
+		return err
+	}
+	if idx < len(results) {
+		return results[idx]
+	}
+	return nil
+}
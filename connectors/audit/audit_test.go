@@ -0,0 +1,247 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+	"github.com/uber-go/dosa/testentity"
+)
+
+var (
+	ctx    = context.Background()
+	testEi = createTestEi()
+	fixed  = time.Unix(1500000000, 0).UTC()
+)
+
+func createTestEi() *dosa.EntityInfo {
+	table, _ := dosa.TableFromInstance(&testentity.TestEntity{})
+	ed := table.EntityDefinition.Clone()
+	ed.FindColumnDefinition("strv").Tags = map[string]string{DefaultSensitiveTag: ""}
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "testing", NamePrefix: "example", EntityName: ed.Name},
+		Def: ed,
+	}
+}
+
+type collectingSink struct {
+	sync.Mutex
+	records []*Record
+	err     error
+}
+
+func (s *collectingSink) Write(_ context.Context, record *Record) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func newTestConnector(next dosa.Connector, sink Sink, options ...Options) *Connector {
+	c, err := NewConnector(next, sink, nil, options...)
+	if err != nil {
+		panic(err)
+	}
+	c.now = func() time.Time { return fixed }
+	return c
+}
+
+func testKeys() map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"),
+		"strkey":      "key",
+		"int64key":    int64(1),
+	}
+}
+
+func testValues() map[string]dosa.FieldValue {
+	values := testKeys()
+	values["strv"] = "secret"
+	values["int32v"] = int32(7)
+	return values
+}
+
+func TestUpsertAndCreate(t *testing.T) {
+	sink := &collectingSink{}
+	c := newTestConnector(memory.NewConnector(), sink, WithCaller("default-caller"))
+
+	assert.NoError(t, c.Upsert(SetContextCaller(ctx, "my-service"), testEi, testValues()))
+	assert.Error(t, c.CreateIfNotExists(ctx, testEi, testValues()))
+
+	assert.Len(t, sink.records, 2)
+	upsert := sink.records[0]
+	assert.Equal(t, &Record{
+		Time:       fixed,
+		Caller:     "my-service",
+		Scope:      "testing",
+		NamePrefix: "example",
+		Entity:     "awesome_test_entity",
+		Operation:  OpUpsert,
+		Keys:       testKeys(),
+		Columns:    []string{"int32v", "strv"},
+		Values:     map[string]dosa.FieldValue{"int32v": int32(7), "strv": Redacted},
+		Outcome:    OutcomeSuccess,
+	}, upsert)
+
+	create := sink.records[1]
+	assert.Equal(t, OpCreateIfNotExists, create.Operation)
+	assert.Equal(t, "default-caller", create.Caller)
+	assert.Equal(t, OutcomeFailure, create.Outcome)
+	assert.NotEmpty(t, create.Error)
+}
+
+func TestMultiWritesRecordEachRow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConn := mocks.NewMockConnector(ctrl)
+
+	rows := []map[string]dosa.FieldValue{testValues(), testValues()}
+	rowErr := errors.New("row failed")
+	mockConn.EXPECT().MultiUpsert(ctx, testEi, rows).Return([]error{nil, rowErr}, nil)
+	mockConn.EXPECT().MultiRemove(ctx, testEi, rows).Return(nil, errors.New("all failed"))
+
+	sink := &collectingSink{}
+	c := newTestConnector(mockConn, sink)
+
+	results, err := c.MultiUpsert(ctx, testEi, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, rowErr}, results)
+	_, err = c.MultiRemove(ctx, testEi, rows)
+	assert.Error(t, err)
+
+	assert.Len(t, sink.records, 4)
+	assert.Equal(t, OutcomeSuccess, sink.records[0].Outcome)
+	assert.Equal(t, OutcomeFailure, sink.records[1].Outcome)
+	assert.Equal(t, "row failed", sink.records[1].Error)
+	for _, r := range sink.records[2:] {
+		assert.Equal(t, OpMultiRemove, r.Operation)
+		assert.Equal(t, "all failed", r.Error)
+	}
+}
+
+func TestRemoveAndRemoveRange(t *testing.T) {
+	sink := &collectingSink{}
+	c := newTestConnector(memory.NewConnector(), sink)
+
+	assert.NoError(t, c.Remove(ctx, testEi, testKeys()))
+	conditions := map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9")}},
+		"strkey":      {{Op: dosa.Gt, Value: "a"}},
+	}
+	assert.NoError(t, c.RemoveRange(ctx, testEi, conditions))
+
+	assert.Len(t, sink.records, 2)
+	assert.Equal(t, OpRemove, sink.records[0].Operation)
+	assert.Equal(t, testKeys(), sink.records[0].Keys)
+	assert.Empty(t, sink.records[0].Columns)
+
+	rr := sink.records[1]
+	assert.Equal(t, OpRemoveRange, rr.Operation)
+	assert.Equal(t, dosa.NormalizeConditions(conditions), rr.Conditions)
+}
+
+func TestRedactsSensitiveConditions(t *testing.T) {
+	sink := &collectingSink{}
+	c := newTestConnector(memory.NewConnector(), sink, WithSensitiveTags("pii", DefaultSensitiveTag))
+
+	ei := createTestEi()
+	ei.Def.FindColumnDefinition("strkey").Tags = map[string]string{"pii": "true"}
+	conditions := map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9")}},
+		"strkey":      {{Op: dosa.Gt, Value: "a"}},
+	}
+	assert.NoError(t, c.RemoveRange(ctx, ei, conditions))
+	assert.Equal(t, Redacted, sink.records[0].Conditions[1].Condition.Value)
+	assert.Equal(t, dosa.Gt, sink.records[0].Conditions[1].Condition.Op)
+	// the caller's conditions must not be modified
+	assert.Equal(t, "a", conditions["strkey"][0].Value)
+}
+
+type otherEntity struct {
+	dosa.Entity `dosa:"primaryKey=(ID)"`
+	ID          int64
+}
+
+type invalidEntity struct {
+	dosa.Entity `dosa:"primaryKey=()"`
+	ID          int64
+}
+
+func TestAuditedEntities(t *testing.T) {
+	sink := &collectingSink{}
+	c := newTestConnector(memory.NewConnector(), sink, WithAuditedEntities(&testentity.TestEntity{}))
+	assert.NoError(t, c.Upsert(ctx, testEi, testValues()))
+	assert.Len(t, sink.records, 1)
+
+	sink = &collectingSink{}
+	c = newTestConnector(memory.NewConnector(), sink, WithAuditedEntities(&otherEntity{}))
+	assert.NoError(t, c.Upsert(ctx, testEi, testValues()))
+	assert.Empty(t, sink.records)
+}
+
+func TestInvalidAuditedEntities(t *testing.T) {
+	c, err := NewConnector(memory.NewConnector(), &collectingSink{}, nil, WithAuditedEntities(&testentity.TestEntity{}, &invalidEntity{}))
+	assert.Error(t, err)
+	assert.Nil(t, c)
+}
+
+func TestSinkFailureDoesNotFailWrite(t *testing.T) {
+	sink := &collectingSink{err: errors.New("sink down")}
+	c := newTestConnector(memory.NewConnector(), sink)
+	assert.NoError(t, c.Upsert(ctx, testEi, testValues()))
+	assert.Len(t, sink.records, 1)
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	c := newTestConnector(memory.NewConnector(), NewJSONSink(&buf))
+	assert.NoError(t, c.Upsert(ctx, testEi, testValues()))
+	assert.NoError(t, c.Remove(ctx, testEi, testKeys()))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(lines[0], &decoded))
+	assert.Equal(t, "Upsert", decoded["operation"])
+	assert.Equal(t, Redacted, decoded["values"].(map[string]interface{})["strv"])
+	assert.Equal(t, "success", decoded["outcome"])
+}
+
+func TestSinkFunc(t *testing.T) {
+	var called bool
+	f := SinkFunc(func(_ context.Context, r *Record) error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, f.Write(ctx, &Record{}))
+	assert.True(t, called)
+}
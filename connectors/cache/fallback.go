@@ -22,7 +22,10 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -49,6 +52,54 @@ type rangeQuery struct {
 	Limit      int
 }
 
+// Values stored in the fallback are wrapped in an envelope made of payloadMagic, the payload format version,
+// the schema version of the entity, the entry flags, the time the entry was written and the generation it
+// was written under, followed by the encoded value. Bump payloadFormatVersion whenever the layout of the
+// cached values changes, so that the entries written with an older layout get evicted.
+const (
+	payloadMagic         byte = 0xd0
+	payloadFormatVersion byte = 3
+	payloadHeaderSize         = 23
+
+	// payloadNegative flags an entry recording that the row was not found in the origin
+	payloadNegative byte = 1 << 0
+)
+
+var (
+	// errStaleEntry is returned when an entry of the fallback was written under another schema or payload format
+	errStaleEntry = errors.New("cache entry was written with another schema or payload format")
+	// errNegativeEntry is returned when an entry of the fallback records that the row was not found in the origin
+	errNegativeEntry = errors.New("cache entry records a row not found in the origin")
+	// errInvalidatedEntry is returned when an entry of the fallback was written under an older generation
+	errInvalidatedEntry = errors.New("cache entry was written under an older generation")
+)
+
+// cacheEntry is an entry of the fallback, unwrapped from its payload envelope
+type cacheEntry struct {
+	Negative   bool
+	WrittenAt  time.Time
+	Generation uint64
+	Data       []byte
+}
+
+// Kinds of generations. Every entry cached for a partition is written under the current generation of
+// the partition, and is only served while that generation is current: invalidating the entries of a
+// partition is a matter of writing a new generation, so it takes a single write to the fallback and
+// doesn't depend on tracking the entries. Writing a row invalidates the whole partition, so that the rows
+// read from the origin before the write can be written under the generation that was current before the
+// read, and never be served. Range results that cannot be attributed to a partition (scans and queries on
+// secondary indexes) use a single generation for the entity.
+const (
+	partitionGeneration = "partition"
+	entityGeneration    = "entity"
+)
+
+// generationKey is the cache key of a generation
+type generationKey struct {
+	Kind      string
+	Partition []map[string]dosa.FieldValue
+}
+
 // Options returns a function that's being used for connector initialization
 type Options func(*Connector) error
 
@@ -81,6 +132,84 @@ func GetContextEndpoint(ctx context.Context) string {
 	return endpoint
 }
 
+// WithEncoder sets the encoder of the keys and values stored in the fallback, gob by default
+func WithEncoder(encoder encoding.Encoder) Options {
+	return func(c *Connector) error {
+		if encoder == nil {
+			return errors.New("nil encoder")
+		}
+		c.encoder = encoder
+		return nil
+	}
+}
+
+// WithEntityEncoder sets the encoder of the values stored in the fallback for each entity, built from
+// the definition of the entity by newEncoder, such as encoding.NewSchemaEncoder. Keys are still encoded
+// with the encoder set by WithEncoder.
+func WithEntityEncoder(newEncoder func(ed *dosa.EntityDefinition) encoding.Encoder) Options {
+	return func(c *Connector) error {
+		if newEncoder == nil {
+			return errors.New("nil entity encoder")
+		}
+		c.newEntityEncoder = newEncoder
+		return nil
+	}
+}
+
+// WithNegativeCaching caches the ErrNotFound results of the origin for the given entities, so that reads of
+// missing rows are served from the fallback for ttl instead of going to the origin. Writes to these entities
+// invalidate the fallback before returning, so that a read following a write never gets a negative entry
+// cached before it.
+func WithNegativeCaching(ttl time.Duration, entities ...dosa.DomainObject) Options {
+	return func(c *Connector) error {
+		if ttl <= 0 {
+			return errors.New("negative caching ttl must be positive")
+		}
+		for name := range createCacheMapFromEntites(entities) {
+			c.negativeCacheTTL[name] = ttl
+		}
+		return nil
+	}
+}
+
+// WithStaleWhileRevalidate serves the reads of the given entities from the fallback when the cached entry
+// was written less than maxStale ago, while refreshing the entry from the origin in the background. Writes
+// to these entities invalidate the fallback before returning, so that a read following a write never gets
+// an entry cached before it.
+func WithStaleWhileRevalidate(maxStale time.Duration, entities ...dosa.DomainObject) Options {
+	return func(c *Connector) error {
+		if maxStale <= 0 {
+			return errors.New("stale-while-revalidate max staleness must be positive")
+		}
+		for name := range createCacheMapFromEntites(entities) {
+			c.maxStale[name] = maxStale
+		}
+		return nil
+	}
+}
+
+// SetNegativeCachingEndpoints restricts negative caching to the given endpoints. When not set,
+// negative caching applies to all the cacheable endpoints.
+func SetNegativeCachingEndpoints(endpoints ...string) Options {
+	return func(c *Connector) error {
+		for _, endpoint := range endpoints {
+			c.negativeCachingEndpointStatus[endpoint] = endpointActiveStatus
+		}
+		return nil
+	}
+}
+
+// SetStaleWhileRevalidateEndpoints restricts stale-while-revalidate to the given endpoints. When not set,
+// stale-while-revalidate applies to all the cacheable endpoints.
+func SetStaleWhileRevalidateEndpoints(endpoints ...string) Options {
+	return func(c *Connector) error {
+		for _, endpoint := range endpoints {
+			c.staleWhileRevalidateEndpointStatus[endpoint] = endpointActiveStatus
+		}
+		return nil
+	}
+}
+
 // SetCacheableEndpoints sets cacheable endpoints
 func SetCacheableEndpoints(endpoints ...string) Options {
 	return func(c *Connector) error {
@@ -91,17 +220,16 @@ func SetCacheableEndpoints(endpoints ...string) Options {
 	}
 }
 
-// NewConnector creates a fallback cache connector
-func NewConnector(origin, fallback dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, options ...Options) *Connector {
+// NewConnector creates a fallback cache connector. It fails if any of the options is invalid rather
+// than silently using the defaults.
+func NewConnector(origin, fallback dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, options ...Options) (*Connector, error) {
 	c := newConnector(origin, fallback, scope, encoding.NewGobEncoder(), entities)
 	for _, option := range options {
-		err := option(c)
-		if err != nil {
-			// It's a no-op when getting an error now
-			continue
+		if err := option(c); err != nil {
+			return nil, err
 		}
 	}
-	return c
+	return c, nil
 }
 
 func newConnector(origin, fallback dosa.Connector, scope metrics.Scope, encoder encoding.Encoder, entities []dosa.DomainObject) *Connector {
@@ -109,12 +237,19 @@ func newConnector(origin, fallback dosa.Connector, scope metrics.Scope, encoder
 	set := createCachedEntitiesSet(entities)
 	cacheableEndpointStatus := make(map[string]bool)
 	return &Connector{
-		Connector:               bc,
-		fallback:                fallback,
-		encoder:                 encoder,
-		cacheableEntities:       set,
-		cacheableEndpointStatus: cacheableEndpointStatus,
-		stats:                   scope,
+		Connector:                          bc,
+		fallback:                           fallback,
+		encoder:                            encoder,
+		entityEncoders:                     map[string]encoding.Encoder{},
+		cacheableEntities:                  set,
+		cacheableEndpointStatus:            cacheableEndpointStatus,
+		negativeCacheTTL:                   map[string]time.Duration{},
+		negativeCachingEndpointStatus:      map[string]bool{},
+		maxStale:                           map[string]time.Duration{},
+		staleWhileRevalidateEndpointStatus: map[string]bool{},
+		revalidating:                       map[string]bool{},
+		stats:                              scope,
+		now:                                time.Now,
 	}
 }
 
@@ -126,24 +261,60 @@ type Connector struct {
 	cacheableEntities              map[string]bool
 	cacheableEndpointStatus        map[string]bool
 	skipWriteInvalidateEntitiesMap map[string]bool
-	mux                            sync.Mutex
-	stats                          metrics.Scope
+	// encoders of the values, per entity name and schema version, when set by WithEntityEncoder
+	newEntityEncoder  func(ed *dosa.EntityDefinition) encoding.Encoder
+	entityEncoders    map[string]encoding.Encoder
+	entityEncodersMux sync.Mutex
+	// negative caching and stale-while-revalidate settings, per entity name and per endpoint
+	negativeCacheTTL                   map[string]time.Duration
+	negativeCachingEndpointStatus      map[string]bool
+	maxStale                           map[string]time.Duration
+	staleWhileRevalidateEndpointStatus map[string]bool
+	// encoded keys of the entries being refreshed from the origin
+	revalidating    map[string]bool
+	revalidatingMux sync.Mutex
+	stats           metrics.Scope
+	now             func() time.Time
 	// Used primarily for testing so that nothing is called in a goroutine
 	synchronous bool
 }
 
-// Upsert removes (invalidates) the entry from the fallback if the entity is not in the skipWriteInvalidateEntitiesMap
+// CreateIfNotExists removes (invalidates) the entries of its partition from the fallback once written to the origin,
+// if the entity is not in the skipWriteInvalidateEntitiesMap
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.CreateIfNotExists(ctx, ei, values)
+	c.invalidateRows(ctx, ei, values)
+	return err
+}
+
+// Upsert removes (invalidates) the entries of its partition from the fallback once written to the origin,
+// if the entity is not in the skipWriteInvalidateEntitiesMap
 func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	if c.isCacheable(ctx, ei) {
-		w := func() error {
-			return c.removeValueFromFallback(ctx, ei, createCacheKey(ei, values))
-		}
-		_ = c.cacheWrite(w)
-	}
-	return c.Next.Upsert(ctx, ei, values)
+	err := c.Next.Upsert(ctx, ei, values)
+	c.invalidateRows(ctx, ei, values)
+	return err
 }
 
+// Read reads from the origin, and from the fallback when the origin fails. When negative caching or
+// stale-while-revalidate is enabled for the entity and endpoint, the fallback is read first.
 func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (values map[string]dosa.FieldValue, err error) {
+	// generation to cache the row under, 0 to use the current one when writing to the fallback
+	var generation uint64
+	if c.isCacheable(ctx, ei) && (c.negativeTTL(ctx, ei) > 0 || c.staleness(ctx, ei) > 0) {
+		if values, ok, err := c.readFallbackFirst(ctx, ei, keys); ok {
+			return values, err
+		}
+		// the reads of the entity are served from the fallback, so the row must not be cached if a write
+		// invalidates it while it is read from the origin
+		if generation, err = c.generationForWrite(ctx, ei, rowGeneration(ei, keys)); err != nil {
+			source, sourceErr := c.Next.Read(ctx, ei, keys, dosa.All())
+			if sourceErr == nil {
+				populateValuesWithKeys(keys, source)
+			}
+			return source, sourceErr
+		}
+	}
+
 	// Read from source of truth first
 	source, sourceErr := c.Next.Read(ctx, ei, keys, dosa.All())
 	// Add the primary keys back into results map as dosa.All() does not fetch the keys
@@ -158,16 +329,101 @@ func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[stri
 	// if source of truth is good, return result and write result to cache
 	if sourceErr == nil {
 		w := func() error {
-			return c.write(ctx, ei, keys, source)
+			return c.write(ctx, ei, keys, source, generation)
 		}
 		_ = c.cacheWrite(w)
 
 		return source, sourceErr
 	}
 
+	if dosa.ErrorIsNotFound(sourceErr) && c.negativeTTL(ctx, ei) > 0 {
+		w := func() error {
+			return c.writeNegative(ctx, ei, keys, generation)
+		}
+		_ = c.cacheWrite(w)
+	}
+
 	return c.read(ctx, ei, keys, source, sourceErr, "READ")
 }
 
+// readFallbackFirst serves a read from the fallback, if the entry is a negative entry that hasn't expired yet,
+// or an entry that is not older than the max staleness. In the latter case, the entry is refreshed in the
+// background. It returns false when the read must go to the origin.
+func (c *Connector) readFallbackFirst(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (map[string]dosa.FieldValue, bool, error) {
+	ckey := createCacheKey(ei, keys)
+	entry, err := c.getEntryFromFallback(ctx, ei, ckey, rowGeneration(ei, keys))
+	if err != nil {
+		return nil, false, nil
+	}
+	age := c.now().Sub(entry.WrittenAt)
+
+	if entry.Negative {
+		if age >= c.negativeTTL(ctx, ei) {
+			return nil, false, nil
+		}
+		c.countFallback("READ", ei.Def.Name, "negative_hit")
+		return nil, true, &dosa.ErrNotFound{}
+	}
+
+	if maxStale := c.staleness(ctx, ei); maxStale == 0 || age >= maxStale {
+		return nil, false, nil
+	}
+	result := map[string]dosa.FieldValue{}
+	if err := c.encoderFor(ei).Decode(entry.Data, &result); err != nil {
+		return nil, false, nil
+	}
+	c.countFallback("READ", ei.Def.Name, "stale_hit")
+	c.revalidate(ctx, ei, keys)
+	return rawRowAsPointers(ei, result), true, nil
+}
+
+// revalidate refreshes an entry of the fallback from the origin in the background. Concurrent refreshes of
+// the same entry are collapsed into one.
+func (c *Connector) revalidate(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) {
+	encodedKey, err := c.encodeKey(ei, createCacheKey(ei, keys))
+	if err != nil {
+		return
+	}
+	c.revalidatingMux.Lock()
+	if c.revalidating[string(encodedKey)] {
+		c.revalidatingMux.Unlock()
+		return
+	}
+	c.revalidating[string(encodedKey)] = true
+	c.revalidatingMux.Unlock()
+
+	// the refresh outlives the request, so it doesn't inherit its cancellation
+	endpoint := GetContextEndpoint(ctx)
+	w := func() error {
+		defer func() {
+			c.revalidatingMux.Lock()
+			delete(c.revalidating, string(encodedKey))
+			c.revalidatingMux.Unlock()
+		}()
+		newCtx, cancel := createContextForFallback(SetContextEndpoint(context.Background(), endpoint))
+		defer cancel()
+
+		generation, err := c.generationForWrite(newCtx, ei, rowGeneration(ei, keys))
+		if err != nil {
+			return err
+		}
+		source, err := c.Next.Read(newCtx, ei, keys, dosa.All())
+		switch {
+		case err == nil:
+			populateValuesWithKeys(keys, source)
+			return c.write(newCtx, ei, keys, source, generation)
+		case dosa.ErrorIsNotFound(err) && c.negativeTTL(newCtx, ei) > 0:
+			return c.writeNegative(newCtx, ei, keys, generation)
+		case dosa.ErrorIsNotFound(err):
+			return c.removeEncodedKeyFromFallback(newCtx, ei, encodedKey)
+		default:
+			c.countFallback("READ", ei.Def.Name, "revalidate_failure")
+			return err
+		}
+	}
+	_ = c.cacheWrite(w)
+}
+
 // if source of truth fails, try the fallback. If the fallback fails, return the original error
 func (c *Connector) read(
 	ctx context.Context,
@@ -182,22 +438,36 @@ func (c *Connector) read(
 	}
 
 	ckey := createCacheKey(ei, keys)
-	value, err := c.getValueFromFallback(ctx, ei, ckey)
+	value, err := c.getValueFromFallback(ctx, ei, ckey, rowGeneration(ei, keys))
 	c.logFallback(methodName, ei.Def.Name, err)
 	if err != nil {
 		return source, sourceErr
 	}
 	result := map[string]dosa.FieldValue{}
-	err = c.encoder.Decode(value, &result)
+	err = c.encoderFor(ei).Decode(value, &result)
 	if err != nil {
 		return source, sourceErr
 	}
 	return rawRowAsPointers(ei, result), err
 }
 
-func (c *Connector) write(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, source map[string]dosa.FieldValue) (err error) {
-	cacheKey := createCacheKey(ei, keys)
-	return c.writeKeyValueToFallback(ctx, ei, cacheKey, source)
+// write caches a row read from the origin under the given generation, or under the current one if it is 0.
+// Writing the row under the generation that was current before reading it makes sure that it is never served
+// if a write invalidated it in the meantime.
+func (c *Connector) write(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, source map[string]dosa.FieldValue, generation uint64) (err error) {
+	cacheValue, err := c.encoderFor(ei).Encode(source)
+	if err != nil {
+		return err
+	}
+	entry := cacheEntry{Generation: generation, Data: cacheValue}
+	return c.writeEntryToFallback(ctx, ei, createCacheKey(ei, keys), entry, rowGeneration(ei, keys))
+}
+
+// writeNegative records in the fallback that the row was not found in the origin, under the given generation
+// like write
+func (c *Connector) writeNegative(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, generation uint64) error {
+	entry := cacheEntry{Negative: true, Generation: generation}
+	return c.writeEntryToFallback(ctx, ei, createCacheKey(ei, keys), entry, rowGeneration(ei, keys))
 }
 
 // Range returns range from origin, reverts to fallback if origin fails
@@ -218,20 +488,20 @@ func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnCondit
 
 	if sourceErr == nil {
 		w := func() error {
-			return c.writeKeyValueToFallback(ctx, ei, cacheKey, rangeResult)
+			return c.writeKeyValueToFallback(ctx, ei, cacheKey, rangeResult, rangeGeneration(ei, columnConditions))
 		}
 		_ = c.cacheWrite(w)
 
 		return sourceRows, sourceToken, sourceErr
 	}
 
-	value, err := c.getValueFromFallback(ctx, ei, cacheKey)
+	value, err := c.getValueFromFallback(ctx, ei, cacheKey, rangeGeneration(ei, columnConditions))
 	c.logFallback("RANGE", ei.Def.Name, err)
 	if err != nil {
 		return sourceRows, sourceToken, sourceErr
 	}
 	unpack := rangeResults{}
-	err = c.encoder.Decode(value, &unpack)
+	err = c.encoderFor(ei).Decode(value, &unpack)
 	if err != nil {
 		return sourceRows, sourceToken, sourceErr
 	}
@@ -247,11 +517,12 @@ func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields
 
 // MultiRead reads from fallback for the keys that failed
 // There are a few scenarios for the fallback:
-// 1. The original multiread call fails overall with an error XYZ. The fallback will try to read as many keys as possible.
+//  1. The original multiread call fails overall with an error XYZ. The fallback will try to read as many keys as possible.
 // - If none of the keys are in the fallback, the original XYZ error is returned.
 // - If there are partial successes, the fallback will return an array of dosa.FieldValuesOrError.
 //   The keys that are not found will have the original overall failure XYZ set as the error
-// 2. The original multiread does not have an error but some of the results have errors. The fallback will try to read
+//  2. The original multiread does not have an error but some of the results have errors. The fallback will try to read
+//
 // the keys that have an error and replace the failed result with the result from fallback. If the key is not in fallback,
 // do not modify the original result
 func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) (results []*dosa.FieldValuesOrError, err error) {
@@ -273,7 +544,7 @@ func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []m
 		w := func() error {
 			for idx, result := range source {
 				if result.Error == nil {
-					_ = c.write(ctx, ei, keys[idx], result.Values)
+					_ = c.write(ctx, ei, keys[idx], result.Values, 0)
 				}
 			}
 			return nil
@@ -321,50 +592,187 @@ func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []m
 	return source, sourceErr
 }
 
-// Remove deletes an entry
+// Remove deletes the entries of its partition from the fallback once removed from the origin
 func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
-	if c.isCacheable(ctx, ei) {
-		w := func() error {
-			return c.removeValueFromFallback(ctx, ei, createCacheKey(ei, keys))
+	err := c.Next.Remove(ctx, ei, keys)
+	c.invalidateRows(ctx, ei, keys)
+	return err
+}
+
+// RemoveRange deletes all the entries of the affected partition from the fallback once removed from the origin
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	err := c.Next.RemoveRange(ctx, ei, columnConditions)
+	c.invalidateRange(ctx, ei, columnConditions)
+	return err
+}
+
+// invalidateRange removes the rows and range results of the partition targeted by the conditions from the fallback
+func (c *Connector) invalidateRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) {
+	if !c.isCacheable(ctx, ei) {
+		return
+	}
+	w := func() error {
+		if partition, ok := partitionFromConditions(ei, columnConditions); ok {
+			_ = c.invalidateGeneration(ctx, ei, generationKey{Kind: partitionGeneration, Partition: partition})
 		}
-		_ = c.cacheWrite(w)
+		return c.invalidateGeneration(ctx, ei, generationKey{Kind: entityGeneration})
 	}
-
-	return c.Next.Remove(ctx, ei, keys)
+	_ = c.invalidate(ei, w)
 }
 
-// MultiUpsert deletes the entries getting upserted from the fallback if the entity is not in the skipWriteInvalidateEntitiesMap
+// MultiUpsert deletes the entries of the partitions getting upserted from the fallback once written to the origin,
+// if the entity is not in the skipWriteInvalidateEntitiesMap
 func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) (result []error, err error) {
-	if c.isCacheable(ctx, ei) {
-		w := func() error {
-			for _, values := range multiValues {
-				_ = c.removeValueFromFallback(ctx, ei, createCacheKey(ei, values))
+	result, err = c.Next.MultiUpsert(ctx, ei, multiValues)
+	c.invalidateRows(ctx, ei, multiValues...)
+	return result, err
+}
+
+// MultiRemove deletes the entries of the partitions of multiple rows from the fallback once removed from the origin
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) (result []error, err error) {
+	result, err = c.Next.MultiRemove(ctx, ei, multiKeys)
+	c.invalidateRows(ctx, ei, multiKeys...)
+	return result, err
+}
+
+// invalidateRows removes the given rows from the fallback, as well as every entry of their partitions and every
+// range result that may contain them. It runs once the rows are written to the origin, whether the write
+// succeeded or not, as a failed write may still have been applied: invalidating the entries before the write
+// would let a concurrent read cache the rows it overwrites.
+func (c *Connector) invalidateRows(ctx context.Context, ei *dosa.EntityInfo, rows ...map[string]dosa.FieldValue) {
+	if !c.isCacheable(ctx, ei) {
+		return
+	}
+	w := func() error {
+		invalidated := map[string]bool{}
+		for _, values := range rows {
+			_ = c.removeValueFromFallback(ctx, ei, createCacheKey(ei, values))
+			partition, ok := partitionFromValues(ei, values)
+			if !ok {
+				continue
+			}
+			// several rows may share the same partition
+			gen := generationKey{Kind: partitionGeneration, Partition: partition}
+			if encoded, err := c.encodeKey(ei, gen); err == nil && !invalidated[string(encoded)] {
+				invalidated[string(encoded)] = true
+				_ = c.invalidateGeneration(ctx, ei, gen)
 			}
-			return nil
 		}
-		_ = c.cacheWrite(w)
+		return c.invalidateGeneration(ctx, ei, generationKey{Kind: entityGeneration})
 	}
-	return c.Next.MultiUpsert(ctx, ei, multiValues)
+	_ = c.invalidate(ei, w)
 }
 
-// MultiRemove deletes multiple entries from the fallback
-func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) (result []error, err error) {
-	if c.isCacheable(ctx, ei) {
+// invalidate runs an invalidation of the fallback. It runs in the background, unless the reads of the entity
+// may be served from the fallback before going to the origin, from any endpoint: the invalidation must then
+// be done by the time the write returns, or the reads following it could get the entries it invalidates.
+func (c *Connector) invalidate(ei *dosa.EntityInfo, w func() error) error {
+	if c.negativeCacheTTL[ei.Def.Name] > 0 || c.maxStale[ei.Def.Name] > 0 {
+		return w()
+	}
+	return c.cacheWrite(w)
+}
+
+// invalidateGeneration invalidates every entry written under the current generation, by replacing it
+// with a new one
+func (c *Connector) invalidateGeneration(ctx context.Context, ei *dosa.EntityInfo, gen generationKey) error {
+	if c.shouldSkipInvalidateCacheOnWrite(ei) {
+		return nil
+	}
+	_, err := c.writeGeneration(ctx, ei, gen)
+	return err
+}
+
+// writeGeneration writes a new generation to the fallback and returns it
+func (c *Connector) writeGeneration(ctx context.Context, ei *dosa.EntityInfo, gen generationKey) (uint64, error) {
+	generation := newGeneration()
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, generation)
+	if err := c.putEntryToFallback(ctx, ei, gen, cacheEntry{Data: data}); err != nil {
+		return 0, err
+	}
+	return generation, nil
+}
+
+// readGeneration returns the current generation from the fallback, or 0 if there is none. No entry is
+// ever written under generation 0.
+func (c *Connector) readGeneration(ctx context.Context, ei *dosa.EntityInfo, gen generationKey) (uint64, error) {
+	newCtx, cancel := createContextForFallback(ctx)
+	defer cancel()
+	entry, err := c.getRawEntryFromFallback(newCtx, ei, gen)
+	if dosa.ErrorIsNotFound(err) || err == errStaleEntry {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(entry.Data) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(entry.Data), nil
+}
+
+// generationForWrite returns the generation to write new entries under, starting a new generation if
+// there is none in the fallback. A generation started concurrently by another process may replace this
+// one, in which case the entries written under it are invalidated.
+func (c *Connector) generationForWrite(ctx context.Context, ei *dosa.EntityInfo, gen generationKey) (uint64, error) {
+	generation, err := c.readGeneration(ctx, ei, gen)
+	if err != nil || generation != 0 {
+		return generation, err
+	}
+	return c.writeGeneration(ctx, ei, gen)
+}
+
+// newGeneration returns a random, non-zero generation. Generations are random rather than sequential so
+// that they can be replaced with a blind write, concurrently by several processes.
+func newGeneration() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if generation := binary.BigEndian.Uint64(b[:]); generation != 0 {
+			return generation
+		}
+	}
+}
+
+func (c *Connector) getValueFromFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}, gen generationKey) ([]byte, error) {
+	entry, err := c.getEntryFromFallback(ctx, ei, ckey, gen)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Negative {
+		return nil, errNegativeEntry
+	}
+	return entry.Data, nil
+}
+
+// getEntryFromFallback reads an entry from the fallback. Entries written under another generation than
+// the current one have been invalidated: they are evicted instead of being returned.
+func (c *Connector) getEntryFromFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}, gen generationKey) (*cacheEntry, error) {
+	entry, err := c.getRawEntryFromFallback(ctx, ei, ckey)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := c.readGeneration(ctx, ei, gen)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Generation != generation {
 		w := func() error {
-			for _, keys := range multiKeys {
-				_ = c.removeValueFromFallback(ctx, ei, createCacheKey(ei, keys))
-			}
-			return nil
+			return c.removeValueFromFallback(ctx, ei, ckey)
 		}
 		_ = c.cacheWrite(w)
+		return nil, errInvalidatedEntry
 	}
-
-	return c.Next.MultiRemove(ctx, ei, multiKeys)
+	return entry, nil
 }
 
-func (c *Connector) getValueFromFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}) ([]byte, error) {
+// getRawEntryFromFallback reads an entry from the fallback, regardless of its generation
+func (c *Connector) getRawEntryFromFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}) (*cacheEntry, error) {
 	adaptedEi := adaptToKeyValue(ei)
-	keyValue, err := c.encoder.Encode(ckey)
+	keyValue, err := c.encodeKey(ei, ckey)
 	if err != nil {
 		return nil, err
 	}
@@ -378,18 +786,30 @@ func (c *Connector) getValueFromFallback(ctx context.Context, ei *dosa.EntityInf
 	if !ok {
 		return nil, errors.New("No value in cache for key")
 	}
-	return cacheValue, nil
+	entry, err := decodePayload(ei, cacheValue)
+	if err != nil {
+		// the entry can't be served anymore, evict it
+		w := func() error {
+			return c.removeEncodedKeyFromFallback(ctx, ei, keyValue)
+		}
+		_ = c.cacheWrite(w)
+		return nil, err
+	}
+	return entry, nil
 }
 
 func (c *Connector) removeValueFromFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}) error {
 	if c.shouldSkipInvalidateCacheOnWrite(ei) {
 		return nil
 	}
-	cacheKey, err := c.encoder.Encode(ckey)
+	cacheKey, err := c.encodeKey(ei, ckey)
 	if err != nil {
 		return err
 	}
+	return c.removeEncodedKeyFromFallback(ctx, ei, cacheKey)
+}
 
+func (c *Connector) removeEncodedKeyFromFallback(ctx context.Context, ei *dosa.EntityInfo, cacheKey []byte) error {
 	newCtx, cancel := createContextForFallback(ctx)
 	defer cancel()
 
@@ -397,20 +817,38 @@ func (c *Connector) removeValueFromFallback(ctx context.Context, ei *dosa.Entity
 	return c.fallback.Remove(newCtx, adaptedEi, map[string]dosa.FieldValue{key: cacheKey})
 }
 
-func (c *Connector) writeKeyValueToFallback(ctx context.Context, ei *dosa.EntityInfo, ckey, cvalue interface{}) error {
-	cacheKey, err := c.encoder.Encode(ckey)
+func (c *Connector) writeKeyValueToFallback(ctx context.Context, ei *dosa.EntityInfo, ckey, cvalue interface{}, gen generationKey) error {
+	cacheValue, err := c.encoderFor(ei).Encode(cvalue)
 	if err != nil {
 		return err
 	}
+	return c.writeEntryToFallback(ctx, ei, ckey, cacheEntry{Data: cacheValue}, gen)
+}
+
+// writeEntryToFallback writes an entry to the fallback under its generation, or under the current one if it
+// has none
+func (c *Connector) writeEntryToFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}, entry cacheEntry, gen generationKey) error {
+	if entry.Generation == 0 {
+		generation, err := c.generationForWrite(ctx, ei, gen)
+		if err != nil {
+			return err
+		}
+		entry.Generation = generation
+	}
+	return c.putEntryToFallback(ctx, ei, ckey, entry)
+}
 
-	cacheValue, err := c.encoder.Encode(cvalue)
+// putEntryToFallback writes an entry to the fallback, stamped with the current time
+func (c *Connector) putEntryToFallback(ctx context.Context, ei *dosa.EntityInfo, ckey interface{}, entry cacheEntry) error {
+	cacheKey, err := c.encodeKey(ei, ckey)
 	if err != nil {
 		return err
 	}
 
+	entry.WrittenAt = c.now()
 	newValues := map[string]dosa.FieldValue{
 		key:   cacheKey,
-		value: cacheValue,
+		value: encodePayload(ei, entry),
 	}
 
 	newCtx, cancel := createContextForFallback(ctx)
@@ -443,6 +881,12 @@ func (c *Connector) cacheWrite(w func() error) error {
 	return nil
 }
 
+func (c *Connector) countFallback(method, entityName, counter string) {
+	if c.stats != nil {
+		c.stats.SubScope("fallback").Tagged(map[string]string{"method": method, "entityName": entityName}).Counter(counter).Inc(1)
+	}
+}
+
 func (c *Connector) shouldSkipInvalidateCacheOnWrite(ei *dosa.EntityInfo) bool {
 	return c.skipWriteInvalidateEntitiesMap[ei.Def.Name]
 }
@@ -462,6 +906,23 @@ func (c *Connector) isEndpointCacheable(ctx context.Context) bool {
 	return c.cacheableEndpointStatus[endpoint]
 }
 
+// negativeTTL returns how long not found results are cached for the entity and endpoint, 0 when disabled
+func (c *Connector) negativeTTL(ctx context.Context, ei *dosa.EntityInfo) time.Duration {
+	if len(c.negativeCachingEndpointStatus) != 0 && !c.negativeCachingEndpointStatus[GetContextEndpoint(ctx)] {
+		return 0
+	}
+	return c.negativeCacheTTL[ei.Def.Name]
+}
+
+// staleness returns the max staleness of the entries served while revalidating for the entity and
+// endpoint, 0 when disabled
+func (c *Connector) staleness(ctx context.Context, ei *dosa.EntityInfo) time.Duration {
+	if len(c.staleWhileRevalidateEndpointStatus) != 0 && !c.staleWhileRevalidateEndpointStatus[GetContextEndpoint(ctx)] {
+		return 0
+	}
+	return c.maxStale[ei.Def.Name]
+}
+
 func createCacheMapFromEntites(entities []dosa.DomainObject) map[string]bool {
 	set := map[string]bool{}
 	for _, e := range entities {
@@ -524,6 +985,117 @@ func createCacheKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) inte
 	return orderedKeys
 }
 
+// encoderFor returns the encoder of the values of an entity
+func (c *Connector) encoderFor(ei *dosa.EntityInfo) encoding.Encoder {
+	if c.newEntityEncoder == nil {
+		return c.encoder
+	}
+	name := fmt.Sprintf("%s:%d", ei.Def.Name, schemaVersion(ei))
+	c.entityEncodersMux.Lock()
+	defer c.entityEncodersMux.Unlock()
+	encoder, ok := c.entityEncoders[name]
+	if !ok {
+		encoder = c.newEntityEncoder(ei.Def)
+		c.entityEncoders[name] = encoder
+	}
+	return encoder
+}
+
+// encodeKey encodes a cache key, prefixed by the schema version of the entity so that
+// the entries written under another version of the schema are never read
+func (c *Connector) encodeKey(ei *dosa.EntityInfo, ckey interface{}) ([]byte, error) {
+	encoded, err := c.encoder.Encode(ckey)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(fmt.Sprintf("v%d:", schemaVersion(ei))), encoded...), nil
+}
+
+// encodePayload wraps an entry in the payload envelope
+func encodePayload(ei *dosa.EntityInfo, entry cacheEntry) []byte {
+	payload := make([]byte, payloadHeaderSize, payloadHeaderSize+len(entry.Data))
+	payload[0] = payloadMagic
+	payload[1] = payloadFormatVersion
+	binary.BigEndian.PutUint32(payload[2:6], uint32(schemaVersion(ei)))
+	if entry.Negative {
+		payload[6] |= payloadNegative
+	}
+	binary.BigEndian.PutUint64(payload[7:15], uint64(entry.WrittenAt.UnixNano()))
+	binary.BigEndian.PutUint64(payload[15:payloadHeaderSize], entry.Generation)
+	return append(payload, entry.Data...)
+}
+
+// decodePayload unwraps an entry from the payload envelope. It returns errStaleEntry if the
+// payload was written with another payload format or under another version of the schema.
+func decodePayload(ei *dosa.EntityInfo, payload []byte) (*cacheEntry, error) {
+	if len(payload) < payloadHeaderSize || payload[0] != payloadMagic || payload[1] != payloadFormatVersion {
+		return nil, errStaleEntry
+	}
+	if int32(binary.BigEndian.Uint32(payload[2:6])) != schemaVersion(ei) {
+		return nil, errStaleEntry
+	}
+	return &cacheEntry{
+		Negative:   payload[6]&payloadNegative != 0,
+		WrittenAt:  time.Unix(0, int64(binary.BigEndian.Uint64(payload[7:15]))),
+		Generation: binary.BigEndian.Uint64(payload[15:payloadHeaderSize]),
+		Data:       payload[payloadHeaderSize:],
+	}, nil
+}
+
+func schemaVersion(ei *dosa.EntityInfo) int32 {
+	if ei.Ref == nil {
+		return 0
+	}
+	return ei.Ref.Version
+}
+
+// returns the partition key values of a row, if they are all present
+func partitionFromValues(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) ([]map[string]dosa.FieldValue, bool) {
+	var partition []map[string]dosa.FieldValue
+	for _, pk := range sortedPartitionKeys(ei) {
+		v, ok := values[pk]
+		if !ok {
+			return nil, false
+		}
+		partition = append(partition, map[string]dosa.FieldValue{pk: v})
+	}
+	return partition, true
+}
+
+// returns the partition targeted by range conditions, if every partition key has an Eq condition
+func partitionFromConditions(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) ([]map[string]dosa.FieldValue, bool) {
+	values := map[string]dosa.FieldValue{}
+	for _, pk := range ei.Def.Key.PartitionKeys {
+		for _, cond := range columnConditions[pk] {
+			if cond.Op == dosa.Eq {
+				values[pk] = cond.Value
+			}
+		}
+	}
+	return partitionFromValues(ei, values)
+}
+
+// returns the key of the generation of a row
+func rowGeneration(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) generationKey {
+	partition, _ := partitionFromValues(ei, keys)
+	return generationKey{Kind: partitionGeneration, Partition: partition}
+}
+
+// returns the key of the generation of the results of a range query
+func rangeGeneration(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) generationKey {
+	if partition, ok := partitionFromConditions(ei, columnConditions); ok {
+		return generationKey{Kind: partitionGeneration, Partition: partition}
+	}
+	return generationKey{Kind: entityGeneration}
+}
+
+func sortedPartitionKeys(ei *dosa.EntityInfo) []string {
+	keys := make([]string, len(ei.Def.Key.PartitionKeys))
+	copy(keys, ei.Def.Key.PartitionKeys)
+	sort.Strings(keys)
+	return keys
+}
+
 func createContextForFallback(ctx context.Context) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(ctx, 5*time.Minute)
 }
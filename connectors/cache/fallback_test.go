@@ -22,6 +22,7 @@ package cache
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"sort"
 	"strings"
@@ -32,9 +33,11 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/conformance"
 	"github.com/uber-go/dosa/connectors/memory"
 	"github.com/uber-go/dosa/connectors/redis"
 	"github.com/uber-go/dosa/encoding"
+	"github.com/uber-go/dosa/metrics"
 	"github.com/uber-go/dosa/mocks"
 	"github.com/uber-go/dosa/testentity"
 )
@@ -59,6 +62,11 @@ var (
 		&testentity.TestEntity{},
 	}
 	encodedValue = []byte("Test encoding")
+	// encodedKey and encodedPayload are what gets stored in the fallback for encodedValue
+	encodedKey     = append([]byte("v0:"), encodedValue...)
+	encodedPayload = payloadOf(encodedValue)
+	testTime       = time.Unix(1500000000, 0)
+	testGeneration = uint64(1234)
 
 	i            = int32(7)
 	s            = "test decode"
@@ -169,6 +177,25 @@ func (e *argsEncoder) Decode(byt []byte, int interface{}) error {
 	return nil
 }
 
+// expectGenerations allows the fallback to be used for the generations of the cached entries. The current
+// generation is always testGeneration. It must be set after the specific expectations.
+func expectGenerations(mockFallback *mocks.MockConnector) {
+	generation := make([]byte, 8)
+	binary.BigEndian.PutUint64(generation, testGeneration)
+	current := map[string]dosa.FieldValue{value: encodePayload(testEi, cacheEntry{Data: generation})}
+	mockFallback.EXPECT().Read(gomock.Not(context.TODO()), adaptedEi, gomock.Any(), dosa.All()).Return(current, nil).AnyTimes()
+	mockFallback.EXPECT().Upsert(gomock.Not(context.TODO()), adaptedEi, gomock.Any()).Return(nil).AnyTimes()
+}
+
+// payloadOf returns what gets stored in the fallback for an encoded value written at testTime
+func payloadOf(data []byte) []byte {
+	return encodePayload(testEi, cacheEntry{WrittenAt: testTime, Generation: testGeneration, Data: data})
+}
+
+func testNow() time.Time {
+	return testTime
+}
+
 func withTestEncoder(encoder encoding.Encoder) Options {
 	return func(c *Connector) error {
 		c.encoder = encoder
@@ -176,6 +203,14 @@ func withTestEncoder(encoder encoding.Encoder) Options {
 	}
 }
 
+func newTestConnector(origin, fallback dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, options ...Options) *Connector {
+	c, err := NewConnector(origin, fallback, scope, entities, options...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
 func provideTestWithOptions(tc testCase, shouldskip bool, opts ...Options) func(t *testing.T) {
 	return func(t *testing.T) {
 		originCtrl := gomock.NewController(t)
@@ -188,10 +223,11 @@ func provideTestWithOptions(tc testCase, shouldskip bool, opts ...Options) func(
 		if tc.fallbackUpsert != nil && !shouldskip {
 			mockFallback.EXPECT().Remove(gomock.Not(context.TODO()), adaptedEi, tc.fallbackUpsert.values).Return(nil).MinTimes(1)
 		}
+		expectGenerations(mockFallback)
 
 		mockOrigin.EXPECT().Upsert(context.TODO(), testEi, tc.originUpsert.values).Return(tc.originUpsert.err)
 
-		connector := NewConnector(mockOrigin, mockFallback, nil, cacheableEntities, opts...)
+		connector := newTestConnector(mockOrigin, mockFallback, nil, cacheableEntities, opts...)
 		connector.setSynchronousMode(true)
 
 		err := connector.Upsert(context.TODO(), testEi, tc.originUpsert.values)
@@ -199,7 +235,7 @@ func provideTestWithOptions(tc testCase, shouldskip bool, opts ...Options) func(
 	}
 }
 
-// Test the option return error, and initialization should still be proceed
+// Test that an option returning an error fails the initialization
 func TestNewConnectorWithOptionsFailed(t *testing.T) {
 	originCtrl := gomock.NewController(t)
 	defer originCtrl.Finish()
@@ -213,10 +249,10 @@ func TestNewConnectorWithOptionsFailed(t *testing.T) {
 		return errors.New("Failed option")
 	}
 
-	connector := NewConnector(mockOrigin, mockFallback, nil, cacheableEntities, failOption,
+	connector, err := NewConnector(mockOrigin, mockFallback, nil, cacheableEntities, failOption,
 		WithSkipWriteInvalidateEntities(cacheableEntities...))
-
-	assert.NotNil(t, connector)
+	assert.EqualError(t, err, "Failed option")
+	assert.Nil(t, connector)
 }
 
 // Test dosa upsert and the various behaviors of the fallback
@@ -235,7 +271,7 @@ func TestUpsertCases(t *testing.T) {
 				}},
 			fallbackUpsert: &expectArgs{
 				values: map[string]dosa.FieldValue{
-					"key": encodedValue,
+					"key": encodedKey,
 				},
 			},
 			encoder: staticEncoder{},
@@ -263,7 +299,7 @@ func TestUpsertCases(t *testing.T) {
 			},
 			fallbackUpsert: &expectArgs{
 				values: map[string]dosa.FieldValue{
-					"key": encodedValue,
+					"key": encodedKey,
 				},
 			},
 			encoder:     staticEncoder{},
@@ -316,9 +352,11 @@ func TestMultiUpsertCases(t *testing.T) {
 			for _, args := range tc.fallbackArgs {
 				mockFallback.EXPECT().Remove(gomock.Not(context.TODO()), adaptedEi, args.values).Return(nil)
 			}
+			expectGenerations(mockFallback)
 
 			connector := newConnector(mockOrigin, mockFallback, nil, tc.encoder, tc.cachedEntities)
 			connector.setSynchronousMode(true)
+			connector.now = testNow
 			resp, err := connector.MultiUpsert(context.TODO(), testEi, tc.originArgs)
 			assert.Equal(t, tc.originResp, resp)
 			assert.Equal(t, tc.originErr, err)
@@ -337,8 +375,8 @@ func TestMultiUpsertCases(t *testing.T) {
 			originArgs:  multiupsertArgs,
 			originResp:  multiupsertResp,
 			fallbackArgs: []expectArgs{
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
 			},
 			cachedEntities: cacheableEntities,
 			encoder:        staticEncoder{},
@@ -360,8 +398,8 @@ func TestMultiUpsertCases(t *testing.T) {
 			originResp:  multiupsertResp,
 			originErr:   assert.AnError,
 			fallbackArgs: []expectArgs{
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
 			},
 			cachedEntities: cacheableEntities,
 			encoder:        staticEncoder{},
@@ -399,9 +437,11 @@ func TestMultiRemoveCases(t *testing.T) {
 			for _, args := range tc.fallbackArgs {
 				mockFallback.EXPECT().Remove(gomock.Not(context.TODO()), adaptedEi, args.values).Return(nil)
 			}
+			expectGenerations(mockFallback)
 
 			connector := newConnector(mockOrigin, mockFallback, nil, tc.encoder, tc.cachedEntities)
 			connector.setSynchronousMode(true)
+			connector.now = testNow
 			resp, err := connector.MultiRemove(context.TODO(), testEi, tc.originArgs)
 			assert.Equal(t, tc.originResp, resp)
 			assert.Equal(t, tc.originErr, err)
@@ -414,8 +454,8 @@ func TestMultiRemoveCases(t *testing.T) {
 			originArgs:  []map[string]dosa.FieldValue{{"a": "b"}, {"c": "d"}},
 			originResp:  []error{},
 			fallbackArgs: []expectArgs{
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
 			},
 			cachedEntities: cacheableEntities,
 			encoder:        staticEncoder{},
@@ -437,7 +477,7 @@ func TestMultiRemoveCases(t *testing.T) {
 			originResp:  []error{},
 			originErr:   assert.AnError,
 			fallbackArgs: []expectArgs{
-				{values: map[string]dosa.FieldValue{"key": encodedValue}},
+				{values: map[string]dosa.FieldValue{"key": encodedKey}},
 			},
 			cachedEntities: cacheableEntities,
 			encoder:        staticEncoder{},
@@ -456,7 +496,7 @@ func TestAsyncUpsert(t *testing.T) {
 		"StrV":        "test value string",
 		"BoolV":       false,
 	}
-	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities)
+	connector := newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities)
 	err := connector.Upsert(context.TODO(), testEi, values)
 	assert.NoError(t, err)
 }
@@ -480,9 +520,11 @@ func TestReadCases(t *testing.T) {
 			if tc.fallbackUpsert != nil {
 				mockFallback.EXPECT().Upsert(gomock.Not(context.TODO()), adaptedEi, tc.fallbackUpsert.values).Return(tc.fallbackUpsert.err)
 			}
+			expectGenerations(mockFallback)
 
 			connector := newConnector(mockOrigin, mockFallback, nil, tc.encoder, tc.cachedEntities)
 			connector.setSynchronousMode(true)
+			connector.now = testNow
 			resp, err := connector.Read(context.TODO(), testEi, tc.originRead.values, []string{})
 			assert.Equal(t, tc.expectedErr, err, tc.description)
 			assert.Equal(t, tc.expectedResp, resp, tc.description)
@@ -515,8 +557,8 @@ func createReadSuccessTestCase() testCase {
 		},
 		fallbackUpsert: &expectArgs{
 			values: map[string]dosa.FieldValue{
-				key:   encodedValue,
-				value: encodedValue,
+				key:   encodedKey,
+				value: encodedPayload,
 			},
 		},
 		expectedResp: map[string]dosa.FieldValue{"a": "b", "strkey": "primaryValue"},
@@ -548,8 +590,8 @@ func createReadFailTestCase() testCase {
 			err:    assert.AnError,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
-			resp:   map[string]dosa.FieldValue{"value": []byte("some response")},
+			values: map[string]dosa.FieldValue{key: encodedKey},
+			resp:   map[string]dosa.FieldValue{"value": payloadOf([]byte("some response"))},
 		},
 		expectedResp: decodedValueAsPointers,
 		expectedErr:  nil,
@@ -618,8 +660,8 @@ func createReadDecodeErrorTestCase() testCase {
 			err:    originErr,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
-			resp:   map[string]dosa.FieldValue{"value": []byte("some response")},
+			values: map[string]dosa.FieldValue{key: encodedKey},
+			resp:   map[string]dosa.FieldValue{"value": payloadOf([]byte("some response"))},
 		},
 		expectedResp: originResponse,
 		expectedErr:  originErr,
@@ -639,7 +681,7 @@ func createReadFallbackFailTestCase() testCase {
 			err:    originErr,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
+			values: map[string]dosa.FieldValue{key: encodedKey},
 			err:    errors.New("fallback error"),
 		},
 		expectedResp: originResponse,
@@ -663,7 +705,7 @@ func createReadFallbackBadValueTestCase() testCase {
 			err:    originErr,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
+			values: map[string]dosa.FieldValue{key: encodedKey},
 			// fallback returns a response with no value field
 			resp: nil,
 		},
@@ -696,7 +738,8 @@ func TestFallbackStats(t *testing.T) {
 		fallbackResp map[string]dosa.FieldValue
 		fallbackErr  error
 	}
-	connector := NewConnector(mockOrigin, mockFallback, mockStats, cacheableEntities)
+	connector := newTestConnector(mockOrigin, mockFallback, mockStats, cacheableEntities)
+	expectGenerations(mockFallback)
 
 	testCases := []testCase{
 		{
@@ -705,7 +748,7 @@ func TestFallbackStats(t *testing.T) {
 		},
 		{
 			counter:      "success",
-			fallbackResp: map[string]dosa.FieldValue{"value": []byte("{\"b\": 7}")},
+			fallbackResp: map[string]dosa.FieldValue{"value": payloadOf([]byte("{\"b\": 7}"))},
 		},
 	}
 	for _, t := range testCases {
@@ -741,9 +784,11 @@ func TestRangeCases(t *testing.T) {
 			if tc.fallbackUpsert != nil {
 				mockFallback.EXPECT().Upsert(gomock.Not(context.TODO()), adaptedEi, tc.fallbackUpsert.values).Return(tc.fallbackUpsert.err)
 			}
+			expectGenerations(mockFallback)
 
 			connector := newConnector(mockOrigin, mockFallback, nil, tc.encoder, tc.cachedEntities)
 			connector.setSynchronousMode(true)
+			connector.now = testNow
 
 			resp, tok, err := connector.Range(context.TODO(), testEi, tc.originRange.columnConditions, []string{}, tc.originRange.token, tc.originRange.limit)
 			assert.Equal(t, tc.expectedErr, err, tc.description)
@@ -782,8 +827,8 @@ func createRangeSuccessTestCase() testCase {
 		},
 		fallbackUpsert: &expectArgs{
 			values: map[string]dosa.FieldValue{
-				key:   encodedValue,
-				value: encodedValue,
+				key:   encodedKey,
+				value: encodedPayload,
 			},
 			err: nil,
 		},
@@ -828,8 +873,8 @@ func createRangeFailTestCase() testCase {
 			err:              assert.AnError,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
-			resp:   map[string]dosa.FieldValue{"value": []byte("b")},
+			values: map[string]dosa.FieldValue{key: encodedKey},
+			resp:   map[string]dosa.FieldValue{"value": payloadOf([]byte("b"))},
 		},
 		expectedErr:      nil,
 		expectedManyResp: []map[string]dosa.FieldValue{decodedValueAsPointers},
@@ -889,7 +934,7 @@ func createRangeDecodeErrorTestCase() testCase {
 	rangeResponse := []map[string]dosa.FieldValue{{"a": "b"}}
 	rangeTok := "nextToken"
 	rangeErr := errors.New("origin error")
-	fallbackResponse := map[string]dosa.FieldValue{"value": []byte("bad cache value")}
+	fallbackResponse := map[string]dosa.FieldValue{"value": payloadOf([]byte("bad cache value"))}
 
 	return testCase{
 		description:    "Bad decoding of fallback response should result in returning the original response",
@@ -903,7 +948,7 @@ func createRangeDecodeErrorTestCase() testCase {
 			err:              rangeErr,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
+			values: map[string]dosa.FieldValue{key: encodedKey},
 			resp:   fallbackResponse,
 		},
 		expectedErr:      rangeErr,
@@ -928,7 +973,7 @@ func createRangeFallbackFailTestCase() testCase {
 			err:       rangeErr,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
+			values: map[string]dosa.FieldValue{key: encodedKey},
 			err:    assert.AnError,
 		},
 		expectedErr:      rangeErr,
@@ -953,7 +998,7 @@ func createRangeFallbackBadValueTestCase() testCase {
 			err:       rangeErr,
 		},
 		fallbackRead: &expectArgs{
-			values: map[string]dosa.FieldValue{key: encodedValue},
+			values: map[string]dosa.FieldValue{key: encodedKey},
 			resp:   nil,
 		},
 		expectedErr:      rangeErr,
@@ -973,7 +1018,7 @@ func TestScan(t *testing.T) {
 	rangeTok := "nextToken"
 	mockOrigin.EXPECT().Range(context.TODO(), testEi, nil, dosa.All(), "token", 2).Return(rangeResponse, rangeTok, nil)
 
-	connector := NewConnector(mockOrigin, memory.NewConnector(), nil, nil)
+	connector := newTestConnector(mockOrigin, memory.NewConnector(), nil, nil)
 	resp, tok, err := connector.Scan(context.TODO(), testEi, []string{}, "token", 2)
 	assert.NoError(t, err)
 	assert.EqualValues(t, rangeResponse, resp)
@@ -993,29 +1038,544 @@ func TestRemove(t *testing.T) {
 	keys := map[string]dosa.FieldValue{}
 	mockOrigin.EXPECT().Remove(context.TODO(), testEi, keys).Return(assert.AnError)
 	mockFallback.EXPECT().Remove(gomock.Not(context.TODO()), adaptedEi, gomock.Any()).Return(nil)
+	expectGenerations(mockFallback)
 
-	connector := NewConnector(mockOrigin, mockFallback, nil, cacheableEntities)
+	connector := newTestConnector(mockOrigin, mockFallback, nil, cacheableEntities)
 	connector.setSynchronousMode(true)
 	err := connector.Remove(context.TODO(), testEi, keys)
 	assert.Error(t, err)
 }
 
-// Test that if a Connector interface method is not defined in fallback.Connector, revert to
-// using the origin's implementation of the method
+// Test that CreateIfNotExists invalidates the entry in the fallback before calling the origin
 func TestCreateIfNotExists(t *testing.T) {
 	originCtrl := gomock.NewController(t)
 	defer originCtrl.Finish()
 	mockOrigin := mocks.NewMockConnector(originCtrl)
 
+	fallbackCtrl := gomock.NewController(t)
+	defer fallbackCtrl.Finish()
+	mockFallback := mocks.NewMockConnector(fallbackCtrl)
+
 	values := map[string]dosa.FieldValue{}
 	mockOrigin.EXPECT().CreateIfNotExists(context.TODO(), testEi, values).Return(nil)
+	mockFallback.EXPECT().Remove(gomock.Not(context.TODO()), adaptedEi, gomock.Any()).Return(nil)
+	expectGenerations(mockFallback)
 
-	connector := NewConnector(mockOrigin, nil, nil, cacheableEntities)
+	connector := newTestConnector(mockOrigin, mockFallback, nil, cacheableEntities)
 	connector.setSynchronousMode(true)
 	err := connector.CreateIfNotExists(context.TODO(), testEi, values)
 	assert.NoError(t, err)
 }
 
+// Test that writes invalidate the rows and range results cached for the affected partition
+func TestPartitionInvalidation(t *testing.T) {
+	ctx := context.TODO()
+	partition := dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9")
+	rowKeys := func(strkey string) map[string]dosa.FieldValue {
+		return map[string]dosa.FieldValue{"an_uuid_key": partition, "strkey": strkey, "int64key": int64(1)}
+	}
+	conditions := map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: partition}},
+	}
+	type entry struct {
+		ckey interface{}
+		gen  generationKey
+	}
+	rowA := entry{createCacheKey(testEi, rowKeys("a")), rowGeneration(testEi, rowKeys("a"))}
+	rowB := entry{createCacheKey(testEi, rowKeys("b")), rowGeneration(testEi, rowKeys("b"))}
+	rangeEntry := entry{rangeQuery{Conditions: dosa.NormalizeConditions(conditions), Limit: 10}, rangeGeneration(testEi, conditions)}
+	otherRange := entry{rangeQuery{Limit: 10}, generationKey{Kind: entityGeneration}}
+
+	populate := func(t *testing.T) *Connector {
+		connector := newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities)
+		connector.setSynchronousMode(true)
+		for _, k := range []string{"a", "b"} {
+			values := rowKeys(k)
+			values["strv"] = "value " + k
+			assert.NoError(t, connector.Upsert(ctx, testEi, values))
+		}
+		for _, k := range []string{"a", "b"} {
+			_, err := connector.Read(ctx, testEi, rowKeys(k), nil)
+			assert.NoError(t, err)
+		}
+		_, _, err := connector.Range(ctx, testEi, conditions, nil, "", 10)
+		assert.NoError(t, err)
+		// the memory connector can't serve scans through Range, so write an entity-wide entry directly
+		assert.NoError(t, connector.writeKeyValueToFallback(ctx, testEi, otherRange.ckey, rangeResults{}, otherRange.gen))
+
+		for _, e := range []entry{rowA, rowB, rangeEntry, otherRange} {
+			_, err := connector.getValueFromFallback(ctx, testEi, e.ckey, e.gen)
+			assert.NoError(t, err, "%v should be cached", e.ckey)
+		}
+		return connector
+	}
+	assertCached := func(t *testing.T, connector *Connector, e entry, cached bool) {
+		_, err := connector.getValueFromFallback(ctx, testEi, e.ckey, e.gen)
+		if cached {
+			assert.NoError(t, err, "%v should be cached", e.ckey)
+		} else {
+			assert.Error(t, err, "%v should not be cached", e.ckey)
+		}
+	}
+
+	t.Run("upsert", func(t *testing.T) {
+		connector := populate(t)
+		assert.NoError(t, connector.Upsert(ctx, testEi, rowKeys("a")))
+		assertCached(t, connector, rowA, false)
+		assertCached(t, connector, rowB, false)
+		assertCached(t, connector, rangeEntry, false)
+		assertCached(t, connector, otherRange, false)
+	})
+	t.Run("create if not exists", func(t *testing.T) {
+		connector := populate(t)
+		assert.NoError(t, connector.CreateIfNotExists(ctx, testEi, rowKeys("c")))
+		assertCached(t, connector, rowA, false)
+		assertCached(t, connector, rangeEntry, false)
+		assertCached(t, connector, otherRange, false)
+	})
+	t.Run("remove range", func(t *testing.T) {
+		connector := populate(t)
+		assert.NoError(t, connector.RemoveRange(ctx, testEi, conditions))
+		assertCached(t, connector, rowA, false)
+		assertCached(t, connector, rowB, false)
+		assertCached(t, connector, rangeEntry, false)
+		assertCached(t, connector, otherRange, false)
+	})
+	t.Run("skip invalidation", func(t *testing.T) {
+		connector := populate(t)
+		connector.skipWriteInvalidateEntitiesMap = map[string]bool{testEi.Def.Name: true}
+		assert.NoError(t, connector.RemoveRange(ctx, testEi, conditions))
+		assertCached(t, connector, rowA, true)
+		assertCached(t, connector, rangeEntry, true)
+	})
+}
+
+// Test that the entries written under an older generation are not served, whichever process replaced it
+func TestGenerations(t *testing.T) {
+	ctx := context.TODO()
+	fallback := memory.NewConnector()
+	writer := newTestConnector(memory.NewConnector(), fallback, nil, cacheableEntities)
+	writer.setSynchronousMode(true)
+	reader := newTestConnector(memory.NewConnector(), fallback, nil, cacheableEntities)
+	reader.setSynchronousMode(true)
+
+	ckey := rangeQuery{Limit: 10}
+	gen := generationKey{Kind: entityGeneration}
+	assert.NoError(t, reader.writeKeyValueToFallback(ctx, testEi, ckey, rangeResults{}, gen))
+	_, err := reader.getValueFromFallback(ctx, testEi, ckey, gen)
+	assert.NoError(t, err)
+
+	// a write through another connector invalidates the entry
+	writer.invalidateRows(ctx, testEi, map[string]dosa.FieldValue{"strkey": "a"})
+	_, err = reader.getValueFromFallback(ctx, testEi, ckey, gen)
+	assert.Equal(t, errInvalidatedEntry, err)
+	_, err = reader.getValueFromFallback(ctx, testEi, ckey, gen)
+	assert.True(t, dosa.ErrorIsNotFound(err), "invalidated entries should be evicted")
+
+	// an entry outliving its generation is never served again
+	assert.NoError(t, reader.writeKeyValueToFallback(ctx, testEi, ckey, rangeResults{}, gen))
+	assert.NoError(t, reader.removeValueFromFallback(ctx, testEi, gen))
+	_, err = reader.getValueFromFallback(ctx, testEi, ckey, gen)
+	assert.Equal(t, errInvalidatedEntry, err)
+}
+
+// Test that the entries of the fallback are only served under the schema version and payload format they were written with
+func TestSchemaVersionedEntries(t *testing.T) {
+	ctx := context.TODO()
+	fallback := memory.NewConnector()
+	connector := newTestConnector(memory.NewConnector(), fallback, nil, cacheableEntities)
+	connector.setSynchronousMode(true)
+
+	withVersion := func(version int32) *dosa.EntityInfo {
+		ref := *testEi.Ref
+		ref.Version = version
+		return &dosa.EntityInfo{Def: testEi.Def, Ref: &ref}
+	}
+	keys := map[string]dosa.FieldValue{"strkey": "primaryValue"}
+	ckey := createCacheKey(testEi, keys)
+	gen := rowGeneration(testEi, keys)
+	assert.NoError(t, connector.writeKeyValueToFallback(ctx, withVersion(1), ckey, decodedValue, gen))
+
+	_, err := connector.getValueFromFallback(ctx, withVersion(1), ckey, gen)
+	assert.NoError(t, err)
+	_, err = connector.getValueFromFallback(ctx, withVersion(2), ckey, gen)
+	assert.True(t, dosa.ErrorIsNotFound(err), "entries of another schema version should not be read")
+
+	// an entry without the payload envelope is evicted when read
+	encodedKey, err := connector.encodeKey(withVersion(1), ckey)
+	assert.NoError(t, err)
+	legacy := map[string]dosa.FieldValue{key: encodedKey, value: []byte("legacy value")}
+	assert.NoError(t, fallback.Upsert(ctx, adaptToKeyValue(withVersion(1)), legacy))
+	_, err = connector.getValueFromFallback(ctx, withVersion(1), ckey, gen)
+	assert.Equal(t, errStaleEntry, err)
+	_, err = connector.getValueFromFallback(ctx, withVersion(1), ckey, gen)
+	assert.True(t, dosa.ErrorIsNotFound(err), "stale entries should be evicted")
+}
+
+func TestDecodePayload(t *testing.T) {
+	entry := cacheEntry{Negative: true, WrittenAt: testTime, Generation: 42, Data: []byte("data")}
+	ei := createTestEi(dosa.SchemaRef{Scope: "testing", NamePrefix: "example", Version: 3})
+
+	decoded, err := decodePayload(ei, encodePayload(ei, entry))
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Data, decoded.Data)
+	assert.True(t, decoded.Negative)
+	assert.True(t, testTime.Equal(decoded.WrittenAt))
+	assert.Equal(t, uint64(42), decoded.Generation)
+
+	_, err = decodePayload(testEi, encodePayload(ei, entry))
+	assert.Equal(t, errStaleEntry, err)
+
+	payload := encodePayload(ei, entry)
+	payload[1] = payloadFormatVersion - 1
+	_, err = decodePayload(ei, payload)
+	assert.Equal(t, errStaleEntry, err)
+
+	_, err = decodePayload(ei, entry.Data)
+	assert.Equal(t, errStaleEntry, err)
+}
+
+// Test that not found results from the origin are served from the fallback until they expire or get invalidated
+func TestNegativeCaching(t *testing.T) {
+	ctx := context.TODO()
+	keys := map[string]dosa.FieldValue{"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "strkey": "a", "int64key": int64(1)}
+
+	originCtrl := gomock.NewController(t)
+	defer originCtrl.Finish()
+	mockOrigin := mocks.NewMockConnector(originCtrl)
+
+	now := testTime
+	connector := newTestConnector(mockOrigin, memory.NewConnector(), nil, cacheableEntities,
+		WithNegativeCaching(time.Minute, &testentity.TestEntity{}),
+		SetNegativeCachingEndpoints("negative"))
+	connector.setSynchronousMode(true)
+	connector.now = func() time.Time { return now }
+	negativeCtx := SetContextEndpoint(ctx, "negative")
+
+	// the first read goes to the origin, the second one is served from the fallback
+	mockOrigin.EXPECT().Read(negativeCtx, testEi, keys, dosa.All()).Return(nil, &dosa.ErrNotFound{})
+	for i := 0; i < 2; i++ {
+		_, err := connector.Read(negativeCtx, testEi, keys, nil)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+	}
+
+	// other endpoints always go to the origin
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(nil, &dosa.ErrNotFound{}).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := connector.Read(ctx, testEi, keys, nil)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+	}
+
+	// the negative entry expires
+	now = now.Add(time.Minute)
+	mockOrigin.EXPECT().Read(negativeCtx, testEi, keys, dosa.All()).Return(nil, &dosa.ErrNotFound{})
+	_, err := connector.Read(negativeCtx, testEi, keys, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	// writes invalidate the negative entry
+	mockOrigin.EXPECT().Upsert(negativeCtx, testEi, keys).Return(nil)
+	assert.NoError(t, connector.Upsert(negativeCtx, testEi, keys))
+	mockOrigin.EXPECT().Read(negativeCtx, testEi, keys, dosa.All()).Return(map[string]dosa.FieldValue{"strv": "created"}, nil)
+	values, err := connector.Read(negativeCtx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "created", values["strv"])
+
+	// negative entries are not served when the origin fails
+	mockOrigin.EXPECT().Upsert(negativeCtx, testEi, keys).Return(nil)
+	assert.NoError(t, connector.Upsert(negativeCtx, testEi, keys))
+	assert.NoError(t, connector.writeNegative(ctx, testEi, keys, 0))
+	_, err = connector.getValueFromFallback(ctx, testEi, createCacheKey(testEi, keys), rowGeneration(testEi, keys))
+	assert.Equal(t, errNegativeEntry, err)
+}
+
+// Test that the reads following a write get the written row when the fallback is read first, even though the
+// fallback is otherwise written in the background
+func TestWriteThenReadFallbackFirst(t *testing.T) {
+	ctx := context.TODO()
+	keys := map[string]dosa.FieldValue{"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "strkey": "a", "int64key": int64(1)}
+	row := func(strv string) map[string]dosa.FieldValue {
+		values := map[string]dosa.FieldValue{"strv": strv}
+		for k, v := range keys {
+			values[k] = v
+		}
+		return values
+	}
+	options := map[string]Options{
+		"negative caching":       WithNegativeCaching(time.Minute, &testentity.TestEntity{}),
+		"stale while revalidate": WithStaleWhileRevalidate(time.Minute, &testentity.TestEntity{}),
+	}
+	for name, option := range options {
+		t.Run(name, func(t *testing.T) {
+			connector := newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities, option)
+			// cache the missing row, then the first version of the row
+			connector.setSynchronousMode(true)
+			_, err := connector.Read(ctx, testEi, keys, nil)
+			assert.True(t, dosa.ErrorIsNotFound(err))
+			connector.setSynchronousMode(false)
+
+			assert.NoError(t, connector.CreateIfNotExists(ctx, testEi, row("created")))
+			values, err := connector.Read(ctx, testEi, keys, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, "created", values["strv"])
+
+			connector.setSynchronousMode(true)
+			_, err = connector.Read(ctx, testEi, keys, nil)
+			assert.NoError(t, err)
+			connector.setSynchronousMode(false)
+
+			assert.NoError(t, connector.Upsert(ctx, testEi, row("updated")))
+			values, err = connector.Read(ctx, testEi, keys, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, "updated", values["strv"])
+		})
+	}
+}
+
+// interleavedOrigin runs a function, such as a write, once the next read of the origin is done
+type interleavedOrigin struct {
+	dosa.Connector
+	afterRead func()
+}
+
+func (o *interleavedOrigin) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	values, err := o.Connector.Read(ctx, ei, keys, minimumFields)
+	o.interleave()
+	return values, err
+}
+
+func (o *interleavedOrigin) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	results, err := o.Connector.MultiRead(ctx, ei, keys, minimumFields)
+	o.interleave()
+	return results, err
+}
+
+func (o *interleavedOrigin) interleave() {
+	if afterRead := o.afterRead; afterRead != nil {
+		o.afterRead = nil
+		afterRead()
+	}
+}
+
+// Test that the rows read from the origin are not served from the fallback if they were written to the origin
+// while being read, when the cache write lands after the invalidation
+func TestWriteDuringReadFallbackFirst(t *testing.T) {
+	ctx := context.TODO()
+	keys := map[string]dosa.FieldValue{"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "strkey": "a", "int64key": int64(1)}
+	row := func(strv string) map[string]dosa.FieldValue {
+		values := map[string]dosa.FieldValue{"strv": strv}
+		for k, v := range keys {
+			values[k] = v
+		}
+		return values
+	}
+
+	t.Run("negative caching", func(t *testing.T) {
+		origin := &interleavedOrigin{Connector: memory.NewConnector()}
+		connector := newTestConnector(origin, memory.NewConnector(), nil, cacheableEntities,
+			WithNegativeCaching(time.Minute, &testentity.TestEntity{}))
+		connector.setSynchronousMode(true)
+		origin.afterRead = func() {
+			assert.NoError(t, connector.CreateIfNotExists(ctx, testEi, row("created")))
+		}
+		_, err := connector.Read(ctx, testEi, keys, nil)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+
+		values, err := connector.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "created", values["strv"])
+	})
+	t.Run("stale while revalidate", func(t *testing.T) {
+		origin := &interleavedOrigin{Connector: memory.NewConnector()}
+		connector := newTestConnector(origin, memory.NewConnector(), nil, cacheableEntities,
+			WithStaleWhileRevalidate(time.Minute, &testentity.TestEntity{}))
+		connector.setSynchronousMode(true)
+		assert.NoError(t, connector.CreateIfNotExists(ctx, testEi, row("created")))
+		_, err := connector.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+
+		// the entry is served and refreshed with the row read before the upsert
+		origin.afterRead = func() {
+			assert.NoError(t, connector.Upsert(ctx, testEi, row("updated")))
+		}
+		values, err := connector.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "created", *values["strv"].(*string))
+
+		values, err = connector.Read(ctx, testEi, keys, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "updated", values["strv"])
+	})
+}
+
+// Test that entries are served from the fallback while being refreshed from the origin
+func TestStaleWhileRevalidate(t *testing.T) {
+	ctx := context.TODO()
+	keys := map[string]dosa.FieldValue{"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "strkey": "a", "int64key": int64(1)}
+	row := func(v string) map[string]dosa.FieldValue {
+		return map[string]dosa.FieldValue{"strv": v}
+	}
+	strv := func(values map[string]dosa.FieldValue) string {
+		if v, ok := values["strv"].(*string); ok {
+			return *v
+		}
+		return fmt.Sprintf("%v", values["strv"])
+	}
+
+	originCtrl := gomock.NewController(t)
+	defer originCtrl.Finish()
+	mockOrigin := mocks.NewMockConnector(originCtrl)
+
+	now := testTime
+	connector := newTestConnector(mockOrigin, memory.NewConnector(), nil, cacheableEntities,
+		WithStaleWhileRevalidate(time.Hour, &testentity.TestEntity{}))
+	connector.setSynchronousMode(true)
+	connector.now = func() time.Time { return now }
+
+	// the first read populates the fallback
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(row("v1"), nil)
+	values, err := connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", strv(values))
+
+	// the second read is served from the fallback, and refreshes it
+	mockOrigin.EXPECT().Read(gomock.Not(ctx), testEi, keys, dosa.All()).Return(row("v2"), nil)
+	values, err = connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", strv(values))
+
+	// a failed refresh keeps the entry
+	mockOrigin.EXPECT().Read(gomock.Not(ctx), testEi, keys, dosa.All()).Return(nil, assert.AnError)
+	values, err = connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", strv(values))
+
+	// the refresh removes rows that no longer exist
+	mockOrigin.EXPECT().Read(gomock.Not(ctx), testEi, keys, dosa.All()).Return(nil, &dosa.ErrNotFound{})
+	values, err = connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", strv(values))
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(row("v3"), nil)
+	values, err = connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v3", strv(values))
+
+	// entries older than the max staleness are read from the origin
+	now = now.Add(time.Hour)
+	mockOrigin.EXPECT().Read(ctx, testEi, keys, dosa.All()).Return(row("v4"), nil)
+	values, err = connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v4", strv(values))
+}
+
+func TestReadFallbackFirstOptions(t *testing.T) {
+	_, err := NewConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities,
+		WithNegativeCaching(0, &testentity.TestEntity{}))
+	assert.EqualError(t, err, "negative caching ttl must be positive")
+	_, err = NewConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities,
+		WithStaleWhileRevalidate(-time.Second, &testentity.TestEntity{}))
+	assert.EqualError(t, err, "stale-while-revalidate max staleness must be positive")
+
+	c := newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, cacheableEntities,
+		WithNegativeCaching(time.Second, &testentity.TestEntity{}),
+		WithStaleWhileRevalidate(time.Minute, &testentity.TestEntity{}),
+		SetStaleWhileRevalidateEndpoints("swr"))
+	assert.Equal(t, time.Second, c.negativeTTL(context.TODO(), testEi))
+	assert.Equal(t, time.Duration(0), c.staleness(context.TODO(), testEi))
+	assert.Equal(t, time.Minute, c.staleness(SetContextEndpoint(context.TODO(), "swr"), testEi))
+}
+
+// Test that rows are served from the fallback with their original types when using the typed json encoder
+func TestFallbackWithTypedJSONEncoder(t *testing.T) {
+	ctx := context.TODO()
+	ts := time.Date(2020, 5, 29, 12, 30, 15, 123456789, time.UTC)
+	keys := map[string]dosa.FieldValue{"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "strkey": "a", "int64key": int64(1)}
+	row := map[string]dosa.FieldValue{"int32v": int32(7), "doublev": 0.1, "blobv": []byte{0, 255}, "tsv": ts}
+	for k, v := range keys {
+		row[k] = v
+	}
+	origin := memory.NewConnector()
+	assert.NoError(t, origin.Upsert(ctx, testEi, row))
+
+	connector := newTestConnector(origin, memory.NewConnector(), nil, cacheableEntities, WithEncoder(encoding.NewTypedJSONEncoder()))
+	connector.setSynchronousMode(true)
+	_, err := connector.Read(ctx, testEi, keys, nil)
+	assert.NoError(t, err)
+
+	// serve the read from the fallback, as if the origin failed
+	values, err := connector.read(ctx, testEi, keys, nil, assert.AnError, "READ")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7), *values["int32v"].(*int32))
+	assert.Equal(t, 0.1, *values["doublev"].(*float64))
+	assert.Equal(t, []byte{0, 255}, values["blobv"])
+	assert.Equal(t, ts, *values["tsv"].(*time.Time))
+	assert.Equal(t, keys["an_uuid_key"], *values["an_uuid_key"].(*dosa.UUID))
+
+	_, err = NewConnector(origin, memory.NewConnector(), nil, cacheableEntities, WithEncoder(nil))
+	assert.EqualError(t, err, "nil encoder")
+}
+
+// counterEntity is cached alongside testentity.TestEntity, to check the encoders per entity
+type counterEntity struct {
+	dosa.Entity `dosa:"name=counters,primaryKey=(Name)"`
+	Name        string
+	Count       int64
+}
+
+// Test that the rows of several entities are served from the fallback when encoded with a schema encoder per
+// entity, and that they take less space than with gob
+func TestFallbackWithEntityEncoder(t *testing.T) {
+	ctx := context.TODO()
+	table, err := dosa.TableFromInstance(&counterEntity{})
+	assert.NoError(t, err)
+	counterEi := &dosa.EntityInfo{Ref: &schemaRef, Def: &table.EntityDefinition}
+	testKeys := map[string]dosa.FieldValue{"an_uuid_key": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "strkey": "a", "int64key": int64(1)}
+	testRow := map[string]dosa.FieldValue{"int32v": int32(7), "strv": "test value", "boolv": true}
+	for k, v := range testKeys {
+		testRow[k] = v
+	}
+	counterKeys := map[string]dosa.FieldValue{"name": "visits"}
+	counterRow := map[string]dosa.FieldValue{"name": "visits", "count": int64(42)}
+
+	origin := memory.NewConnector()
+	assert.NoError(t, origin.Upsert(ctx, testEi, testRow))
+	assert.NoError(t, origin.Upsert(ctx, counterEi, counterRow))
+	entities := []dosa.DomainObject{&testentity.TestEntity{}, &counterEntity{}}
+
+	// cacheRows caches both rows, and returns the size of what's stored in the fallback for them
+	cacheRows := func(t *testing.T, options ...Options) (*Connector, int) {
+		fallback := memory.NewConnector()
+		connector := newTestConnector(origin, fallback, nil, entities, options...)
+		connector.setSynchronousMode(true)
+		size := 0
+		for ei, keys := range map[*dosa.EntityInfo]map[string]dosa.FieldValue{testEi: testKeys, counterEi: counterKeys} {
+			_, err := connector.Read(ctx, ei, keys, nil)
+			assert.NoError(t, err)
+			encodedKey, err := connector.encodeKey(ei, createCacheKey(ei, keys))
+			assert.NoError(t, err)
+			stored, err := fallback.Read(ctx, adaptToKeyValue(ei), map[string]dosa.FieldValue{key: encodedKey}, dosa.All())
+			if assert.NoError(t, err, "the row of %s should be cached", ei.Def.Name) {
+				size += len(stored[value].([]byte))
+			}
+		}
+		return connector, size
+	}
+
+	connector, size := cacheRows(t, WithEntityEncoder(encoding.NewSchemaEncoder))
+	_, gobSize := cacheRows(t)
+	assert.True(t, size < gobSize, "schema encoded rows take %d bytes, gob %d", size, gobSize)
+
+	// serve the reads from the fallback, as if the origin failed
+	values, err := connector.read(ctx, testEi, testKeys, nil, assert.AnError, "READ")
+	assert.NoError(t, err)
+	assert.Equal(t, "test value", *values["strv"].(*string))
+	values, err = connector.read(ctx, counterEi, counterKeys, nil, assert.AnError, "READ")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), *values["count"].(*int64))
+
+	_, err = NewConnector(origin, memory.NewConnector(), nil, entities, WithEntityEncoder(nil))
+	assert.EqualError(t, err, "nil entity encoder")
+}
+
 // Test read and write against actual redis fallback.
 // First read successfully to origin, which should populate the entry into redis cache
 // Then force origin to fail, and verify that it returns the value from redis
@@ -1078,7 +1638,7 @@ func TestFallbackEndToEnd(t *testing.T) {
 	// Fake origin read failing, and then read from redis
 	mockDownstreamConnector.EXPECT().Read(context.TODO(), testEi, values, dosa.All()).Return(nil, assert.AnError)
 
-	connector := NewConnector(mockDownstreamConnector, redisC, nil, cacheableEntities)
+	connector := newTestConnector(mockDownstreamConnector, redisC, nil, cacheableEntities)
 	connector.setSynchronousMode(true)
 
 	_, err := connector.Read(context.TODO(), testEi, values, nil)
@@ -1138,11 +1698,11 @@ func TestCacheableEntities(t *testing.T) {
 
 // Test setting cacheable endpoints
 func TestCacheableEndpoints(t *testing.T) {
-	c := NewConnector(memory.NewConnector(), memory.NewConnector(), nil, nil)
+	c := newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, nil)
 	assert.Len(t, c.cacheableEndpointStatus, 0)
 
 	endpoints := []string{"getEaterPromotions", "getPromotionsForStores"}
-	c = NewConnector(memory.NewConnector(), memory.NewConnector(), nil, nil, SetCacheableEndpoints(endpoints...))
+	c = newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, nil, SetCacheableEndpoints(endpoints...))
 	assert.Len(t, c.cacheableEndpointStatus, 2)
 
 	// Test context key setters and getters
@@ -1154,8 +1714,8 @@ func TestCacheableEndpoints(t *testing.T) {
 }
 
 func TestWriteKeyValueToFallback(t *testing.T) {
-	connector := NewConnector(memory.NewConnector(), memory.NewConnector(), nil, nil)
-	err := connector.writeKeyValueToFallback(context.TODO(), testEi, "a", nil)
+	connector := newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, nil)
+	err := connector.writeKeyValueToFallback(context.TODO(), testEi, "a", nil, generationKey{})
 	// Should error on being unable to encode nil value
 	assert.Error(t, err)
 }
@@ -1206,9 +1766,11 @@ func TestMultiReadCases(t *testing.T) {
 			for _, args := range tc.fallbackUpsertArgs {
 				mockFallback.EXPECT().Upsert(gomock.Not(context.TODO()), adaptedEi, args.values).Return(args.err)
 			}
+			expectGenerations(mockFallback)
 
 			connector := newConnector(mockOrigin, mockFallback, nil, tc.encoder, tc.cachedEntities)
 			connector.setSynchronousMode(true)
+			connector.now = testNow
 			resp, err := connector.MultiRead(context.TODO(), testEi, tc.keys, []string{})
 			assert.Equal(t, tc.expectedErr, err, tc.description)
 			assert.Equal(t, tc.expectedResp, resp, tc.description)
@@ -1240,7 +1802,7 @@ func TestMultiReadCases(t *testing.T) {
 			originErr:      assert.AnError,
 			fallbackReadArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": encodedValue},
+					values: map[string]dosa.FieldValue{"key": encodedKey},
 					err:    fmt.Errorf("Fallback error"),
 				},
 			},
@@ -1256,7 +1818,7 @@ func TestMultiReadCases(t *testing.T) {
 			originErr:      nil,
 			fallbackUpsertArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": encodedValue, "value": encodedValue},
+					values: map[string]dosa.FieldValue{"key": encodedKey, "value": encodedPayload},
 					err:    nil,
 				},
 			},
@@ -1272,7 +1834,7 @@ func TestMultiReadCases(t *testing.T) {
 			originErr:      assert.AnError,
 			fallbackReadArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": encodedValue},
+					values: map[string]dosa.FieldValue{"key": encodedKey},
 					resp:   nil,
 					err:    nil,
 				},
@@ -1289,8 +1851,8 @@ func TestMultiReadCases(t *testing.T) {
 			originErr:      assert.AnError,
 			fallbackReadArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": encodedValue},
-					resp:   map[string]dosa.FieldValue{"value": []byte("some response")},
+					values: map[string]dosa.FieldValue{"key": encodedKey},
+					resp:   map[string]dosa.FieldValue{"value": payloadOf([]byte("some response"))},
 					err:    nil,
 				},
 			},
@@ -1308,12 +1870,12 @@ func TestMultiReadCases(t *testing.T) {
 			// Try to read two entries from cache, one succeeds, one has error
 			fallbackReadArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": []byte("int64key|20")},
-					resp:   map[string]dosa.FieldValue{"value": []byte("some response")},
+					values: map[string]dosa.FieldValue{"key": []byte("v0:int64key|20")},
+					resp:   map[string]dosa.FieldValue{"value": payloadOf([]byte("some response"))},
 					err:    nil,
 				},
 				{
-					values: map[string]dosa.FieldValue{"key": []byte("strkey|anotherKeyValue")},
+					values: map[string]dosa.FieldValue{"key": []byte("v0:strkey|anotherKeyValue")},
 					resp:   nil,
 					err:    assert.AnError,
 				},
@@ -1321,7 +1883,7 @@ func TestMultiReadCases(t *testing.T) {
 			// Should try to write one entry to cache
 			fallbackUpsertArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": []byte("strkey|primaryValue"), "value": []byte("c|d|strkey|primaryValue")},
+					values: map[string]dosa.FieldValue{"key": []byte("v0:strkey|primaryValue"), "value": payloadOf([]byte("c|d|strkey|primaryValue"))},
 					err:    nil,
 				},
 			},
@@ -1345,12 +1907,12 @@ func TestMultiReadCases(t *testing.T) {
 			// Should try to read two entries from cache, but cache has no value or errors
 			fallbackReadArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": []byte("int64key|20")},
+					values: map[string]dosa.FieldValue{"key": []byte("v0:int64key|20")},
 					resp:   nil,
 					err:    nil,
 				},
 				{
-					values: map[string]dosa.FieldValue{"key": []byte("strkey|anotherKeyValue")},
+					values: map[string]dosa.FieldValue{"key": []byte("v0:strkey|anotherKeyValue")},
 					resp:   nil,
 					err:    assert.AnError,
 				},
@@ -1358,7 +1920,7 @@ func TestMultiReadCases(t *testing.T) {
 			// Should try to write one entry to cache
 			fallbackUpsertArgs: []expectArgs{
 				{
-					values: map[string]dosa.FieldValue{"key": []byte("strkey|primaryValue"), "value": []byte("c|d|strkey|primaryValue")},
+					values: map[string]dosa.FieldValue{"key": []byte("v0:strkey|primaryValue"), "value": payloadOf([]byte("c|d|strkey|primaryValue"))},
 					err:    nil,
 				},
 			},
@@ -1370,3 +1932,31 @@ func TestMultiReadCases(t *testing.T) {
 		runTestCase(tc)
 	}
 }
+
+// conformanceAllTypes and conformanceEvents make the entities of the conformance suite cacheable,
+// which is decided by entity name
+type conformanceAllTypes struct {
+	dosa.Entity `dosa:"name=alltypes,primaryKey=(ID)"`
+	ID          string
+}
+
+type conformanceEvents struct {
+	dosa.Entity `dosa:"name=events,primaryKey=(Owner, Day, Seq DESC)"`
+	Owner       string
+	Day         int64
+	Seq         int64
+}
+
+var conformanceEntities = []dosa.DomainObject{&conformanceAllTypes{}, &conformanceEvents{}}
+
+func TestConformance(t *testing.T) {
+	conformance.RunSuite(t, func() dosa.Connector {
+		return newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, conformanceEntities)
+	}, conformance.Skip(conformance.TestScan)) // Scan is a Range without conditions on the origin, see TestScan
+}
+
+func TestConformanceWithEntityEncoder(t *testing.T) {
+	conformance.RunSuite(t, func() dosa.Connector {
+		return newTestConnector(memory.NewConnector(), memory.NewConnector(), nil, conformanceEntities, WithEntityEncoder(encoding.NewSchemaEncoder))
+	}, conformance.Skip(conformance.TestScan))
+}
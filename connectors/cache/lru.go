@@ -0,0 +1,577 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/encoding"
+	"github.com/uber-go/dosa/metrics"
+)
+
+const (
+	defaultLRUMaxEntries = 10000
+	defaultLRUTTL        = time.Minute
+)
+
+// LRUOptions returns a function that's being used for LRU connector initialization
+type LRUOptions func(*LRUConnector) error
+
+// WithLRUMaxEntries bounds the number of rows and range pages held by the cache
+func WithLRUMaxEntries(n int) LRUOptions {
+	return func(c *LRUConnector) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max entries %d", n)
+		}
+		c.cache.maxEntries = n
+		return nil
+	}
+}
+
+// WithLRUMaxBytes bounds the estimated memory size of the cached rows and range pages.
+// By default the cache is only bounded by the number of entries.
+func WithLRUMaxBytes(n int64) LRUOptions {
+	return func(c *LRUConnector) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max bytes %d", n)
+		}
+		c.cache.maxBytes = n
+		return nil
+	}
+}
+
+// WithLRUTTL sets how long entries of entities without a specific TTL stay in the cache
+func WithLRUTTL(ttl time.Duration) LRUOptions {
+	return func(c *LRUConnector) error {
+		c.defaultTTL = ttl
+		return nil
+	}
+}
+
+// WithLRUEntityTTL sets how long entries of a given entity stay in the cache
+func WithLRUEntityTTL(entity dosa.DomainObject, ttl time.Duration) LRUOptions {
+	return func(c *LRUConnector) error {
+		if entity == nil {
+			return errors.New("nil entity")
+		}
+		t, err := dosa.TableFromInstance(entity)
+		if err != nil {
+			return err
+		}
+		c.entityTTLs[t.EntityDefinition.Name] = ttl
+		return nil
+	}
+}
+
+// WithLRURangeCaching enables serving Range and Scan pages from the cache
+func WithLRURangeCaching() LRUOptions {
+	return func(c *LRUConnector) error {
+		c.cacheRanges = true
+		return nil
+	}
+}
+
+// LRUConnector is a read-through cache connector backed by a bounded in-process LRU.
+// Reads of cacheable entities are served from the cache when possible and populate it
+// otherwise; every write invalidates the affected entries.
+type LRUConnector struct {
+	base.Connector
+	cache             *lruCache
+	encoder           encoding.Encoder
+	cacheableEntities map[string]bool
+	defaultTTL        time.Duration
+	entityTTLs        map[string]time.Duration
+	cacheRanges       bool
+	stats             metrics.Scope
+	now               func() time.Time
+	// statsName and statsTags are the sub scope and extra tags of the hit and miss counters
+	statsName string
+	statsTags map[string]string
+
+	// generations is used to avoid populating the cache with results of reads that raced with writes.
+	// It is bumped for an entity every time one of its entries is invalidated.
+	genMux      sync.Mutex
+	generations map[string]uint64
+}
+
+// NewLRUConnector creates a read-through cache connector in front of origin for the given entities.
+// It fails if any of the options is invalid rather than silently using the defaults.
+func NewLRUConnector(origin dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, options ...LRUOptions) (*LRUConnector, error) {
+	c := &LRUConnector{
+		Connector:         base.Connector{Next: origin},
+		cache:             newLRUCache(defaultLRUMaxEntries, 0),
+		encoder:           encoding.NewGobEncoder(),
+		cacheableEntities: createCachedEntitiesSet(entities),
+		defaultTTL:        defaultLRUTTL,
+		entityTTLs:        map[string]time.Duration{},
+		stats:             metrics.CheckIfNilStats(scope),
+		now:               time.Now,
+		statsName:         "lru",
+		generations:       map[string]uint64{},
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Read returns the row from the cache, or reads it from origin and caches it
+func (c *LRUConnector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if !c.isCacheable(ei) {
+		return c.Next.Read(ctx, ei, keys, minimumFields)
+	}
+	ckey, err := c.rowKey(ei, keys)
+	if err != nil {
+		return c.Next.Read(ctx, ei, keys, minimumFields)
+	}
+	if v, ok := c.cache.get(ckey, c.now()); ok {
+		c.logHit("READ", ei)
+		return copyRow(v.(map[string]dosa.FieldValue)), nil
+	}
+	c.logMiss("READ", ei)
+
+	gen := c.generation(ei)
+	// Always fetch the whole row so that it can serve reads of any set of fields
+	values, err := c.Next.Read(ctx, ei, keys, dosa.All())
+	if err != nil {
+		return values, err
+	}
+	populateValuesWithKeys(keys, values)
+	c.put(ei, gen, ckey, copyRow(values), rowSize(values))
+	return values, nil
+}
+
+// MultiRead serves the keys it can from the cache and reads the rest from origin
+func (c *LRUConnector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	if !c.isCacheable(ei) {
+		return c.Next.MultiRead(ctx, ei, keys, minimumFields)
+	}
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	ckeys := make([]string, len(keys))
+	var missing []map[string]dosa.FieldValue
+	var missingIdx []int
+	now := c.now()
+	for idx, k := range keys {
+		ckey, err := c.rowKey(ei, k)
+		if err == nil {
+			if v, ok := c.cache.get(ckey, now); ok {
+				c.logHit("MULTIREAD", ei)
+				results[idx] = &dosa.FieldValuesOrError{Values: copyRow(v.(map[string]dosa.FieldValue))}
+				continue
+			}
+		}
+		c.logMiss("MULTIREAD", ei)
+		ckeys[idx] = ckey
+		missing = append(missing, k)
+		missingIdx = append(missingIdx, idx)
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	gen := c.generation(ei)
+	source, err := c.Next.MultiRead(ctx, ei, missing, dosa.All())
+	if err != nil {
+		return source, err
+	}
+	for i, result := range source {
+		idx := missingIdx[i]
+		results[idx] = result
+		if result.Error == nil {
+			populateValuesWithKeys(keys[idx], result.Values)
+			if ckeys[idx] != "" {
+				c.put(ei, gen, ckeys[idx], copyRow(result.Values), rowSize(result.Values))
+			}
+		}
+	}
+	return results, nil
+}
+
+// Range returns the page from the cache if range caching is enabled, otherwise it reads from origin
+func (c *LRUConnector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if !c.isCacheable(ei) || !c.cacheRanges {
+		return c.Next.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+	}
+	return c.page(ei, "RANGE", columnConditions, token, limit, func() ([]map[string]dosa.FieldValue, string, error) {
+		return c.Next.Range(ctx, ei, columnConditions, dosa.All(), token, limit)
+	})
+}
+
+// Scan returns the page from the cache if range caching is enabled, otherwise it reads from origin
+func (c *LRUConnector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if !c.isCacheable(ei) || !c.cacheRanges {
+		return c.Next.Scan(ctx, ei, minimumFields, token, limit)
+	}
+	return c.page(ei, "SCAN", nil, token, limit, func() ([]map[string]dosa.FieldValue, string, error) {
+		return c.Next.Scan(ctx, ei, dosa.All(), token, limit)
+	})
+}
+
+// page serves a Range or Scan page from the cache, or fetches it from origin and caches it
+func (c *LRUConnector) page(ei *dosa.EntityInfo, method string, columnConditions map[string][]*dosa.Condition, token string, limit int,
+	fetch func() ([]map[string]dosa.FieldValue, string, error)) ([]map[string]dosa.FieldValue, string, error) {
+	ckey, err := c.rangeKey(ei, columnConditions, token, limit)
+	if err != nil {
+		return fetch()
+	}
+	if v, ok := c.cache.get(ckey, c.now()); ok {
+		c.logHit(method, ei)
+		page := v.(*rangeResults)
+		return copyRows(page.Rows), page.TokenNext, nil
+	}
+	c.logMiss(method, ei)
+
+	gen := c.generation(ei)
+	rows, nextToken, err := fetch()
+	if err != nil {
+		return rows, nextToken, err
+	}
+	size := int64(len(nextToken))
+	for _, row := range rows {
+		size += rowSize(row)
+	}
+	c.put(ei, gen, ckey, &rangeResults{Rows: copyRows(rows), TokenNext: nextToken}, size)
+	return rows, nextToken, nil
+}
+
+// CreateIfNotExists invalidates the row and the range pages of the entity
+func (c *LRUConnector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.CreateIfNotExists(ctx, ei, values)
+	c.invalidateRows(ei, values)
+	return err
+}
+
+// Upsert invalidates the row and the range pages of the entity
+func (c *LRUConnector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.Upsert(ctx, ei, values)
+	c.invalidateRows(ei, values)
+	return err
+}
+
+// MultiUpsert invalidates the rows and the range pages of the entity
+func (c *LRUConnector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	result, err := c.Next.MultiUpsert(ctx, ei, multiValues)
+	c.invalidateRows(ei, multiValues...)
+	return result, err
+}
+
+// Remove invalidates the row and the range pages of the entity
+func (c *LRUConnector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	err := c.Next.Remove(ctx, ei, keys)
+	c.invalidateRows(ei, keys)
+	return err
+}
+
+// MultiRemove invalidates the rows and the range pages of the entity
+func (c *LRUConnector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	result, err := c.Next.MultiRemove(ctx, ei, multiKeys)
+	c.invalidateRows(ei, multiKeys...)
+	return result, err
+}
+
+// RemoveRange invalidates every cached entry of the entity, since the removed rows are not known
+func (c *LRUConnector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	err := c.Next.RemoveRange(ctx, ei, columnConditions)
+	if c.isCacheable(ei) {
+		c.bumpGeneration(ei)
+		c.cache.removeGroup(rowGroup(ei))
+		c.cache.removeGroup(rangeGroup(ei))
+	}
+	return err
+}
+
+// Shutdown empties the cache and shuts down origin
+func (c *LRUConnector) Shutdown() error {
+	c.cache.clear()
+	return c.Next.Shutdown()
+}
+
+// Invalidation happens after the write to origin, so that a concurrent read cannot
+// re-populate the cache with the old value after it was invalidated.
+func (c *LRUConnector) invalidateRows(ei *dosa.EntityInfo, rows ...map[string]dosa.FieldValue) {
+	if !c.isCacheable(ei) {
+		return
+	}
+	c.bumpGeneration(ei)
+	for _, values := range rows {
+		if ckey, err := c.rowKey(ei, values); err == nil {
+			c.cache.remove(ckey)
+		}
+	}
+	c.cache.removeGroup(rangeGroup(ei))
+}
+
+func (c *LRUConnector) put(ei *dosa.EntityInfo, gen uint64, ckey string, v interface{}, size int64) {
+	group := rowGroup(ei)
+	if _, ok := v.(*rangeResults); ok {
+		group = rangeGroup(ei)
+	}
+	c.genMux.Lock()
+	defer c.genMux.Unlock()
+	if c.generations[ei.Def.Name] != gen {
+		// a write happened while reading from origin, the result may be stale
+		return
+	}
+	c.cache.put(ckey, group, v, int64(len(ckey))+size, c.now().Add(c.ttl(ei)))
+}
+
+func (c *LRUConnector) generation(ei *dosa.EntityInfo) uint64 {
+	c.genMux.Lock()
+	defer c.genMux.Unlock()
+	return c.generations[ei.Def.Name]
+}
+
+func (c *LRUConnector) bumpGeneration(ei *dosa.EntityInfo) {
+	c.genMux.Lock()
+	defer c.genMux.Unlock()
+	c.generations[ei.Def.Name]++
+}
+
+func (c *LRUConnector) ttl(ei *dosa.EntityInfo) time.Duration {
+	if ttl, ok := c.entityTTLs[ei.Def.Name]; ok {
+		return ttl
+	}
+	return c.defaultTTL
+}
+
+func (c *LRUConnector) isCacheable(ei *dosa.EntityInfo) bool {
+	return c.cacheableEntities[ei.Def.Name]
+}
+
+func (c *LRUConnector) rowKey(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (string, error) {
+	encoded, err := c.encoder.Encode(createCacheKey(ei, values))
+	if err != nil {
+		return "", err
+	}
+	return rowGroup(ei) + string(encoded), nil
+}
+
+func (c *LRUConnector) rangeKey(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, token string, limit int) (string, error) {
+	encoded, err := c.encoder.Encode(rangeQuery{
+		Conditions: dosa.NormalizeConditions(columnConditions),
+		Token:      token,
+		Limit:      limit,
+	})
+	if err != nil {
+		return "", err
+	}
+	return rangeGroup(ei) + string(encoded), nil
+}
+
+func (c *LRUConnector) logHit(method string, ei *dosa.EntityInfo) {
+	c.logLookup(method, ei, "hit")
+}
+
+func (c *LRUConnector) logMiss(method string, ei *dosa.EntityInfo) {
+	c.logLookup(method, ei, "miss")
+}
+
+func (c *LRUConnector) logLookup(method string, ei *dosa.EntityInfo, counter string) {
+	tags := map[string]string{"method": method, "entityName": ei.Def.Name}
+	for k, v := range c.statsTags {
+		tags[k] = v
+	}
+	c.stats.SubScope(c.statsName).Tagged(tags).Counter(counter).Inc(1)
+}
+
+func entityGroup(ei *dosa.EntityInfo) string {
+	return fmt.Sprintf("%s.%s.%s", ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name)
+}
+
+func rowGroup(ei *dosa.EntityInfo) string {
+	return entityGroup(ei) + "/row/"
+}
+
+func rangeGroup(ei *dosa.EntityInfo) string {
+	return entityGroup(ei) + "/range/"
+}
+
+func copyRow(row map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	if row == nil {
+		return nil
+	}
+	c := make(map[string]dosa.FieldValue, len(row))
+	for k, v := range row {
+		c[k] = v
+	}
+	return c
+}
+
+func copyRows(rows []map[string]dosa.FieldValue) []map[string]dosa.FieldValue {
+	if rows == nil {
+		return nil
+	}
+	c := make([]map[string]dosa.FieldValue, len(rows))
+	for i, row := range rows {
+		c[i] = copyRow(row)
+	}
+	return c
+}
+
+// rowSize estimates the memory used by a row
+func rowSize(row map[string]dosa.FieldValue) int64 {
+	var size int64
+	for name, v := range row {
+		size += int64(len(name)) + valueSize(v)
+	}
+	return size
+}
+
+func valueSize(v dosa.FieldValue) int64 {
+	switch t := v.(type) {
+	case string:
+		return int64(len(t))
+	case *string:
+		if t != nil {
+			return int64(len(*t)) + 8
+		}
+	case []byte:
+		return int64(len(t))
+	case dosa.UUID:
+		return int64(len(t))
+	case *dosa.UUID:
+		if t != nil {
+			return int64(len(*t)) + 8
+		}
+	case time.Time, *time.Time:
+		return 24
+	}
+	return 8
+}
+
+// lruCache is a size-bounded LRU cache of entries with an expiration time. Every entry belongs to a
+// group so that related entries can be removed together.
+type lruCache struct {
+	sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	order      *list.List
+	entries    map[string]*list.Element
+	groups     map[string]map[string]struct{}
+}
+
+type lruEntry struct {
+	key       string
+	group     string
+	value     interface{}
+	size      int64
+	expiresAt time.Time
+}
+
+func newLRUCache(maxEntries int, maxBytes int64) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+		groups:     map[string]map[string]struct{}{},
+	}
+}
+
+func (l *lruCache) get(key string, now time.Time) (interface{}, bool) {
+	l.Lock()
+	defer l.Unlock()
+	elem, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !now.Before(entry.expiresAt) {
+		l.removeElement(elem)
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (l *lruCache) put(key, group string, value interface{}, size int64, expiresAt time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	if elem, ok := l.entries[key]; ok {
+		l.removeElement(elem)
+	}
+	if l.maxBytes > 0 && size > l.maxBytes {
+		// would evict everything else and still not fit
+		return
+	}
+	entry := &lruEntry{key: key, group: group, value: value, size: size, expiresAt: expiresAt}
+	l.entries[key] = l.order.PushFront(entry)
+	if l.groups[group] == nil {
+		l.groups[group] = map[string]struct{}{}
+	}
+	l.groups[group][key] = struct{}{}
+	l.bytes += size
+	for l.order.Len() > l.maxEntries || (l.maxBytes > 0 && l.bytes > l.maxBytes) {
+		l.removeElement(l.order.Back())
+	}
+}
+
+func (l *lruCache) remove(key string) {
+	l.Lock()
+	defer l.Unlock()
+	if elem, ok := l.entries[key]; ok {
+		l.removeElement(elem)
+	}
+}
+
+func (l *lruCache) removeGroup(group string) {
+	l.Lock()
+	defer l.Unlock()
+	for key := range l.groups[group] {
+		l.removeElement(l.entries[key])
+	}
+}
+
+func (l *lruCache) clear() {
+	l.Lock()
+	defer l.Unlock()
+	l.order.Init()
+	l.entries = map[string]*list.Element{}
+	l.groups = map[string]map[string]struct{}{}
+	l.bytes = 0
+}
+
+func (l *lruCache) len() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.order.Len()
+}
+
+func (l *lruCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	l.order.Remove(elem)
+	delete(l.entries, entry.key)
+	delete(l.groups[entry.group], entry.key)
+	if len(l.groups[entry.group]) == 0 {
+		delete(l.groups, entry.group)
+	}
+	l.bytes -= entry.size
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/conformance"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/encoding"
+	"github.com/uber-go/dosa/metrics"
+	"github.com/uber-go/dosa/mocks"
+	"github.com/uber-go/dosa/testentity"
+)
+
+var lruUUID = dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9")
+
+func lruKeys(strKey string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"an_uuid_key": lruUUID,
+		"strkey":      strKey,
+		"int64key":    int64(1),
+	}
+}
+
+func lruRow(strKey string, v int32) map[string]dosa.FieldValue {
+	row := lruKeys(strKey)
+	row["int32v"] = v
+	return row
+}
+
+func newTestLRUConnector(origin dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, options ...LRUOptions) *LRUConnector {
+	c, err := NewLRUConnector(origin, scope, entities, options...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func newTestTieredConnector(origin, l2 dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, encoder encoding.Encoder, options ...LRUOptions) *LRUConnector {
+	c, err := NewTieredConnector(origin, l2, scope, entities, encoder, options...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestLRUReadThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	origin := mocks.NewMockConnector(ctrl)
+	// Only one read reaches origin, and it fetches all fields
+	origin.EXPECT().Read(context.TODO(), testEi, lruKeys("a"), dosa.All()).
+		Return(map[string]dosa.FieldValue{"int32v": int32(1)}, nil).Times(1)
+
+	c := newTestLRUConnector(origin, nil, cacheableEntities)
+	for i := 0; i < 3; i++ {
+		values, err := c.Read(context.TODO(), testEi, lruKeys("a"), []string{"int32v"})
+		assert.NoError(t, err)
+		assert.Equal(t, lruRow("a", 1), values)
+	}
+}
+
+func TestLRUNotCacheable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	origin := mocks.NewMockConnector(ctrl)
+	origin.EXPECT().Read(context.TODO(), testEi, lruKeys("a"), []string{"int32v"}).
+		Return(lruRow("a", 1), nil).Times(2)
+
+	c := newTestLRUConnector(origin, nil, nil)
+	for i := 0; i < 2; i++ {
+		_, err := c.Read(context.TODO(), testEi, lruKeys("a"), []string{"int32v"})
+		assert.NoError(t, err)
+	}
+}
+
+func TestLRUInvalidation(t *testing.T) {
+	origin := memory.NewConnector()
+	c := newTestLRUConnector(origin, nil, cacheableEntities)
+	ctx := context.TODO()
+
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("a", 1)))
+	values, err := c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), values["int32v"])
+
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("a", 2)))
+	values, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), values["int32v"])
+
+	_, err = c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{lruRow("a", 3)})
+	assert.NoError(t, err)
+	values, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), values["int32v"])
+
+	assert.NoError(t, c.Remove(ctx, testEi, lruKeys("a")))
+	_, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.NoError(t, c.CreateIfNotExists(ctx, testEi, lruRow("a", 4)))
+	values, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), values["int32v"])
+
+	_, err = c.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{lruKeys("a")})
+	assert.NoError(t, err)
+	_, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	assert.Equal(t, 0, c.cache.len())
+}
+
+func TestLRUMultiRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	origin := mocks.NewMockConnector(ctrl)
+	origin.EXPECT().Read(context.TODO(), testEi, lruKeys("a"), dosa.All()).Return(lruRow("a", 1), nil)
+	// only the key that is not cached is read from origin
+	origin.EXPECT().MultiRead(context.TODO(), testEi, []map[string]dosa.FieldValue{lruKeys("b"), lruKeys("c")}, dosa.All()).
+		Return([]*dosa.FieldValuesOrError{{Values: lruRow("b", 2)}, {Error: &dosa.ErrNotFound{}}}, nil)
+
+	c := newTestLRUConnector(origin, nil, cacheableEntities)
+	_, err := c.Read(context.TODO(), testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+
+	results, err := c.MultiRead(context.TODO(), testEi, []map[string]dosa.FieldValue{lruKeys("a"), lruKeys("b"), lruKeys("c")}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, lruRow("a", 1), results[0].Values)
+	assert.Equal(t, lruRow("b", 2), results[1].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[2].Error))
+
+	// a and b are now both cached
+	results, err = c.MultiRead(context.TODO(), testEi, []map[string]dosa.FieldValue{lruKeys("a"), lruKeys("b")}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, lruRow("b", 2), results[1].Values)
+}
+
+func TestLRURange(t *testing.T) {
+	origin := memory.NewConnector()
+	ctx := context.TODO()
+	conds := map[string][]*dosa.Condition{"an_uuid_key": {{Op: dosa.Eq, Value: lruUUID}}}
+
+	// without range caching, pages always come from origin
+	c := newTestLRUConnector(origin, nil, cacheableEntities)
+	assert.NoError(t, origin.Upsert(ctx, testEi, lruRow("a", 1)))
+	rows, _, err := c.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, 0, c.cache.len())
+
+	c = newTestLRUConnector(origin, nil, cacheableEntities, WithLRURangeCaching())
+	rows, _, err = c.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+
+	// a write that bypasses the cache is not seen
+	assert.NoError(t, origin.Upsert(ctx, testEi, lruRow("b", 2)))
+	rows, _, err = c.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+
+	// a write through the cache invalidates all pages of the entity
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("c", 3)))
+	rows, _, err = c.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	rows, _, err = c.Scan(ctx, testEi, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	_, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, c.cache.len())
+
+	assert.NoError(t, c.RemoveRange(ctx, testEi, conds))
+	assert.Equal(t, 0, c.cache.len())
+	rows, _, err = c.Range(ctx, testEi, conds, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestLRUEntityTTL(t *testing.T) {
+	origin := memory.NewConnector()
+	ctx := context.TODO()
+	now := time.Unix(1500000000, 0)
+	c := newTestLRUConnector(origin, nil, cacheableEntities,
+		WithLRUTTL(time.Hour), WithLRUEntityTTL(&testentity.TestEntity{}, time.Second))
+	c.now = func() time.Time { return now }
+
+	assert.NoError(t, origin.Upsert(ctx, testEi, lruRow("a", 1)))
+	_, err := c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+
+	assert.NoError(t, origin.Upsert(ctx, testEi, lruRow("a", 2)))
+	values, err := c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), values["int32v"])
+
+	now = now.Add(2 * time.Second)
+	values, err = c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), values["int32v"])
+}
+
+func TestLRUOptionErrors(t *testing.T) {
+	for _, option := range []LRUOptions{WithLRUMaxEntries(0), WithLRUMaxBytes(-1), WithLRUEntityTTL(nil, time.Second)} {
+		c, err := NewLRUConnector(nil, nil, nil, option)
+		assert.Error(t, err)
+		assert.Nil(t, c)
+	}
+	_, err := NewTieredConnector(nil, nil, nil, nil, nil, WithLRUMaxEntries(0))
+	assert.Error(t, err)
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	l := newLRUCache(2, 0)
+	l.put("a", "g1", 1, 1, later)
+	l.put("b", "g1", 2, 1, later)
+	_, ok := l.get("a", now)
+	assert.True(t, ok)
+	// b is the least recently used
+	l.put("c", "g2", 3, 1, later)
+	_, ok = l.get("b", now)
+	assert.False(t, ok)
+	assert.Equal(t, 2, l.len())
+
+	l.removeGroup("g1")
+	assert.Equal(t, 1, l.len())
+	_, ok = l.get("c", now)
+	assert.True(t, ok)
+
+	l = newLRUCache(10, 10)
+	l.put("a", "g", 1, 6, later)
+	l.put("b", "g", 2, 6, later)
+	assert.Equal(t, 1, l.len())
+	assert.Equal(t, int64(6), l.bytes)
+	// too big to ever fit
+	l.put("c", "g", 3, 11, later)
+	_, ok = l.get("c", now)
+	assert.False(t, ok)
+
+	// expired entries are dropped
+	_, ok = l.get("b", later)
+	assert.False(t, ok)
+	assert.Equal(t, 0, l.len())
+	assert.Equal(t, int64(0), l.bytes)
+}
+
+func TestLRUSkipsStalePopulate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	origin := mocks.NewMockConnector(ctrl)
+	c := newTestLRUConnector(origin, nil, cacheableEntities)
+
+	// a write completes while the read is in flight
+	origin.EXPECT().Read(context.TODO(), testEi, lruKeys("a"), dosa.All()).DoAndReturn(
+		func(_ context.Context, _ *dosa.EntityInfo, _ map[string]dosa.FieldValue, _ []string) (map[string]dosa.FieldValue, error) {
+			c.invalidateRows(testEi, lruKeys("a"))
+			return lruRow("a", 1), nil
+		})
+	_, err := c.Read(context.TODO(), testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, c.cache.len())
+}
+
+func TestValueSize(t *testing.T) {
+	s := "abc"
+	assert.Equal(t, int64(3), valueSize("abc"))
+	assert.Equal(t, int64(11), valueSize(&s))
+	assert.Equal(t, int64(2), valueSize([]byte{1, 2}))
+	assert.Equal(t, int64(24), valueSize(time.Now()))
+	assert.Equal(t, int64(8), valueSize(int64(1)))
+}
+
+func TestLRUConformance(t *testing.T) {
+	conformance.RunSuite(t, func() dosa.Connector {
+		return newTestLRUConnector(memory.NewConnector(), nil, conformanceEntities, WithLRURangeCaching())
+	})
+}
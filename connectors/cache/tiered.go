@@ -0,0 +1,224 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/encoding"
+	"github.com/uber-go/dosa/metrics"
+)
+
+// Tiers of the tiered cache, used to tag its hit and miss counters
+const (
+	tierL1 = "l1"
+	tierL2 = "l2"
+)
+
+// NewTieredConnector creates a read-through cache connector made of two tiers in front of origin:
+// an in-process LRU (L1), configured by the LRU options, and a shared key-value cache (L2) such as
+// the redis connector. Reads are served by the first tier holding the row: L1 misses are populated
+// from L2, and L2 misses are read from origin and populate both tiers. Every write goes to origin
+// first and then invalidates both tiers.
+//
+// The rows stored in L2 are encoded with encoder, which defaults to gob when nil, and are keyed and
+// versioned like the entries of the fallback Connector. Hits and misses are counted per tier under
+// the "tiered" sub scope.
+func NewTieredConnector(origin, l2 dosa.Connector, scope metrics.Scope, entities []dosa.DomainObject, encoder encoding.Encoder, options ...LRUOptions) (*LRUConnector, error) {
+	if encoder == nil {
+		encoder = encoding.NewGobEncoder()
+	}
+	stats := metrics.CheckIfNilStats(scope)
+	tier2 := newL2Connector(origin, l2, stats, encoder, entities)
+
+	l1, err := NewLRUConnector(tier2, stats, entities, options...)
+	if err != nil {
+		return nil, err
+	}
+	l1.statsName = "tiered"
+	l1.statsTags = map[string]string{"tier": tierL1}
+	return l1, nil
+}
+
+// l2Connector is the L2 tier of the tiered cache. It reads rows from the L2 cache, reads the
+// misses from origin and invalidates the L2 cache on writes.
+type l2Connector struct {
+	base.Connector
+	// cache handles the keys, payloads and invalidation of the entries in the L2 cache
+	cache *Connector
+	stats metrics.Scope
+}
+
+func newL2Connector(origin, l2 dosa.Connector, stats metrics.Scope, encoder encoding.Encoder, entities []dosa.DomainObject) *l2Connector {
+	cache := newConnector(origin, l2, nil, encoder, entities)
+	// the L2 cache must be invalidated by the time a write returns
+	cache.setSynchronousMode(true)
+	return &l2Connector{
+		Connector: base.Connector{Next: origin},
+		cache:     cache,
+		stats:     stats,
+	}
+}
+
+// Read returns the row from the L2 cache, or reads it from origin and caches it
+func (c *l2Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if !c.cache.isCacheable(ctx, ei) {
+		return c.Next.Read(ctx, ei, keys, minimumFields)
+	}
+	if values, ok := c.get(ctx, ei, keys); ok {
+		c.logLookup("READ", ei, "hit")
+		return values, nil
+	}
+	c.logLookup("READ", ei, "miss")
+
+	generation := c.snapshot(ctx, ei, keys)
+	values, err := c.Next.Read(ctx, ei, keys, dosa.All())
+	if err != nil {
+		return values, err
+	}
+	populateValuesWithKeys(keys, values)
+	if generation != 0 {
+		_ = c.cache.write(ctx, ei, keys, values, generation)
+	}
+	return values, nil
+}
+
+// MultiRead serves the keys it can from the L2 cache and reads the rest from origin
+func (c *l2Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	if !c.cache.isCacheable(ctx, ei) {
+		return c.Next.MultiRead(ctx, ei, keys, minimumFields)
+	}
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	var missing []map[string]dosa.FieldValue
+	var missingIdx []int
+	var generations []uint64
+	for idx, k := range keys {
+		if values, ok := c.get(ctx, ei, k); ok {
+			c.logLookup("MULTIREAD", ei, "hit")
+			results[idx] = &dosa.FieldValuesOrError{Values: values}
+			continue
+		}
+		c.logLookup("MULTIREAD", ei, "miss")
+		missing = append(missing, k)
+		missingIdx = append(missingIdx, idx)
+		generations = append(generations, c.snapshot(ctx, ei, k))
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	source, err := c.Next.MultiRead(ctx, ei, missing, dosa.All())
+	if err != nil {
+		return source, err
+	}
+	for i, result := range source {
+		idx := missingIdx[i]
+		results[idx] = result
+		if result.Error == nil {
+			populateValuesWithKeys(keys[idx], result.Values)
+			if generations[i] != 0 {
+				_ = c.cache.write(ctx, ei, keys[idx], result.Values, generations[i])
+			}
+		}
+	}
+	return results, nil
+}
+
+// CreateIfNotExists invalidates the row in the L2 cache
+func (c *l2Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.CreateIfNotExists(ctx, ei, values)
+	c.cache.invalidateRows(ctx, ei, values)
+	return err
+}
+
+// Upsert invalidates the row in the L2 cache
+func (c *l2Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	err := c.Next.Upsert(ctx, ei, values)
+	c.cache.invalidateRows(ctx, ei, values)
+	return err
+}
+
+// MultiUpsert invalidates the rows in the L2 cache
+func (c *l2Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	result, err := c.Next.MultiUpsert(ctx, ei, multiValues)
+	c.cache.invalidateRows(ctx, ei, multiValues...)
+	return result, err
+}
+
+// Remove invalidates the row in the L2 cache
+func (c *l2Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	err := c.Next.Remove(ctx, ei, keys)
+	c.cache.invalidateRows(ctx, ei, keys)
+	return err
+}
+
+// MultiRemove invalidates the rows in the L2 cache
+func (c *l2Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	result, err := c.Next.MultiRemove(ctx, ei, multiKeys)
+	c.cache.invalidateRows(ctx, ei, multiKeys...)
+	return result, err
+}
+
+// RemoveRange invalidates the rows of the affected partition in the L2 cache
+func (c *l2Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	err := c.Next.RemoveRange(ctx, ei, columnConditions)
+	c.cache.invalidateRange(ctx, ei, columnConditions)
+	return err
+}
+
+// Shutdown shuts down the L2 cache and origin
+func (c *l2Connector) Shutdown() error {
+	if err := c.cache.fallback.Shutdown(); err != nil {
+		return err
+	}
+	return c.Next.Shutdown()
+}
+
+// snapshot returns the generation to cache a row under once read from origin, which must be taken before
+// the read: a write invalidating the row while it is read replaces the generation, so the row never gets
+// served. It returns 0 when the generation can't be read, in which case the row must not be cached.
+func (c *l2Connector) snapshot(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) uint64 {
+	generation, err := c.cache.generationForWrite(ctx, ei, rowGeneration(ei, keys))
+	if err != nil {
+		return 0
+	}
+	return generation
+}
+
+// get returns the row from the L2 cache
+func (c *l2Connector) get(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (map[string]dosa.FieldValue, bool) {
+	value, err := c.cache.getValueFromFallback(ctx, ei, createCacheKey(ei, keys), rowGeneration(ei, keys))
+	if err != nil {
+		return nil, false
+	}
+	result := map[string]dosa.FieldValue{}
+	if err := c.cache.encoderFor(ei).Decode(value, &result); err != nil {
+		return nil, false
+	}
+	return rawRowAsPointers(ei, result), true
+}
+
+func (c *l2Connector) logLookup(method string, ei *dosa.EntityInfo, counter string) {
+	tags := map[string]string{"method": method, "entityName": ei.Def.Name, "tier": tierL2}
+	c.stats.SubScope("tiered").Tagged(tags).Counter(counter).Inc(1)
+}
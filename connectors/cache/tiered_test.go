@@ -0,0 +1,263 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/metrics"
+	"github.com/uber-go/dosa/mocks"
+)
+
+// recordingScope counts the increments of the counters, by name and tier
+type recordingScope struct {
+	name   string
+	tags   map[string]string
+	mux    *sync.Mutex
+	counts map[string]int64
+}
+
+func newRecordingScope() *recordingScope {
+	return &recordingScope{tags: map[string]string{}, mux: &sync.Mutex{}, counts: map[string]int64{}}
+}
+
+func (s *recordingScope) Counter(name string) metrics.Counter {
+	return &recordingCounter{scope: s, name: strings.TrimPrefix(s.name+"."+name, ".") + "/" + s.tags["tier"]}
+}
+
+func (s *recordingScope) Tagged(tags map[string]string) metrics.Scope {
+	merged := map[string]string{}
+	for k, v := range s.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return &recordingScope{name: s.name, tags: merged, mux: s.mux, counts: s.counts}
+}
+
+func (s *recordingScope) SubScope(name string) metrics.Scope {
+	return &recordingScope{name: strings.TrimPrefix(s.name+"."+name, "."), tags: s.tags, mux: s.mux, counts: s.counts}
+}
+
+func (s *recordingScope) Timer(name string) metrics.Timer {
+	return (&metrics.NoopScope{}).Timer(name)
+}
+
+func (s *recordingScope) get(name string) int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.counts[name]
+}
+
+type recordingCounter struct {
+	scope *recordingScope
+	name  string
+}
+
+func (c *recordingCounter) Inc(delta int64) {
+	c.scope.mux.Lock()
+	defer c.scope.mux.Unlock()
+	c.scope.counts[c.name] += delta
+}
+
+func TestTieredReadThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	origin := mocks.NewMockConnector(ctrl)
+	// Only one read reaches origin, the other connector reads from L2
+	origin.EXPECT().Read(context.TODO(), testEi, lruKeys("a"), dosa.All()).
+		Return(map[string]dosa.FieldValue{"int32v": int32(1)}, nil).Times(1)
+
+	l2 := memory.NewConnector()
+	stats := newRecordingScope()
+	c := newTestTieredConnector(origin, l2, stats, cacheableEntities, nil)
+	for i := 0; i < 2; i++ {
+		values, err := c.Read(context.TODO(), testEi, lruKeys("a"), []string{"int32v"})
+		assert.NoError(t, err)
+		assert.Equal(t, lruRow("a", 1), values)
+	}
+	assert.Equal(t, int64(1), stats.get("tiered.hit/l1"))
+	assert.Equal(t, int64(1), stats.get("tiered.miss/l1"))
+	assert.Equal(t, int64(1), stats.get("tiered.miss/l2"))
+
+	other := newTestTieredConnector(origin, l2, stats, cacheableEntities, nil)
+	values, err := other.Read(context.TODO(), testEi, lruKeys("a"), []string{"int32v"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), *values["int32v"].(*int32))
+	assert.Equal(t, int64(2), stats.get("tiered.miss/l1"))
+	assert.Equal(t, int64(1), stats.get("tiered.hit/l2"))
+
+	// the L1 of the other connector got populated from L2
+	_, err = other.Read(context.TODO(), testEi, lruKeys("a"), []string{"int32v"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stats.get("tiered.hit/l1"))
+}
+
+func TestTieredInvalidation(t *testing.T) {
+	ctx := context.TODO()
+	origin := memory.NewConnector()
+	l2 := memory.NewConnector()
+	c := newTestTieredConnector(origin, l2, nil, cacheableEntities, nil, WithLRUTTL(time.Hour))
+	// fresh returns a connector with an empty L1, so that reads are served by L2 or origin
+	fresh := func() *LRUConnector {
+		return newTestTieredConnector(origin, l2, nil, cacheableEntities, nil)
+	}
+	readInt32 := func(c *LRUConnector, strKey string) (int32, error) {
+		values, err := c.Read(ctx, testEi, lruKeys(strKey), dosa.All())
+		if err != nil {
+			return 0, err
+		}
+		switch v := values["int32v"].(type) {
+		case *int32:
+			return *v, nil
+		default:
+			return v.(int32), nil
+		}
+	}
+
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("a", 1)))
+	v, err := readInt32(c, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), v)
+
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("a", 2)))
+	v, err = readInt32(c, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), v)
+	v, err = readInt32(fresh(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), v)
+
+	_, err = c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{lruRow("a", 3)})
+	assert.NoError(t, err)
+	v, err = readInt32(fresh(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), v)
+
+	assert.NoError(t, c.Remove(ctx, testEi, lruKeys("a")))
+	_, err = readInt32(fresh(), "a")
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.NoError(t, c.CreateIfNotExists(ctx, testEi, lruRow("a", 4)))
+	v, err = readInt32(c, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), v)
+
+	_, err = c.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{lruKeys("a")})
+	assert.NoError(t, err)
+	_, err = readInt32(fresh(), "a")
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	// RemoveRange invalidates the rows of the partition in both tiers
+	for _, k := range []string{"b", "c"} {
+		assert.NoError(t, c.Upsert(ctx, testEi, lruRow(k, 5)))
+		_, err = readInt32(c, k)
+		assert.NoError(t, err)
+	}
+	conditions := map[string][]*dosa.Condition{"an_uuid_key": {{Op: dosa.Eq, Value: lruUUID}}}
+	assert.NoError(t, c.RemoveRange(ctx, testEi, conditions))
+	for _, k := range []string{"b", "c"} {
+		_, err = readInt32(c, k)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+		_, err = readInt32(fresh(), k)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+	}
+}
+
+// Test that the rows read from origin are not cached in L2 if they are written while being read
+func TestTieredWriteDuringRead(t *testing.T) {
+	ctx := context.TODO()
+	origin := &interleavedOrigin{Connector: memory.NewConnector()}
+	l2 := memory.NewConnector()
+	c := newTestTieredConnector(origin, l2, nil, cacheableEntities, nil)
+	readInt32 := func(strKey string) int32 {
+		// a connector with an empty L1, so that the read is served by L2 or origin
+		values, err := newTestTieredConnector(origin, l2, nil, cacheableEntities, nil).Read(ctx, testEi, lruKeys(strKey), dosa.All())
+		assert.NoError(t, err)
+		if v, ok := values["int32v"].(*int32); ok {
+			return *v
+		}
+		return values["int32v"].(int32)
+	}
+
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("a", 1)))
+	origin.afterRead = func() {
+		assert.NoError(t, c.Upsert(ctx, testEi, lruRow("a", 2)))
+	}
+	_, err := c.Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), readInt32("a"))
+
+	assert.NoError(t, c.Upsert(ctx, testEi, lruRow("b", 1)))
+	origin.afterRead = func() {
+		assert.NoError(t, c.Upsert(ctx, testEi, lruRow("b", 2)))
+	}
+	_, err = c.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{lruKeys("b")}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), readInt32("b"))
+}
+
+func TestTieredMultiRead(t *testing.T) {
+	ctx := context.TODO()
+	origin := memory.NewConnector()
+	l2 := memory.NewConnector()
+	for _, k := range []string{"a", "b", "c"} {
+		assert.NoError(t, origin.Upsert(ctx, testEi, lruRow(k, 1)))
+	}
+
+	// populate L2 with "a" only
+	_, err := newTestTieredConnector(origin, l2, nil, cacheableEntities, nil).Read(ctx, testEi, lruKeys("a"), dosa.All())
+	assert.NoError(t, err)
+
+	stats := newRecordingScope()
+	c := newTestTieredConnector(origin, l2, stats, cacheableEntities, nil)
+	keys := []map[string]dosa.FieldValue{lruKeys("a"), lruKeys("b"), lruKeys("c")}
+	results, err := c.MultiRead(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	var strKeys []string
+	for _, result := range results {
+		assert.NoError(t, result.Error)
+		strKeys = append(strKeys, result.Values["strkey"].(string))
+	}
+	sort.Strings(strKeys)
+	assert.Equal(t, []string{"a", "b", "c"}, strKeys)
+	assert.Equal(t, int64(3), stats.get("tiered.miss/l1"))
+	assert.Equal(t, int64(1), stats.get("tiered.hit/l2"))
+	assert.Equal(t, int64(2), stats.get("tiered.miss/l2"))
+
+	// every tier is populated now
+	_, err = newTestTieredConnector(memory.NewConnector(), l2, stats, cacheableEntities, nil).MultiRead(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), stats.get("tiered.hit/l2"))
+	_, err = c.MultiRead(ctx, testEi, keys, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.get("tiered.hit/l1"))
+}
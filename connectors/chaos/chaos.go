@@ -0,0 +1,380 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package chaos provides a connector that injects faults into the calls to another connector:
+// latency, errors, partial failures of Multi* operations and outage windows. Faults are drawn
+// from a seeded source, so a sequence of calls fails the same way on every run.
+package chaos
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/metrics"
+)
+
+// Errors to inject. Any other error can be injected too.
+var (
+	// ErrInjected is injected when a rule or an outage has no error set.
+	ErrInjected = errors.New("chaos: injected failure")
+	// ErrConnectionRefused looks like the error of a connector failing to reach its server, e.g.
+	// yarpc.ErrorIsConnectionRefused recognizes it.
+	ErrConnectionRefused = errors.New("chaos: dial tcp: getsockopt: connection refused")
+	// ErrRateLimited is the error of a rate limited call.
+	ErrRateLimited error = &dosa.ErrRateLimited{}
+	// ErrTimeout fails a call once its context is done, or at once if it has no deadline.
+	ErrTimeout = context.DeadlineExceeded
+)
+
+// Latency is a distribution of delays.
+type Latency interface {
+	// Sample draws a delay from the distribution.
+	Sample(r *rand.Rand) time.Duration
+}
+
+// LatencyFunc adapts a function to the Latency interface
+type LatencyFunc func(r *rand.Rand) time.Duration
+
+// Sample calls f(r)
+func (f LatencyFunc) Sample(r *rand.Rand) time.Duration {
+	return f(r)
+}
+
+// Fixed always delays calls by d.
+func Fixed(d time.Duration) Latency {
+	return LatencyFunc(func(*rand.Rand) time.Duration {
+		return d
+	})
+}
+
+// Uniform delays calls by a duration uniformly distributed in [min, max).
+func Uniform(min, max time.Duration) Latency {
+	return LatencyFunc(func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	})
+}
+
+// Normal delays calls by a normally distributed duration, never negative.
+func Normal(mean, stddev time.Duration) Latency {
+	return LatencyFunc(func(r *rand.Rand) time.Duration {
+		return nonNegative(float64(mean) + r.NormFloat64()*float64(stddev))
+	})
+}
+
+// Exponential delays calls by an exponentially distributed duration, which gives a long tail
+// of slow calls.
+func Exponential(mean time.Duration) Latency {
+	return LatencyFunc(func(r *rand.Rand) time.Duration {
+		return nonNegative(r.ExpFloat64() * float64(mean))
+	})
+}
+
+func nonNegative(d float64) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+// Rule describes the faults injected into some operations.
+type Rule struct {
+	// Operations are the names of the connector methods the rule applies to, e.g. "Read" or
+	// "MultiUpsert"; a rule without operations applies to all of them.
+	Operations []string
+	// Latency delays the calls before they are made, or fail.
+	Latency Latency
+	// ErrorRate is the probability, from 0 to 1, that a call fails with Error instead of being made.
+	ErrorRate float64
+	// PartialFailureRate is the probability that each row of a MultiRead, MultiUpsert or
+	// MultiRemove call fails with Error; the other rows are passed on.
+	PartialFailureRate float64
+	// Error is the error injected, ErrInjected when nil.
+	Error error
+}
+
+func (r *Rule) appliesTo(op string) bool {
+	return appliesTo(r.Operations, op)
+}
+
+// Outage is a window of time during which calls fail.
+type Outage struct {
+	// Operations are the names of the connector methods failing, all of them when empty.
+	Operations []string
+	// Start is when the outage begins, relative to the creation of the connector.
+	Start time.Duration
+	// Duration is how long the outage lasts.
+	Duration time.Duration
+	// Every repeats the outage with this period when it is positive.
+	Every time.Duration
+	// Error is the error calls fail with, ErrConnectionRefused when nil.
+	Error error
+}
+
+func (o *Outage) activeAt(elapsed time.Duration) bool {
+	if elapsed < o.Start {
+		return false
+	}
+	since := elapsed - o.Start
+	if o.Every > 0 {
+		since %= o.Every
+	}
+	return since < o.Duration
+}
+
+func appliesTo(operations []string, op string) bool {
+	if len(operations) == 0 {
+		return true
+	}
+	for _, o := range operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configure the chaos connector.
+type Options func(*Connector) error
+
+// WithSeed sets the seed of the source faults are drawn from, 0 by default.
+func WithSeed(seed int64) Options {
+	return func(c *Connector) error {
+		c.rand = rand.New(rand.NewSource(seed))
+		return nil
+	}
+}
+
+// WithRules sets the rules injecting faults. The first rule applying to an operation is used.
+func WithRules(rules ...Rule) Options {
+	return func(c *Connector) error {
+		for _, r := range rules {
+			if r.ErrorRate < 0 || r.ErrorRate > 1 || r.PartialFailureRate < 0 || r.PartialFailureRate > 1 {
+				return errors.Errorf("invalid rule %+v: rates must be between 0 and 1", r)
+			}
+		}
+		c.rules = rules
+		return nil
+	}
+}
+
+// WithOutages sets the outage windows.
+func WithOutages(outages ...Outage) Options {
+	return func(c *Connector) error {
+		for _, o := range outages {
+			if o.Duration <= 0 || o.Start < 0 || o.Every < 0 {
+				return errors.Errorf("invalid outage %+v", o)
+			}
+		}
+		c.outages = outages
+		return nil
+	}
+}
+
+// WithClock sets the clock outage windows are measured with.
+func WithClock(now func() time.Time) Options {
+	return func(c *Connector) error {
+		c.now = now
+		c.start = now()
+		return nil
+	}
+}
+
+// WithStats sets the scope the connector reports a "chaos.faults" counter to, tagged with the
+// operation and the fault injected: "latency", "error", "partial" or "outage".
+func WithStats(scope metrics.Scope) Options {
+	return func(c *Connector) error {
+		c.stats = metrics.CheckIfNilStats(scope)
+		return nil
+	}
+}
+
+// Connector injects faults into the calls to the next connector
+type Connector struct {
+	base.Connector
+	rules   []Rule
+	outages []Outage
+	stats   metrics.Scope
+	now     func() time.Time
+	start   time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+
+	// rand is not safe for concurrent use
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewConnector creates a chaos connector wrapping next. Without rules or outages, it only
+// passes calls through. Invalid rules or outages are returned as an error: a connector that
+// dropped them would inject fewer faults than asked for, and tests would pass for the wrong reason.
+func NewConnector(next dosa.Connector, opts ...Options) (*Connector, error) {
+	c := &Connector{
+		Connector: base.Connector{Next: next},
+		stats:     metrics.CheckIfNilStats(nil),
+		now:       time.Now,
+		sleep:     sleep,
+		rand:      rand.New(rand.NewSource(0)),
+	}
+	c.start = c.now()
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Connector) count(op, fault string) {
+	c.stats.SubScope("chaos").Tagged(map[string]string{
+		"operation": op,
+		"fault":     fault,
+	}).Counter("faults").Inc(1)
+}
+
+func (c *Connector) rule(op string) *Rule {
+	for i := range c.rules {
+		if c.rules[i].appliesTo(op) {
+			return &c.rules[i]
+		}
+	}
+	return nil
+}
+
+// inject applies the outages and the rule of an operation before it is called, returning the
+// error to fail the call with, if any.
+func (c *Connector) inject(ctx context.Context, op string) error {
+	elapsed := c.now().Sub(c.start)
+	for i := range c.outages {
+		if o := &c.outages[i]; appliesTo(o.Operations, op) && o.activeAt(elapsed) {
+			c.count(op, "outage")
+			err := o.Error
+			if err == nil {
+				err = ErrConnectionRefused
+			}
+			return fail(ctx, err)
+		}
+	}
+
+	r := c.rule(op)
+	if r == nil {
+		return nil
+	}
+	var delay time.Duration
+	var failed bool
+	c.mu.Lock()
+	if r.Latency != nil {
+		delay = r.Latency.Sample(c.rand)
+	}
+	if r.ErrorRate > 0 {
+		failed = c.rand.Float64() < r.ErrorRate
+	}
+	c.mu.Unlock()
+
+	if delay > 0 {
+		c.count(op, "latency")
+		if err := c.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+	if failed {
+		c.count(op, "error")
+		return fail(ctx, ruleError(r))
+	}
+	return nil
+}
+
+// failing draws the rows of a Multi* call that fail, nil when none do.
+func (c *Connector) failing(op string, rows int) ([]bool, error) {
+	r := c.rule(op)
+	if r == nil || r.PartialFailureRate == 0 {
+		return nil, nil
+	}
+	var failed []bool
+	c.mu.Lock()
+	for i := 0; i < rows; i++ {
+		if c.rand.Float64() < r.PartialFailureRate {
+			if failed == nil {
+				failed = make([]bool, rows)
+			}
+			failed[i] = true
+		}
+	}
+	c.mu.Unlock()
+	if failed != nil {
+		c.count(op, "partial")
+	}
+	return failed, ruleError(r)
+}
+
+func ruleError(r *Rule) error {
+	if r.Error == nil {
+		return ErrInjected
+	}
+	return r.Error
+}
+
+// fail returns the error to inject; a timeout first waits for the context to be done.
+func fail(ctx context.Context, err error) error {
+	if err == ErrTimeout {
+		if _, ok := ctx.Deadline(); ok {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// passed returns the rows that did not fail, and their positions.
+func passed(values []map[string]dosa.FieldValue, failed []bool) ([]map[string]dosa.FieldValue, []int) {
+	var rows []map[string]dosa.FieldValue
+	var positions []int
+	for i, v := range values {
+		if !failed[i] {
+			rows = append(rows, v)
+			positions = append(positions, i)
+		}
+	}
+	return rows, positions
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	testInfo = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "testing", NamePrefix: "example", EntityName: "t"},
+		Def: &dosa.EntityDefinition{
+			Name: "t",
+			Columns: []*dosa.ColumnDefinition{
+				{Name: "id", Type: dosa.Int64},
+				{Name: "v", Type: dosa.String},
+			},
+			Key: &dosa.PrimaryKey{PartitionKeys: []string{"id"}},
+		},
+	}
+	ctx = context.Background()
+)
+
+func row(id int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"id": int64(id), "v": fmt.Sprint(id)}
+}
+
+func key(id int) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"id": int64(id)}
+}
+
+func newTestConnector(next dosa.Connector, opts ...Options) *Connector {
+	c, err := NewConnector(next, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// recordSleeps makes the connector record delays instead of sleeping.
+func recordSleeps(c *Connector) *[]time.Duration {
+	var delays []time.Duration
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return &delays
+}
+
+func TestPassThrough(t *testing.T) {
+	c := newTestConnector(memory.NewConnector())
+	require.NoError(t, c.Upsert(ctx, testInfo, row(1)))
+	values, err := c.Read(ctx, testInfo, key(1), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1", values["v"])
+	version, err := c.CheckSchema(ctx, "testing", "example", []*dosa.EntityDefinition{testInfo.Def})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), version)
+}
+
+func failures(c *Connector, calls int) []bool {
+	var failed []bool
+	for i := 0; i < calls; i++ {
+		_, err := c.Read(ctx, testInfo, key(i), nil)
+		failed = append(failed, err != nil && !dosa.ErrorIsNotFound(err))
+	}
+	return failed
+}
+
+func TestErrorRateIsReproducible(t *testing.T) {
+	rule := Rule{Operations: []string{"Read"}, ErrorRate: 0.3, Error: ErrRateLimited}
+	first := failures(newTestConnector(memory.NewConnector(), WithSeed(5), WithRules(rule)), 1000)
+	second := failures(newTestConnector(memory.NewConnector(), WithSeed(5), WithRules(rule)), 1000)
+	other := failures(newTestConnector(memory.NewConnector(), WithSeed(6), WithRules(rule)), 1000)
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, other)
+
+	count := 0
+	for _, f := range first {
+		if f {
+			count++
+		}
+	}
+	assert.InDelta(t, 300, count, 60)
+
+	c := newTestConnector(memory.NewConnector(), WithRules(Rule{ErrorRate: 1, Error: ErrRateLimited}))
+	_, err := c.Read(ctx, testInfo, key(1), nil)
+	assert.True(t, dosa.ErrorIsRateLimited(err))
+	c = newTestConnector(memory.NewConnector(), WithRules(Rule{ErrorRate: 1, Error: ErrConnectionRefused}))
+	assert.True(t, strings.Contains(c.Upsert(ctx, testInfo, row(1)).Error(), "getsockopt: connection refused"))
+	c = newTestConnector(memory.NewConnector(), WithRules(Rule{ErrorRate: 1}))
+	assert.Equal(t, ErrInjected, c.DropScope(ctx, "testing"))
+}
+
+func TestRulesApplyToOperations(t *testing.T) {
+	c := newTestConnector(memory.NewConnector(),
+		WithRules(Rule{Operations: []string{"Upsert", "Remove"}, ErrorRate: 1}, Rule{ErrorRate: 0}))
+	assert.Equal(t, ErrInjected, c.Upsert(ctx, testInfo, row(1)))
+	assert.Equal(t, ErrInjected, c.Remove(ctx, testInfo, key(1)))
+	assert.NoError(t, c.CreateIfNotExists(ctx, testInfo, row(1)))
+	_, err := c.Read(ctx, testInfo, key(1), nil)
+	assert.NoError(t, err)
+	_, _, err = c.Scan(ctx, testInfo, nil, "", 10)
+	assert.NoError(t, err)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opt := range []Options{
+		WithRules(Rule{ErrorRate: 2}),
+		WithRules(Rule{PartialFailureRate: -1}),
+		WithOutages(Outage{Duration: -time.Second}),
+	} {
+		c, err := NewConnector(memory.NewConnector(), opt)
+		assert.Error(t, err)
+		assert.Nil(t, c)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	c := newTestConnector(memory.NewConnector(), WithRules(
+		Rule{Operations: []string{"Read"}, Latency: Fixed(10 * time.Millisecond)},
+		Rule{Operations: []string{"Upsert"}, Latency: Uniform(time.Millisecond, 2*time.Millisecond)},
+	))
+	delays := recordSleeps(c)
+	_, _ = c.Read(ctx, testInfo, key(1), nil)
+	for i := 0; i < 100; i++ {
+		_ = c.Upsert(ctx, testInfo, row(i))
+	}
+	assert.Equal(t, 10*time.Millisecond, (*delays)[0])
+	for _, d := range (*delays)[1:] {
+		assert.True(t, d >= time.Millisecond && d < 2*time.Millisecond, "%v", d)
+	}
+	assert.Len(t, *delays, 101)
+
+	// The call fails when the context is done before the delay is over.
+	c = newTestConnector(memory.NewConnector(), WithRules(Rule{Latency: Fixed(time.Hour)}))
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err := c.Read(cctx, testInfo, key(1), nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestDistributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var sumNormal, sumExp time.Duration
+	for i := 0; i < 10000; i++ {
+		n := Normal(10*time.Millisecond, 5*time.Millisecond).Sample(r)
+		assert.True(t, n >= 0)
+		sumNormal += n
+		e := Exponential(10 * time.Millisecond).Sample(r)
+		assert.True(t, e >= 0)
+		sumExp += e
+	}
+	assert.InDelta(t, float64(10*time.Millisecond), float64(sumNormal/10000), float64(time.Millisecond))
+	assert.InDelta(t, float64(10*time.Millisecond), float64(sumExp/10000), float64(time.Millisecond))
+	assert.Equal(t, time.Millisecond, Uniform(time.Millisecond, time.Millisecond).Sample(r))
+	assert.Equal(t, time.Duration(0), Normal(-time.Second, 0).Sample(r))
+}
+
+func TestTimeout(t *testing.T) {
+	c := newTestConnector(memory.NewConnector(), WithRules(Rule{ErrorRate: 1, Error: ErrTimeout}))
+	_, err := c.Read(ctx, testInfo, key(1), nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Read(cctx, testInfo, key(1), nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestPartialFailures(t *testing.T) {
+	next := memory.NewConnector()
+	c := newTestConnector(next, WithSeed(1), WithRules(Rule{PartialFailureRate: 0.5, Error: ErrRateLimited}))
+	var rows, keys []map[string]dosa.FieldValue
+	for i := 0; i < 100; i++ {
+		rows = append(rows, row(i))
+		keys = append(keys, key(i))
+	}
+
+	errs, err := c.MultiUpsert(ctx, testInfo, rows)
+	require.NoError(t, err)
+	require.Len(t, errs, 100)
+	failed := 0
+	for i, e := range errs {
+		_, readErr := next.Read(ctx, testInfo, key(i), nil)
+		if e != nil {
+			failed++
+			assert.True(t, dosa.ErrorIsRateLimited(e))
+			assert.True(t, dosa.ErrorIsNotFound(readErr), "row %d failed but was written", i)
+		} else {
+			assert.NoError(t, readErr)
+		}
+	}
+	assert.InDelta(t, 50, failed, 20)
+
+	results, err := c.MultiRead(ctx, testInfo, keys, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 100)
+	for i, r := range results {
+		if r.Error == nil {
+			assert.Equal(t, fmt.Sprint(i), r.Values["v"])
+		}
+	}
+
+	errs, err = c.MultiRemove(ctx, testInfo, keys)
+	require.NoError(t, err)
+	assert.Len(t, errs, 100)
+
+	// All rows failing
+	c = newTestConnector(next, WithRules(Rule{PartialFailureRate: 1}))
+	errs, err = c.MultiUpsert(ctx, testInfo, rows[:3])
+	assert.NoError(t, err)
+	assert.Equal(t, []error{ErrInjected, ErrInjected, ErrInjected}, errs)
+}
+
+func TestOutages(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newTestConnector(memory.NewConnector(),
+		WithClock(func() time.Time { return now }),
+		WithOutages(
+			Outage{Operations: []string{"Upsert"}, Start: time.Minute, Duration: 30 * time.Second, Every: 2 * time.Minute},
+			Outage{Operations: []string{"Read"}, Start: time.Hour, Duration: time.Minute, Error: ErrTimeout},
+		))
+
+	cases := []struct {
+		at     time.Duration
+		failed bool
+	}{
+		{0, false},
+		{time.Minute - time.Nanosecond, false},
+		{time.Minute, true},
+		{time.Minute + 29*time.Second, true},
+		{time.Minute + 30*time.Second, false},
+		{3 * time.Minute, true},
+		{3*time.Minute + 31*time.Second, false},
+	}
+	for _, tc := range cases {
+		now = time.Unix(1000, 0).Add(tc.at)
+		err := c.Upsert(ctx, testInfo, row(1))
+		if tc.failed {
+			assert.Equal(t, ErrConnectionRefused, err, "at %v", tc.at)
+		} else {
+			assert.NoError(t, err, "at %v", tc.at)
+		}
+		_, err = c.Read(ctx, testInfo, key(1), nil)
+		assert.NoError(t, err)
+	}
+
+	now = time.Unix(1000, 0).Add(time.Hour)
+	_, err := c.Read(ctx, testInfo, key(1), nil)
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func TestStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mocks.NewMockScope(ctrl)
+	counter := mocks.NewMockCounter(ctrl)
+	stats.EXPECT().SubScope("chaos").Return(stats).Times(2)
+	stats.EXPECT().Tagged(map[string]string{"operation": "Read", "fault": "latency"}).Return(stats)
+	stats.EXPECT().Tagged(map[string]string{"operation": "Read", "fault": "error"}).Return(stats)
+	stats.EXPECT().Counter("faults").Return(counter).Times(2)
+	counter.EXPECT().Inc(int64(1)).Times(2)
+
+	c := newTestConnector(memory.NewConnector(), WithStats(stats),
+		WithRules(Rule{Latency: Fixed(time.Millisecond), ErrorRate: 1}))
+	recordSleeps(c)
+	_, err := c.Read(ctx, testInfo, key(1), nil)
+	assert.Equal(t, ErrInjected, err)
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package chaos
+
+import (
+	"context"
+
+	"github.com/uber-go/dosa"
+)
+
+// CreateIfNotExists injects faults, then calls Next
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.inject(ctx, "CreateIfNotExists"); err != nil {
+		return err
+	}
+	return c.Connector.CreateIfNotExists(ctx, ei, values)
+}
+
+// Read injects faults, then calls Next
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	if err := c.inject(ctx, "Read"); err != nil {
+		return nil, err
+	}
+	return c.Connector.Read(ctx, ei, values, minimumFields)
+}
+
+// MultiRead injects faults, failing some rows, then calls Next with the other rows
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	if err := c.inject(ctx, "MultiRead"); err != nil {
+		return nil, err
+	}
+	failed, rowErr := c.failing("MultiRead", len(values))
+	if failed == nil {
+		return c.Connector.MultiRead(ctx, ei, values, minimumFields)
+	}
+
+	results := make([]*dosa.FieldValuesOrError, len(values))
+	for i := range results {
+		if failed[i] {
+			results[i] = &dosa.FieldValuesOrError{Error: rowErr}
+		}
+	}
+	rows, positions := passed(values, failed)
+	if len(rows) > 0 {
+		read, err := c.Connector.MultiRead(ctx, ei, rows, minimumFields)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range positions {
+			if j < len(read) {
+				results[i] = read[j]
+			}
+		}
+	}
+	return results, nil
+}
+
+// Upsert injects faults, then calls Next
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.inject(ctx, "Upsert"); err != nil {
+		return err
+	}
+	return c.Connector.Upsert(ctx, ei, values)
+}
+
+// MultiUpsert injects faults, failing some rows, then calls Next with the other rows
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
+	return c.multiWrite(ctx, "MultiUpsert", values, func(rows []map[string]dosa.FieldValue) ([]error, error) {
+		return c.Connector.MultiUpsert(ctx, ei, rows)
+	})
+}
+
+// Remove injects faults, then calls Next
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.inject(ctx, "Remove"); err != nil {
+		return err
+	}
+	return c.Connector.Remove(ctx, ei, values)
+}
+
+// RemoveRange injects faults, then calls Next
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	if err := c.inject(ctx, "RemoveRange"); err != nil {
+		return err
+	}
+	return c.Connector.RemoveRange(ctx, ei, columnConditions)
+}
+
+// MultiRemove injects faults, failing some rows, then calls Next with the other rows
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	return c.multiWrite(ctx, "MultiRemove", multiValues, func(rows []map[string]dosa.FieldValue) ([]error, error) {
+		return c.Connector.MultiRemove(ctx, ei, rows)
+	})
+}
+
+func (c *Connector) multiWrite(ctx context.Context, op string, values []map[string]dosa.FieldValue, write func([]map[string]dosa.FieldValue) ([]error, error)) ([]error, error) {
+	if err := c.inject(ctx, op); err != nil {
+		return nil, err
+	}
+	failed, rowErr := c.failing(op, len(values))
+	if failed == nil {
+		return write(values)
+	}
+
+	results := make([]error, len(values))
+	for i := range results {
+		if failed[i] {
+			results[i] = rowErr
+		}
+	}
+	rows, positions := passed(values, failed)
+	if len(rows) > 0 {
+		errs, err := write(rows)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range positions {
+			if j < len(errs) {
+				results[i] = errs[j]
+			}
+		}
+	}
+	return results, nil
+}
+
+// Range injects faults, then calls Next
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := c.inject(ctx, "Range"); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+}
+
+// Scan injects faults, then calls Next
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	if err := c.inject(ctx, "Scan"); err != nil {
+		return nil, "", err
+	}
+	return c.Connector.Scan(ctx, ei, minimumFields, token, limit)
+}
+
+// CheckSchema injects faults, then calls Next
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	if err := c.inject(ctx, "CheckSchema"); err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return c.Connector.CheckSchema(ctx, scope, namePrefix, ed)
+}
+
+// CanUpsertSchema injects faults, then calls Next
+func (c *Connector) CanUpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
+	if err := c.inject(ctx, "CanUpsertSchema"); err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return c.Connector.CanUpsertSchema(ctx, scope, namePrefix, ed)
+}
+
+// UpsertSchema injects faults, then calls Next
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	if err := c.inject(ctx, "UpsertSchema"); err != nil {
+		return nil, err
+	}
+	return c.Connector.UpsertSchema(ctx, scope, namePrefix, ed)
+}
+
+// CheckSchemaStatus injects faults, then calls Next
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	if err := c.inject(ctx, "CheckSchemaStatus"); err != nil {
+		return nil, err
+	}
+	return c.Connector.CheckSchemaStatus(ctx, scope, namePrefix, version)
+}
+
+// GetEntitySchema injects faults, then calls Next
+func (c *Connector) GetEntitySchema(ctx context.Context, scope, namePrefix, entityName string, version int32) (*dosa.EntityDefinition, error) {
+	if err := c.inject(ctx, "GetEntitySchema"); err != nil {
+		return nil, err
+	}
+	return c.Connector.GetEntitySchema(ctx, scope, namePrefix, entityName, version)
+}
+
+// CreateScope injects faults, then calls Next
+func (c *Connector) CreateScope(ctx context.Context, md *dosa.ScopeMetadata) error {
+	if err := c.inject(ctx, "CreateScope"); err != nil {
+		return err
+	}
+	return c.Connector.CreateScope(ctx, md)
+}
+
+// TruncateScope injects faults, then calls Next
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	if err := c.inject(ctx, "TruncateScope"); err != nil {
+		return err
+	}
+	return c.Connector.TruncateScope(ctx, scope)
+}
+
+// DropScope injects faults, then calls Next
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	if err := c.inject(ctx, "DropScope"); err != nil {
+		return err
+	}
+	return c.Connector.DropScope(ctx, scope)
+}
+
+// ScopeExists injects faults, then calls Next
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	if err := c.inject(ctx, "ScopeExists"); err != nil {
+		return false, err
+	}
+	return c.Connector.ScopeExists(ctx, scope)
+}
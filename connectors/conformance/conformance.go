@@ -0,0 +1,598 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package conformance provides a test suite pinning down the contract of dosa.Connector, so
+// that every connector behaves the same way. Run it from the tests of a connector:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.RunSuite(t, func() dosa.Connector {
+//			return memory.NewConnector()
+//		})
+//	}
+//
+// Every test of the suite runs as a subtest with a new connector from the factory, which must
+// start empty. A connector that does not support a feature skips its tests with Skip.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+)
+
+// Names of the tests of the suite, to be used with Skip
+const (
+	TestCreateIfNotExists = "CreateIfNotExists"
+	TestRead              = "Read"
+	TestMinimumFields     = "MinimumFields"
+	TestUpsert            = "Upsert"
+	TestRemove            = "Remove"
+	TestMultiRead         = "MultiRead"
+	TestMultiUpsert       = "MultiUpsert"
+	TestMultiRemove       = "MultiRemove"
+	TestRangeOrder        = "RangeOrder"
+	TestRangeConditions   = "RangeConditions"
+	TestRangePagination   = "RangePagination"
+	TestRemoveRange       = "RemoveRange"
+	TestSecondaryIndex    = "SecondaryIndex"
+	TestScan              = "Scan"
+)
+
+// Factory creates an empty connector for a test of the suite
+type Factory func() dosa.Connector
+
+// Options configure the suite
+type Options func(*suite)
+
+// Skip skips tests of the suite, e.g. for features a connector does not support
+func Skip(tests ...string) Options {
+	return func(s *suite) {
+		for _, test := range tests {
+			s.skipped[test] = true
+		}
+	}
+}
+
+type suite struct {
+	factory Factory
+	skipped map[string]bool
+}
+
+// RunSuite runs the conformance tests against the connectors created by factory
+func RunSuite(t *testing.T, factory Factory, opts ...Options) {
+	s := &suite{factory: factory, skipped: map[string]bool{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	tests := []struct {
+		name string
+		test func(t *testing.T, c dosa.Connector)
+	}{
+		{TestCreateIfNotExists, testCreateIfNotExists},
+		{TestRead, testRead},
+		{TestMinimumFields, testMinimumFields},
+		{TestUpsert, testUpsert},
+		{TestRemove, testRemove},
+		{TestMultiRead, testMultiRead},
+		{TestMultiUpsert, testMultiUpsert},
+		{TestMultiRemove, testMultiRemove},
+		{TestRangeOrder, testRangeOrder},
+		{TestRangeConditions, testRangeConditions},
+		{TestRangePagination, testRangePagination},
+		{TestRemoveRange, testRemoveRange},
+		{TestSecondaryIndex, testSecondaryIndex},
+		{TestScan, testScan},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if s.skipped[test.name] {
+				t.Skip("skipped for this connector")
+			}
+			c := s.factory()
+			defer func() {
+				assert.NoError(t, c.Shutdown())
+			}()
+			test.test(t, c)
+		})
+	}
+}
+
+var (
+	ctx = context.Background()
+
+	// allTypes has a column of every type, and a single partition key
+	allTypes = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "conformance", NamePrefix: "suite", EntityName: "alltypes", Version: 1},
+		Def: &dosa.EntityDefinition{
+			Name: "alltypes",
+			Key:  &dosa.PrimaryKey{PartitionKeys: []string{"id"}},
+			Columns: []*dosa.ColumnDefinition{
+				{Name: "id", Type: dosa.String},
+				{Name: "i32", Type: dosa.Int32},
+				{Name: "i64", Type: dosa.Int64},
+				{Name: "d", Type: dosa.Double},
+				{Name: "b", Type: dosa.Blob},
+				{Name: "ts", Type: dosa.Timestamp},
+				{Name: "u", Type: dosa.TUUID},
+				{Name: "ok", Type: dosa.Bool},
+				{Name: "s", Type: dosa.String},
+			},
+		},
+	}
+
+	// events is clustered by an ascending and a descending key, with an index on tag
+	events = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "conformance", NamePrefix: "suite", EntityName: "events", Version: 1},
+		Def: &dosa.EntityDefinition{
+			Name: "events",
+			Key: &dosa.PrimaryKey{
+				PartitionKeys: []string{"owner"},
+				ClusteringKeys: []*dosa.ClusteringKey{
+					{Name: "day", Descending: false},
+					{Name: "seq", Descending: true},
+				},
+			},
+			Columns: []*dosa.ColumnDefinition{
+				{Name: "owner", Type: dosa.String},
+				{Name: "day", Type: dosa.Int64},
+				{Name: "seq", Type: dosa.Int64},
+				{Name: "tag", Type: dosa.String},
+				{Name: "note", Type: dosa.String},
+			},
+			Indexes: map[string]*dosa.IndexDefinition{
+				"events_by_tag": {Key: &dosa.PrimaryKey{PartitionKeys: []string{"tag"}}},
+			},
+		},
+	}
+)
+
+func allTypesRow(id string) map[string]dosa.FieldValue {
+	n := int64(len(id))
+	return map[string]dosa.FieldValue{
+		"id":  id,
+		"i32": int32(n),
+		"i64": n << 40,
+		"d":   float64(n) / 4,
+		"b":   []byte("blob " + id),
+		// millisecond precision, as stored by most databases
+		"ts": time.Unix(1500000000+n, 123000000).UTC(),
+		"u":  dosa.UUID("b8a7e4d4-1b4e-11e8-accf-0ed5f89f718b"),
+		"ok": n%2 == 0,
+		"s":  "value of " + id,
+	}
+}
+
+func allTypesKey(id string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"id": id}
+}
+
+func event(owner string, day, seq int64) map[string]dosa.FieldValue {
+	tag := "even"
+	if (day+seq)%2 == 1 {
+		tag = "odd"
+	}
+	return map[string]dosa.FieldValue{
+		"owner": owner,
+		"day":   day,
+		"seq":   seq,
+		"tag":   tag,
+		"note":  fmt.Sprintf("%s %d/%d", owner, day, seq),
+	}
+}
+
+func eventKey(owner string, day, seq int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"owner": owner, "day": day, "seq": seq}
+}
+
+// upsertEvents writes 3 days of 3 events for owners a and b, in no particular order
+func upsertEvents(t *testing.T, c dosa.Connector) {
+	for _, owner := range []string{"b", "a"} {
+		for _, day := range []int64{2, 3, 1} {
+			for _, seq := range []int64{1, 3, 2} {
+				require.NoError(t, c.Upsert(ctx, events, event(owner, day, seq)))
+			}
+		}
+	}
+}
+
+// eventIDs identifies rows of events as owner/day/seq
+func eventIDs(rows []map[string]dosa.FieldValue) []string {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = fmt.Sprintf("%v/%v/%v", row["owner"], row["day"], row["seq"])
+	}
+	return ids
+}
+
+// equalValues checks if two values are the same, e.g. timestamps are the same instant
+func equalValues(expected, actual dosa.FieldValue) bool {
+	switch e := expected.(type) {
+	case time.Time:
+		a, ok := actual.(time.Time)
+		return ok && e.Equal(a)
+	case []byte:
+		a, ok := actual.([]byte)
+		return ok && bytes.Equal(e, a)
+	}
+	return expected == actual
+}
+
+// assertRow checks that a row has the expected values for the given columns, all of them if nil,
+// and that any other column it holds has its expected value too
+func assertRow(t *testing.T, expected, actual map[string]dosa.FieldValue, columns []string) {
+	if columns == nil {
+		for name := range expected {
+			columns = append(columns, name)
+		}
+	}
+	for _, name := range columns {
+		value, ok := actual[name]
+		if assert.True(t, ok, "column %q is missing", name) {
+			assert.True(t, equalValues(expected[name], value), "column %q: expected %#v, got %#v", name, expected[name], value)
+		}
+	}
+	for name, value := range actual {
+		if e, ok := expected[name]; ok {
+			assert.True(t, equalValues(e, value), "column %q: expected %#v, got %#v", name, e, value)
+		}
+	}
+}
+
+// rangeAll reads all the pages of a range query
+func rangeAll(t *testing.T, c dosa.Connector, ei *dosa.EntityInfo, conditions map[string][]*dosa.Condition, limit int) []map[string]dosa.FieldValue {
+	var rows []map[string]dosa.FieldValue
+	token := ""
+	for pages := 0; pages < 100; pages++ {
+		page, next, err := c.Range(ctx, ei, conditions, nil, token, limit)
+		require.NoError(t, err)
+		assert.True(t, len(page) <= limit, "%d rows in a page of %d", len(page), limit)
+		rows = append(rows, page...)
+		if next == "" {
+			return rows
+		}
+		token = next
+	}
+	require.FailNow(t, "too many pages")
+	return nil
+}
+
+func eq(value dosa.FieldValue) []*dosa.Condition {
+	return []*dosa.Condition{{Op: dosa.Eq, Value: value}}
+}
+
+func testCreateIfNotExists(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.CreateIfNotExists(ctx, allTypes, allTypesRow("a")))
+	err := c.CreateIfNotExists(ctx, allTypes, allTypesRow("a"))
+	assert.True(t, dosa.ErrorIsAlreadyExists(err), "expected ErrAlreadyExists, got %v", err)
+	require.NoError(t, c.CreateIfNotExists(ctx, events, event("a", 1, 1)))
+	err = c.CreateIfNotExists(ctx, events, event("a", 1, 1))
+	assert.True(t, dosa.ErrorIsAlreadyExists(err), "expected ErrAlreadyExists, got %v", err)
+	// another row of the same partition
+	assert.NoError(t, c.CreateIfNotExists(ctx, events, event("a", 1, 2)))
+
+	// the row is created again once removed
+	require.NoError(t, c.Remove(ctx, allTypes, allTypesKey("a")))
+	assert.NoError(t, c.CreateIfNotExists(ctx, allTypes, allTypesRow("a")))
+}
+
+func testRead(t *testing.T, c dosa.Connector) {
+	_, err := c.Read(ctx, allTypes, allTypesKey("a"), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err), "expected ErrNotFound, got %v", err)
+
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("bb")))
+	row, err := c.Read(ctx, allTypes, allTypesKey("a"), nil)
+	require.NoError(t, err)
+	assertRow(t, allTypesRow("a"), row, nil)
+
+	require.NoError(t, c.Upsert(ctx, events, event("a", 1, 1)))
+	row, err = c.Read(ctx, events, eventKey("a", 1, 1), nil)
+	require.NoError(t, err)
+	assertRow(t, event("a", 1, 1), row, nil)
+	_, err = c.Read(ctx, events, eventKey("a", 1, 2), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err), "expected ErrNotFound, got %v", err)
+}
+
+func testMinimumFields(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	// at least the key and the requested columns are read
+	row, err := c.Read(ctx, allTypes, allTypesKey("a"), []string{"i32", "ts"})
+	require.NoError(t, err)
+	assertRow(t, allTypesRow("a"), row, []string{"id", "i32", "ts"})
+
+	results, err := c.MultiRead(ctx, allTypes, []map[string]dosa.FieldValue{allTypesKey("a")}, []string{"b"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Error)
+	assertRow(t, allTypesRow("a"), results[0].Values, []string{"id", "b"})
+
+	require.NoError(t, c.Upsert(ctx, events, event("a", 1, 1)))
+	rows, _, err := c.Range(ctx, events, map[string][]*dosa.Condition{"owner": eq("a")}, []string{"note"}, "", 10)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assertRow(t, event("a", 1, 1), rows[0], []string{"owner", "day", "seq", "note"})
+}
+
+func testUpsert(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	// updating some columns leaves the others unchanged
+	require.NoError(t, c.Upsert(ctx, allTypes, map[string]dosa.FieldValue{"id": "a", "s": "updated", "i32": int32(-1)}))
+	row, err := c.Read(ctx, allTypes, allTypesKey("a"), nil)
+	require.NoError(t, err)
+	expected := allTypesRow("a")
+	expected["s"] = "updated"
+	expected["i32"] = int32(-1)
+	assertRow(t, expected, row, nil)
+
+	require.NoError(t, c.Upsert(ctx, events, event("a", 1, 1)))
+	require.NoError(t, c.Upsert(ctx, events, map[string]dosa.FieldValue{"owner": "a", "day": int64(1), "seq": int64(1), "note": "updated"}))
+	row, err = c.Read(ctx, events, eventKey("a", 1, 1), nil)
+	require.NoError(t, err)
+	expected = event("a", 1, 1)
+	expected["note"] = "updated"
+	assertRow(t, expected, row, nil)
+}
+
+func testRemove(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("b")))
+	require.NoError(t, c.Remove(ctx, allTypes, allTypesKey("a")))
+	_, err := c.Read(ctx, allTypes, allTypesKey("a"), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err), "expected ErrNotFound, got %v", err)
+	_, err = c.Read(ctx, allTypes, allTypesKey("b"), nil)
+	assert.NoError(t, err)
+	// removing a missing row is not an error
+	assert.NoError(t, c.Remove(ctx, allTypes, allTypesKey("a")))
+
+	upsertEvents(t, c)
+	require.NoError(t, c.Remove(ctx, events, eventKey("a", 2, 2)))
+	_, err = c.Read(ctx, events, eventKey("a", 2, 2), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err), "expected ErrNotFound, got %v", err)
+	rows := rangeAll(t, c, events, map[string][]*dosa.Condition{"owner": eq("a"), "day": eq(int64(2))}, 10)
+	assert.Equal(t, []string{"a/2/3", "a/2/1"}, eventIDs(rows))
+}
+
+func testMultiRead(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("c")))
+	// results are in the order of the keys, with an error for each missing row
+	results, err := c.MultiRead(ctx, allTypes, []map[string]dosa.FieldValue{
+		allTypesKey("c"), allTypesKey("b"), allTypesKey("a"),
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	if assert.NoError(t, results[0].Error) {
+		assertRow(t, allTypesRow("c"), results[0].Values, nil)
+	}
+	assert.True(t, dosa.ErrorIsNotFound(results[1].Error), "expected ErrNotFound, got %v", results[1].Error)
+	if assert.NoError(t, results[2].Error) {
+		assertRow(t, allTypesRow("a"), results[2].Values, nil)
+	}
+
+	results, err = c.MultiRead(ctx, allTypes, []map[string]dosa.FieldValue{allTypesKey("x")}, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, dosa.ErrorIsNotFound(results[0].Error), "expected ErrNotFound, got %v", results[0].Error)
+}
+
+func testMultiUpsert(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	errs, err := c.MultiUpsert(ctx, allTypes, []map[string]dosa.FieldValue{
+		{"id": "a", "s": "updated"}, allTypesRow("b"), allTypesRow("cc"),
+	})
+	require.NoError(t, err)
+	require.Len(t, errs, 3)
+	for i, err := range errs {
+		assert.NoError(t, err, "row %d", i)
+	}
+	expected := allTypesRow("a")
+	expected["s"] = "updated"
+	row, err := c.Read(ctx, allTypes, allTypesKey("a"), nil)
+	require.NoError(t, err)
+	assertRow(t, expected, row, nil)
+	for _, id := range []string{"b", "cc"} {
+		row, err := c.Read(ctx, allTypes, allTypesKey(id), nil)
+		require.NoError(t, err)
+		assertRow(t, allTypesRow(id), row, nil)
+	}
+}
+
+func testMultiRemove(t *testing.T, c dosa.Connector) {
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("a")))
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("b")))
+	require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow("c")))
+	errs, err := c.MultiRemove(ctx, allTypes, []map[string]dosa.FieldValue{
+		allTypesKey("a"), allTypesKey("missing"), allTypesKey("c"),
+	})
+	require.NoError(t, err)
+	require.Len(t, errs, 3)
+	for i, err := range errs {
+		// removing a missing row is not an error
+		assert.NoError(t, err, "row %d", i)
+	}
+	for id, found := range map[string]bool{"a": false, "b": true, "c": false} {
+		_, err := c.Read(ctx, allTypes, allTypesKey(id), nil)
+		if found {
+			assert.NoError(t, err, id)
+		} else {
+			assert.True(t, dosa.ErrorIsNotFound(err), "%s: expected ErrNotFound, got %v", id, err)
+		}
+	}
+}
+
+func testRangeOrder(t *testing.T, c dosa.Connector) {
+	upsertEvents(t, c)
+	// rows are ordered by day ascending, then by seq descending
+	rows := rangeAll(t, c, events, map[string][]*dosa.Condition{"owner": eq("a")}, 100)
+	assert.Equal(t, []string{
+		"a/1/3", "a/1/2", "a/1/1",
+		"a/2/3", "a/2/2", "a/2/1",
+		"a/3/3", "a/3/2", "a/3/1",
+	}, eventIDs(rows))
+	for _, row := range rows {
+		assertRow(t, event(row["owner"].(string), row["day"].(int64), row["seq"].(int64)), row, nil)
+	}
+
+	rows = rangeAll(t, c, events, map[string][]*dosa.Condition{"owner": eq("missing")}, 100)
+	assert.Empty(t, rows)
+}
+
+func testRangeConditions(t *testing.T, c dosa.Connector) {
+	upsertEvents(t, c)
+	tests := []struct {
+		conditions map[string][]*dosa.Condition
+		expected   []string
+	}{
+		{
+			conditions: map[string][]*dosa.Condition{"owner": eq("b"), "day": eq(int64(2))},
+			expected:   []string{"b/2/3", "b/2/2", "b/2/1"},
+		},
+		{
+			conditions: map[string][]*dosa.Condition{"owner": eq("b"), "day": {{Op: dosa.Gt, Value: int64(1)}}},
+			expected:   []string{"b/2/3", "b/2/2", "b/2/1", "b/3/3", "b/3/2", "b/3/1"},
+		},
+		{
+			conditions: map[string][]*dosa.Condition{
+				"owner": eq("b"),
+				"day":   {{Op: dosa.GtOrEq, Value: int64(1)}, {Op: dosa.LtOrEq, Value: int64(2)}},
+			},
+			expected: []string{"b/1/3", "b/1/2", "b/1/1", "b/2/3", "b/2/2", "b/2/1"},
+		},
+		{
+			conditions: map[string][]*dosa.Condition{"owner": eq("b"), "day": {{Op: dosa.Lt, Value: int64(1)}}},
+			expected:   []string{},
+		},
+		{
+			conditions: map[string][]*dosa.Condition{
+				"owner": eq("a"),
+				"day":   eq(int64(3)),
+				"seq":   {{Op: dosa.Lt, Value: int64(3)}},
+			},
+			expected: []string{"a/3/2", "a/3/1"},
+		},
+	}
+	for _, test := range tests {
+		rows := rangeAll(t, c, events, test.conditions, 100)
+		assert.Equal(t, test.expected, eventIDs(rows), "conditions %v", test.conditions)
+	}
+}
+
+func testRangePagination(t *testing.T, c dosa.Connector) {
+	upsertEvents(t, c)
+	conditions := map[string][]*dosa.Condition{"owner": eq("a")}
+	all := eventIDs(rangeAll(t, c, events, conditions, 100))
+	for _, limit := range []int{1, 2, 4, 9} {
+		assert.Equal(t, all, eventIDs(rangeAll(t, c, events, conditions, limit)), "limit %d", limit)
+	}
+
+	// the same token always resumes from the same row
+	first, token, err := c.Range(ctx, events, conditions, nil, "", 4)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.Equal(t, all[:4], eventIDs(first))
+	for i := 0; i < 2; i++ {
+		page, _, err := c.Range(ctx, events, conditions, nil, token, 4)
+		require.NoError(t, err)
+		assert.Equal(t, all[4:8], eventIDs(page))
+	}
+	// even when rows of the previous pages are removed
+	require.NoError(t, c.Remove(ctx, events, eventKey("a", 1, 3)))
+	page, _, err := c.Range(ctx, events, conditions, nil, token, 4)
+	require.NoError(t, err)
+	assert.Equal(t, all[4:8], eventIDs(page))
+}
+
+func testRemoveRange(t *testing.T, c dosa.Connector) {
+	upsertEvents(t, c)
+	require.NoError(t, c.RemoveRange(ctx, events, map[string][]*dosa.Condition{
+		"owner": eq("a"),
+		"day":   {{Op: dosa.GtOrEq, Value: int64(2)}},
+	}))
+	rows := rangeAll(t, c, events, map[string][]*dosa.Condition{"owner": eq("a")}, 100)
+	assert.Equal(t, []string{"a/1/3", "a/1/2", "a/1/1"}, eventIDs(rows))
+	// other partitions are left alone
+	rows = rangeAll(t, c, events, map[string][]*dosa.Condition{"owner": eq("b")}, 100)
+	assert.Len(t, rows, 9)
+
+	require.NoError(t, c.RemoveRange(ctx, events, map[string][]*dosa.Condition{"owner": eq("b")}))
+	rows = rangeAll(t, c, events, map[string][]*dosa.Condition{"owner": eq("b")}, 100)
+	assert.Empty(t, rows)
+	_, err := c.Read(ctx, events, eventKey("b", 1, 1), nil)
+	assert.True(t, dosa.ErrorIsNotFound(err), "expected ErrNotFound, got %v", err)
+}
+
+func testSecondaryIndex(t *testing.T, c dosa.Connector) {
+	upsertEvents(t, c)
+	rows := rangeAll(t, c, events, map[string][]*dosa.Condition{"tag": eq("odd")}, 3)
+	ids := eventIDs(rows)
+	sort.Strings(ids)
+	assert.Equal(t, []string{"a/1/2", "a/2/1", "a/2/3", "a/3/2", "b/1/2", "b/2/1", "b/2/3", "b/3/2"}, ids)
+	for _, row := range rows {
+		assertRow(t, event(row["owner"].(string), row["day"].(int64), row["seq"].(int64)), row, []string{"owner", "day", "seq", "tag"})
+	}
+
+	// removed rows are removed from the index too
+	require.NoError(t, c.Remove(ctx, events, eventKey("a", 1, 2)))
+	require.NoError(t, c.RemoveRange(ctx, events, map[string][]*dosa.Condition{"owner": eq("b")}))
+	rows = rangeAll(t, c, events, map[string][]*dosa.Condition{"tag": eq("odd")}, 100)
+	ids = eventIDs(rows)
+	sort.Strings(ids)
+	assert.Equal(t, []string{"a/2/1", "a/2/3", "a/3/2"}, ids)
+}
+
+func testScan(t *testing.T, c dosa.Connector) {
+	rows, _, err := c.Scan(ctx, allTypes, nil, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+
+	ids := []string{"a", "b", "c", "dd", "ee", "fff", "g"}
+	for _, id := range ids {
+		require.NoError(t, c.Upsert(ctx, allTypes, allTypesRow(id)))
+	}
+	// every row is scanned exactly once, whatever the page size
+	for _, limit := range []int{1, 3, 7, 100} {
+		var scanned []string
+		token := ""
+		for pages := 0; ; pages++ {
+			require.True(t, pages < 100, "too many pages")
+			page, next, err := c.Scan(ctx, allTypes, nil, token, limit)
+			require.NoError(t, err)
+			assert.True(t, len(page) <= limit, "%d rows in a page of %d", len(page), limit)
+			for _, row := range page {
+				scanned = append(scanned, row["id"].(string))
+				assertRow(t, allTypesRow(row["id"].(string)), row, nil)
+			}
+			if next == "" {
+				break
+			}
+			token = next
+		}
+		sort.Strings(scanned)
+		assert.Equal(t, ids, scanned, "limit %d", limit)
+	}
+}
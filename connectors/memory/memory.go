@@ -525,16 +525,28 @@ func (c *Connector) Range(_ context.Context, ei *dosa.EntityInfo, columnConditio
 	slice := partitionRange.values()
 	token = ""
 	if len(slice) > limit {
-		token = makeToken(slice[limit-1])
+		token = makeToken(slice[limit-1], ei.Def.Key, key)
 		slice = slice[:limit]
 	}
 
 	return copyRows(slice), token, nil
 }
 
-func makeToken(v map[string]dosa.FieldValue) string {
+// makeToken encodes the columns of the given keys, which is all that is needed
+// to resume after the row; the other columns may hold values gob cannot
+// encode, like nil pointers
+func makeToken(v map[string]dosa.FieldValue, keys ...*dosa.PrimaryKey) string {
+	key := make(map[string]dosa.FieldValue)
+	for _, pk := range keys {
+		for _, col := range pk.PartitionKeys {
+			key[col] = v[col]
+		}
+		for _, ck := range pk.ClusteringKeys {
+			key[ck.Name] = v[ck.Name]
+		}
+	}
 	encoder := encoding.NewGobEncoder()
-	encodedKey, err := encoder.Encode(v)
+	encodedKey, err := encoder.Encode(key)
 	if err != nil {
 		// this should really be impossible, unless someone forgot to
 		// register some newly supported type with the encoder
@@ -692,7 +704,7 @@ func (c *Connector) Scan(_ context.Context, ei *dosa.EntityInfo, minimumFields [
 	// see if we need a token to return
 	token = ""
 	if len(allTheThings) > limit {
-		token = makeToken(allTheThings[limit-1])
+		token = makeToken(allTheThings[limit-1], ei.Def.Key)
 		allTheThings = allTheThings[:limit]
 	}
 	return copyRows(allTheThings), token, nil
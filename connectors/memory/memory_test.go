@@ -22,6 +22,7 @@ package memory
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"sort"
@@ -32,6 +33,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/conformance"
 )
 
 var testSchemaRef = dosa.SchemaRef{
@@ -1143,11 +1145,31 @@ func TestInvalidToken(t *testing.T) {
 func TestEncoderPanic(t *testing.T) {
 	assert.Panics(t, func() {
 		makeToken(map[string]dosa.FieldValue{
-			"oops": func() {},
-		})
+			"p1": func() {},
+		}, testEi.Def.Key)
 	})
 }
 
+// TestConnector_ScanWithNilPointers ensures nil pointer values don't explode either,
+// only the primary key is encoded in the token
+func TestConnector_ScanWithNilPointers(t *testing.T) {
+	sut := NewConnector()
+	for x := 0; x < 3; x++ {
+		err := sut.Upsert(context.TODO(), testEi, map[string]dosa.FieldValue{
+			"p1": dosa.FieldValue(fmt.Sprintf("key%d", x)),
+			"c6": dosa.FieldValue((*int32)(nil)),
+		})
+		assert.NoError(t, err)
+	}
+	data, token, err := sut.Scan(context.TODO(), testEi, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.Len(t, data, 2)
+	data, token, err = sut.Scan(context.TODO(), testEi, dosa.All(), token, 2)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1)
+	assert.Empty(t, token)
+}
+
 // TestConnector_ScanWithTimeFields ensures time.Time values don't explode
 func TestConnector_ScanWithTimeFields(t *testing.T) {
 	sut := NewConnector()
@@ -1601,3 +1623,9 @@ func createTestData(t *testing.T, sut *Connector, keyGenFunc func(int) string, i
 		assert.NoError(t, err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	conformance.RunSuite(t, func() dosa.Connector {
+		return NewConnector()
+	})
+}
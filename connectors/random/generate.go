@@ -0,0 +1,379 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package random
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/uber-go/dosa"
+)
+
+var validRunes = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ!@#$%^&*()_-+={[}];:,.<>/?")
+
+// attempts is how many candidates are tried for a value satisfying some conditions before
+// falling back to an inclusive bound of the conditions.
+const attempts = 8
+
+// rng is a splitmix64 generator. It is cheap to create, so each value gets its own, seeded from
+// the connector seed and what the value is for, which makes values independent of the order
+// they are generated in.
+type rng struct {
+	state uint64
+}
+
+func (r *rng) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a number in [0, n).
+func (r *rng) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+// float64 returns a number in [0, 1).
+func (r *rng) float64() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+// between returns a number in [min, max].
+func (r *rng) between(min, max int) int {
+	return min + r.intn(max-min+1)
+}
+
+// seedOf hashes the connector seed with the name and values of the given fields, in name
+// order, and a number distinguishing the rows generated for the same fields.
+func seedOf(seed int64, name string, values map[string]dosa.FieldValue, n uint64) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(seed))
+	_, _ = h.Write(buf[:])
+	writeField(h, []byte(name))
+	names := make([]string, 0, len(values))
+	for field := range values {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		writeField(h, []byte(field))
+		writeField(h, encodeValue(values[field]))
+	}
+	binary.BigEndian.PutUint64(buf[:], n)
+	_, _ = h.Write(buf[:])
+	return h.Sum64()
+}
+
+// columnRNG returns the generator of a column of a row.
+func columnRNG(rowSeed uint64, column string) *rng {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(column))
+	return &rng{state: rowSeed ^ h.Sum64()}
+}
+
+// writeField writes a length-prefixed field to the hash, so that consecutive fields cannot be
+// confused with each other.
+func writeField(h hash.Hash64, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	_, _ = h.Write(length[:])
+	_, _ = h.Write(data)
+}
+
+func encodeValue(value dosa.FieldValue) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case int32:
+		return strconv.AppendInt(nil, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case float64:
+		return strconv.AppendUint(nil, math.Float64bits(v), 10)
+	case bool:
+		return strconv.AppendBool(nil, v)
+	case time.Time:
+		return strconv.AppendInt(nil, v.UnixNano(), 10)
+	case dosa.UUID:
+		return []byte(v)
+	}
+	return []byte(fmt.Sprint(value))
+}
+
+// sizes are the inclusive bounds of the length of generated strings or blobs.
+type sizes struct {
+	min, max int
+}
+
+// generate returns a value of the given type satisfying all the conditions, if it finds one.
+// Without conditions, it always does.
+func (c *Connector) generate(r *rng, t dosa.Type, conds []*dosa.Condition) (dosa.FieldValue, bool) {
+	var lo, hi *dosa.Condition
+	for _, cond := range conds {
+		switch cond.Op {
+		case dosa.Eq:
+			return cond.Value, satisfies(cond.Value, conds)
+		case dosa.Gt, dosa.GtOrEq:
+			lo = cond
+		case dosa.Lt, dosa.LtOrEq:
+			hi = cond
+		}
+	}
+
+	for i := 0; i < attempts; i++ {
+		v := c.candidate(r, t, lo, hi, i)
+		if satisfies(v, conds) {
+			return v, true
+		}
+	}
+	if lo != nil && lo.Op == dosa.GtOrEq && satisfies(lo.Value, conds) {
+		return lo.Value, true
+	}
+	if hi != nil && hi.Op == dosa.LtOrEq && satisfies(hi.Value, conds) {
+		return hi.Value, true
+	}
+	return nil, false
+}
+
+// candidate returns a value of the given type, trying to stay within the bounds; the attempt
+// number varies the strategy used for strings and blobs.
+func (c *Connector) candidate(r *rng, t dosa.Type, lo, hi *dosa.Condition, attempt int) dosa.FieldValue {
+	switch t {
+	case dosa.Int32:
+		return int32(intWithin(r, lo, hi, math.MinInt32, math.MaxInt32, func(v dosa.FieldValue) int64 {
+			return int64(v.(int32))
+		}))
+	case dosa.Int64:
+		return intWithin(r, lo, hi, math.MinInt64, math.MaxInt64, func(v dosa.FieldValue) int64 {
+			return v.(int64)
+		})
+	case dosa.Timestamp:
+		return time.Unix(0, intWithin(r, lo, hi, 0, math.MaxInt64/2, func(v dosa.FieldValue) int64 {
+			return v.(time.Time).UnixNano()
+		}))
+	case dosa.Double:
+		return doubleWithin(r, lo, hi)
+	case dosa.Bool:
+		if attempt > 0 {
+			// make sure both values get tried
+			return attempt%2 == 1
+		}
+		return r.intn(2) == 1
+	case dosa.String:
+		return string(bytesWithin(r, lo, hi, attempt, c.stringSizes(), func() byte {
+			return validRunes[r.intn(len(validRunes))]
+		}, func(v dosa.FieldValue) []byte {
+			return []byte(v.(string))
+		}))
+	case dosa.Blob:
+		return bytesWithin(r, lo, hi, attempt, c.blobSizes(), func() byte {
+			return byte(r.intn(256))
+		}, func(v dosa.FieldValue) []byte {
+			return v.([]byte)
+		})
+	case dosa.TUUID:
+		var u uuid.UUID
+		binary.BigEndian.PutUint64(u[:8], r.next())
+		binary.BigEndian.PutUint64(u[8:], r.next())
+		u.SetVersion(uuid.V4)
+		u.SetVariant(uuid.VariantRFC4122)
+		return dosa.UUID(u.String())
+	}
+	panic("invalid type " + t.String())
+}
+
+// intWithin returns a number within the bounds, and within [min, max] when there are none.
+func intWithin(r *rng, lo, hi *dosa.Condition, min, max int64, toInt func(dosa.FieldValue) int64) int64 {
+	low, high := min, max
+	if lo == nil && hi == nil && min < 0 {
+		// unbounded numbers are not negative, as they have always been
+		low = 0
+	}
+	if lo != nil {
+		low = toInt(lo.Value)
+		if lo.Op == dosa.Gt {
+			if low == max {
+				return low
+			}
+			low++
+		}
+	}
+	if hi != nil {
+		high = toInt(hi.Value)
+		if hi.Op == dosa.Lt {
+			if high == min {
+				return high
+			}
+			high--
+		}
+	}
+	if low > high {
+		return low
+	}
+	span := uint64(high) - uint64(low) + 1
+	if span == 0 {
+		// the whole range of int64
+		return int64(r.next())
+	}
+	return low + int64(r.next()%span)
+}
+
+// doubleWithin returns a number within the bounds, and within [0, 1) when there are none.
+func doubleWithin(r *rng, lo, hi *dosa.Condition) float64 {
+	low, high := 0.0, 1.0
+	switch {
+	case lo != nil && hi != nil:
+		low, high = lo.Value.(float64), hi.Value.(float64)
+	case lo != nil:
+		low = lo.Value.(float64)
+		high = low + 1
+	case hi != nil:
+		high = hi.Value.(float64)
+		low = high - 1
+	}
+	v := low + (high-low)*r.float64()
+	if lo != nil && lo.Op == dosa.Gt && v == low {
+		v = math.Nextafter(v, math.Inf(1))
+	}
+	return v
+}
+
+// bytesWithin returns a string of bytes, trying to stay within the bounds: extending the lower
+// bound, truncating the upper bound, or generating one from scratch.
+func bytesWithin(r *rng, lo, hi *dosa.Condition, attempt int, size sizes, next func() byte, toBytes func(dosa.FieldValue) []byte) []byte {
+	random := func(min, max int) []byte {
+		b := make([]byte, r.between(min, max))
+		for i := range b {
+			b[i] = next()
+		}
+		return b
+	}
+	switch {
+	case attempt%3 == 0 && lo != nil:
+		low := toBytes(lo.Value)
+		return append(append([]byte{}, low...), random(1, size.max)...)
+	case attempt%3 == 1 && hi != nil:
+		high := toBytes(hi.Value)
+		if len(high) > 0 {
+			return append([]byte{}, high[:r.intn(len(high))]...)
+		}
+	}
+	return random(size.min, size.max)
+}
+
+// satisfies checks a value against conditions.
+func satisfies(v dosa.FieldValue, conds []*dosa.Condition) bool {
+	for _, cond := range conds {
+		cmp := compare(v, cond.Value)
+		switch cond.Op {
+		case dosa.Eq:
+			if cmp != 0 {
+				return false
+			}
+		case dosa.Gt:
+			if cmp <= 0 {
+				return false
+			}
+		case dosa.GtOrEq:
+			if cmp < 0 {
+				return false
+			}
+		case dosa.Lt:
+			if cmp >= 0 {
+				return false
+			}
+		case dosa.LtOrEq:
+			if cmp > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compare orders two values of the same type the way the memory connector does.
+func compare(a, b dosa.FieldValue) int {
+	switch a := a.(type) {
+	case int32:
+		return compareInts(int64(a), int64(b.(int32)))
+	case int64:
+		return compareInts(a, b.(int64))
+	case float64:
+		switch b := b.(float64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case bool:
+		return compareInts(boolToInt(a), boolToInt(b.(bool)))
+	case time.Time:
+		return compareInts(a.UnixNano(), b.(time.Time).UnixNano())
+	case string:
+		return bytes.Compare([]byte(a), []byte(b.(string)))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case dosa.UUID:
+		u1 := uuid.FromStringOrNil(string(a))
+		u2 := uuid.FromStringOrNil(string(b.(dosa.UUID)))
+		if u1.Version() != u2.Version() {
+			return compareInts(int64(u1.Version()), int64(u2.Version()))
+		}
+		if u1.Version() == uuid.V1 {
+			t1, _ := uuid.TimestampFromV1(u1)
+			t2, _ := uuid.TimestampFromV1(u2)
+			return compareInts(int64(t1), int64(t2))
+		}
+		return bytes.Compare([]byte(a), []byte(b.(dosa.UUID)))
+	}
+	panic(fmt.Sprintf("cannot compare %T values", a))
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
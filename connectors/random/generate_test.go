@@ -0,0 +1,108 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package random
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+)
+
+func TestGenerateWithinBounds(t *testing.T) {
+	c := NewConnector(WithSeed(3))
+	types := []dosa.Type{dosa.Int32, dosa.Int64, dosa.Double, dosa.Timestamp, dosa.String, dosa.Blob, dosa.Bool, dosa.TUUID}
+	ops := []dosa.Operator{dosa.Gt, dosa.GtOrEq, dosa.Lt, dosa.LtOrEq}
+	seeds := rand.New(rand.NewSource(1))
+
+	for _, typ := range types {
+		found, tried := 0, 0
+		for i := 0; i < 200; i++ {
+			r := &rng{state: seeds.Uint64()}
+			a, _ := c.generate(r, typ, nil)
+			b, _ := c.generate(r, typ, nil)
+			if compare(a, b) > 0 {
+				a, b = b, a
+			}
+			conds := []*dosa.Condition{{Op: ops[i%2], Value: a}, {Op: ops[2+(i/2)%2], Value: b}}
+			if i%5 == 0 {
+				// a single bound
+				conds = conds[i%2 : i%2+1]
+			}
+			tried++
+			if v, ok := c.generate(r, typ, conds); ok {
+				found++
+				assert.True(t, satisfies(v, conds), "%s %v does not satisfy %v %v", typ, v, conds[0], conds[len(conds)-1])
+			}
+		}
+		// ranges between two random values are hardly ever empty, except for booleans
+		if typ != dosa.Bool {
+			assert.True(t, found > tried*9/10, "%s: only %d values found in %d ranges", typ, found, tried)
+		}
+	}
+}
+
+func TestGenerateEmptyRanges(t *testing.T) {
+	c := NewConnector()
+	r := &rng{}
+	_, ok := c.generate(r, dosa.Int64, []*dosa.Condition{{Op: dosa.Gt, Value: int64(5)}, {Op: dosa.Lt, Value: int64(6)}})
+	assert.False(t, ok)
+	_, ok = c.generate(r, dosa.Int32, []*dosa.Condition{{Op: dosa.Gt, Value: int32(math.MaxInt32)}})
+	assert.False(t, ok)
+	_, ok = c.generate(r, dosa.String, []*dosa.Condition{{Op: dosa.Lt, Value: ""}})
+	assert.False(t, ok)
+
+	v, ok := c.generate(r, dosa.Int64, []*dosa.Condition{{Op: dosa.GtOrEq, Value: int64(5)}, {Op: dosa.Lt, Value: int64(6)}})
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), v)
+	v, ok = c.generate(r, dosa.Bool, []*dosa.Condition{{Op: dosa.Gt, Value: false}})
+	assert.True(t, ok)
+	assert.Equal(t, true, v)
+	v, ok = c.generate(r, dosa.Timestamp, []*dosa.Condition{{Op: dosa.Eq, Value: time.Unix(10, 0)}})
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(10, 0), v)
+}
+
+func TestGenerateSizes(t *testing.T) {
+	c := NewConnector(WithStringSize(0, 3), WithBlobSize(8, 8))
+	for i := 0; i < 100; i++ {
+		r := &rng{state: uint64(i)}
+		s, _ := c.generate(r, dosa.String, nil)
+		assert.True(t, len(s.(string)) <= 3)
+		b, _ := c.generate(r, dosa.Blob, nil)
+		assert.Len(t, b, 8)
+	}
+}
+
+func TestSeedOf(t *testing.T) {
+	values := map[string]dosa.FieldValue{"a": "x", "b": int64(1)}
+	assert.Equal(t, seedOf(1, "e", values, 0), seedOf(1, "e", map[string]dosa.FieldValue{"b": int64(1), "a": "x"}, 0))
+	assert.NotEqual(t, seedOf(1, "e", values, 0), seedOf(2, "e", values, 0))
+	assert.NotEqual(t, seedOf(1, "e", values, 0), seedOf(1, "f", values, 0))
+	assert.NotEqual(t, seedOf(1, "e", values, 0), seedOf(1, "e", values, 1))
+	// field boundaries matter
+	assert.NotEqual(t,
+		seedOf(1, "e", map[string]dosa.FieldValue{"a": "bc"}, 0),
+		seedOf(1, "e", map[string]dosa.FieldValue{"ab": "c"}, 0))
+}
@@ -22,10 +22,13 @@ package random
 
 import (
 	"context"
-
+	"encoding/base64"
 	"math/rand"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
 )
 
@@ -33,10 +36,88 @@ const (
 	maxBlobSize       = 32
 	maxStringSize     = 64
 	defaultRangeLimit = 200
+	defaultRowCount   = 1000
 )
 
-// Connector is a connector implementation for testing
-type Connector struct{}
+// Connector is a connector implementation for testing. The data it returns is generated from
+// a seed, so the same seed always gives the same rows: Read returns the same values for the same
+// key, and Range and Scan the same pages for the same conditions and tokens. The zero value
+// uses seed 0 and the default sizes and row count.
+type Connector struct {
+	seed        int64
+	stringSize  sizes
+	blobSize    sizes
+	rowCount    int
+	hasRowCount bool
+}
+
+// Options configure the random connector.
+type Options func(*Connector) error
+
+// WithSeed sets the seed the data is generated from.
+func WithSeed(seed int64) Options {
+	return func(c *Connector) error {
+		c.seed = seed
+		return nil
+	}
+}
+
+// WithStringSize sets the bounds of the length of generated strings, 1 to 64 by default.
+func WithStringSize(min, max int) Options {
+	return func(c *Connector) error {
+		if min < 0 || max < min || max == 0 {
+			return errors.Errorf("invalid string size %d-%d", min, max)
+		}
+		c.stringSize = sizes{min: min, max: max}
+		return nil
+	}
+}
+
+// WithBlobSize sets the bounds of the length of generated blobs, 1 to 31 by default.
+func WithBlobSize(min, max int) Options {
+	return func(c *Connector) error {
+		if min < 0 || max < min || max == 0 {
+			return errors.Errorf("invalid blob size %d-%d", min, max)
+		}
+		c.blobSize = sizes{min: min, max: max}
+		return nil
+	}
+}
+
+// WithRowCount sets how many rows Scan returns in all, and Range in each partition, over all
+// pages, 1000 by default. Range may return fewer rows, when the conditions on the clustering keys
+// leave few distinct values.
+func WithRowCount(rows int) Options {
+	return func(c *Connector) error {
+		if rows < 0 {
+			return errors.Errorf("invalid row count %d", rows)
+		}
+		c.rowCount = rows
+		c.hasRowCount = true
+		return nil
+	}
+}
+
+func (c *Connector) stringSizes() sizes {
+	if c.stringSize.max == 0 {
+		return sizes{min: 1, max: maxStringSize}
+	}
+	return c.stringSize
+}
+
+func (c *Connector) blobSizes() sizes {
+	if c.blobSize.max == 0 {
+		return sizes{min: 1, max: maxBlobSize - 1}
+	}
+	return c.blobSize
+}
+
+func (c *Connector) rows() int {
+	if !c.hasRowCount {
+		return defaultRowCount
+	}
+	return c.rowCount
+}
 
 // CreateIfNotExists always succeeds
 func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
@@ -44,8 +125,7 @@ func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo,
 }
 
 func randomString(slen int) string {
-	var validRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ!@#$%^&*()_-+={[}];:,.<>/?")
-	str := make([]rune, slen)
+	str := make([]byte, slen)
 	for i := range str {
 		str[i] = validRunes[rand.Intn(len(validRunes))]
 	}
@@ -96,18 +176,43 @@ func Data(ei *dosa.EntityInfo, minimumFields []string) map[string]dosa.FieldValu
 	return result
 }
 
-// Read always returns random data of the type specified
-func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+// row generates a row with the given key values. The other fields, the minimum fields or all the
+// columns when there are none, only depend on the seed, the entity and the key.
+func (c *Connector) row(ei *dosa.EntityInfo, key map[string]dosa.FieldValue, minimumFields []string) map[string]dosa.FieldValue {
+	rowSeed := seedOf(c.seed, ei.Def.Name, key, 0)
+	result := make(map[string]dosa.FieldValue, len(key)+len(minimumFields))
+	for name, value := range key {
+		result[name] = value
+	}
+	generate := func(cd *dosa.ColumnDefinition) {
+		if _, ok := result[cd.Name]; !ok {
+			result[cd.Name], _ = c.generate(columnRNG(rowSeed, cd.Name), cd.Type, nil)
+		}
+	}
+	if minimumFields == nil {
+		for _, cd := range ei.Def.Columns {
+			generate(cd)
+		}
+	}
+	for _, field := range minimumFields {
+		if cd := ei.Def.FindColumnDefinition(field); cd != nil {
+			generate(cd)
+		}
+	}
+	return result
+}
 
-	return Data(ei, minimumFields), nil
+// Read returns generated data of the type specified, along with the key values read
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	return c.row(ei, values, minimumFields), nil
 }
 
-// MultiRead returns a set of random data for each key you specify
+// MultiRead returns a set of generated data for each key you specify
 func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
 	vals := make([]*dosa.FieldValuesOrError, len(values))
 	for inx := range values {
 		vals[inx] = &dosa.FieldValuesOrError{
-			Values: Data(ei, minimumFields),
+			Values: c.row(ei, values[inx], minimumFields),
 		}
 	}
 	return vals, nil
@@ -147,21 +252,131 @@ func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiV
 	return makeErrorSlice(len(multiValues), &dosa.ErrNotFound{}), nil
 }
 
-// Range returns a random set of data, and a random continuation token
+// Range returns generated rows of the partition, satisfying the conditions on the clustering keys
+// and ordered by them. Tokens are offsets in the rows of the partition.
 func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	name, key, err := ei.IndexFromConditions(columnConditions, true)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Invalid range conditions")
+	}
+	offset, err := decodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
 	if limit == dosa.AdaptiveRangeLimit {
 		limit = defaultRangeLimit
 	}
-	vals := make([]map[string]dosa.FieldValue, limit)
-	for inx := range vals {
-		vals[inx] = Data(ei, minimumFields)
+
+	partition := make(map[string]dosa.FieldValue, len(key.PartitionKeys))
+	for _, pk := range key.PartitionKeys {
+		partition[pk] = columnConditions[pk][0].Value
+	}
+	var rows []map[string]dosa.FieldValue
+	seen := make(map[uint64]bool)
+	for i := 0; i < c.rows(); i++ {
+		r := &rng{state: seedOf(c.seed, name, partition, uint64(i))}
+		values, ok := c.clusteringValues(r, ei, key, columnConditions)
+		if !ok {
+			continue
+		}
+		id := seedOf(0, "", values, 0)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		for pk, value := range partition {
+			values[pk] = value
+		}
+		rows = append(rows, values)
 	}
-	return vals, randomString(32), nil
+	sort.Slice(rows, func(i, j int) bool {
+		for _, ck := range key.ClusteringKeys {
+			if cmp := compare(rows[i][ck.Name], rows[j][ck.Name]); cmp != 0 {
+				return (cmp < 0) != ck.Descending
+			}
+		}
+		return false
+	})
+
+	page, token := paginate(len(rows), offset, limit)
+	results := make([]map[string]dosa.FieldValue, 0, len(page))
+	for _, i := range page {
+		results = append(results, c.row(ei, rows[i], minimumFields))
+	}
+	return results, token, nil
 }
 
-// Scan also returns a random set of data, like Range
+// clusteringValues generates values of the clustering keys satisfying their conditions.
+func (c *Connector) clusteringValues(r *rng, ei *dosa.EntityInfo, key *dosa.PrimaryKey, columnConditions map[string][]*dosa.Condition) (map[string]dosa.FieldValue, bool) {
+	values := make(map[string]dosa.FieldValue, len(key.ClusteringKeys))
+	for _, ck := range key.ClusteringKeys {
+		cd := ei.Def.FindColumnDefinition(ck.Name)
+		if cd == nil {
+			return nil, false
+		}
+		value, ok := c.generate(r, cd.Type, columnConditions[ck.Name])
+		if !ok {
+			return nil, false
+		}
+		values[ck.Name] = value
+	}
+	return values, true
+}
+
+// Scan returns generated rows with generated keys. Tokens are offsets in all the rows.
 func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
-	return c.Range(ctx, ei, map[string][]*dosa.Condition{}, minimumFields, token, limit)
+	offset, err := decodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit == dosa.AdaptiveRangeLimit {
+		limit = defaultRangeLimit
+	}
+
+	page, token := paginate(c.rows(), offset, limit)
+	results := make([]map[string]dosa.FieldValue, 0, len(page))
+	for _, i := range page {
+		r := &rng{state: seedOf(c.seed, ei.Def.Name, nil, uint64(i))}
+		key := make(map[string]dosa.FieldValue)
+		for _, pk := range ei.Def.Key.PartitionKeys {
+			if cd := ei.Def.FindColumnDefinition(pk); cd != nil {
+				key[pk], _ = c.generate(r, cd.Type, nil)
+			}
+		}
+		values, _ := c.clusteringValues(r, ei, ei.Def.Key, nil)
+		for ck, value := range values {
+			key[ck] = value
+		}
+		results = append(results, c.row(ei, key, minimumFields))
+	}
+	return results, token, nil
+}
+
+// paginate returns the positions of the rows of the page starting at offset, and the token of
+// the next page, empty if it is the last one.
+func paginate(count, offset, limit int) ([]int, string) {
+	var page []int
+	for i := offset; i < count && len(page) < limit; i++ {
+		page = append(page, i)
+	}
+	if offset+len(page) >= count {
+		return page, ""
+	}
+	return page, base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(offset + len(page))))
+}
+
+func decodeToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err == nil {
+		var offset int
+		if offset, err = strconv.Atoi(string(data)); err == nil && offset >= 0 {
+			return offset, nil
+		}
+	}
+	return 0, errors.Errorf("Invalid token %q", token)
 }
 
 // CheckSchema always returns schema version 1
@@ -217,7 +432,15 @@ func (c *Connector) Shutdown() error {
 	return nil
 }
 
-// NewConnector creates a new random connector
-func NewConnector() *Connector {
-	return &Connector{}
+// NewConnector creates a new random connector. The random connector is a test fixture whose
+// options are fixed by the code using it, so NewConnector panics on an invalid option rather
+// than generating data that silently ignores it.
+func NewConnector(opts ...Options) *Connector {
+	c := &Connector{}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			panic(err)
+		}
+	}
+	return c
 }
@@ -22,6 +22,7 @@ package random_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"time"
@@ -45,6 +46,26 @@ type AllTypes struct {
 	UUIDType    dosa.UUID
 }
 
+type ClusteredTypes struct {
+	dosa.Entity `dosa:"primaryKey=((StringType), Int64Type DESC, UUIDType)"`
+	StringType  string
+	Int64Type   int64
+	UUIDType    dosa.UUID
+	BlobType    []byte
+	DoubleType  float64
+}
+
+var (
+	clusteredTable, _ = dosa.TableFromInstance((*ClusteredTypes)(nil))
+	clusteredInfo     = &dosa.EntityInfo{
+		Def: &clusteredTable.EntityDefinition,
+		Ref: &dosa.SchemaRef{Scope: "testScope", NamePrefix: "testPrefix", EntityName: "clusteredtypes"},
+	}
+	partitionConditions = map[string][]*dosa.Condition{
+		"stringtype": {{Op: dosa.Eq, Value: "partition"}},
+	}
+)
+
 var (
 	testTable, _ = dosa.TableFromInstance((*AllTypes)(nil))
 	testInfo     = &dosa.EntityInfo{
@@ -76,6 +97,26 @@ func TestRandom_Read(t *testing.T) {
 	}
 }
 
+func TestRandom_ReadIsReproducible(t *testing.T) {
+	key := map[string]dosa.FieldValue{"stringtype": "k", "int64type": int64(7), "uuidtype": dosa.UUID("5d4a7b6c-2f0e-4a3b-9c8d-1e2f3a4b5c6d")}
+	val, err := random.NewConnector(random.WithSeed(42)).Read(ctx, clusteredInfo, key, nil)
+	assert.NoError(t, err)
+	for name, value := range key {
+		assert.Equal(t, value, val[name])
+	}
+	assert.Len(t, val, len(clusteredInfo.Def.Columns))
+
+	again, _ := random.NewConnector(random.WithSeed(42)).Read(ctx, clusteredInfo, key, []string{"blobtype"})
+	assert.Equal(t, val["blobtype"], again["blobtype"])
+	assert.NotContains(t, again, "doubletype")
+	other, _ := random.NewConnector(random.WithSeed(43)).Read(ctx, clusteredInfo, key, nil)
+	assert.NotEqual(t, val["doubletype"], other["doubletype"])
+
+	multi, err := random.NewConnector(random.WithSeed(42)).MultiRead(ctx, clusteredInfo, []map[string]dosa.FieldValue{key}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, val, multi[0].Values)
+}
+
 func TestRandom_MultiRead(t *testing.T) {
 	v, e := sut.MultiRead(ctx, testInfo, testMultiValues, minimumFields)
 	assert.NotNil(t, v)
@@ -116,23 +157,121 @@ func TestRandom_MultiRemove(t *testing.T) {
 }
 
 func TestRandom_Range(t *testing.T) {
-	vals, _, err := sut.Range(ctx, testInfo, testConditions, minimumFields, "", 32)
-	assert.NotNil(t, vals)
+	vals, _, err := sut.Range(ctx, clusteredInfo, partitionConditions, nil, "", 32)
+	assert.Len(t, vals, 32)
 	assert.NoError(t, err)
+
+	_, _, err = sut.Range(ctx, testInfo, testConditions, minimumFields, "", 32)
+	assert.Error(t, err)
 }
 
 func TestRandom_RangeAdaptiveLimits(t *testing.T) {
-	vals, _, err := sut.Range(ctx, testInfo, testConditions, minimumFields, "", dosa.AdaptiveRangeLimit)
+	vals, _, err := sut.Range(ctx, clusteredInfo, partitionConditions, nil, "", dosa.AdaptiveRangeLimit)
 	assert.Len(t, vals, 200)
 	assert.NoError(t, err)
 }
 
+func TestRandom_RangeConditions(t *testing.T) {
+	sut := random.NewConnector(random.WithSeed(7), random.WithRowCount(100))
+	conditions := map[string][]*dosa.Condition{
+		"stringtype": {{Op: dosa.Eq, Value: "partition"}},
+		"int64type":  {{Op: dosa.Gt, Value: int64(10)}, {Op: dosa.LtOrEq, Value: int64(60)}},
+	}
+
+	var rows []map[string]dosa.FieldValue
+	token := ""
+	for pages := 0; pages == 0 || token != ""; pages++ {
+		var page []map[string]dosa.FieldValue
+		var err error
+		page, token, err = sut.Range(ctx, clusteredInfo, conditions, []string{"blobtype"}, token, 30)
+		assert.NoError(t, err)
+		assert.True(t, len(page) <= 30)
+		rows = append(rows, page...)
+		assert.True(t, pages < 10, "too many pages")
+	}
+	assert.Len(t, rows, 100)
+
+	seen := make(map[string]bool)
+	for i, row := range rows {
+		assert.Equal(t, "partition", row["stringtype"])
+		v := row["int64type"].(int64)
+		assert.True(t, v > 10 && v <= 60, "%d out of range", v)
+		if i > 0 {
+			assert.True(t, v <= rows[i-1]["int64type"].(int64), "not in descending order")
+		}
+		assert.NotNil(t, row["blobtype"])
+		id := fmt.Sprint(v, row["uuidtype"])
+		assert.False(t, seen[id], "duplicate key %s", id)
+		seen[id] = true
+	}
+
+	// Only one row can have int64type 60 when uuidtype is fixed.
+	conditions["int64type"] = []*dosa.Condition{{Op: dosa.Eq, Value: int64(60)}}
+	conditions["uuidtype"] = []*dosa.Condition{{Op: dosa.Eq, Value: dosa.UUID("5d4a7b6c-2f0e-4a3b-9c8d-1e2f3a4b5c6d")}}
+	page, token, err := sut.Range(ctx, clusteredInfo, conditions, nil, "", 30)
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Empty(t, token)
+
+	// Same seed, same pages.
+	first, token1, _ := sut.Range(ctx, clusteredInfo, partitionConditions, nil, "", 10)
+	second, token2, _ := random.NewConnector(random.WithSeed(7), random.WithRowCount(100)).Range(ctx, clusteredInfo, partitionConditions, nil, "", 10)
+	assert.Equal(t, first, second)
+	assert.Equal(t, token1, token2)
+
+	_, _, err = sut.Range(ctx, clusteredInfo, partitionConditions, nil, "not a token", 10)
+	assert.Error(t, err)
+}
+
 func TestRandom_Scan(t *testing.T) {
 	vals, _, err := sut.Scan(ctx, testInfo, minimumFields, "", 32)
 	assert.NotNil(t, vals)
 	assert.NoError(t, err)
 }
 
+func TestRandom_ScanPages(t *testing.T) {
+	sut := random.NewConnector(random.WithRowCount(25), random.WithStringSize(5, 5))
+	page1, token, err := sut.Scan(ctx, clusteredInfo, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page1, 10)
+	page2, token, err := sut.Scan(ctx, clusteredInfo, nil, token, 10)
+	assert.NoError(t, err)
+	assert.Len(t, page2, 10)
+	page3, token, err := sut.Scan(ctx, clusteredInfo, nil, token, 10)
+	assert.NoError(t, err)
+	assert.Len(t, page3, 5)
+	assert.Empty(t, token)
+	assert.NotEqual(t, page1[0], page2[0])
+	for _, row := range append(append(page1, page2...), page3...) {
+		assert.Len(t, row["stringtype"], 5)
+	}
+
+	// Scanned rows read back the same.
+	key := map[string]dosa.FieldValue{}
+	for _, name := range []string{"stringtype", "int64type", "uuidtype"} {
+		key[name] = page2[3][name]
+	}
+	row, err := sut.Read(ctx, clusteredInfo, key, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, page2[3], row)
+
+	empty, token, err := random.NewConnector(random.WithRowCount(0)).Scan(ctx, clusteredInfo, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+	assert.Empty(t, token)
+}
+
+func TestRandom_InvalidOptions(t *testing.T) {
+	for _, opt := range []random.Options{
+		random.WithStringSize(3, 2),
+		random.WithStringSize(0, 0),
+		random.WithBlobSize(-1, 4),
+		random.WithRowCount(-1),
+	} {
+		assert.Panics(t, func() { random.NewConnector(opt) })
+	}
+}
+
 func TestRandom_CheckSchema(t *testing.T) {
 	defs := make([]*dosa.EntityDefinition, 4)
 	version, err := sut.CheckSchema(ctx, "testScope", "testPrefix", defs)
@@ -0,0 +1,275 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// appendOrdered appends an encoding of value to dst whose byte order matches the order of the
+// values themselves. Rows are indexed in sorted sets where every member has the same score, so
+// redis compares members byte by byte; encoding the clustering keys this way keeps the members
+// in clustering order and lets ZRANGEBYLEX serve range queries.
+//
+// The encoding of a single value is never a prefix of the encoding of another value of the same
+// type, so the encodings of several columns can be concatenated. Descending columns have all the
+// bytes of their encoding inverted, which reverses their order.
+func appendOrdered(dst []byte, t dosa.Type, value dosa.FieldValue, descending bool) ([]byte, error) {
+	start := len(dst)
+	switch t {
+	case dosa.TUUID:
+		v, ok := value.(dosa.UUID)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for uuid column", value)
+		}
+		id, err := uuid.FromString(string(v))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid uuid %q", v)
+		}
+		// time UUIDs are ordered by their timestamp first, like the memory connector does
+		dst = append(dst, id.Version())
+		if id.Version() == uuid.V1 {
+			ts, _ := uuid.TimestampFromV1(id)
+			dst = appendUint64(dst, uint64(ts))
+		}
+		dst = append(dst, id.Bytes()...)
+	case dosa.String:
+		v, ok := value.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for string column", value)
+		}
+		dst = appendEscaped(dst, []byte(v))
+	case dosa.Blob:
+		v, ok := value.([]byte)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for blob column", value)
+		}
+		dst = appendEscaped(dst, v)
+	case dosa.Int32:
+		v, ok := value.(int32)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for int32 column", value)
+		}
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(v)^(1<<31))
+		dst = append(dst, buf[:]...)
+	case dosa.Int64:
+		v, ok := value.(int64)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for int64 column", value)
+		}
+		dst = appendUint64(dst, uint64(v)^(1<<63))
+	case dosa.Double:
+		v, ok := value.(float64)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for double column", value)
+		}
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits ^= 1 << 63
+		}
+		dst = appendUint64(dst, bits)
+	case dosa.Timestamp:
+		v, ok := value.(time.Time)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for timestamp column", value)
+		}
+		dst = appendUint64(dst, uint64(v.Unix())^(1<<63))
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(v.Nanosecond()))
+		dst = append(dst, buf[:]...)
+	case dosa.Bool:
+		v, ok := value.(bool)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v for bool column", value)
+		}
+		if v {
+			dst = append(dst, 1)
+		} else {
+			dst = append(dst, 0)
+		}
+	default:
+		return nil, errors.Errorf("unsupported type %v", t)
+	}
+	if descending {
+		for i := start; i < len(dst); i++ {
+			dst[i] = ^dst[i]
+		}
+	}
+	return dst, nil
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+// appendEscaped appends a variable length value, escaping its zero bytes as 0x00 0xff and
+// terminating it with 0x00 0x01 so that shorter values sort before their extensions.
+func appendEscaped(dst []byte, v []byte) []byte {
+	for _, b := range v {
+		if b == 0 {
+			dst = append(dst, 0, 0xff)
+			continue
+		}
+		dst = append(dst, b)
+	}
+	return append(dst, 0, 1)
+}
+
+// successor returns the smallest byte string that is greater than every string starting with
+// prefix, or nil when there is none.
+func successor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			s := make([]byte, i+1)
+			copy(s, prefix)
+			s[i]++
+			return s
+		}
+	}
+	return nil
+}
+
+// encodeField encodes a column value as stored in a field of the row's hash. The values are
+// kept human readable so the rows can be inspected with redis-cli. It returns false for nil
+// pointers, which are stored by removing the field.
+func encodeField(col *dosa.ColumnDefinition, value dosa.FieldValue) ([]byte, bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false, nil
+	case dosa.UUID:
+		return []byte(v), true, nil
+	case *dosa.UUID:
+		if v == nil {
+			return nil, false, nil
+		}
+		return []byte(*v), true, nil
+	case string:
+		return []byte(v), true, nil
+	case *string:
+		if v == nil {
+			return nil, false, nil
+		}
+		return []byte(*v), true, nil
+	case []byte:
+		return v, true, nil
+	case int32:
+		return strconv.AppendInt(nil, int64(v), 10), true, nil
+	case *int32:
+		if v == nil {
+			return nil, false, nil
+		}
+		return strconv.AppendInt(nil, int64(*v), 10), true, nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), true, nil
+	case *int64:
+		if v == nil {
+			return nil, false, nil
+		}
+		return strconv.AppendInt(nil, *v, 10), true, nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), true, nil
+	case *float64:
+		if v == nil {
+			return nil, false, nil
+		}
+		return strconv.AppendFloat(nil, *v, 'g', -1, 64), true, nil
+	case bool:
+		return strconv.AppendBool(nil, v), true, nil
+	case *bool:
+		if v == nil {
+			return nil, false, nil
+		}
+		return strconv.AppendBool(nil, *v), true, nil
+	case time.Time:
+		return []byte(v.UTC().Format(time.RFC3339Nano)), true, nil
+	case *time.Time:
+		if v == nil {
+			return nil, false, nil
+		}
+		return []byte(v.UTC().Format(time.RFC3339Nano)), true, nil
+	}
+	return nil, false, errors.Errorf("unsupported value %v of type %T for column %q", value, value, col.Name)
+}
+
+// decodeField is the inverse of encodeField; pointer columns get pointer values back.
+func decodeField(col *dosa.ColumnDefinition, data []byte) (dosa.FieldValue, error) {
+	var (
+		value interface{}
+		err   error
+	)
+	switch col.Type {
+	case dosa.TUUID:
+		value = dosa.UUID(data)
+	case dosa.String:
+		value = string(data)
+	case dosa.Blob:
+		return data, nil
+	case dosa.Int32:
+		var v int64
+		v, err = strconv.ParseInt(string(data), 10, 32)
+		value = int32(v)
+	case dosa.Int64:
+		value, err = strconv.ParseInt(string(data), 10, 64)
+	case dosa.Double:
+		value, err = strconv.ParseFloat(string(data), 64)
+	case dosa.Bool:
+		value, err = strconv.ParseBool(string(data))
+	case dosa.Timestamp:
+		value, err = time.Parse(time.RFC3339Nano, string(data))
+	default:
+		err = errors.Errorf("unsupported type %v", col.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode column %q", col.Name)
+	}
+	if !col.IsPointer {
+		return value, nil
+	}
+	switch v := value.(type) {
+	case dosa.UUID:
+		return &v, nil
+	case string:
+		return &v, nil
+	case int32:
+		return &v, nil
+	case int64:
+		return &v, nil
+	case float64:
+		return &v, nil
+	case bool:
+		return &v, nil
+	case time.Time:
+		return &v, nil
+	}
+	return value, nil
+}
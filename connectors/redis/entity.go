@@ -0,0 +1,695 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// Entities that are not plain key/value pairs are stored as one hash per row, with a field per
+// column. Two sorted sets index the rows: one per partition, whose members are the encoded
+// clustering keys of its rows, and one per entity, whose members are the encoded primary keys
+// of all its rows. All members have a score of 0 so that they are ordered by their bytes, see
+// appendOrdered. Range reads the partition index and Scan reads the entity index.
+//
+// A row and its index entries are always written and removed together, by a single script, so
+// that neither a failure nor a concurrent reader can observe a row that is partly written. Since
+// scripts can only use keys of a single server, the namespace of an entity is a hash tag: all the
+// keys of an entity are on the same shard of a sharded client.
+//
+// Indexes expire along with the last row written to them. Members whose row has expired are
+// removed lazily by Range and Scan.
+const (
+	rowSegment       = "row"
+	partitionSegment = "partition"
+	entitySegment    = "entity"
+
+	defaultRangeLimit = 200
+	removeRangeBatch  = 1000
+)
+
+// writeRowScript writes the values of a row into its hash, adds the row to both indexes and sets
+// the expiration of the three keys. When ARGV[1] is "1", it only does so if the row does not
+// exist yet, and returns 0 otherwise.
+//
+// KEYS are the row, the partition index and the entity index. ARGV are the create flag, the TTL
+// in milliseconds (0 never expires), the partition and entity index members, the number of fields
+// to set, the fields and values to set, and then the fields to delete.
+const writeRowScript = `
+if ARGV[1] == "1" and redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local set = tonumber(ARGV[5])
+if set > 0 then
+	redis.call("HSET", KEYS[1], unpack(ARGV, 6, 5 + 2 * set))
+end
+if #ARGV > 5 + 2 * set then
+	redis.call("HDEL", KEYS[1], unpack(ARGV, 6 + 2 * set))
+end
+redis.call("ZADD", KEYS[2], 0, ARGV[3])
+redis.call("ZADD", KEYS[3], 0, ARGV[4])
+local ttl = tonumber(ARGV[2])
+for _, key in ipairs(KEYS) do
+	if ttl > 0 then
+		redis.call("PEXPIRE", key, ttl)
+	else
+		redis.call("PERSIST", key)
+	end
+end
+return 1
+`
+
+// removeRowScript deletes a row and removes it from both indexes. KEYS are the row, the partition
+// index and the entity index, ARGV the partition and entity index members.
+const removeRowScript = `
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZREM", KEYS[3], ARGV[2])
+return redis.call("DEL", KEYS[1])
+`
+
+// rowKeys are the redis keys and index members of a single row
+type rowKeys struct {
+	row          string
+	partition    string
+	entity       string
+	member       string
+	entityMember string
+}
+
+// namespace returns the prefix of the keys of an entity, which is a hash tag
+func (c *Connector) namespace(ei *dosa.EntityInfo) string {
+	return "{" + strings.Join([]string{c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name}, keySeparator) + "}"
+}
+
+func (c *Connector) rowKeyFor(ei *dosa.EntityInfo, entityMember string) string {
+	return strings.Join([]string{c.namespace(ei), rowSegment, entityMember}, keySeparator)
+}
+
+func (c *Connector) partitionKeyFor(ei *dosa.EntityInfo, partition []byte) string {
+	return strings.Join([]string{c.namespace(ei), partitionSegment, string(partition)}, keySeparator)
+}
+
+func (c *Connector) entityKeyFor(ei *dosa.EntityInfo) string {
+	return strings.Join([]string{c.namespace(ei), entitySegment}, keySeparator)
+}
+
+// keysOf builds the keys of the row identified by the primary key values
+func (c *Connector) keysOf(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (*rowKeys, error) {
+	types := ei.Def.ColumnTypes()
+	var (
+		partition []byte
+		err       error
+	)
+	for _, name := range ei.Def.Key.PartitionKeys {
+		value, ok := values[name]
+		if !ok {
+			return nil, errors.Errorf("Missing value for partition key %q", name)
+		}
+		if partition, err = appendOrdered(partition, types[name], value, false); err != nil {
+			return nil, errors.Wrapf(err, "invalid partition key %q", name)
+		}
+	}
+	var clustering []byte
+	for _, ck := range ei.Def.Key.ClusteringKeys {
+		value, ok := values[ck.Name]
+		if !ok {
+			return nil, errors.Errorf("Missing value for clustering key %q", ck.Name)
+		}
+		if clustering, err = appendOrdered(clustering, types[ck.Name], value, ck.Descending); err != nil {
+			return nil, errors.Wrapf(err, "invalid clustering key %q", ck.Name)
+		}
+	}
+	entityMember := string(partition) + string(clustering)
+	return &rowKeys{
+		row:          c.rowKeyFor(ei, entityMember),
+		partition:    c.partitionKeyFor(ei, partition),
+		entity:       c.entityKeyFor(ei),
+		member:       string(clustering),
+		entityMember: entityMember,
+	}, nil
+}
+
+// entityTTL returns how long the rows of the entity live in redis: the TTL of the EntityInfo
+// when it has one, the TTL of the connector otherwise. Zero means the rows never expire.
+func (c *Connector) entityTTL(ei *dosa.EntityInfo) time.Duration {
+	if ei.TTL != nil && *ei.TTL != dosa.NoTTL() {
+		return *ei.TTL
+	}
+	return c.ttl
+}
+
+// writeRowCommand returns the script writing the values into the row's hash and indexing it.
+// With create, the script only writes a row that does not exist yet.
+func (c *Connector) writeRowCommand(ei *dosa.EntityInfo, values map[string]dosa.FieldValue, create bool) (Command, error) {
+	keys, err := c.keysOf(ei, values)
+	if err != nil {
+		return Command{}, err
+	}
+	var set, del []interface{}
+	for _, col := range ei.Def.Columns {
+		value, ok := values[col.Name]
+		if !ok {
+			continue
+		}
+		data, ok, err := encodeField(col, value)
+		if err != nil {
+			return Command{}, err
+		}
+		if ok {
+			set = append(set, col.Name, data)
+		} else {
+			del = append(del, col.Name)
+		}
+	}
+	flag := "0"
+	if create {
+		flag = "1"
+	}
+	ttl := c.entityTTL(ei)
+	args := []interface{}{writeRowScript, 3, keys.row, keys.partition, keys.entity,
+		flag, int64(ttl / time.Millisecond), keys.member, keys.entityMember, len(set) / 2}
+	args = append(append(args, set...), del...)
+	return Command{Name: "EVAL", Args: args}, nil
+}
+
+// removeRowCommand returns the script deleting the row and its index entries
+func removeRowCommand(keys *rowKeys) Command {
+	return Command{Name: "EVAL", Args: []interface{}{removeRowScript, 3, keys.row, keys.partition, keys.entity,
+		keys.member, keys.entityMember}}
+}
+
+// firstError returns the first error among pipeline replies
+func firstError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipelineRows runs the command of each row in a single pipeline and returns an error per row.
+// Rows whose command could not be built keep the error they got.
+func pipelineRows(client Commander, rowCommands []Command, errs []error) ([]error, error) {
+	var commands []Command
+	for i, cmd := range rowCommands {
+		if errs[i] == nil {
+			commands = append(commands, cmd)
+		}
+	}
+	if len(commands) == 0 {
+		return errs, nil
+	}
+	replies, err := client.Pipeline(commands)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rowCommands {
+		if errs[i] != nil {
+			continue
+		}
+		errs[i], _ = replies[0].(error)
+		replies = replies[1:]
+	}
+	return errs, nil
+}
+
+// decodeRow converts the reply of HGETALL into the values of a row. Fields that are not
+// columns of the entity, e.g. columns that have since been removed, are ignored.
+func decodeRow(ei *dosa.EntityInfo, reply interface{}) (map[string]dosa.FieldValue, error) {
+	fields, err := redis.ByteSlices(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, &dosa.ErrNotFound{}
+	}
+	columns := ei.Def.ColumnMap()
+	values := make(map[string]dosa.FieldValue, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		col, ok := columns[string(fields[i])]
+		if !ok {
+			continue
+		}
+		if values[col.Name], err = decodeField(col, fields[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (c *Connector) readRow(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) (map[string]dosa.FieldValue, error) {
+	client, err := c.commander()
+	if err != nil {
+		return nil, err
+	}
+	rk, err := c.keysOf(ei, keys)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := client.Do("HGETALL", rk.row)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRow(ei, reply)
+}
+
+func (c *Connector) multiReadRows(ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue) ([]*dosa.FieldValuesOrError, error) {
+	client, err := c.commander()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	var commands []Command
+	for i, k := range keys {
+		results[i] = &dosa.FieldValuesOrError{}
+		rk, err := c.keysOf(ei, k)
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		commands = append(commands, Command{Name: "HGETALL", Args: []interface{}{rk.row}})
+	}
+	if len(commands) == 0 {
+		return results, nil
+	}
+	replies, err := client.Pipeline(commands)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Error != nil {
+			continue
+		}
+		reply := replies[0]
+		replies = replies[1:]
+		if err, ok := reply.(error); ok {
+			result.Error = err
+			continue
+		}
+		result.Values, result.Error = decodeRow(ei, reply)
+	}
+	return results, nil
+}
+
+func (c *Connector) upsertRow(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	client, err := c.commander()
+	if err != nil {
+		return err
+	}
+	cmd, err := c.writeRowCommand(ei, values, false)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(cmd.Name, cmd.Args...)
+	return err
+}
+
+// createRow writes the row only if it does not exist yet. The existence check and the writes
+// are done by the same script, so the row is never left partly written.
+func (c *Connector) createRow(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	client, err := c.commander()
+	if err != nil {
+		return err
+	}
+	cmd, err := c.writeRowCommand(ei, values, true)
+	if err != nil {
+		return err
+	}
+	created, err := redis.Int(client.Do(cmd.Name, cmd.Args...))
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return &dosa.ErrAlreadyExists{}
+	}
+	return nil
+}
+
+func (c *Connector) multiUpsertRows(ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	client, err := c.commander()
+	if err != nil {
+		return nil, err
+	}
+	rowCommands := make([]Command, len(multiValues))
+	errs := make([]error, len(multiValues))
+	for i, values := range multiValues {
+		rowCommands[i], errs[i] = c.writeRowCommand(ei, values, false)
+	}
+	return pipelineRows(client, rowCommands, errs)
+}
+
+func (c *Connector) removeRow(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	errs, err := c.multiRemoveRows(ei, []map[string]dosa.FieldValue{keys})
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+func (c *Connector) multiRemoveRows(ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	client, err := c.commander()
+	if err != nil {
+		return nil, err
+	}
+	rowCommands := make([]Command, len(multiKeys))
+	errs := make([]error, len(multiKeys))
+	for i, keys := range multiKeys {
+		var rk *rowKeys
+		if rk, errs[i] = c.keysOf(ei, keys); errs[i] == nil {
+			rowCommands[i] = removeRowCommand(rk)
+		}
+	}
+	return pipelineRows(client, rowCommands, errs)
+}
+
+// rangeQuery describes the part of a sorted set index read by Range, Scan and RemoveRange
+type rangeQuery struct {
+	index      string
+	rowPrefix  string
+	min        []byte
+	max        []byte
+	conditions map[string][]*dosa.Condition
+}
+
+// lexRange returns the ZRANGEBYLEX arguments for the members of the query. A nil min or max
+// leaves that side of the range open.
+func (q *rangeQuery) lexRange() (string, string) {
+	min, max := "-", "+"
+	if q.min != nil {
+		min = "[" + string(q.min)
+	}
+	if q.max != nil {
+		max = "(" + string(q.max)
+	}
+	return min, max
+}
+
+// partitionQuery builds the query reading a partition for the range conditions. Leading
+// clustering keys with a single Eq condition narrow the range down to the members that share
+// their encoding as a prefix, and the conditions on the clustering key that follows narrow it
+// further. Every condition is checked again on the rows anyway.
+func (c *Connector) partitionQuery(ei *dosa.EntityInfo, conditions map[string][]*dosa.Condition) (*rangeQuery, error) {
+	if err := dosa.EnsureValidRangeConditions(ei.Def, ei.Def.Key, conditions, nil); err != nil {
+		return nil, errors.Wrap(err, "Invalid range conditions")
+	}
+	types := ei.Def.ColumnTypes()
+	var partition []byte
+	for _, name := range ei.Def.Key.PartitionKeys {
+		// the conditions were validated, so there is exactly one Eq condition here
+		partition, _ = appendOrdered(partition, types[name], conditions[name][0].Value, false)
+	}
+	q := &rangeQuery{
+		index:      c.partitionKeyFor(ei, partition),
+		rowPrefix:  c.rowKeyFor(ei, string(partition)),
+		conditions: conditions,
+	}
+
+	var prefix []byte
+	for _, ck := range ei.Def.Key.ClusteringKeys {
+		conds := conditions[ck.Name]
+		if len(conds) == 1 && conds[0].Op == dosa.Eq {
+			prefix, _ = appendOrdered(prefix, types[ck.Name], conds[0].Value, ck.Descending)
+			continue
+		}
+		q.min, q.max = prefix, successor(prefix)
+		for _, cond := range conds {
+			bound, _ := appendOrdered(append([]byte{}, prefix...), types[ck.Name], cond.Value, ck.Descending)
+			op := cond.Op
+			if ck.Descending {
+				op = mirror(op)
+			}
+			switch op {
+			case dosa.Eq:
+				q.narrow(bound, successor(bound))
+			case dosa.Gt:
+				q.narrow(successor(bound), nil)
+			case dosa.GtOrEq:
+				q.narrow(bound, nil)
+			case dosa.Lt:
+				q.narrow(nil, bound)
+			case dosa.LtOrEq:
+				q.narrow(nil, successor(bound))
+			}
+		}
+		return q, nil
+	}
+	q.min, q.max = prefix, successor(prefix)
+	return q, nil
+}
+
+// narrow restricts the range to members at least min and less than max; nil leaves a side as is
+func (q *rangeQuery) narrow(min, max []byte) {
+	if min != nil && bytes.Compare(min, q.min) > 0 {
+		q.min = min
+	}
+	if max != nil && (q.max == nil || bytes.Compare(max, q.max) < 0) {
+		q.max = max
+	}
+}
+
+// mirror returns the operator matching the same values once their order is reversed
+func mirror(op dosa.Operator) dosa.Operator {
+	switch op {
+	case dosa.Gt:
+		return dosa.Lt
+	case dosa.GtOrEq:
+		return dosa.LtOrEq
+	case dosa.Lt:
+		return dosa.Gt
+	case dosa.LtOrEq:
+		return dosa.GtOrEq
+	}
+	return op
+}
+
+// resume moves the start of the range past the member encoded in the token
+func (q *rangeQuery) resume(token string) error {
+	if token == "" {
+		return nil
+	}
+	member, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid token %q", token)
+	}
+	// members are never a prefix of each other, so this is the smallest member after the token
+	q.narrow(append(member, 0), nil)
+	return nil
+}
+
+// matches checks the row against the conditions of the query
+func (q *rangeQuery) matches(ei *dosa.EntityInfo, row map[string]dosa.FieldValue) bool {
+	types := ei.Def.ColumnTypes()
+	for name, conds := range q.conditions {
+		value, _ := appendOrdered(nil, types[name], row[name], false)
+		for _, cond := range conds {
+			bound, _ := appendOrdered(nil, types[name], cond.Value, false)
+			cmp := bytes.Compare(value, bound)
+			switch cond.Op {
+			case dosa.Eq:
+				if cmp != 0 {
+					return false
+				}
+			case dosa.Gt:
+				if cmp <= 0 {
+					return false
+				}
+			case dosa.GtOrEq:
+				if cmp < 0 {
+					return false
+				}
+			case dosa.Lt:
+				if cmp >= 0 {
+					return false
+				}
+			case dosa.LtOrEq:
+				if cmp > 0 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// collect loads the rows of the query in index order until it has limit of them. It returns
+// their members along with the rows, and whether there are more rows to read after them.
+func (c *Connector) collect(client Commander, ei *dosa.EntityInfo, q *rangeQuery, limit int) ([]map[string]dosa.FieldValue, []string, bool, error) {
+	var (
+		rows    []map[string]dosa.FieldValue
+		members []string
+	)
+	batch := limit + 1
+	for {
+		min, max := q.lexRange()
+		batchMembers, err := redis.Strings(client.Do("ZRANGEBYLEX", q.index, min, max, "LIMIT", 0, batch))
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(batchMembers) == 0 {
+			return rows, members, false, nil
+		}
+		commands := make([]Command, len(batchMembers))
+		for i, member := range batchMembers {
+			commands[i] = Command{Name: "HGETALL", Args: []interface{}{q.rowPrefix + member}}
+		}
+		replies, err := client.Pipeline(commands)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		var expired []interface{}
+		for i, reply := range replies {
+			row, err := decodeRow(ei, reply)
+			if dosa.ErrorIsNotFound(err) {
+				expired = append(expired, batchMembers[i])
+				continue
+			}
+			if err != nil {
+				return nil, nil, false, err
+			}
+			if !q.matches(ei, row) {
+				continue
+			}
+			rows = append(rows, row)
+			members = append(members, batchMembers[i])
+			if len(rows) > limit {
+				c.prune(client, q.index, expired)
+				return rows[:limit], members[:limit], true, nil
+			}
+		}
+		c.prune(client, q.index, expired)
+		if len(batchMembers) < batch {
+			return rows, members, false, nil
+		}
+		q.narrow(append([]byte(batchMembers[len(batchMembers)-1]), 0), nil)
+	}
+}
+
+// prune removes the index members of expired rows. It's best effort, the members are removed
+// again the next time they are read otherwise.
+func (c *Connector) prune(client Commander, index string, members []interface{}) {
+	if len(members) == 0 {
+		return
+	}
+	_, _ = client.Do("ZREM", append([]interface{}{index}, members...)...)
+}
+
+func normalizeLimit(limit int) int {
+	if limit == dosa.AdaptiveRangeLimit || limit <= 0 {
+		return defaultRangeLimit
+	}
+	return limit
+}
+
+func makeToken(more bool, members []string) string {
+	if !more || len(members) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(members[len(members)-1]))
+}
+
+func (c *Connector) rangeRows(ei *dosa.EntityInfo, conditions map[string][]*dosa.Condition, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	client, err := c.commander()
+	if err != nil {
+		return nil, "", err
+	}
+	q, err := c.partitionQuery(ei, conditions)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := q.resume(token); err != nil {
+		return nil, "", err
+	}
+	rows, members, more, err := c.collect(client, ei, q, normalizeLimit(limit))
+	if err != nil {
+		return nil, "", err
+	}
+	if rows == nil {
+		rows = []map[string]dosa.FieldValue{}
+	}
+	return rows, makeToken(more, members), nil
+}
+
+func (c *Connector) scanRows(ei *dosa.EntityInfo, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	client, err := c.commander()
+	if err != nil {
+		return nil, "", err
+	}
+	q := &rangeQuery{
+		index:     c.entityKeyFor(ei),
+		rowPrefix: c.rowKeyFor(ei, ""),
+	}
+	if err := q.resume(token); err != nil {
+		return nil, "", err
+	}
+	rows, members, more, err := c.collect(client, ei, q, normalizeLimit(limit))
+	if err != nil {
+		return nil, "", err
+	}
+	if rows == nil {
+		rows = []map[string]dosa.FieldValue{}
+	}
+	return rows, makeToken(more, members), nil
+}
+
+func (c *Connector) removeRangeRows(ei *dosa.EntityInfo, conditions map[string][]*dosa.Condition) error {
+	client, err := c.commander()
+	if err != nil {
+		return err
+	}
+	q, err := c.partitionQuery(ei, conditions)
+	if err != nil {
+		return err
+	}
+	for {
+		rows, _, more, err := c.collect(client, ei, q, removeRangeBatch)
+		if err != nil {
+			return err
+		}
+		var commands []Command
+		for _, row := range rows {
+			rk, err := c.keysOf(ei, row)
+			if err != nil {
+				return err
+			}
+			commands = append(commands, removeRowCommand(rk))
+		}
+		if len(commands) > 0 {
+			replies, err := client.Pipeline(commands)
+			if err != nil {
+				return err
+			}
+			if err := firstError(replies); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/conformance"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/metrics"
+	"github.com/uber-go/dosa/testentity"
+)
+
+var (
+	entityTable, _ = dosa.TableFromInstance(&testentity.TestEntity{})
+	entityEi       = &dosa.EntityInfo{Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix"}, Def: &entityTable.EntityDefinition}
+	kvTable, _     = dosa.TableFromInstance(&testentity.KeyValue{})
+	kvEi           = &dosa.EntityInfo{Ref: &dosa.SchemaRef{Scope: "scope", NamePrefix: "prefix"}, Def: &kvTable.EntityDefinition}
+	partitionUUID  = dosa.UUID("3e4befa0-69d2-11e6-a6d8-5a5b3c5b7b7c")
+	entityTime     = time.Unix(1500000000, 123).UTC()
+)
+
+func newEntityConnector(client *memoryRedis, ttl time.Duration) *Connector {
+	return &Connector{
+		client:    client,
+		ttl:       ttl,
+		stats:     metrics.CheckIfNilStats(nil),
+		keyPrefix: "test",
+	}
+}
+
+func entityRow(str string, i int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"an_uuid_key":    partitionUUID,
+		"strkey":         str,
+		"int64key":       i,
+		"an_int64_value": i * 10,
+		"strv":           "value " + str,
+	}
+}
+
+func entityKey(str string, i int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"an_uuid_key": partitionUUID, "strkey": str, "int64key": i}
+}
+
+func TestEntityReadWrite(t *testing.T) {
+	c := newEntityConnector(newMemoryRedis(), 0)
+	strV := "pointer"
+	int32V := int32(-3)
+	doubleV := math.Inf(-1)
+	boolV := true
+	values := map[string]dosa.FieldValue{
+		"an_uuid_key":    partitionUUID,
+		"strkey":         "key",
+		"int64key":       int64(-9),
+		"uuidv":          dosa.UUID("5e4befa0-69d2-11e6-a6d8-5a5b3c5b7b7c"),
+		"strv":           "with,separators\x00",
+		"an_int64_value": int64(math.MaxInt64),
+		"int32v":         int32(math.MinInt32),
+		"doublev":        1.5e-300,
+		"boolv":          false,
+		"blobv":          []byte{0, 1, 2},
+		"tsv":            entityTime,
+		"strvp":          &strV,
+		"int32vp":        &int32V,
+		"doublevp":       &doubleV,
+		"boolvp":         &boolV,
+		"tsvp":           &entityTime,
+	}
+	assert.NoError(t, c.Upsert(context.TODO(), entityEi, values))
+
+	read, err := c.Read(context.TODO(), entityEi, entityKey("key", -9), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, values, read)
+
+	// upserts only change the values they have, nil pointers clear theirs
+	assert.NoError(t, c.Upsert(context.TODO(), entityEi, map[string]dosa.FieldValue{
+		"an_uuid_key": partitionUUID,
+		"strkey":      "key",
+		"int64key":    int64(-9),
+		"strv":        "updated",
+		"strvp":       (*string)(nil),
+	}))
+	read, err = c.Read(context.TODO(), entityEi, entityKey("key", -9), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", read["strv"])
+	assert.Equal(t, &int32V, read["int32vp"])
+	assert.NotContains(t, read, "strvp")
+
+	assert.NoError(t, c.Remove(context.TODO(), entityEi, entityKey("key", -9)))
+	_, err = c.Read(context.TODO(), entityEi, entityKey("key", -9), dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	// the indexes are gone along with the row
+	client := c.client.(*memoryRedis)
+	assert.Empty(t, client.zsets)
+	assert.Empty(t, client.hashes)
+}
+
+func TestEntityMissingKey(t *testing.T) {
+	c := newEntityConnector(newMemoryRedis(), 0)
+	err := c.Upsert(context.TODO(), entityEi, map[string]dosa.FieldValue{"an_uuid_key": partitionUUID})
+	assert.Contains(t, err.Error(), `Missing value for clustering key "strkey"`)
+	_, err = c.Read(context.TODO(), entityEi, map[string]dosa.FieldValue{}, dosa.All())
+	assert.Contains(t, err.Error(), `Missing value for partition key "an_uuid_key"`)
+}
+
+func TestCommanderRequired(t *testing.T) {
+	c := newEntityConnector(newMemoryRedis(), 0)
+	// hide the Commander methods of the client
+	c.client = struct{ SimpleRedis }{c.client}
+	_, err := c.Read(context.TODO(), entityEi, entityKey("a", 1), dosa.All())
+	assert.EqualError(t, err, new(ErrNotImplemented).Error())
+	err = c.CreateIfNotExists(context.TODO(), kvEi, map[string]dosa.FieldValue{"k": []byte{1}, "v": []byte{2}})
+	assert.EqualError(t, err, new(ErrNotImplemented).Error())
+}
+
+func TestCreateIfNotExists(t *testing.T) {
+	client := newMemoryRedis()
+	c := newEntityConnector(client, 0)
+	// the row is checked, written and indexed by a single command
+	assert.NoError(t, c.CreateIfNotExists(context.TODO(), entityEi, entityRow("a", 1)))
+	assert.Equal(t, 1, client.commands)
+	changed := entityRow("a", 1)
+	changed["strv"] = "changed"
+	err := c.CreateIfNotExists(context.TODO(), entityEi, changed)
+	assert.IsType(t, &dosa.ErrAlreadyExists{}, err)
+	read, err := c.Read(context.TODO(), entityEi, entityKey("a", 1), dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, "value a", read["strv"])
+	assert.NoError(t, c.CreateIfNotExists(context.TODO(), entityEi, entityRow("a", 2)))
+
+	kv := map[string]dosa.FieldValue{"k": []byte{1}, "v": []byte{2}}
+	assert.NoError(t, c.CreateIfNotExists(context.TODO(), kvEi, kv))
+	err = c.CreateIfNotExists(context.TODO(), kvEi, kv)
+	assert.IsType(t, &dosa.ErrAlreadyExists{}, err)
+	read, err = c.Read(context.TODO(), kvEi, map[string]dosa.FieldValue{"k": []byte{1}}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, kv, read)
+}
+
+func TestEntityTTL(t *testing.T) {
+	client := newMemoryRedis()
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	c := newEntityConnector(client, time.Minute)
+
+	hour := time.Hour
+	never := time.Duration(0)
+	noTTL := dosa.NoTTL()
+	withTTL := func(ttl *time.Duration) *dosa.EntityInfo {
+		return &dosa.EntityInfo{Ref: entityEi.Ref, Def: entityEi.Def, TTL: ttl}
+	}
+	assert.NoError(t, c.Upsert(context.TODO(), withTTL(nil), entityRow("config", 1)))
+	assert.NoError(t, c.Upsert(context.TODO(), withTTL(&noTTL), entityRow("config", 2)))
+	assert.NoError(t, c.Upsert(context.TODO(), withTTL(&hour), entityRow("hour", 1)))
+	assert.NoError(t, c.Upsert(context.TODO(), withTTL(&never), entityRow("never", 1)))
+	kvValues := map[string]dosa.FieldValue{"k": []byte{1}, "v": []byte{2}}
+	assert.NoError(t, c.Upsert(context.TODO(), &dosa.EntityInfo{Ref: kvEi.Ref, Def: kvEi.Def, TTL: &hour}, kvValues))
+
+	exists := func(ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) bool {
+		_, err := c.Read(context.TODO(), ei, keys, dosa.All())
+		return err == nil
+	}
+	now = now.Add(2 * time.Minute)
+	assert.False(t, exists(entityEi, entityKey("config", 1)))
+	assert.False(t, exists(entityEi, entityKey("config", 2)))
+	assert.True(t, exists(entityEi, entityKey("hour", 1)))
+	assert.True(t, exists(kvEi, map[string]dosa.FieldValue{"k": []byte{1}}))
+
+	now = now.Add(2 * time.Hour)
+	assert.False(t, exists(entityEi, entityKey("hour", 1)))
+	assert.False(t, exists(kvEi, map[string]dosa.FieldValue{"k": []byte{1}}))
+	assert.True(t, exists(entityEi, entityKey("never", 1)))
+
+	// the last write made the indexes persistent, and the expired rows are pruned from them
+	rows, _, err := c.Scan(context.TODO(), entityEi, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{entityRow("never", 1)}, rows)
+	rows, _, err = c.Range(context.TODO(), entityEi, map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}},
+	}, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{entityRow("never", 1)}, rows)
+	for _, z := range client.zsets {
+		assert.Len(t, z, 1)
+	}
+}
+
+func TestMultiOperations(t *testing.T) {
+	client := newMemoryRedis()
+	c := newEntityConnector(client, 0)
+
+	rows := []map[string]dosa.FieldValue{entityRow("a", 1), entityRow("a", 2), {"an_uuid_key": partitionUUID}, entityRow("b", 1)}
+	errs, err := c.MultiUpsert(context.TODO(), entityEi, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.commands)
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.Error(t, errs[2])
+	assert.NoError(t, errs[3])
+
+	client.commands = 0
+	results, err := c.MultiRead(context.TODO(), entityEi, []map[string]dosa.FieldValue{entityKey("a", 2), entityKey("c", 1), {}, entityKey("b", 1)}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.commands)
+	assert.Equal(t, entityRow("a", 2), results[0].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[1].Error))
+	assert.Error(t, results[2].Error)
+	assert.Equal(t, entityRow("b", 1), results[3].Values)
+
+	client.commands = 0
+	errs, err = c.MultiRemove(context.TODO(), entityEi, []map[string]dosa.FieldValue{entityKey("a", 1), entityKey("b", 1)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.commands)
+	assert.Equal(t, []error{nil, nil}, errs)
+	all, _, err := c.Scan(context.TODO(), entityEi, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{entityRow("a", 2)}, all)
+}
+
+func TestMultiOperationsKeyValue(t *testing.T) {
+	client := newMemoryRedis()
+	c := newEntityConnector(client, 0)
+
+	kv := func(k, v byte) map[string]dosa.FieldValue {
+		return map[string]dosa.FieldValue{"k": []byte{k}, "v": []byte{v}}
+	}
+	key := func(k byte) map[string]dosa.FieldValue {
+		return map[string]dosa.FieldValue{"k": []byte{k}}
+	}
+	errs, err := c.MultiUpsert(context.TODO(), kvEi, []map[string]dosa.FieldValue{kv(1, 10), {"k": []byte{2}}, kv(3, 30)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.commands)
+	assert.NoError(t, errs[0])
+	assert.EqualError(t, errs[1], NewErrInvalidEntity("No value specified.").Error())
+	assert.NoError(t, errs[2])
+
+	client.commands = 0
+	results, err := c.MultiRead(context.TODO(), kvEi, []map[string]dosa.FieldValue{key(1), key(2), {"k": []byte{}}, key(3)}, dosa.All())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.commands)
+	assert.Equal(t, kv(1, 10), results[0].Values)
+	assert.True(t, dosa.ErrorIsNotFound(results[1].Error))
+	assert.EqualError(t, results[2].Error, NewErrInvalidEntity("No key specified.").Error())
+	assert.Equal(t, kv(3, 30), results[3].Values)
+
+	errs, err = c.MultiRemove(context.TODO(), kvEi, []map[string]dosa.FieldValue{key(1), key(3)})
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Empty(t, client.strings)
+
+	// range and remove range are limited to the single key of the partition
+	assert.NoError(t, c.Upsert(context.TODO(), kvEi, kv(4, 40)))
+	rows, token, err := c.Range(context.TODO(), kvEi, map[string][]*dosa.Condition{"k": {{Op: dosa.Eq, Value: []byte{4}}}}, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, []map[string]dosa.FieldValue{kv(4, 40)}, rows)
+	assert.NoError(t, c.RemoveRange(context.TODO(), kvEi, map[string][]*dosa.Condition{"k": {{Op: dosa.Eq, Value: []byte{4}}}}))
+	rows, _, err = c.Range(context.TODO(), kvEi, map[string][]*dosa.Condition{"k": {{Op: dosa.Eq, Value: []byte{4}}}}, dosa.All(), "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestRangeAndPaging(t *testing.T) {
+	c := newEntityConnector(newMemoryRedis(), 0)
+	for _, s := range []string{"a", "b", "c"} {
+		for i := int64(1); i <= 3; i++ {
+			assert.NoError(t, c.Upsert(context.TODO(), entityEi, entityRow(s, i)))
+		}
+	}
+	// another partition is not part of the results
+	other := entityRow("a", 1)
+	other["an_uuid_key"] = dosa.UUID("4e4befa0-69d2-11e6-a6d8-5a5b3c5b7b7c")
+	assert.NoError(t, c.Upsert(context.TODO(), entityEi, other))
+
+	partition := map[string][]*dosa.Condition{"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}}}
+	var (
+		rows  []map[string]dosa.FieldValue
+		token string
+		pages int
+	)
+	for {
+		page, next, err := c.Range(context.TODO(), entityEi, partition, dosa.All(), token, 4)
+		assert.NoError(t, err)
+		rows = append(rows, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, 3, pages)
+	// strkey is ascending and int64key descending
+	assert.Equal(t, []map[string]dosa.FieldValue{
+		entityRow("a", 3), entityRow("a", 2), entityRow("a", 1),
+		entityRow("b", 3), entityRow("b", 2), entityRow("b", 1),
+		entityRow("c", 3), entityRow("c", 2), entityRow("c", 1),
+	}, rows)
+
+	conditions := map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}},
+		"strkey":      {{Op: dosa.Eq, Value: "b"}},
+		"int64key":    {{Op: dosa.Lt, Value: int64(3)}},
+	}
+	rows, token, err := c.Range(context.TODO(), entityEi, conditions, dosa.All(), "", dosa.AdaptiveRangeLimit)
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, []map[string]dosa.FieldValue{entityRow("b", 2), entityRow("b", 1)}, rows)
+
+	_, _, err = c.Range(context.TODO(), entityEi, map[string][]*dosa.Condition{}, dosa.All(), "", 1)
+	assert.Contains(t, err.Error(), "Invalid range conditions")
+	_, _, err = c.Range(context.TODO(), entityEi, partition, dosa.All(), "not base64!", 1)
+	assert.Contains(t, err.Error(), "Invalid token")
+
+	// remove range only removes the matching rows and their index entries
+	conditions = map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}},
+		"strkey":      {{Op: dosa.Eq, Value: "b"}},
+		"int64key":    {{Op: dosa.Gt, Value: int64(1)}},
+	}
+	assert.NoError(t, c.RemoveRange(context.TODO(), entityEi, conditions))
+	conditions = map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}},
+		"strkey":      {{Op: dosa.GtOrEq, Value: "b"}},
+	}
+	assert.NoError(t, c.RemoveRange(context.TODO(), entityEi, conditions))
+	rows, _, err = c.Range(context.TODO(), entityEi, partition, dosa.All(), "", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]dosa.FieldValue{entityRow("a", 3), entityRow("a", 2), entityRow("a", 1)}, rows)
+	rows, _, err = c.Scan(context.TODO(), entityEi, dosa.All(), "", 100)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 4)
+}
+
+// TestRangeMatchesMemoryConnector compares random ranges with the ones of the memory connector
+func TestRangeMatchesMemoryConnector(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := newEntityConnector(newMemoryRedis(), 0)
+	mc := memory.NewConnector()
+	strs := []string{"", "a", "a\x00", "a\x00b", "ab", "b", "\xff"}
+	for i := 0; i < 100; i++ {
+		row := entityRow(strs[r.Intn(len(strs))], r.Int63n(20)-10)
+		assert.NoError(t, c.Upsert(context.TODO(), entityEi, row))
+		assert.NoError(t, mc.Upsert(context.TODO(), entityEi, row))
+	}
+	// a single condition, or a lower bound along with an upper bound
+	bounds := func(value func() dosa.FieldValue) []*dosa.Condition {
+		ops := []dosa.Operator{dosa.Eq, dosa.Lt, dosa.LtOrEq, dosa.Gt, dosa.GtOrEq}
+		if r.Intn(3) > 0 {
+			return []*dosa.Condition{{Op: ops[r.Intn(len(ops))], Value: value()}}
+		}
+		return []*dosa.Condition{
+			{Op: ops[3+r.Intn(2)], Value: value()},
+			{Op: ops[1+r.Intn(2)], Value: value()},
+		}
+	}
+	str := func() dosa.FieldValue { return strs[r.Intn(len(strs))] }
+	num := func() dosa.FieldValue { return r.Int63n(24) - 12 }
+	for i := 0; i < 200; i++ {
+		conditions := map[string][]*dosa.Condition{"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}}}
+		// only the last constrained clustering key can have conditions other than Eq
+		switch r.Intn(3) {
+		case 0:
+			conditions["strkey"] = []*dosa.Condition{{Op: dosa.Eq, Value: str()}}
+			conditions["int64key"] = bounds(num)
+		case 1:
+			conditions["strkey"] = bounds(str)
+		}
+		limit := r.Intn(10) + 1
+		expected, expectedToken, expectedErr := mc.Range(context.TODO(), entityEi, conditions, dosa.All(), "", limit)
+		rows, token, err := c.Range(context.TODO(), entityEi, conditions, dosa.All(), "", limit)
+		if expectedErr != nil {
+			// both reject the same invalid conditions, e.g. empty ranges
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, expected, rows, fmt.Sprintf("conditions %v", conditions))
+		assert.Equal(t, expectedToken == "", token == "")
+	}
+}
+
+func TestScanPaging(t *testing.T) {
+	c := newEntityConnector(newMemoryRedis(), 0)
+	var expected []string
+	for i := 0; i < 25; i++ {
+		row := entityRow("s", int64(i))
+		row["an_uuid_key"] = dosa.NewUUID()
+		assert.NoError(t, c.Upsert(context.TODO(), entityEi, row))
+		expected = append(expected, fmt.Sprint(row["an_uuid_key"], i))
+	}
+	var (
+		seen  []string
+		token string
+	)
+	for {
+		rows, next, err := c.Scan(context.TODO(), entityEi, dosa.All(), token, 10)
+		assert.NoError(t, err)
+		for _, row := range rows {
+			seen = append(seen, fmt.Sprint(row["an_uuid_key"], row["int64key"]))
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	sort.Strings(expected)
+	sort.Strings(seen)
+	assert.Equal(t, expected, seen)
+}
+
+func TestAppendOrdered(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := map[dosa.Type][]dosa.FieldValue{
+		dosa.String:    {"", "a", "a\x00", "a\x00\x00", "a\x01", "ab", "b", "\xff"},
+		dosa.Blob:      {[]byte{}, []byte{0}, []byte{0, 0}, []byte{0, 1}, []byte{1}, []byte{0xff, 0}},
+		dosa.Int32:     {int32(math.MinInt32), int32(-1), int32(0), int32(1), int32(math.MaxInt32)},
+		dosa.Int64:     {int64(math.MinInt64), int64(-1), int64(0), int64(1), int64(math.MaxInt64)},
+		dosa.Double:    {math.Inf(-1), -1.5, -math.SmallestNonzeroFloat64, 0.0, math.SmallestNonzeroFloat64, 2.0, math.Inf(1)},
+		dosa.Timestamp: {time.Unix(-100, 5), time.Unix(0, 0), time.Unix(0, 1), time.Unix(1, 0), entityTime},
+		dosa.Bool:      {false, true},
+		dosa.TUUID: {
+			// time UUIDs are ordered by timestamp, older versions first
+			dosa.UUID("00000000-0000-0000-0000-000000000000"),
+			dosa.UUID("ffffffff-0000-1000-a6d8-5a5b3c5b7b7c"),
+			dosa.UUID("00000000-0001-1000-a6d8-5a5b3c5b7b7c"),
+			dosa.UUID("3e4befa0-69d2-41e6-a6d8-5a5b3c5b7b7c"),
+			dosa.UUID("4e4befa0-69d2-41e6-a6d8-5a5b3c5b7b7c"),
+		},
+	}
+	for typ, ordered := range values {
+		for _, descending := range []bool{false, true} {
+			for i := 0; i < 50; i++ {
+				a, b := r.Intn(len(ordered)), r.Intn(len(ordered))
+				ea, err := appendOrdered([]byte("prefix"), typ, ordered[a], descending)
+				assert.NoError(t, err)
+				eb, err := appendOrdered([]byte("prefix"), typ, ordered[b], descending)
+				assert.NoError(t, err)
+				expected := a < b
+				if descending {
+					expected = a > b
+				}
+				assert.Equal(t, expected, string(ea) < string(eb), "%v %v < %v", typ, ordered[a], ordered[b])
+			}
+		}
+	}
+	_, err := appendOrdered(nil, dosa.Int64, "1", false)
+	assert.Error(t, err)
+	_, err = appendOrdered(nil, dosa.TUUID, dosa.UUID("nope"), false)
+	assert.Error(t, err)
+}
+
+func TestSuccessor(t *testing.T) {
+	assert.Equal(t, []byte{1, 3}, successor([]byte{1, 2}))
+	assert.Equal(t, []byte{2}, successor([]byte{1, 0xff}))
+	assert.Nil(t, successor([]byte{0xff, 0xff}))
+	assert.Nil(t, successor(nil))
+}
+
+func TestConformance(t *testing.T) {
+	conformance.RunSuite(t, func() dosa.Connector {
+		return newEntityConnector(newMemoryRedis(), 0)
+	}, conformance.Skip(conformance.TestSecondaryIndex)) // secondary indexes are not stored
+}
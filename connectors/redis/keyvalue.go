@@ -0,0 +1,207 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// Key/value entities, with a single []byte partition key and a single []byte value, are stored
+// as plain redis strings so that their values can be shared with other redis clients.
+
+// keyValueOf returns the redis key and value to write for a key/value entity
+func (c *Connector) keyValueOf(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (string, []byte, error) {
+	keyName, valueName := nameOfKeyValue(ei)
+
+	cacheValue, ok := values[valueName]
+	if !ok || cacheValue == nil {
+		return "", nil, NewErrInvalidEntity("No value specified.")
+	}
+
+	cacheValueBytes := cacheValue.([]byte)
+	if len(cacheValueBytes) == 0 {
+		return "", nil, NewErrInvalidEntity("No value specified.")
+	}
+
+	cacheKey, err := buildKey(c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name, values[keyName])
+	if err != nil {
+		return "", nil, err
+	}
+	return cacheKey, cacheValueBytes, nil
+}
+
+// setCommand returns the SET command for a key/value pair, with extra arguments such as NX
+func setCommand(key string, value []byte, ttl time.Duration, extra ...interface{}) Command {
+	args := []interface{}{key, value}
+	if ttl > 0 {
+		args = append(args, "PX", int64(ttl/time.Millisecond))
+	}
+	return Command{Name: "SET", Args: append(args, extra...)}
+}
+
+func (c *Connector) createKeyValue(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	client, err := c.commander()
+	if err != nil {
+		return err
+	}
+	cacheKey, cacheValue, err := c.keyValueOf(ei, values)
+	if err != nil {
+		return err
+	}
+	set := setCommand(cacheKey, cacheValue, c.entityTTL(ei), "NX")
+	reply, err := client.Do(set.Name, set.Args...)
+	if err != nil {
+		return err
+	}
+	// SET NX replies nil when the key already exists
+	if reply == nil {
+		return &dosa.ErrAlreadyExists{}
+	}
+	return nil
+}
+
+func (c *Connector) multiReadKeyValues(ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue) ([]*dosa.FieldValuesOrError, error) {
+	keyName, valueName := nameOfKeyValue(ei)
+	results := make([]*dosa.FieldValuesOrError, len(keys))
+	var cacheKeys []string
+	for i, k := range keys {
+		results[i] = &dosa.FieldValuesOrError{}
+		cacheKey, err := buildKey(c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name, k[keyName])
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		cacheKeys = append(cacheKeys, cacheKey)
+	}
+	if len(cacheKeys) == 0 {
+		return results, nil
+	}
+	cacheValues, err := c.client.MGet(cacheKeys)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if result.Error != nil {
+			continue
+		}
+		cacheValue := cacheValues[0]
+		cacheValues = cacheValues[1:]
+		if cacheValue == nil {
+			result.Error = &dosa.ErrNotFound{}
+			continue
+		}
+		result.Values = map[string]dosa.FieldValue{
+			keyName:   keys[i][keyName],
+			valueName: cacheValue,
+		}
+	}
+	return results, nil
+}
+
+// scatter runs a batch operation on the keys that could be built and merges its errors with
+// the ones of the other keys
+func scatter(cacheKeys []string, errs []error, op func(keys []string) ([]error, error)) ([]error, error) {
+	var valid []string
+	for i, key := range cacheKeys {
+		if errs[i] == nil {
+			valid = append(valid, key)
+		}
+	}
+	if len(valid) == 0 {
+		return errs, nil
+	}
+	opErrs, err := op(valid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = opErrs[0]
+			opErrs = opErrs[1:]
+		}
+	}
+	return errs, nil
+}
+
+func (c *Connector) multiUpsertKeyValues(ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	cacheKeys := make([]string, len(multiValues))
+	errs := make([]error, len(multiValues))
+	var cacheValues [][]byte
+	for i, values := range multiValues {
+		var cacheValue []byte
+		if cacheKeys[i], cacheValue, errs[i] = c.keyValueOf(ei, values); errs[i] == nil {
+			cacheValues = append(cacheValues, cacheValue)
+		}
+	}
+	return scatter(cacheKeys, errs, func(keys []string) ([]error, error) {
+		return c.client.MSetEx(keys, cacheValues, c.entityTTL(ei))
+	})
+}
+
+func (c *Connector) multiRemoveKeyValues(ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	keyName, _ := nameOfKeyValue(ei)
+	cacheKeys := make([]string, len(multiKeys))
+	errs := make([]error, len(multiKeys))
+	for i, keys := range multiKeys {
+		cacheKeys[i], errs[i] = buildKey(c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name, keys[keyName])
+	}
+	return scatter(cacheKeys, errs, c.client.MDel)
+}
+
+// keyValueConditions returns the redis key selected by range conditions on a key/value entity,
+// which can only be an Eq condition on its single key
+func (c *Connector) keyValueConditions(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) (string, error) {
+	if err := dosa.EnsureValidRangeConditions(ei.Def, ei.Def.Key, columnConditions, nil); err != nil {
+		return "", errors.Wrap(err, "Invalid range conditions")
+	}
+	keyName, _ := nameOfKeyValue(ei)
+	return buildKey(c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name, columnConditions[keyName][0].Value)
+}
+
+func (c *Connector) rangeKeyValue(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) ([]map[string]dosa.FieldValue, string, error) {
+	cacheKey, err := c.keyValueConditions(ei, columnConditions)
+	if err != nil {
+		return nil, "", err
+	}
+	cacheValue, err := c.client.Get(cacheKey)
+	if dosa.ErrorIsNotFound(err) {
+		return []map[string]dosa.FieldValue{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	keyName, valueName := nameOfKeyValue(ei)
+	return []map[string]dosa.FieldValue{{
+		keyName:   columnConditions[keyName][0].Value,
+		valueName: cacheValue,
+	}}, "", nil
+}
+
+func (c *Connector) removeRangeKeyValue(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	cacheKey, err := c.keyValueConditions(ei, columnConditions)
+	if err != nil {
+		return err
+	}
+	return c.client.Del(cacheKey)
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/dosa"
+)
+
+// memoryRedis is an in-process stand-in for a redis server, implementing the subset of the
+// commands used by the connector with the reply types of redigo.
+type memoryRedis struct {
+	sync.Mutex
+	strings  map[string][]byte
+	hashes   map[string]map[string][]byte
+	zsets    map[string]map[string]struct{}
+	expiries map[string]time.Time
+	now      func() time.Time
+	// commands counts the commands run, pipelines count as one
+	commands int
+}
+
+func newMemoryRedis() *memoryRedis {
+	return &memoryRedis{
+		strings:  make(map[string][]byte),
+		hashes:   make(map[string]map[string][]byte),
+		zsets:    make(map[string]map[string]struct{}),
+		expiries: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+func (m *memoryRedis) Get(key string) ([]byte, error) {
+	v, err := redis.Bytes(m.Do("GET", key))
+	if err == redis.ErrNil {
+		err = &dosa.ErrNotFound{}
+	}
+	return v, err
+}
+
+func (m *memoryRedis) SetEx(key string, value []byte, ttl time.Duration) error {
+	set := setCommand(key, value, ttl)
+	_, err := m.Do(set.Name, set.Args...)
+	return err
+}
+
+func (m *memoryRedis) Del(key string) error {
+	_, err := m.Do("DEL", key)
+	return err
+}
+
+func (m *memoryRedis) MGet(keys []string) ([][]byte, error) {
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	return redis.ByteSlices(m.Do("MGET", args...))
+}
+
+func (m *memoryRedis) MSetEx(keys []string, values [][]byte, ttl time.Duration) ([]error, error) {
+	commands := make([]Command, len(keys))
+	for i, key := range keys {
+		commands[i] = setCommand(key, values[i], ttl)
+	}
+	return m.pipelineErrors(commands)
+}
+
+func (m *memoryRedis) MDel(keys []string) ([]error, error) {
+	commands := make([]Command, len(keys))
+	for i, key := range keys {
+		commands[i] = Command{Name: "DEL", Args: []interface{}{key}}
+	}
+	return m.pipelineErrors(commands)
+}
+
+func (m *memoryRedis) pipelineErrors(commands []Command) ([]error, error) {
+	replies, err := m.Pipeline(commands)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(replies))
+	for i, reply := range replies {
+		errs[i], _ = reply.(error)
+	}
+	return errs, nil
+}
+
+func (m *memoryRedis) Shutdown() error {
+	return nil
+}
+
+func (m *memoryRedis) Do(commandName string, args ...interface{}) (interface{}, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.commands++
+	reply := m.run(commandName, args)
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (m *memoryRedis) Pipeline(commands []Command) ([]interface{}, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.commands++
+	replies := make([]interface{}, len(commands))
+	for i, cmd := range commands {
+		replies[i] = m.run(cmd.Name, cmd.Args)
+	}
+	return replies, nil
+}
+
+// expire drops the key when its expiration passed
+func (m *memoryRedis) expire(key string) {
+	if at, ok := m.expiries[key]; ok && !m.now().Before(at) {
+		m.del(key)
+	}
+}
+
+func (m *memoryRedis) del(key string) int64 {
+	_, s := m.strings[key]
+	_, h := m.hashes[key]
+	_, z := m.zsets[key]
+	delete(m.strings, key)
+	delete(m.hashes, key)
+	delete(m.zsets, key)
+	delete(m.expiries, key)
+	if s || h || z {
+		return 1
+	}
+	return 0
+}
+
+func (m *memoryRedis) exists(key string) bool {
+	_, s := m.strings[key]
+	_, h := m.hashes[key]
+	_, z := m.zsets[key]
+	return s || h || z
+}
+
+func arg(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func (m *memoryRedis) run(name string, rawArgs []interface{}) interface{} {
+	args := make([]string, len(rawArgs))
+	for i, a := range rawArgs {
+		args[i] = arg(a)
+	}
+	if len(args) == 0 {
+		return redis.Error("ERR wrong number of arguments")
+	}
+	name = strings.ToUpper(name)
+	if name == "EVAL" {
+		return m.eval(args)
+	}
+	key := args[0]
+	if name != "MGET" && name != "DEL" {
+		m.expire(key)
+	}
+	switch name {
+	case "GET":
+		if v, ok := m.strings[key]; ok {
+			return v
+		}
+		return nil
+	case "MGET":
+		replies := make([]interface{}, len(args))
+		for i, k := range args {
+			m.expire(k)
+			if v, ok := m.strings[k]; ok {
+				replies[i] = v
+			}
+		}
+		return replies
+	case "SET":
+		var expiry time.Duration
+		nx := false
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX", "EX":
+				i++
+				n, err := strconv.ParseFloat(args[i], 64)
+				if err != nil || n <= 0 {
+					return redis.Error("ERR invalid expire time in set")
+				}
+				unit := time.Millisecond
+				if strings.ToUpper(args[i-1]) == "EX" {
+					unit = time.Second
+				}
+				expiry = time.Duration(n * float64(unit))
+			}
+		}
+		if nx && m.exists(key) {
+			return nil
+		}
+		m.del(key)
+		m.strings[key] = []byte(args[1])
+		if expiry > 0 {
+			m.expiries[key] = m.now().Add(expiry)
+		}
+		return "OK"
+	case "DEL":
+		var n int64
+		for _, k := range args {
+			m.expire(k)
+			n += m.del(k)
+		}
+		return n
+	case "HSET", "HSETNX":
+		if len(args)%2 != 1 || len(args) < 3 {
+			return redis.Error("ERR wrong number of arguments for " + name)
+		}
+		h, ok := m.hashes[key]
+		if !ok {
+			h = make(map[string][]byte)
+			m.hashes[key] = h
+		}
+		var n int64
+		for i := 1; i < len(args); i += 2 {
+			_, exists := h[args[i]]
+			if exists && name == "HSETNX" {
+				continue
+			}
+			if !exists {
+				n++
+			}
+			h[args[i]] = []byte(args[i+1])
+		}
+		return n
+	case "HDEL":
+		var n int64
+		if h, ok := m.hashes[key]; ok {
+			for _, f := range args[1:] {
+				if _, ok := h[f]; ok {
+					delete(h, f)
+					n++
+				}
+			}
+			if len(h) == 0 {
+				m.del(key)
+			}
+		}
+		return n
+	case "HGETALL":
+		reply := []interface{}{}
+		h := m.hashes[key]
+		fields := make([]string, 0, len(h))
+		for f := range h {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		for _, f := range fields {
+			reply = append(reply, []byte(f), h[f])
+		}
+		return reply
+	case "PEXPIRE":
+		if !m.exists(key) {
+			return int64(0)
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return redis.Error("ERR value is not an integer")
+		}
+		m.expiries[key] = m.now().Add(time.Duration(ms) * time.Millisecond)
+		return int64(1)
+	case "PERSIST":
+		if _, ok := m.expiries[key]; !ok {
+			return int64(0)
+		}
+		delete(m.expiries, key)
+		return int64(1)
+	case "ZADD":
+		z, ok := m.zsets[key]
+		if !ok {
+			z = make(map[string]struct{})
+			m.zsets[key] = z
+		}
+		var n int64
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := z[args[i]]; !ok {
+				n++
+			}
+			z[args[i]] = struct{}{}
+		}
+		return n
+	case "ZREM":
+		var n int64
+		if z, ok := m.zsets[key]; ok {
+			for _, member := range args[1:] {
+				if _, ok := z[member]; ok {
+					delete(z, member)
+					n++
+				}
+			}
+			if len(z) == 0 {
+				m.del(key)
+			}
+		}
+		return n
+	case "ZRANGEBYLEX":
+		members := make([]string, 0, len(m.zsets[key]))
+		for member := range m.zsets[key] {
+			if inLexRange(member, args[1], args[2]) {
+				members = append(members, member)
+			}
+		}
+		sort.Strings(members)
+		if len(args) == 6 && strings.ToUpper(args[3]) == "LIMIT" {
+			offset, _ := strconv.Atoi(args[4])
+			count, _ := strconv.Atoi(args[5])
+			if offset > len(members) {
+				offset = len(members)
+			}
+			members = members[offset:]
+			if count >= 0 && count < len(members) {
+				members = members[:count]
+			}
+		}
+		reply := make([]interface{}, len(members))
+		for i, member := range members {
+			reply[i] = []byte(member)
+		}
+		return reply
+	}
+	return redis.Error("ERR unknown command '" + name + "'")
+}
+
+// eval runs the scripts of the connector, which are emulated with the commands they call
+func (m *memoryRedis) eval(args []string) interface{} {
+	if len(args) < 2 {
+		return redis.Error("ERR wrong number of arguments for EVAL")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || len(args) < 2+numKeys {
+		return redis.Error("ERR invalid number of keys")
+	}
+	keys, argv := args[2:2+numKeys], args[2+numKeys:]
+	call := func(name string, args ...string) interface{} {
+		raw := make([]interface{}, len(args))
+		for i, a := range args {
+			raw[i] = a
+		}
+		return m.run(name, raw)
+	}
+	switch args[0] {
+	case writeRowScript:
+		m.expire(keys[0])
+		if argv[0] == "1" && m.exists(keys[0]) {
+			return int64(0)
+		}
+		set, _ := strconv.Atoi(argv[4])
+		fields := argv[5:]
+		if set > 0 {
+			call("HSET", append([]string{keys[0]}, fields[:2*set]...)...)
+		}
+		if len(fields) > 2*set {
+			call("HDEL", append([]string{keys[0]}, fields[2*set:]...)...)
+		}
+		call("ZADD", keys[1], "0", argv[2])
+		call("ZADD", keys[2], "0", argv[3])
+		for _, key := range keys {
+			if argv[1] != "0" {
+				call("PEXPIRE", key, argv[1])
+			} else {
+				call("PERSIST", key)
+			}
+		}
+		return int64(1)
+	case removeRowScript:
+		call("ZREM", keys[1], argv[0])
+		call("ZREM", keys[2], argv[1])
+		return call("DEL", keys[0])
+	}
+	return redis.Error("NOSCRIPT unknown script")
+}
+
+func inLexRange(member, min, max string) bool {
+	switch {
+	case min == "+":
+		return false
+	case min[0] == '[' && member < min[1:]:
+		return false
+	case min[0] == '(' && member <= min[1:]:
+		return false
+	}
+	switch {
+	case max == "-":
+		return false
+	case max[0] == '[' && member > max[1:]:
+		return false
+	case max[0] == '(' && member >= max[1:]:
+		return false
+	}
+	return true
+}
@@ -29,9 +29,21 @@ import (
 	"github.com/uber-go/dosa/metrics"
 )
 
-// NewRedigoClient returns a redigo implementation of SimpleRedis
+// NewRedigoClient returns a redigo implementation of SimpleRedis. When the config has several
+// addresses, keys are sharded across them with NewShardedClient.
 func NewRedigoClient(config ServerConfig, scope metrics.Scope) SimpleRedis {
-	c := &simpleRedis{config: config, stats: metrics.CheckIfNilStats(scope)}
+	if len(config.Addresses) == 0 {
+		return newRedigoClient(fmt.Sprintf("%s:%d", config.Host, config.Port), config, scope)
+	}
+	shards := make(map[string]SimpleRedis, len(config.Addresses))
+	for _, address := range config.Addresses {
+		shards[address] = newRedigoClient(address, config, scope)
+	}
+	return NewShardedClient(shards)
+}
+
+func newRedigoClient(address string, config ServerConfig, scope metrics.Scope) *simpleRedis {
+	c := &simpleRedis{address: address, stats: metrics.CheckIfNilStats(scope)}
 	c.pool = &redis.Pool{
 		MaxActive:   config.MaxActive,
 		MaxIdle:     config.MaxIdle,
@@ -39,7 +51,7 @@ func NewRedigoClient(config ServerConfig, scope metrics.Scope) SimpleRedis {
 		Dial: func() (redis.Conn, error) {
 			c, err := redis.Dial(
 				"tcp",
-				c.getURL(),
+				address,
 				redis.DialConnectTimeout(config.ConnectTimeout),
 				redis.DialReadTimeout(config.ReadTimeout),
 				redis.DialWriteTimeout(config.WriteTimeout))
@@ -54,13 +66,9 @@ func NewRedigoClient(config ServerConfig, scope metrics.Scope) SimpleRedis {
 }
 
 type simpleRedis struct {
-	config ServerConfig
-	pool   *redis.Pool
-	stats  metrics.Scope
-}
-
-func (c *simpleRedis) getURL() string {
-	return fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+	address string
+	pool    *redis.Pool
+	stats   metrics.Scope
 }
 
 // Get returns an error if the key is not found in cache
@@ -72,7 +80,12 @@ func (c *simpleRedis) Get(key string) ([]byte, error) {
 	return bytes, err
 }
 
+// SetEx sets the value of a key, expiring it after ttl unless ttl is zero
 func (c *simpleRedis) SetEx(key string, value []byte, ttl time.Duration) error {
+	if ttl == 0 {
+		_, err := c.do("SET", key, value)
+		return err
+	}
 	_, err := c.do("SET", key, value, "EX", ttl.Seconds())
 	return err
 }
@@ -82,6 +95,82 @@ func (c *simpleRedis) Del(key string) error {
 	return err
 }
 
+// MGet returns the values of the keys, nil for the ones not found
+func (c *simpleRedis) MGet(keys []string) ([][]byte, error) {
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	return redis.ByteSlices(c.do("MGET", args...))
+}
+
+// MSetEx pipelines a SET command per key
+func (c *simpleRedis) MSetEx(keys []string, values [][]byte, ttl time.Duration) ([]error, error) {
+	commands := make([]Command, len(keys))
+	for i, key := range keys {
+		commands[i] = setCommand(key, values[i], ttl)
+	}
+	return c.pipelineErrors(commands)
+}
+
+// MDel pipelines a DEL command per key
+func (c *simpleRedis) MDel(keys []string) ([]error, error) {
+	commands := make([]Command, len(keys))
+	for i, key := range keys {
+		commands[i] = Command{Name: "DEL", Args: []interface{}{key}}
+	}
+	return c.pipelineErrors(commands)
+}
+
+// pipelineErrors runs the commands in a pipeline and returns the error of each command
+func (c *simpleRedis) pipelineErrors(commands []Command) ([]error, error) {
+	replies, err := c.Pipeline(commands)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(replies))
+	for i, reply := range replies {
+		errs[i], _ = reply.(error)
+	}
+	return errs, nil
+}
+
+// Do runs a single redis command
+func (c *simpleRedis) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return c.do(commandName, args...)
+}
+
+// Pipeline sends all the commands on one connection before reading any of their replies
+func (c *simpleRedis) Pipeline(commands []Command) ([]interface{}, error) {
+	t := c.stats.SubScope("redis").SubScope("latency").Timer("PIPELINE")
+	t.Start()
+	defer t.Stop()
+
+	conn := c.pool.Get()
+	defer func() { _ = conn.Close() }()
+	for _, cmd := range commands {
+		if err := conn.Send(cmd.Name, cmd.Args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		reply, err := conn.Receive()
+		if err != nil {
+			// errors replied by redis only fail their own command
+			if _, ok := err.(redis.Error); !ok {
+				return nil, err
+			}
+			reply = err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
 // Shutdown closes the underlying connection pool to redis
 func (c *simpleRedis) Shutdown() error {
 	return c.pool.Close()
@@ -112,3 +112,14 @@ func TestTimerCalled(t *testing.T) {
 		t.Run(fmt.Sprintf("%v test", command), f)
 	}
 }
+
+func TestShardedAddresses(t *testing.T) {
+	config := redis.ServerConfig{Addresses: []string{"localhost:1111", "localhost:1112"}}
+	c := redis.NewRedigoClient(config, nil)
+	_, err := c.Get("testkey")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ":111")
+	_, err = c.MGet([]string{"a", "b", "c"})
+	assert.Error(t, err)
+	assert.NoError(t, c.Shutdown())
+}
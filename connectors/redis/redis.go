@@ -33,14 +33,37 @@ import (
 
 const keySeparator = ","
 
-// SimpleRedis is a minimal interface to Redis commands
+// SimpleRedis is a minimal interface to Redis commands. Implementations can be provided to
+// NewConnectorWithClient; they should also implement Commander for the connector to support
+// more than key/value entities.
 type SimpleRedis interface {
 	Get(key string) ([]byte, error)
 	SetEx(key string, value []byte, ttl time.Duration) error
 	Del(key string) error
+	// MGet returns the values of the keys, with nil values for the keys that are not found
+	MGet(keys []string) ([][]byte, error)
+	// MSetEx sets the values of the keys in a single round trip and returns an error per key
+	MSetEx(keys []string, values [][]byte, ttl time.Duration) ([]error, error)
+	// MDel deletes the keys in a single round trip and returns an error per key
+	MDel(keys []string) ([]error, error)
 	Shutdown() error
 }
 
+// Commander is implemented by redis clients that can run any command, either one at a time or
+// pipelined in a single round trip. Replies have the types returned by redigo; in a pipeline,
+// the reply of a command that failed is its error. The connector needs a Commander to store
+// entities that are not key/value pairs, and for all the Multi* and range operations.
+type Commander interface {
+	Do(commandName string, args ...interface{}) (interface{}, error)
+	Pipeline(commands []Command) ([]interface{}, error)
+}
+
+// Command is a single redis command sent in a pipeline
+type Command struct {
+	Name string
+	Args []interface{}
+}
+
 // ErrNotImplemented is returned for interface methods that do not have an implementation
 type ErrNotImplemented struct{}
 
@@ -78,6 +101,10 @@ type Config struct {
 type ServerConfig struct {
 	Host string
 	Port int
+	// Addresses are the "host:port" addresses of several redis servers to spread the keys
+	// across with consistent hashing. Host and Port are ignored when there are addresses, the
+	// other settings apply to each of them.
+	Addresses []string
 	// MaxIdle is the maximum number of idle connections in the pool.
 	MaxIdle int
 	// IdleTimeout directs to close connections after remaining idle for this duration.
@@ -95,8 +122,14 @@ type ServerConfig struct {
 
 // NewConnector initializes a Redis Connector
 func NewConnector(config Config, scope metrics.Scope) dosa.Connector {
+	return NewConnectorWithClient(config, NewRedigoClient(config.ServerSettings, scope), scope)
+}
+
+// NewConnectorWithClient initializes a Redis Connector using the given client, e.g. one built
+// with NewShardedClient. The ServerSettings of the config are not used.
+func NewConnectorWithClient(config Config, client SimpleRedis, scope metrics.Scope) dosa.Connector {
 	return &Connector{
-		client:    NewRedigoClient(config.ServerSettings, scope),
+		client:    client,
 		ttl:       config.TTL,
 		stats:     metrics.CheckIfNilStats(scope),
 		keyPrefix: config.KeyPrefix,
@@ -112,42 +145,98 @@ type Connector struct {
 	keyPrefix string
 }
 
-// CreateIfNotExists not implemented
+// CreateIfNotExists creates a row only when it does not exist yet, using SET NX for key/value
+// entities and a script checking and writing the row atomically for the others
 func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	return new(ErrNotImplemented)
+	var err error
+	if isKeyValue(ei) {
+		err = c.createKeyValue(ei, values)
+	} else {
+		err = c.createRow(ei, values)
+	}
+	c.logCallCount("CreateIfNotExists", err)
+	return err
 }
 
-// MultiRead not implemented
+// MultiRead reads several rows in a single round trip, with MGet for key/value entities and
+// pipelined HGETALL for the others
 func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) (results []*dosa.FieldValuesOrError, err error) {
-	return nil, new(ErrNotImplemented)
+	if isKeyValue(ei) {
+		results, err = c.multiReadKeyValues(ei, keys)
+	} else {
+		results, err = c.multiReadRows(ei, keys)
+	}
+	if err != nil {
+		c.logCallCount("MultiRead", err)
+		return nil, err
+	}
+	for _, result := range results {
+		c.logHitRate("MultiRead", result.Error)
+	}
+	return results, nil
 }
 
-// MultiUpsert not implemented
+// MultiUpsert writes several rows in a single pipeline
 func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) (result []error, err error) {
-	return nil, new(ErrNotImplemented)
+	if isKeyValue(ei) {
+		result, err = c.multiUpsertKeyValues(ei, multiValues)
+	} else {
+		result, err = c.multiUpsertRows(ei, multiValues)
+	}
+	c.logCallCount("MultiUpsert", err)
+	return result, err
 }
 
-// RemoveRange not implemented
+// RemoveRange removes all the rows of a partition matching the conditions
 func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
-	return new(ErrNotImplemented)
+	var err error
+	if isKeyValue(ei) {
+		err = c.removeRangeKeyValue(ei, columnConditions)
+	} else {
+		err = c.removeRangeRows(ei, columnConditions)
+	}
+	c.logCallCount("RemoveRange", err)
+	return err
 }
 
-// MultiRemove not implemented
+// MultiRemove removes several rows in a single pipeline
 func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) (result []error, err error) {
-	return nil, new(ErrNotImplemented)
+	if isKeyValue(ei) {
+		result, err = c.multiRemoveKeyValues(ei, multiKeys)
+	} else {
+		result, err = c.multiRemoveRows(ei, multiKeys)
+	}
+	c.logCallCount("MultiRemove", err)
+	return result, err
 }
 
-// Range not implemented.
+// Range reads the rows of a partition matching the conditions, in clustering key order
 func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
-	return nil, "", new(ErrNotImplemented)
+	var (
+		rows []map[string]dosa.FieldValue
+		err  error
+	)
+	if isKeyValue(ei) {
+		rows, token, err = c.rangeKeyValue(ei, columnConditions)
+	} else {
+		rows, token, err = c.rangeRows(ei, columnConditions, token, limit)
+	}
+	c.logCallCount("Range", err)
+	return rows, token, err
 }
 
-// Scan not implemented.
+// Scan reads all the rows of an entity. It's not implemented for key/value entities, which are
+// stored without any index.
 func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) (multiValues []map[string]dosa.FieldValue, nextToken string, err error) {
-	return nil, "", new(ErrNotImplemented)
+	if isKeyValue(ei) {
+		return nil, "", new(ErrNotImplemented)
+	}
+	multiValues, nextToken, err = c.scanRows(ei, token, limit)
+	c.logCallCount("Scan", err)
+	return multiValues, nextToken, err
 }
 
-// Shutdown not implemented
+// Shutdown closes the redis client
 func (c *Connector) Shutdown() error {
 	err := c.client.Shutdown()
 	c.logCallCount("Shutdown", err)
@@ -156,9 +245,10 @@ func (c *Connector) Shutdown() error {
 
 // Read reads an object based on primary key
 func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, fieldsToRead []string) (map[string]dosa.FieldValue, error) {
-	err := validateSchema(ei)
-	if err != nil {
-		return nil, err
+	if !isKeyValue(ei) {
+		values, err := c.readRow(ei, keys)
+		c.logHitRate("Read", err)
+		return values, err
 	}
 
 	keyName, valueName := nameOfKeyValue(ei)
@@ -185,39 +275,30 @@ func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[stri
 
 // Upsert means update an existing object or create a new object
 func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	err := validateSchema(ei)
-	if err != nil {
+	if !isKeyValue(ei) {
+		err := c.upsertRow(ei, values)
+		c.logCallCount("Upsert", err)
 		return err
 	}
 
-	keyName, valueName := nameOfKeyValue(ei)
-
-	cacheValue, ok := values[valueName]
-	if !ok || cacheValue == nil {
-		return NewErrInvalidEntity("No value specified.")
-	}
-
-	cacheValueBytes := cacheValue.([]byte)
-	if len(cacheValueBytes) == 0 {
-		return NewErrInvalidEntity("No value specified.")
-	}
-
-	cacheKey, err := buildKey(c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name, values[keyName])
+	cacheKey, cacheValue, err := c.keyValueOf(ei, values)
 	if err != nil {
 		return err
 	}
 
-	err = c.client.SetEx(cacheKey, cacheValueBytes, c.ttl)
+	err = c.client.SetEx(cacheKey, cacheValue, c.entityTTL(ei))
 	c.logCallCount("Upsert", err)
 	return err
 }
 
 // Remove deletes a key
 func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
-	err := validateSchema(ei)
-	if err != nil {
+	if !isKeyValue(ei) {
+		err := c.removeRow(ei, keys)
+		c.logCallCount("Remove", err)
 		return err
 	}
+
 	keyName, _ := nameOfKeyValue(ei)
 	cacheKey, err := buildKey(c.keyPrefix, ei.Ref.Scope, ei.Ref.NamePrefix, ei.Def.Name, keys[keyName])
 	if err != nil {
@@ -229,6 +310,16 @@ func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[st
 	return err
 }
 
+// commander returns the client as a Commander, which is needed for everything but reading,
+// writing and removing single key/value pairs
+func (c *Connector) commander() (Commander, error) {
+	client, ok := c.client.(Commander)
+	if !ok {
+		return nil, new(ErrNotImplemented)
+	}
+	return client, nil
+}
+
 func (c *Connector) logHitRate(method string, err error) {
 	if err != nil && dosa.ErrorIsNotFound(err) {
 		c.incStat("miss", method)
@@ -259,6 +350,11 @@ func nameOfKeyValue(ei *dosa.EntityInfo) (string, string) {
 	return keyName, cols[0].Name
 }
 
+// isKeyValue tells whether the entity is stored as a plain redis string rather than a hash
+func isKeyValue(ei *dosa.EntityInfo) bool {
+	return validateSchema(ei) == nil
+}
+
 func validateSchema(ei *dosa.EntityInfo) error {
 	if len(ei.Def.Key.PartitionKeys) != 1 || len(ei.Def.Key.ClusteringKeys) != 0 {
 		return NewErrInvalidEntity("Should only have a single key.")
@@ -50,26 +50,8 @@ var (
 	testEi   = &dosa.EntityInfo{Ref: &sr, Def: &table.EntityDefinition}
 )
 
-func TestUnimplementedFunctions(t *testing.T) {
-	err := rc.CreateIfNotExists(context.TODO(), &dosa.EntityInfo{}, nil)
-	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
-
-	_, err = rc.MultiRead(context.TODO(), &dosa.EntityInfo{}, nil, dosa.All())
-	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
-
-	_, err = rc.MultiUpsert(context.TODO(), &dosa.EntityInfo{}, nil)
-	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
-
-	err = rc.RemoveRange(context.TODO(), &dosa.EntityInfo{}, nil)
-	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
-
-	_, err = rc.MultiRemove(context.TODO(), &dosa.EntityInfo{}, nil)
-	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
-
-	_, _, err = rc.Range(context.TODO(), &dosa.EntityInfo{}, nil, dosa.All(), "", 1)
-	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
-
-	_, _, err = rc.Scan(context.TODO(), &dosa.EntityInfo{}, dosa.All(), "", 1)
+func TestScanKeyValueNotImplemented(t *testing.T) {
+	_, _, err := rc.Scan(context.TODO(), testEi, dosa.All(), "", 1)
 	assert.EqualError(t, err, new(redis.ErrNotImplemented).Error())
 }
 
@@ -143,20 +125,6 @@ func TestWriteNilValue(t *testing.T) {
 	assert.EqualError(t, err, "This entity schema and value not supported by redis. No value specified.")
 }
 
-func TestWriteInvalidEntityKey(t *testing.T) {
-	table, _ := dosa.TableFromInstance(&testentity.TestEntity{})
-	testEi := &dosa.EntityInfo{Ref: nil, Def: &table.EntityDefinition}
-	err := rc.Upsert(context.TODO(), testEi, nil)
-	assert.EqualError(t, err, "This entity schema and value not supported by redis. Should only have a single key.")
-}
-
-func TestWriteInvalidEntityValue(t *testing.T) {
-	table, _ := dosa.TableFromInstance(&testentity.KeyValues{})
-	testEi := &dosa.EntityInfo{Ref: nil, Def: &table.EntityDefinition}
-	err := rc.Upsert(context.TODO(), testEi, nil)
-	assert.EqualError(t, err, "This entity schema and value not supported by redis. Should have one key, one value.")
-}
-
 func TestReadNotFound(t *testing.T) {
 	if !redis.IsRunning() {
 		t.Skip("Redis is not running")
@@ -169,25 +137,11 @@ func TestReadNotFound(t *testing.T) {
 	assert.EqualError(t, err, new(dosa.ErrNotFound).Error())
 }
 
-func TestReadInvalidEntityKey(t *testing.T) {
-	table, _ := dosa.TableFromInstance(&testentity.TestEntity{})
-	testEi := &dosa.EntityInfo{Ref: nil, Def: &table.EntityDefinition}
-	_, err := rc.Read(context.TODO(), testEi, nil, dosa.All())
-	assert.EqualError(t, err, "This entity schema and value not supported by redis. Should only have a single key.")
-}
-
 func TestReadNoKey(t *testing.T) {
 	_, err := rc.Read(context.TODO(), testEi, map[string]dosa.FieldValue{"k": []byte{}}, dosa.All())
 	assert.EqualError(t, err, "This entity schema and value not supported by redis. No key specified.")
 }
 
-func TestReadInvalidEntityValue(t *testing.T) {
-	table, _ := dosa.TableFromInstance(&testentity.KeyValues{})
-	testEi := &dosa.EntityInfo{Ref: nil, Def: &table.EntityDefinition}
-	_, err := rc.Read(context.TODO(), testEi, nil, dosa.All())
-	assert.EqualError(t, err, "This entity schema and value not supported by redis. Should have one key, one value.")
-}
-
 func TestRemove(t *testing.T) {
 	if !redis.IsRunning() {
 		t.Skip("Redis is not running")
@@ -201,20 +155,6 @@ func TestRemoveNoKey(t *testing.T) {
 	assert.EqualError(t, err, "This entity schema and value not supported by redis. No key specified.")
 }
 
-func TestRemoveInvalidEntity(t *testing.T) {
-	table, _ := dosa.TableFromInstance(&testentity.TestEntity{})
-	testEi := &dosa.EntityInfo{Ref: nil, Def: &table.EntityDefinition}
-	err := rc.Remove(context.TODO(), testEi, nil)
-	assert.EqualError(t, err, "This entity schema and value not supported by redis. Should only have a single key.")
-}
-
-func TestEntityTypeNotBytes(t *testing.T) {
-	table, err := dosa.TableFromInstance(&testentity.KeyValueNonByte{})
-	testEi := &dosa.EntityInfo{Ref: nil, Def: &table.EntityDefinition}
-	_, err = rc.Read(context.TODO(), testEi, nil, dosa.All())
-	assert.EqualError(t, err, "This entity schema and value not supported by redis. Types should be []byte.")
-}
-
 func TestShutdownConnector(t *testing.T) {
 	var rc = redis.NewConnector(testRedisConfig, nil)
 	err := rc.Shutdown()
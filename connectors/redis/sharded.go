@@ -0,0 +1,328 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// virtualNodes is the number of points each shard gets on the hash ring. More points spread the
+// keys more evenly at the cost of a larger ring.
+const virtualNodes = 160
+
+// ringNode is a point of the hash ring owned by a shard
+type ringNode struct {
+	hash  uint32
+	shard int
+}
+
+// shardedClient spreads keys across several redis clients with consistent hashing, so that
+// adding or removing a shard only moves the keys of that shard. Like redis cluster, only the
+// part of a key between the first { and the following } is hashed when there is one, which
+// lets related keys be kept on the same shard.
+type shardedClient struct {
+	shards []SimpleRedis
+	ring   []ringNode
+}
+
+// NewShardedClient returns a SimpleRedis spreading keys across the given clients with consistent
+// hashing. Shards are placed on the hash ring by name, usually their address, so their names
+// must stay the same for keys to keep mapping to the same shard. The sharded client is also a
+// Commander, running each command on the shard of its keys, as long as all the shards are.
+// It panics when there are no shards, as there would be nowhere to send the commands.
+func NewShardedClient(shards map[string]SimpleRedis) SimpleRedis {
+	if len(shards) == 0 {
+		panic("redis: a sharded client needs at least one shard")
+	}
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c := &shardedClient{}
+	for i, name := range names {
+		c.shards = append(c.shards, shards[name])
+		for v := 0; v < virtualNodes; v++ {
+			c.ring = append(c.ring, ringNode{
+				hash:  crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(v))),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i].hash < c.ring[j].hash })
+	return c
+}
+
+// hashTag returns the part of the key that is hashed
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+// shardOf returns the index of the shard owning the key
+func (c *shardedClient) shardOf(key string) int {
+	h := crc32.ChecksumIEEE([]byte(hashTag(key)))
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].shard
+}
+
+// groupByShard returns the positions of the keys grouped by the shard owning them
+func (c *shardedClient) groupByShard(keys []string) map[int][]int {
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		shard := c.shardOf(key)
+		groups[shard] = append(groups[shard], i)
+	}
+	return groups
+}
+
+// forEachShard runs f concurrently for every group of keys and returns the first error
+func (c *shardedClient) forEachShard(groups map[int][]int, f func(shard SimpleRedis, positions []int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mux      sync.Mutex
+		firstErr error
+	)
+	for shard, positions := range groups {
+		wg.Add(1)
+		go func(shard SimpleRedis, positions []int) {
+			defer wg.Done()
+			if err := f(shard, positions); err != nil {
+				mux.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mux.Unlock()
+			}
+		}(c.shards[shard], positions)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func pick(keys []string, positions []int) []string {
+	picked := make([]string, len(positions))
+	for i, p := range positions {
+		picked[i] = keys[p]
+	}
+	return picked
+}
+
+func (c *shardedClient) Get(key string) ([]byte, error) {
+	return c.shards[c.shardOf(key)].Get(key)
+}
+
+func (c *shardedClient) SetEx(key string, value []byte, ttl time.Duration) error {
+	return c.shards[c.shardOf(key)].SetEx(key, value, ttl)
+}
+
+func (c *shardedClient) Del(key string) error {
+	return c.shards[c.shardOf(key)].Del(key)
+}
+
+func (c *shardedClient) MGet(keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	err := c.forEachShard(c.groupByShard(keys), func(shard SimpleRedis, positions []int) error {
+		shardValues, err := shard.MGet(pick(keys, positions))
+		if err != nil {
+			return err
+		}
+		for i, p := range positions {
+			values[p] = shardValues[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (c *shardedClient) MSetEx(keys []string, values [][]byte, ttl time.Duration) ([]error, error) {
+	errs := make([]error, len(keys))
+	err := c.forEachShard(c.groupByShard(keys), func(shard SimpleRedis, positions []int) error {
+		shardValues := make([][]byte, len(positions))
+		for i, p := range positions {
+			shardValues[i] = values[p]
+		}
+		shardErrs, err := shard.MSetEx(pick(keys, positions), shardValues, ttl)
+		if err != nil {
+			return err
+		}
+		for i, p := range positions {
+			errs[p] = shardErrs[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+func (c *shardedClient) MDel(keys []string) ([]error, error) {
+	errs := make([]error, len(keys))
+	err := c.forEachShard(c.groupByShard(keys), func(shard SimpleRedis, positions []int) error {
+		shardErrs, err := shard.MDel(pick(keys, positions))
+		if err != nil {
+			return err
+		}
+		for i, p := range positions {
+			errs[p] = shardErrs[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// Shutdown shuts all the shards down and returns the first error
+func (c *shardedClient) Shutdown() error {
+	var firstErr error
+	for _, shard := range c.shards {
+		if err := shard.Shutdown(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// commandShard returns the shard running the command. Commands on several keys are only
+// supported when all their keys are on the same shard.
+func (c *shardedClient) commandShard(commandName string, args []interface{}) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.Errorf("cannot shard %s without a key", commandName)
+	}
+	keys := args[:1]
+	switch strings.ToUpper(commandName) {
+	case "MGET", "DEL", "EXISTS", "UNLINK":
+		keys = args
+	case "EVAL", "EVALSHA":
+		// scripts are followed by the number of keys and the keys
+		var numKeys int
+		if len(args) > 1 {
+			numKeys, _ = strconv.Atoi(keyString(args[1]))
+		}
+		if numKeys <= 0 || len(args) < 2+numKeys {
+			return 0, errors.Errorf("cannot shard %s without a key", commandName)
+		}
+		keys = args[2 : 2+numKeys]
+	}
+	shard := -1
+	for _, key := range keys {
+		s := c.shardOf(keyString(key))
+		if shard >= 0 && s != shard {
+			return 0, errors.Errorf("keys of %s are on different shards", commandName)
+		}
+		shard = s
+	}
+	if _, ok := c.shards[shard].(Commander); !ok {
+		return 0, new(ErrNotImplemented)
+	}
+	return shard, nil
+}
+
+// keyString converts a command argument holding a key to a string
+func keyString(key interface{}) string {
+	switch key := key.(type) {
+	case string:
+		return key
+	case []byte:
+		return string(key)
+	}
+	return fmt.Sprint(key)
+}
+
+// Do runs the command on the shard of its keys
+func (c *shardedClient) Do(commandName string, args ...interface{}) (interface{}, error) {
+	shard, err := c.commandShard(commandName, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.shards[shard].(Commander).Do(commandName, args...)
+}
+
+// Pipeline splits the commands into one pipeline per shard, which run concurrently. Commands
+// that cannot be sharded get their error as reply.
+func (c *shardedClient) Pipeline(commands []Command) ([]interface{}, error) {
+	replies := make([]interface{}, len(commands))
+	pipelines := make(map[int][]int)
+	for i, cmd := range commands {
+		shard, err := c.commandShard(cmd.Name, cmd.Args)
+		if err != nil {
+			replies[i] = redis.Error(err.Error())
+			continue
+		}
+		pipelines[shard] = append(pipelines[shard], i)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mux      sync.Mutex
+		firstErr error
+	)
+	for shard, positions := range pipelines {
+		wg.Add(1)
+		go func(commander Commander, positions []int) {
+			defer wg.Done()
+			shardCommands := make([]Command, len(positions))
+			for i, p := range positions {
+				shardCommands[i] = commands[p]
+			}
+			shardReplies, err := commander.Pipeline(shardCommands)
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for i, p := range positions {
+				replies[p] = shardReplies[i]
+			}
+		}(c.shards[shard].(Commander), positions)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return replies, nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+)
+
+func newShards(names ...string) map[string]SimpleRedis {
+	shards := make(map[string]SimpleRedis, len(names))
+	for _, name := range names {
+		shards[name] = newMemoryRedis()
+	}
+	return shards
+}
+
+func TestShardedDistribution(t *testing.T) {
+	c := NewShardedClient(newShards("a:6379", "b:6379", "c:6379")).(*shardedClient)
+	counts := make(map[int]int)
+	for i := 0; i < 3000; i++ {
+		counts[c.shardOf(fmt.Sprintf("key%d", i))]++
+	}
+	assert.Len(t, counts, 3)
+	for _, count := range counts {
+		assert.InDelta(t, 1000, count, 300)
+	}
+}
+
+func TestShardedConsistency(t *testing.T) {
+	three := NewShardedClient(newShards("a:6379", "b:6379", "c:6379")).(*shardedClient)
+	four := NewShardedClient(newShards("a:6379", "b:6379", "c:6379", "d:6379")).(*shardedClient)
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before, after := three.shardOf(key), four.shardOf(key)
+		// shards are ordered by name, so the new shard is the last one
+		if after != before {
+			moved++
+			assert.Equal(t, 3, after, "keys only move to the new shard")
+		}
+	}
+	assert.InDelta(t, 1000, moved, 300)
+}
+
+func TestHashTag(t *testing.T) {
+	assert.Equal(t, "user1", hashTag("a{user1}b"))
+	assert.Equal(t, "{}x", hashTag("{}x"))
+	assert.Equal(t, "a{b", hashTag("a{b"))
+	c := NewShardedClient(newShards("a:6379", "b:6379", "c:6379")).(*shardedClient)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, c.shardOf(fmt.Sprintf("{tag}%d", i)), c.shardOf("other{tag}"))
+	}
+}
+
+func TestShardedBatches(t *testing.T) {
+	shards := newShards("a:6379", "b:6379", "c:6379")
+	client := NewShardedClient(shards)
+	c := client.(*shardedClient)
+
+	var (
+		keys   []string
+		values [][]byte
+	)
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+		values = append(values, []byte{byte(i)})
+	}
+	errs, err := client.MSetEx(keys, values, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]error, len(keys)), errs)
+	for i, key := range keys {
+		// every key is on the shard owning it and only there
+		for _, shard := range shards {
+			_, ok := shard.(*memoryRedis).strings[key]
+			assert.Equal(t, shard == c.shards[c.shardOf(key)], ok)
+		}
+		v, err := client.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, values[i], v)
+	}
+
+	read, err := client.MGet(append([]string{"missing"}, keys...))
+	assert.NoError(t, err)
+	assert.Equal(t, append([][]byte{nil}, values...), read)
+
+	errs, err = client.MDel(keys[:10])
+	assert.NoError(t, err)
+	assert.Equal(t, make([]error, 10), errs)
+	assert.NoError(t, client.Del(keys[10]))
+	read, err = client.MGet(keys[:12])
+	assert.NoError(t, err)
+	assert.Equal(t, append(make([][]byte, 11), values[11]), read)
+
+	assert.NoError(t, client.SetEx("single", []byte{1}, 0))
+	_, err = c.shards[c.shardOf("single")].Get("single")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Shutdown())
+}
+
+func TestShardedCommands(t *testing.T) {
+	c := NewShardedClient(newShards("a:6379", "b:6379", "c:6379")).(*shardedClient)
+	var sameShard, otherShard string
+	for i := 0; otherShard == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if c.shardOf(key) == c.shardOf("key") {
+			sameShard = key
+		} else {
+			otherShard = key
+		}
+	}
+	_, err := c.Do("SET", "key", "v")
+	assert.NoError(t, err)
+	_, err = c.Do("MGET", "key", otherShard)
+	assert.Contains(t, err.Error(), "different shards")
+	_, err = c.Do("PING")
+	assert.Error(t, err)
+
+	replies, err := c.Pipeline([]Command{
+		{Name: "SET", Args: []interface{}{otherShard, "w"}},
+		{Name: "DEL", Args: []interface{}{"key", otherShard}},
+		{Name: "GET", Args: []interface{}{[]byte("key")}},
+		{Name: "GET", Args: []interface{}{otherShard}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", replies[0])
+	assert.Contains(t, replies[1].(error).Error(), "different shards")
+	assert.Equal(t, []byte("v"), replies[2])
+	assert.Equal(t, []byte("w"), replies[3])
+	if sameShard != "" {
+		_, err = c.Do("DEL", "key", sameShard)
+		assert.NoError(t, err)
+	}
+
+	// scripts run on the shard of their keys
+	reply, err := c.Do("EVAL", removeRowScript, 3, "{t}row", "{t}partition", "{t}entity", "m", "e")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), reply)
+	_, err = c.Do("EVAL", removeRowScript, 3, "key", otherShard, "key", "m", "e")
+	assert.Contains(t, err.Error(), "different shards")
+	_, err = c.Do("EVAL", removeRowScript, 0)
+	assert.Contains(t, err.Error(), "without a key")
+
+	// shards that are not Commanders only support the SimpleRedis methods
+	plain := NewShardedClient(map[string]SimpleRedis{"a:6379": struct{ SimpleRedis }{newMemoryRedis()}}).(*shardedClient)
+	_, err = plain.Do("GET", "key")
+	assert.EqualError(t, err, new(ErrNotImplemented).Error())
+}
+
+func TestShardedClientWithoutShards(t *testing.T) {
+	assert.Panics(t, func() { NewShardedClient(nil) })
+	assert.Panics(t, func() { NewShardedClient(map[string]SimpleRedis{}) })
+}
+
+func TestShardedConnector(t *testing.T) {
+	c := NewConnectorWithClient(Config{KeyPrefix: "test"}, NewShardedClient(newShards("a:6379", "b:6379", "c:6379")), nil)
+	for _, s := range []string{"a", "b"} {
+		for i := int64(1); i <= 3; i++ {
+			assert.NoError(t, c.Upsert(context.TODO(), entityEi, entityRow(s, i)))
+		}
+	}
+	rows, token, err := c.Range(context.TODO(), entityEi, map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: partitionUUID}},
+		"strkey":      {{Op: dosa.Eq, Value: "b"}},
+	}, dosa.All(), "", 2)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, []map[string]dosa.FieldValue{entityRow("b", 3), entityRow("b", 2)}, rows)
+	rows, _, err = c.Scan(context.TODO(), entityEi, dosa.All(), "", 100)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 6)
+
+	var kvs []map[string]dosa.FieldValue
+	var keys []map[string]dosa.FieldValue
+	for i := byte(0); i < 20; i++ {
+		kvs = append(kvs, map[string]dosa.FieldValue{"k": []byte{i + 1}, "v": []byte{i}})
+		keys = append(keys, map[string]dosa.FieldValue{"k": []byte{i + 1}})
+	}
+	errs, err := c.MultiUpsert(context.TODO(), kvEi, kvs)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]error, len(kvs)), errs)
+	results, err := c.MultiRead(context.TODO(), kvEi, keys, dosa.All())
+	assert.NoError(t, err)
+	for i, result := range results {
+		assert.NoError(t, result.Error)
+		assert.Equal(t, kvs[i], result.Values)
+	}
+	assert.NoError(t, c.Shutdown())
+}
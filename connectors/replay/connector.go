@@ -0,0 +1,301 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package replay
+
+import (
+	"context"
+
+	"github.com/uber-go/dosa"
+)
+
+// CreateIfNotExists records or replays the creation of a row
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	call := newCall("CreateIfNotExists", ei)
+	call.Request.Values = values
+	if err := c.do(call, func(r *Response) error {
+		return c.next.CreateIfNotExists(ctx, ei, values)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// Read records or replays the read of a row
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	call := newCall("Read", ei)
+	call.Request.Values = keys
+	call.Request.MinimumFields = minimumFields
+	if err := c.do(call, func(r *Response) (err error) {
+		r.Values, err = c.next.Read(ctx, ei, keys, minimumFields)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return call.Response.Values, call.Response.error()
+}
+
+// MultiRead records or replays the read of several rows
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	call := newCall("MultiRead", ei)
+	call.Request.MultiValues = keys
+	call.Request.MinimumFields = minimumFields
+	var results []*dosa.FieldValuesOrError
+	if err := c.do(call, func(r *Response) (err error) {
+		results, err = c.next.MultiRead(ctx, ei, keys, minimumFields)
+		for _, result := range results {
+			if result == nil {
+				r.Results = append(r.Results, nil)
+				continue
+			}
+			r.Results = append(r.Results, &Result{Values: result.Values, Error: newError(result.Error)})
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if c.next == nil {
+		for _, result := range call.Response.Results {
+			if result == nil {
+				results = append(results, nil)
+				continue
+			}
+			results = append(results, &dosa.FieldValuesOrError{Values: result.Values, Error: result.Error.err()})
+		}
+	}
+	return results, call.Response.error()
+}
+
+// Upsert records or replays the update of a row
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	call := newCall("Upsert", ei)
+	call.Request.Values = values
+	if err := c.do(call, func(r *Response) error {
+		return c.next.Upsert(ctx, ei, values)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// MultiUpsert records or replays the update of several rows
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	call := newCall("MultiUpsert", ei)
+	call.Request.MultiValues = multiValues
+	return c.multiWrite(call, func() ([]error, error) {
+		return c.next.MultiUpsert(ctx, ei, multiValues)
+	})
+}
+
+// Remove records or replays the removal of a row
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	call := newCall("Remove", ei)
+	call.Request.Values = keys
+	if err := c.do(call, func(r *Response) error {
+		return c.next.Remove(ctx, ei, keys)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// RemoveRange records or replays the removal of a range of rows
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	call := newCall("RemoveRange", ei)
+	call.Request.Conditions = columnConditions
+	if err := c.do(call, func(r *Response) error {
+		return c.next.RemoveRange(ctx, ei, columnConditions)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// MultiRemove records or replays the removal of several rows
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	call := newCall("MultiRemove", ei)
+	call.Request.MultiValues = multiKeys
+	return c.multiWrite(call, func() ([]error, error) {
+		return c.next.MultiRemove(ctx, ei, multiKeys)
+	})
+}
+
+// multiWrite records or replays a MultiUpsert or a MultiRemove
+func (c *Connector) multiWrite(call *Call, fn func() ([]error, error)) ([]error, error) {
+	var result []error
+	if err := c.do(call, func(r *Response) (err error) {
+		result, err = fn()
+		for _, e := range result {
+			r.Errors = append(r.Errors, newError(e))
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if c.next == nil {
+		for _, e := range call.Response.Errors {
+			result = append(result, e.err())
+		}
+	}
+	return result, call.Response.error()
+}
+
+// Range records or replays a page of a range query
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	call := newCall("Range", ei)
+	call.Request.Conditions = columnConditions
+	call.Request.MinimumFields = minimumFields
+	call.Request.Token = token
+	call.Request.Limit = limit
+	if err := c.do(call, func(r *Response) (err error) {
+		r.MultiValues, r.Token, err = c.next.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+		return err
+	}); err != nil {
+		return nil, "", err
+	}
+	return call.Response.MultiValues, call.Response.Token, call.Response.error()
+}
+
+// Scan records or replays a page of a scan
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	call := newCall("Scan", ei)
+	call.Request.MinimumFields = minimumFields
+	call.Request.Token = token
+	call.Request.Limit = limit
+	if err := c.do(call, func(r *Response) (err error) {
+		r.MultiValues, r.Token, err = c.next.Scan(ctx, ei, minimumFields, token, limit)
+		return err
+	}); err != nil {
+		return nil, "", err
+	}
+	return call.Response.MultiValues, call.Response.Token, call.Response.error()
+}
+
+// CheckSchema records or replays a schema check
+func (c *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (int32, error) {
+	call := newSchemaCall("CheckSchema", scope, namePrefix)
+	call.Request.EntityDefinitions = eds
+	if err := c.do(call, func(r *Response) (err error) {
+		r.Version, err = c.next.CheckSchema(ctx, scope, namePrefix, eds)
+		return err
+	}); err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return call.Response.Version, call.Response.error()
+}
+
+// CanUpsertSchema records or replays a schema compatibility check
+func (c *Connector) CanUpsertSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (int32, error) {
+	call := newSchemaCall("CanUpsertSchema", scope, namePrefix)
+	call.Request.EntityDefinitions = eds
+	if err := c.do(call, func(r *Response) (err error) {
+		r.Version, err = c.next.CanUpsertSchema(ctx, scope, namePrefix, eds)
+		return err
+	}); err != nil {
+		return dosa.InvalidVersion, err
+	}
+	return call.Response.Version, call.Response.error()
+}
+
+// UpsertSchema records or replays a schema upsert
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, eds []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	call := newSchemaCall("UpsertSchema", scope, namePrefix)
+	call.Request.EntityDefinitions = eds
+	if err := c.do(call, func(r *Response) (err error) {
+		r.Status, err = c.next.UpsertSchema(ctx, scope, namePrefix, eds)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return call.Response.Status, call.Response.error()
+}
+
+// CheckSchemaStatus records or replays a schema status check
+func (c *Connector) CheckSchemaStatus(ctx context.Context, scope, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	call := newSchemaCall("CheckSchemaStatus", scope, namePrefix)
+	call.Request.Version = version
+	if err := c.do(call, func(r *Response) (err error) {
+		r.Status, err = c.next.CheckSchemaStatus(ctx, scope, namePrefix, version)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return call.Response.Status, call.Response.error()
+}
+
+// GetEntitySchema records or replays the lookup of an entity schema
+func (c *Connector) GetEntitySchema(ctx context.Context, scope, namePrefix, entityName string, version int32) (*dosa.EntityDefinition, error) {
+	call := newSchemaCall("GetEntitySchema", scope, namePrefix)
+	call.Request.EntityName = entityName
+	call.Request.Version = version
+	if err := c.do(call, func(r *Response) (err error) {
+		r.EntityDefinition, err = c.next.GetEntitySchema(ctx, scope, namePrefix, entityName, version)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return call.Response.EntityDefinition, call.Response.error()
+}
+
+// CreateScope records or replays the creation of a scope
+func (c *Connector) CreateScope(ctx context.Context, md *dosa.ScopeMetadata) error {
+	call := newSchemaCall("CreateScope", md.Name, "")
+	call.Request.ScopeMetadata = md
+	if err := c.do(call, func(r *Response) error {
+		return c.next.CreateScope(ctx, md)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// TruncateScope records or replays the truncation of a scope
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	call := newSchemaCall("TruncateScope", scope, "")
+	if err := c.do(call, func(r *Response) error {
+		return c.next.TruncateScope(ctx, scope)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// DropScope records or replays the removal of a scope
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	call := newSchemaCall("DropScope", scope, "")
+	if err := c.do(call, func(r *Response) error {
+		return c.next.DropScope(ctx, scope)
+	}); err != nil {
+		return err
+	}
+	return call.Response.error()
+}
+
+// ScopeExists records or replays the check of a scope
+func (c *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
+	call := newSchemaCall("ScopeExists", scope, "")
+	if err := c.do(call, func(r *Response) (err error) {
+		r.Exists, err = c.next.ScopeExists(ctx, scope)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	return call.Response.Exists, call.Response.error()
+}
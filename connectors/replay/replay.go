@@ -0,0 +1,413 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package replay provides a connector that records the calls to another connector to a golden
+// file, and a connector that replays them. Record realistic traffic once, e.g. against a gateway:
+//
+//	recorder, err := replay.NewFileRecorder(yarpcConnector, "testdata/orders.golden")
+//
+// then run deterministic tests offline with the recorded responses:
+//
+//	replayer, err := replay.NewFileReplayer("testdata/orders.golden")
+//	...
+//	assert.NoError(t, replayer.Verify())
+//
+// A golden file holds one call per line: the operation, the entity it targets, its arguments,
+// and its results and error. Field values keep their types, see encoding.NewTypedJSONEncoder.
+//
+// The replayer serves a call with the first recorded call that has the same operation, entity
+// and arguments and that was not replayed yet, so identical calls are served in the order they
+// were recorded, while calls with different arguments may come in any order. A call that was not
+// recorded fails with an ErrUnexpectedCall.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/encoding"
+)
+
+// Call is a recorded call
+type Call struct {
+	Operation  string
+	Scope      string
+	NamePrefix string
+	// Entity is empty for schema and scope operations
+	Entity   string
+	Request  *Request
+	Response *Response
+}
+
+// Request holds the arguments of a call. Only the arguments of the operation are set.
+type Request struct {
+	// Values holds the values or keys of a single row
+	Values map[string]dosa.FieldValue
+	// MultiValues holds the values or keys of a Multi* operation
+	MultiValues       []map[string]dosa.FieldValue
+	Conditions        map[string][]*dosa.Condition
+	MinimumFields     []string
+	Token             string
+	Limit             int
+	EntityDefinitions []*dosa.EntityDefinition
+	EntityName        string
+	Version           int32
+	ScopeMetadata     *dosa.ScopeMetadata
+}
+
+// Response holds the results of a call. Only the results of the operation are set.
+type Response struct {
+	Values      map[string]dosa.FieldValue
+	MultiValues []map[string]dosa.FieldValue
+	// Results holds the results of MultiRead
+	Results []*Result
+	// Errors holds the results of MultiUpsert and MultiRemove
+	Errors           []*Error
+	Token            string
+	Version          int32
+	Status           *dosa.SchemaStatus
+	EntityDefinition *dosa.EntityDefinition
+	Exists           bool
+	Error            *Error
+
+	// err is the error returned by the recorded connector
+	err error
+}
+
+// Result is the result of reading a single row in MultiRead
+type Result struct {
+	Values map[string]dosa.FieldValue
+	Error  *Error
+}
+
+// Kinds of recorded errors, so that replayed errors are recognized by dosa.ErrorIsNotFound and friends
+const (
+	KindNotFound       = "NotFound"
+	KindAlreadyExists  = "AlreadyExists"
+	KindRateLimited    = "RateLimited"
+	KindNotInitialized = "NotInitialized"
+	KindDeadline       = "DeadlineExceeded"
+	KindCanceled       = "Canceled"
+)
+
+// Error is a recorded error
+type Error struct {
+	// Kind is one of the Kind constants, or empty for any other error
+	Kind    string
+	Message string
+}
+
+// newError records an error, nil when there is no error
+func newError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Message: err.Error()}
+	switch cause := errors.Cause(err); {
+	case dosa.ErrorIsNotFound(err):
+		e.Kind = KindNotFound
+	case dosa.ErrorIsAlreadyExists(err):
+		e.Kind = KindAlreadyExists
+	case dosa.ErrorIsRateLimited(err):
+		e.Kind = KindRateLimited
+	case dosa.ErrorIsNotInitialized(err):
+		e.Kind = KindNotInitialized
+	case cause == context.DeadlineExceeded:
+		e.Kind = KindDeadline
+	case cause == context.Canceled:
+		e.Kind = KindCanceled
+	}
+	return e
+}
+
+// err returns an error with the recorded message and kind
+func (e *Error) err() error {
+	if e == nil {
+		return nil
+	}
+	var cause error
+	switch e.Kind {
+	case KindNotFound:
+		cause = &dosa.ErrNotFound{}
+	case KindAlreadyExists:
+		cause = &dosa.ErrAlreadyExists{}
+	case KindRateLimited:
+		cause = &dosa.ErrRateLimited{}
+	case KindNotInitialized:
+		cause = &dosa.ErrNotInitialized{}
+	case KindDeadline:
+		cause = context.DeadlineExceeded
+	case KindCanceled:
+		cause = context.Canceled
+	default:
+		return errors.New(e.Message)
+	}
+	if cause.Error() == e.Message {
+		return cause
+	}
+	return &replayedError{message: e.Message, cause: cause}
+}
+
+// replayedError is a wrapped error with its recorded message
+type replayedError struct {
+	message string
+	cause   error
+}
+
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+// Cause returns the error it wraps, see errors.Cause
+func (e *replayedError) Cause() error {
+	return e.cause
+}
+
+// error returns the error of the call
+func (r *Response) error() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Error.err()
+}
+
+// ErrUnexpectedCall is returned by the replayer for a call that was not recorded
+type ErrUnexpectedCall struct {
+	Call string
+}
+
+// Error returns the unexpected call
+func (e *ErrUnexpectedCall) Error() string {
+	return "unexpected call " + e.Call
+}
+
+// ErrorIsUnexpectedCall checks if the error is caused by an ErrUnexpectedCall
+func ErrorIsUnexpectedCall(err error) bool {
+	_, ok := errors.Cause(err).(*ErrUnexpectedCall)
+	return ok
+}
+
+// Connector records the calls to another connector, or replays recorded calls
+type Connector struct {
+	next    dosa.Connector
+	encoder encoding.Encoder
+
+	mu sync.Mutex
+	// when recording
+	writer io.Writer
+	closer io.Closer
+	err    error
+	// when replaying
+	calls    []*Call
+	replayed []bool
+	byKey    map[string][]int
+}
+
+// NewRecorder creates a connector that passes every call to next and writes it to w, one call per line
+func NewRecorder(next dosa.Connector, w io.Writer) *Connector {
+	return &Connector{
+		next:    next,
+		encoder: encoding.NewTypedJSONEncoder(),
+		writer:  w,
+	}
+}
+
+// NewFileRecorder creates a recorder that writes to a golden file, replacing its content.
+// The file is closed by Shutdown.
+func NewFileRecorder(next dosa.Connector, path string) (*Connector, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create golden file")
+	}
+	c := NewRecorder(next, f)
+	c.closer = f
+	return c, nil
+}
+
+// NewReplayer creates a connector that serves the calls recorded in r
+func NewReplayer(r io.Reader) (*Connector, error) {
+	c := &Connector{
+		encoder: encoding.NewTypedJSONEncoder(),
+		byKey:   map[string][]int{},
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		call := &Call{}
+		if err := c.encoder.Decode(data, call); err != nil {
+			return nil, errors.Wrapf(err, "invalid call on line %d", line)
+		}
+		if call.Request == nil || call.Response == nil {
+			return nil, fmt.Errorf("invalid call on line %d: missing request or response", line)
+		}
+		key, err := c.key(call)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid call on line %d", line)
+		}
+		c.byKey[key] = append(c.byKey[key], len(c.calls))
+		c.calls = append(c.calls, call)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot read recorded calls")
+	}
+	c.replayed = make([]bool, len(c.calls))
+	return c, nil
+}
+
+// NewFileReplayer creates a replayer serving the calls recorded in a golden file
+func NewFileReplayer(path string) (*Connector, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open golden file")
+	}
+	defer f.Close()
+	return NewReplayer(f)
+}
+
+// Verify returns an error listing the recorded calls that were not replayed
+func (c *Connector) Verify() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var missing []string
+	for i, call := range c.calls {
+		if !c.replayed[i] {
+			missing = append(missing, describe(call))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d recorded calls were not replayed: %s", len(missing), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Shutdown shuts down the recorded connector and closes the golden file. It returns the first
+// error writing a call, if any.
+func (c *Connector) Shutdown() error {
+	if c.next == nil {
+		return nil
+	}
+	err := c.next.Shutdown()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closer != nil {
+		if closeErr := c.closer.Close(); closeErr != nil && c.err == nil {
+			c.err = closeErr
+		}
+		c.closer = nil
+	}
+	if c.err != nil {
+		return errors.Wrap(c.err, "cannot record calls")
+	}
+	return err
+}
+
+// newCall creates a call to an entity
+func newCall(operation string, ei *dosa.EntityInfo) *Call {
+	return &Call{
+		Operation:  operation,
+		Scope:      ei.Ref.Scope,
+		NamePrefix: ei.Ref.NamePrefix,
+		Entity:     ei.Ref.EntityName,
+		Request:    &Request{},
+	}
+}
+
+// newSchemaCall creates a call to a scope or a schema
+func newSchemaCall(operation, scope, namePrefix string) *Call {
+	return &Call{
+		Operation:  operation,
+		Scope:      scope,
+		NamePrefix: namePrefix,
+		Request:    &Request{},
+	}
+}
+
+// do records the call, passing it to the recorded connector with fn, or sets its recorded response
+func (c *Connector) do(call *Call, fn func(r *Response) error) error {
+	if c.next == nil {
+		return c.replay(call)
+	}
+	call.Response = &Response{}
+	call.Response.err = fn(call.Response)
+	call.Response.Error = newError(call.Response.err)
+	c.record(call)
+	return nil
+}
+
+func (c *Connector) record(call *Call) {
+	data, err := c.encoder.Encode(call)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		_, err = c.writer.Write(append(data, '\n'))
+	}
+	if err != nil && c.err == nil {
+		c.err = errors.Wrapf(err, "cannot record %s", describe(call))
+	}
+}
+
+func (c *Connector) replay(call *Call) error {
+	key, err := c.key(call)
+	if err != nil {
+		return errors.Wrapf(err, "cannot replay %s", describe(call))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range c.byKey[key] {
+		if !c.replayed[i] {
+			c.replayed[i] = true
+			call.Response = c.calls[i].Response
+			return nil
+		}
+	}
+	return &ErrUnexpectedCall{Call: describe(call) + " " + key}
+}
+
+// key identifies the operation, the entity and the arguments of a call
+func (c *Connector) key(call *Call) (string, error) {
+	data, err := c.encoder.Encode(&Call{
+		Operation:  call.Operation,
+		Scope:      call.Scope,
+		NamePrefix: call.NamePrefix,
+		Entity:     call.Entity,
+		Request:    call.Request,
+	})
+	return string(data), err
+}
+
+func describe(call *Call) string {
+	target := call.Scope + "." + call.NamePrefix
+	if call.Entity != "" {
+		target += "." + call.Entity
+	}
+	return fmt.Sprintf("%s(%s)", call.Operation, target)
+}
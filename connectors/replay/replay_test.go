@@ -0,0 +1,292 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+)
+
+var (
+	testInfo = &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "testing", NamePrefix: "example", EntityName: "events"},
+		Def: &dosa.EntityDefinition{
+			Name: "events",
+			Columns: []*dosa.ColumnDefinition{
+				{Name: "id", Type: dosa.Int64},
+				{Name: "ts", Type: dosa.Timestamp},
+				{Name: "n", Type: dosa.Int32},
+				{Name: "u", Type: dosa.TUUID},
+				{Name: "b", Type: dosa.Blob},
+				{Name: "d", Type: dosa.Double},
+				{Name: "ok", Type: dosa.Bool},
+			},
+			Key: &dosa.PrimaryKey{
+				PartitionKeys:  []string{"id"},
+				ClusteringKeys: []*dosa.ClusteringKey{{Name: "ts", Descending: true}},
+			},
+		},
+	}
+	ctx = context.Background()
+)
+
+func row(id int64, sec int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"id": id,
+		"ts": time.Unix(sec, 5).UTC(),
+		"n":  int32(sec),
+		"u":  dosa.UUID("b8a7e4d4-1b4e-11e8-accf-0ed5f89f718b"),
+		"b":  []byte{1, 2, byte(sec)},
+		"d":  float64(sec) / 3,
+		"ok": sec%2 == 0,
+	}
+}
+
+func key(id int64, sec int64) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{"id": id, "ts": time.Unix(sec, 5).UTC()}
+}
+
+// exercise makes calls to c and returns what they returned
+func exercise(t *testing.T, c dosa.Connector) []interface{} {
+	var results []interface{}
+	add := func(values ...interface{}) {
+		for _, v := range values {
+			if err, ok := v.(error); ok {
+				v = describeError(err)
+			}
+			results = append(results, v)
+		}
+	}
+
+	add(c.CheckSchema(ctx, "testing", "example", []*dosa.EntityDefinition{testInfo.Def}))
+	add(c.Read(ctx, testInfo, key(1, 10), nil))
+	add(c.Upsert(ctx, testInfo, row(1, 10)))
+	add(c.CreateIfNotExists(ctx, testInfo, row(1, 10)))
+	add(c.Read(ctx, testInfo, key(1, 10), nil))
+	add(c.Read(ctx, testInfo, key(1, 10), []string{"n"}))
+	errs, err := c.MultiUpsert(ctx, testInfo, []map[string]dosa.FieldValue{row(1, 11), row(1, 12), row(2, 10)})
+	add(errs, err)
+	rows, err2 := c.MultiRead(ctx, testInfo, []map[string]dosa.FieldValue{key(1, 11), key(3, 10)}, nil)
+	for _, r := range rows {
+		add(r.Values, r.Error)
+	}
+	add(err2)
+	conditions := map[string][]*dosa.Condition{
+		"id": {{Op: dosa.Eq, Value: int64(1)}},
+		"ts": {{Op: dosa.Gt, Value: time.Unix(10, 0).UTC()}},
+	}
+	add(c.Range(ctx, testInfo, conditions, nil, "", 1))
+	add(c.Scan(ctx, testInfo, []string{"id", "ts"}, "", 10))
+	add(c.Remove(ctx, testInfo, key(2, 10)))
+	add(c.RemoveRange(ctx, testInfo, conditions))
+	errs, err = c.MultiRemove(ctx, testInfo, []map[string]dosa.FieldValue{key(1, 10)})
+	add(errs, err)
+	// the same call again, after the rows changed
+	add(c.Read(ctx, testInfo, key(1, 10), nil))
+	return results
+}
+
+func describeError(err error) string {
+	switch {
+	case dosa.ErrorIsNotFound(err):
+		return "not found: " + err.Error()
+	case dosa.ErrorIsAlreadyExists(err):
+		return "already exists: " + err.Error()
+	}
+	return err.Error()
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var golden bytes.Buffer
+	recorder := NewRecorder(memory.NewConnector(), &golden)
+	recorded := exercise(t, recorder)
+	assert.NoError(t, recorder.Shutdown())
+	assert.Equal(t, 14, strings.Count(golden.String(), "\n"))
+
+	replayer, err := NewReplayer(&golden)
+	require.NoError(t, err)
+	assert.Equal(t, recorded, exercise(t, replayer))
+	assert.NoError(t, replayer.Verify())
+	assert.NoError(t, replayer.Shutdown())
+
+	// values keep their types
+	assert.Contains(t, recorded, row(1, 10))
+	assert.Contains(t, recorded, "not found: not found")
+	assert.Contains(t, recorded, "already exists: already exists")
+}
+
+func TestReplayUnexpectedCalls(t *testing.T) {
+	var golden bytes.Buffer
+	recorder := NewRecorder(memory.NewConnector(), &golden)
+	require.NoError(t, recorder.Upsert(ctx, testInfo, row(1, 10)))
+	_, err := recorder.Read(ctx, testInfo, key(1, 10), nil)
+	require.NoError(t, err)
+
+	replayer, err := NewReplayer(bytes.NewReader(golden.Bytes()))
+	require.NoError(t, err)
+	// different arguments
+	_, err = replayer.Read(ctx, testInfo, key(1, 11), nil)
+	assert.True(t, ErrorIsUnexpectedCall(err))
+	assert.Contains(t, err.Error(), "unexpected call Read(testing.example.events)")
+	// calls with different arguments may come in any order
+	_, err = replayer.Read(ctx, testInfo, key(1, 10), nil)
+	assert.NoError(t, err)
+	// every recorded call is replayed once
+	_, err = replayer.Read(ctx, testInfo, key(1, 10), nil)
+	assert.True(t, ErrorIsUnexpectedCall(err))
+	err = replayer.Verify()
+	assert.EqualError(t, err, "1 recorded calls were not replayed: Upsert(testing.example.events)")
+	assert.NoError(t, replayer.Upsert(ctx, testInfo, row(1, 10)))
+	assert.NoError(t, replayer.Verify())
+
+	_, err = replayer.ScopeExists(ctx, "testing")
+	assert.True(t, ErrorIsUnexpectedCall(err))
+}
+
+func TestScopeAndSchemaCalls(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	md := &dosa.ScopeMetadata{Name: "testing", Owner: "me", CreatedOn: time.Unix(1, 0).UTC()}
+	eds := []*dosa.EntityDefinition{testInfo.Def}
+	status := &dosa.SchemaStatus{Version: 2, Status: "COMPLETED"}
+	next := mocks.NewMockConnector(ctrl)
+	next.EXPECT().CreateScope(gomock.Any(), md).Return(nil)
+	next.EXPECT().CanUpsertSchema(gomock.Any(), "testing", "example", eds).Return(int32(2), nil)
+	next.EXPECT().UpsertSchema(gomock.Any(), "testing", "example", eds).Return(status, nil)
+	next.EXPECT().CheckSchemaStatus(gomock.Any(), "testing", "example", int32(2)).Return(status, nil)
+	next.EXPECT().GetEntitySchema(gomock.Any(), "testing", "example", "events", int32(2)).Return(testInfo.Def, nil)
+	next.EXPECT().TruncateScope(gomock.Any(), "testing").Return(errors.New("not allowed"))
+	next.EXPECT().DropScope(gomock.Any(), "testing").Return(nil)
+	next.EXPECT().ScopeExists(gomock.Any(), "testing").Return(true, nil)
+	next.EXPECT().Shutdown().Return(nil)
+
+	calls := func(c dosa.Connector) []interface{} {
+		var results []interface{}
+		add := func(values ...interface{}) {
+			for _, v := range values {
+				if err, ok := v.(error); ok {
+					v = err.Error()
+				}
+				results = append(results, v)
+			}
+		}
+		add(c.CreateScope(ctx, md))
+		add(c.CanUpsertSchema(ctx, "testing", "example", eds))
+		add(c.UpsertSchema(ctx, "testing", "example", eds))
+		add(c.CheckSchemaStatus(ctx, "testing", "example", 2))
+		add(c.GetEntitySchema(ctx, "testing", "example", "events", 2))
+		add(c.TruncateScope(ctx, "testing"))
+		add(c.DropScope(ctx, "testing"))
+		add(c.ScopeExists(ctx, "testing"))
+		return results
+	}
+	var golden bytes.Buffer
+	recorder := NewRecorder(next, &golden)
+	recorded := calls(recorder)
+	assert.NoError(t, recorder.Shutdown())
+	assert.Equal(t, []interface{}{nil, int32(2), nil, status, nil, status, nil, testInfo.Def, nil, "not allowed", nil, true, nil}, recorded)
+
+	replayer, err := NewReplayer(&golden)
+	require.NoError(t, err)
+	assert.Equal(t, recorded, calls(replayer))
+	assert.NoError(t, replayer.Verify())
+}
+
+func TestErrors(t *testing.T) {
+	errs := []error{
+		&dosa.ErrNotFound{},
+		&dosa.ErrAlreadyExists{},
+		&dosa.ErrRateLimited{},
+		&dosa.ErrNotInitialized{},
+		context.DeadlineExceeded,
+		context.Canceled,
+		errors.New("boom"),
+	}
+	for _, err := range errs {
+		replayed := newError(err).err()
+		assert.Equal(t, err.Error(), replayed.Error())
+		assert.Equal(t, reflect.TypeOf(err), reflect.TypeOf(replayed))
+	}
+
+	wrapped := newError(errors.Wrap(&dosa.ErrNotFound{}, "lookup")).err()
+	assert.EqualError(t, wrapped, "lookup: not found")
+	assert.True(t, dosa.ErrorIsNotFound(wrapped))
+	wrapped = newError(errors.Wrap(context.DeadlineExceeded, "call")).err()
+	assert.EqualError(t, wrapped, "call: context deadline exceeded")
+	assert.Nil(t, newError(nil).err())
+}
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "calls.golden")
+
+	recorder, err := NewFileRecorder(memory.NewConnector(), path)
+	require.NoError(t, err)
+	assert.NoError(t, recorder.Upsert(ctx, testInfo, row(1, 10)))
+	assert.NoError(t, recorder.Shutdown())
+
+	replayer, err := NewFileReplayer(path)
+	require.NoError(t, err)
+	assert.NoError(t, replayer.Upsert(ctx, testInfo, row(1, 10)))
+	assert.NoError(t, replayer.Verify())
+
+	_, err = NewFileReplayer(filepath.Join(dir, "missing.golden"))
+	assert.Contains(t, err.Error(), "cannot open golden file")
+	_, err = NewFileRecorder(memory.NewConnector(), filepath.Join(dir, "missing", "calls.golden"))
+	assert.Contains(t, err.Error(), "cannot create golden file")
+}
+
+func TestInvalidGoldenFile(t *testing.T) {
+	_, err := NewReplayer(strings.NewReader("\n{"))
+	assert.Contains(t, err.Error(), "invalid call on line 2")
+	_, err = NewReplayer(strings.NewReader(`{"Operation": "Read"}`))
+	assert.EqualError(t, err, "invalid call on line 1: missing request or response")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRecordingErrors(t *testing.T) {
+	recorder := NewRecorder(memory.NewConnector(), failingWriter{})
+	// the call itself succeeds
+	assert.NoError(t, recorder.Upsert(ctx, testInfo, row(1, 10)))
+	assert.EqualError(t, recorder.Shutdown(), "cannot record calls: cannot record Upsert(testing.example.events): disk full")
+}
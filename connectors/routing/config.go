@@ -26,6 +26,7 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
 )
 
 // defaultName is an alias for the glob "*" (regexp .*)
@@ -64,6 +65,28 @@ const defaultName = "default"
 // The string "default" is a synonym for "*".
 // Rules are tried in order. Literal strings (no "*") sort before patterns, i.e. "footer" < "foo*"
 //
+// Instead of an engine-name, a pattern can be assigned a rule, or a list of rules tried in the
+// order they are listed. A rule can apply to a single entity or entities with a name prefix, and
+// to only reads or writes; when it doesn't match an operation, the next rules are tried. A rule can
+// also split traffic between engines, by a stable hash of the partition key of the rows:
+//
+// routers:
+// - production:
+//     serviceA:
+//     - entity: orders
+//       operations: write
+//       split:
+//         cassandra: 90
+//         schemaless: 10
+//     - entity: order_*
+//       connector: schemaless
+//     - connector: cassandra
+//     *: dosa
+//
+// Scans go through each engine of a split in turn, and the other operations that are not about one
+// partition, e.g. schema operations, go to the engine with the largest share. Schema and scope
+// operations are only routed by rules without an entity or operations restriction.
+//
 type Config struct {
 	Routers routers `yaml:"routers"`
 }
@@ -86,7 +109,7 @@ func (r routers) Less(i, j int) bool {
 	return r[i].canonScope < r[j].canonScope
 }
 
-// UnmarshalYAML unmarshals the config into gocql cluster config
+// UnmarshalYAML unmarshals the config into a list of routing rules
 func (r *routers) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	routers := make(routers, 0)
 	scopes := make([]map[string]interface{}, 0)
@@ -100,23 +123,22 @@ func (r *routers) UnmarshalYAML(unmarshal func(interface{}) error) error {
 				return fmt.Errorf("failed to parse the config: %v", namePrefixes)
 			}
 
-			for namePrefix, connector := range namePrefixesMap {
+			for namePrefix, destination := range namePrefixesMap {
 				namePrefixStr := namePrefix.(string)
-				connectorName, ok := connector.(string)
-				if !ok {
-					return fmt.Errorf("failed to parse the config: %v", namePrefixesMap)
-				}
-				router, err := newRule(scope, namePrefixStr, connectorName)
+				rules, err := parseRules(scope, namePrefixStr, destination)
 				if err != nil {
 					return errors.Wrap(err, "failed to parse routing config")
 				}
-				routers = append(routers, router)
+				routers = append(routers, rules...)
 			}
 		}
 	}
-	sort.Sort(routers)
+	if len(routers) == 0 {
+		return errors.New("no rules defined in the 'routers' config")
+	}
+	sort.Stable(routers)
 	lastRule := routers[len(routers)-1]
-	if lastRule.Scope() != "*" || lastRule.NamePrefix() != "*" {
+	if lastRule.Scope() != "*" || lastRule.NamePrefix() != "*" || lastRule.isConditional() {
 		return errors.New("no default rule defined in the 'routers' config")
 	}
 
@@ -124,14 +146,52 @@ func (r *routers) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// parseRules parses the destination of a pattern: an engine-name, a rule, or a list of rules.
+func parseRules(scope, namePrefix string, destination interface{}) ([]*rule, error) {
+	switch d := destination.(type) {
+	case string:
+		r, err := newRule(scope, namePrefix, d)
+		if err != nil {
+			return nil, err
+		}
+		return []*rule{r}, nil
+	case map[interface{}]interface{}:
+		r, err := parseRuleSpec(scope, namePrefix, d)
+		if err != nil {
+			return nil, err
+		}
+		return []*rule{r}, nil
+	case []interface{}:
+		rules := make([]*rule, 0, len(d))
+		for _, spec := range d {
+			r, err := parseRuleSpec(scope, namePrefix, spec)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, r)
+		}
+		return rules, nil
+	}
+	return nil, fmt.Errorf("invalid destination for %s.%s: %v", scope, namePrefix, destination)
+}
+
+func parseRuleSpec(scope, namePrefix string, spec interface{}) (*rule, error) {
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var rs ruleSpec
+	if err := yaml.UnmarshalStrict(data, &rs); err != nil {
+		return nil, errors.Wrapf(err, "invalid rule for %s.%s", scope, namePrefix)
+	}
+	return newRuleFromSpec(scope, namePrefix, rs)
+}
+
 // getEngineName returns the name of the engine to use for a given (scope, name-prefix). The "Routers" list
 // MUST be sorted in priority order.
 func (c *Config) getEngineName(scope, namePrefix string) string {
-	// At some point we should replace this sequential search with something like a trie....
-	for _, rule := range c.Routers {
-		if rule.canHandle(scope, namePrefix) {
-			return rule.Destination()
-		}
+	if rule := c.findRule(scope, namePrefix); rule != nil {
+		return rule.Destination()
 	}
 
 	// The last rule in the list is the default rule, which always exists; we should never
@@ -139,6 +199,29 @@ func (c *Config) getEngineName(scope, namePrefix string) string {
 	return "an unknown error has occurred"
 }
 
+// findRule returns the first unconditional rule handling the given (scope, name-prefix), or nil if
+// there is none.
+func (c *Config) findRule(scope, namePrefix string) *rule {
+	// At some point we should replace this sequential search with something like a trie....
+	for _, rule := range c.Routers {
+		if !rule.isConditional() && rule.canHandle(scope, namePrefix) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// findEntityRule returns the first rule handling the given operation on an entity in (scope,
+// name-prefix), or nil if there is none.
+func (c *Config) findEntityRule(scope, namePrefix, entity string, op operation) *rule {
+	for _, rule := range c.Routers {
+		if rule.matches(scope, namePrefix, entity, op) {
+			return rule
+		}
+	}
+	return nil
+}
+
 func (r *routers) String() string {
 	s := []string{}
 	for _, rule := range *r {
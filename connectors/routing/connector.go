@@ -23,43 +23,94 @@ package routing
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/metrics"
 )
 
-// The routing connector maps a (scope, namePrefix) pair into a storage engine connector.
+// The routing connector maps a (scope, namePrefix) pair into a storage engine connector. Operations
+// on entities are also routed by entity name, by kind (read or write) and by partition key.
 
 // Connector is a routing connector.
 type Connector struct {
-	config     Config
+	// config holds the *Config in use, which Reload swaps atomically
+	config     atomic.Value
 	connectors map[string]dosa.Connector
+	stats      metrics.Scope
 }
 
 // NewConnector initializes the Connector
 // connectorMap has a key of connectorName, and the value is a dosa.connector instance
-func NewConnector(cfg Config, connectorMap map[string]dosa.Connector) *Connector {
-	return &Connector{
+func NewConnector(cfg Config, connectorMap map[string]dosa.Connector, opts ...Options) *Connector {
+	rc := &Connector{
 		connectors: connectorMap,
-		config:     cfg,
+		stats:      metrics.CheckIfNilStats(nil),
 	}
+	rc.config.Store(&cfg)
+	for _, o := range opts {
+		o(rc)
+	}
+	return rc
 }
 
 func (rc *Connector) String() string {
-	return fmt.Sprintf("[Routing %s]", rc.config.String())
+	return fmt.Sprintf("[Routing %s]", rc.Config().String())
+}
+
+// Config returns the routing config currently in use.
+func (rc *Connector) Config() *Config {
+	return rc.config.Load().(*Config)
 }
 
 // Resolve returns the destination engine for the given scope and name-prefix.
 func (rc *Connector) Resolve(scope, namePrefix string) string {
-	return rc.config.getEngineName(scope, namePrefix)
+	return rc.Config().getEngineName(scope, namePrefix)
 }
 
-// get connector by scope and namePrefix
-func (rc *Connector) getConnector(scope, namePrefix string) (dosa.Connector, error) {
-	connName := rc.Resolve(scope, namePrefix)
-	c, ok := rc.connectors[connName]
+// get connector by scope and namePrefix, counting the request against the rule serving it
+func (rc *Connector) getConnector(method, scope, namePrefix string) (dosa.Connector, error) {
+	r := rc.Config().findRule(scope, namePrefix)
+	if r == nil {
+		return nil, fmt.Errorf("no routing rule for scope %q and name prefix %q", scope, namePrefix)
+	}
+	return rc.connectorFor(method, r, r.Destination())
+}
+
+// get connector for an operation on the entity row with the given partition key values; without
+// them, e.g. for scans, the primary destination of the rule serving the operation is used
+func (rc *Connector) getEntityConnector(method string, op operation, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) (dosa.Connector, error) {
+	r, err := rc.findEntityRule(ei, op)
+	if err != nil {
+		return nil, err
+	}
+	return rc.connectorFor(method, r, r.destinationOf(ei, values))
+}
+
+func (rc *Connector) findEntityRule(ei *dosa.EntityInfo, op operation) (*rule, error) {
+	var entity string
+	if ei.Def != nil {
+		entity = ei.Def.Name
+	}
+	r := rc.Config().findEntityRule(ei.Ref.Scope, ei.Ref.NamePrefix, entity, op)
+	if r == nil {
+		return nil, fmt.Errorf("no routing rule for %s of entity %q with scope %q and name prefix %q", op, entity, ei.Ref.Scope, ei.Ref.NamePrefix)
+	}
+	return r, nil
+}
+
+// connectorFor counts a request against the rule serving it and returns the destination connector
+func (rc *Connector) connectorFor(method string, r *rule, destination string) (dosa.Connector, error) {
+	rc.stats.SubScope("routing").Tagged(map[string]string{
+		"method":      method,
+		"rule":        r.name(),
+		"destination": destination,
+	}).Counter("requests").Inc(1)
+
+	c, ok := rc.connectors[destination]
 	if !ok {
-		return nil, fmt.Errorf("can't find %q connector", connName)
+		return nil, fmt.Errorf("can't find %q connector", destination)
 	}
 
 	return c, nil
@@ -67,7 +118,7 @@ func (rc *Connector) getConnector(scope, namePrefix string) (dosa.Connector, err
 
 // CreateIfNotExists selects corresponding connector
 func (rc *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	connector, err := rc.getEntityConnector("CreateIfNotExists", writeOperation, ei, values)
 	if err != nil {
 		return err
 	}
@@ -76,43 +127,60 @@ func (rc *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo,
 
 // Read selects corresponding connector
 func (rc *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	connector, err := rc.getEntityConnector("Read", readOperation, ei, values)
 	if err != nil {
 		return nil, err
 	}
 	return connector.Read(ctx, ei, values, minimumFields)
 }
 
-// MultiRead selects corresponding connector
+// MultiRead selects corresponding connectors, reading each row from the connector serving it
 func (rc *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	batches, err := rc.splitBatch("MultiRead", readOperation, ei, values)
 	if err != nil {
 		return nil, err
 	}
-	return connector.MultiRead(ctx, ei, values, minimumFields)
+	if len(batches) == 1 {
+		return batches[0].connector.MultiRead(ctx, ei, values, minimumFields)
+	}
+	results := make([]*dosa.FieldValuesOrError, len(values))
+	for _, b := range batches {
+		res, err := b.connector.MultiRead(ctx, ei, b.values, minimumFields)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range b.indexes {
+			if j < len(res) {
+				results[i] = res[j]
+			}
+		}
+	}
+	return results, nil
 }
 
 // Upsert selects corresponding connector
 func (rc *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	connector, err := rc.getEntityConnector("Upsert", writeOperation, ei, values)
 	if err != nil {
 		return err
 	}
 	return connector.Upsert(ctx, ei, values)
 }
 
-// MultiUpsert selects corresponding connector
+// MultiUpsert selects corresponding connectors, writing each row to the connector serving it
 func (rc *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]error, error) {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	batches, err := rc.splitBatch("MultiUpsert", writeOperation, ei, values)
 	if err != nil {
 		return nil, err
 	}
-	return connector.MultiUpsert(ctx, ei, values)
+	return mergeErrors(batches, len(values), func(b *batch) ([]error, error) {
+		return b.connector.MultiUpsert(ctx, ei, b.values)
+	})
 }
 
 // Remove selects corresponding connector
 func (rc *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	connector, err := rc.getEntityConnector("Remove", writeOperation, ei, values)
 	if err != nil {
 		// here returns err because connector is not found
 		return err
@@ -123,34 +191,44 @@ func (rc *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, values map
 
 // RemoveRange selects corresponding connector
 func (rc *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	connector, err := rc.getEntityConnector("RemoveRange", writeOperation, ei, partitionValues(ei, columnConditions))
 	if err != nil {
 		return err
 	}
 	return connector.RemoveRange(ctx, ei, columnConditions)
 }
 
-// MultiRemove selects corresponding connector
+// MultiRemove selects corresponding connectors, removing each row from the connector serving it
 func (rc *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	batches, err := rc.splitBatch("MultiRemove", writeOperation, ei, multiValues)
 	if err != nil {
 		return nil, err
 	}
-	return connector.MultiRemove(ctx, ei, multiValues)
+	return mergeErrors(batches, len(multiValues), func(b *batch) ([]error, error) {
+		return b.connector.MultiRemove(ctx, ei, b.values)
+	})
 }
 
 // Range selects corresponding connector
 func (rc *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	connector, err := rc.getEntityConnector("Range", readOperation, ei, partitionValues(ei, columnConditions))
 	if err != nil {
 		return nil, "", err
 	}
 	return connector.Range(ctx, ei, columnConditions, minimumFields, token, limit)
 }
 
-// Scan selects corresponding connector
+// Scan selects corresponding connector. The scan of an entity split between several connectors
+// goes through each of them in turn, see scanSplit.
 func (rc *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
-	connector, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	r, err := rc.findEntityRule(ei, readOperation)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(r.split) != 0 {
+		return rc.scanSplit(ctx, r, ei, minimumFields, token, limit)
+	}
+	connector, err := rc.connectorFor("Scan", r, r.Destination())
 	if err != nil {
 		return nil, "", err
 	}
@@ -159,7 +237,7 @@ func (rc *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumField
 
 // CheckSchema calls selected connector
 func (rc *Connector) CheckSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
-	connector, err := rc.getConnector(scope, namePrefix)
+	connector, err := rc.getConnector("CheckSchema", scope, namePrefix)
 	if err != nil {
 		return dosa.InvalidVersion, err
 	}
@@ -168,7 +246,7 @@ func (rc *Connector) CheckSchema(ctx context.Context, scope, namePrefix string,
 
 // UpsertSchema calls selected connector
 func (rc *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
-	connector, err := rc.getConnector(scope, namePrefix)
+	connector, err := rc.getConnector("UpsertSchema", scope, namePrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -177,7 +255,7 @@ func (rc *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string,
 
 // CanUpsertSchema calls selected connector
 func (rc *Connector) CanUpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (int32, error) {
-	connector, err := rc.getConnector(scope, namePrefix)
+	connector, err := rc.getConnector("CanUpsertSchema", scope, namePrefix)
 	if err != nil {
 		return dosa.InvalidVersion, err
 	}
@@ -186,7 +264,7 @@ func (rc *Connector) CanUpsertSchema(ctx context.Context, scope, namePrefix stri
 
 // CheckSchemaStatus calls selected connector
 func (rc *Connector) CheckSchemaStatus(ctx context.Context, scope string, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
-	connector, err := rc.getConnector(scope, namePrefix)
+	connector, err := rc.getConnector("CheckSchemaStatus", scope, namePrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -195,7 +273,7 @@ func (rc *Connector) CheckSchemaStatus(ctx context.Context, scope string, namePr
 
 // GetEntitySchema calls the selected connector
 func (rc *Connector) GetEntitySchema(ctx context.Context, scope, namePrefix, entityName string, version int32) (*dosa.EntityDefinition, error) {
-	connector, err := rc.getConnector(scope, namePrefix)
+	connector, err := rc.getConnector("GetEntitySchema", scope, namePrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +283,7 @@ func (rc *Connector) GetEntitySchema(ctx context.Context, scope, namePrefix, ent
 // CreateScope calls selected connector
 func (rc *Connector) CreateScope(ctx context.Context, md *dosa.ScopeMetadata) error {
 	// will fall to default connector
-	connector, err := rc.getConnector(md.Name, "")
+	connector, err := rc.getConnector("CreateScope", md.Name, "")
 	if err != nil {
 		return err
 	}
@@ -215,7 +293,7 @@ func (rc *Connector) CreateScope(ctx context.Context, md *dosa.ScopeMetadata) er
 // TruncateScope calls selected connector
 func (rc *Connector) TruncateScope(ctx context.Context, scope string) error {
 	// will fall to default connector
-	connector, err := rc.getConnector(scope, "")
+	connector, err := rc.getConnector("TruncateScope", scope, "")
 	if err != nil {
 		return err
 	}
@@ -225,7 +303,7 @@ func (rc *Connector) TruncateScope(ctx context.Context, scope string) error {
 // DropScope calls selected connector
 func (rc *Connector) DropScope(ctx context.Context, scope string) error {
 	// will fall to default connector
-	connector, err := rc.getConnector(scope, "")
+	connector, err := rc.getConnector("DropScope", scope, "")
 	if err != nil {
 		return err
 	}
@@ -235,7 +313,7 @@ func (rc *Connector) DropScope(ctx context.Context, scope string) error {
 // ScopeExists calls selected connector
 func (rc *Connector) ScopeExists(ctx context.Context, scope string) (bool, error) {
 	// will fall to default connector
-	connector, err := rc.getConnector(scope, "")
+	connector, err := rc.getConnector("ScopeExists", scope, "")
 	if err != nil {
 		return false, err
 	}
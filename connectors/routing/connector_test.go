@@ -29,6 +29,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/conformance"
 	"github.com/uber-go/dosa/connectors/devnull"
 	"github.com/uber-go/dosa/connectors/memory"
 	"github.com/uber-go/dosa/connectors/random"
@@ -184,18 +185,18 @@ func TestGetConnector(t *testing.T) {
 	ei := &dosa.EntityInfo{
 		Ref: &dosa.SchemaRef{Scope: "ebook", NamePrefix: "apple.v1"},
 	}
-	conn, err := rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	conn, err := rc.getConnector("Read", ei.Ref.Scope, ei.Ref.NamePrefix)
 	assert.Nil(t, err)
 	assert.NotNil(t, conn)
 
 	// exact match
 	ei.Ref.NamePrefix = "ebook_store"
-	conn, err = rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	conn, err = rc.getConnector("Read", ei.Ref.Scope, ei.Ref.NamePrefix)
 	assert.Nil(t, err)
 	assert.NotNil(t, conn)
 
 	// match "*"
-	conn, err = rc.getConnector(ei.Ref.Scope, "*")
+	conn, err = rc.getConnector("Read", ei.Ref.Scope, "*")
 	assert.Nil(t, err)
 	assert.NotNil(t, conn)
 
@@ -203,7 +204,7 @@ func TestGetConnector(t *testing.T) {
 	ei = &dosa.EntityInfo{
 		Ref: &dosa.SchemaRef{Scope: "notexist", NamePrefix: "apple.v1"},
 	}
-	conn, err = rc.getConnector(ei.Ref.Scope, ei.Ref.NamePrefix)
+	conn, err = rc.getConnector("Read", ei.Ref.Scope, ei.Ref.NamePrefix)
 	assert.Nil(t, err)
 	assert.NotNil(t, conn)
 	assert.Equal(t, reflect.TypeOf(conn), reflect.TypeOf(memory.NewConnector()))
@@ -1039,3 +1040,9 @@ func createTestData(t *testing.T, rc *Connector, keyGenFunc func(int) string, id
 		assert.NoError(t, err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	conformance.RunSuite(t, func() dosa.Connector {
+		return NewConnector(cfg, getConnectorMap())
+	})
+}
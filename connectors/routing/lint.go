@@ -0,0 +1,198 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Match returns the position in Routers of the rule serving an operation, "read" or "write", on
+// an entity in (scope, name-prefix). When entity is empty, it returns the position of the rule
+// serving schema and scope operations instead, which ignores the operation.
+func (c *Config) Match(scope, namePrefix, entity, operations string) (int, error) {
+	var r *rule
+	if entity == "" {
+		r = c.findRule(scope, namePrefix)
+	} else {
+		op, err := parseOperation(operations)
+		if err != nil {
+			return -1, err
+		}
+		if op == anyOperation {
+			return -1, fmt.Errorf("an entity operation is either a read or a write, not %q", operations)
+		}
+		r = c.findEntityRule(scope, namePrefix, entity, op)
+	}
+	for i, candidate := range c.Routers {
+		if candidate == r {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no routing rule for scope %q and name prefix %q", scope, namePrefix)
+}
+
+// Lint reports the rules of the config that can never be used because a rule before them matches
+// everything they do, and the rules that an earlier rule for another scope or name prefix, with
+// another destination, takes precedence over for part of what they match. Rules listed for the
+// same scope and name prefix are not reported for overlapping, as their order is explicit. Rules
+// are numbered from 1 in precedence order.
+func (c *Config) Lint() []string {
+	var issues []string
+	live := make([]int, 0, len(c.Routers))
+	for j, later := range c.Routers {
+		if i := c.firstCovering(live, later.match()); i >= 0 {
+			issues = append(issues, fmt.Sprintf("rule #%d %s is unreachable: rule #%d %s matches everything it does",
+				j+1, later, i+1, c.Routers[i]))
+			continue
+		}
+		for _, i := range live {
+			earlier := c.Routers[i]
+			if earlier.Scope() == later.Scope() && earlier.NamePrefix() == later.NamePrefix() {
+				continue
+			}
+			common, ok := earlier.match().intersect(later.match())
+			if !ok || later.match().covers(earlier.match()) || sameDestinations(earlier, later) {
+				continue
+			}
+			// Only report the rule actually serving the overlap.
+			if c.firstCovering(live, common) != i {
+				continue
+			}
+			issues = append(issues, fmt.Sprintf("rule #%d %s is shadowed for %s: rule #%d %s takes precedence",
+				j+1, later, common, i+1, earlier))
+		}
+		live = append(live, j)
+	}
+	return issues
+}
+
+// firstCovering returns the first of the given rules matching everything m does, or -1.
+func (c *Config) firstCovering(positions []int, m match) int {
+	for _, i := range positions {
+		if c.Routers[i].match().covers(m) {
+			return i
+		}
+	}
+	return -1
+}
+
+// pattern is a scope, name prefix or entity name of a rule; a glob matches the names starting with name.
+type pattern struct {
+	name string
+	glob bool
+}
+
+func (p pattern) covers(q pattern) bool {
+	if p.glob {
+		return strings.HasPrefix(strings.ToLower(q.name), strings.ToLower(p.name))
+	}
+	return !q.glob && strings.EqualFold(p.name, q.name)
+}
+
+func (p pattern) String() string {
+	if p.glob {
+		return p.name + "*"
+	}
+	return p.name
+}
+
+// match describes the operations a rule matches.
+type match struct {
+	scope, namePrefix, entity pattern
+	operation                 operation
+}
+
+func (r *rule) match() match {
+	return match{
+		scope:      pattern{name: r.scope, glob: r.scopePattern != nil},
+		namePrefix: pattern{name: r.namePrefix, glob: r.prefixPattern != nil},
+		entity:     pattern{name: r.entity, glob: r.entity == "" || r.entityPattern != nil},
+		operation:  r.operation,
+	}
+}
+
+// covers says whether m matches every operation other matches.
+func (m match) covers(other match) bool {
+	return (m.operation == anyOperation || m.operation == other.operation) &&
+		m.scope.covers(other.scope) && m.namePrefix.covers(other.namePrefix) && m.entity.covers(other.entity)
+}
+
+// intersect returns what is matched by both m and other, if anything.
+func (m match) intersect(other match) (match, bool) {
+	var common match
+	var ok bool
+	if common.scope, ok = narrower(m.scope, other.scope); !ok {
+		return common, false
+	}
+	if common.namePrefix, ok = narrower(m.namePrefix, other.namePrefix); !ok {
+		return common, false
+	}
+	if common.entity, ok = narrower(m.entity, other.entity); !ok {
+		return common, false
+	}
+	switch {
+	case m.operation == anyOperation:
+		common.operation = other.operation
+	case other.operation == anyOperation || other.operation == m.operation:
+		common.operation = m.operation
+	default:
+		return common, false
+	}
+	return common, true
+}
+
+// narrower returns the pattern matching the names both patterns match, if they overlap.
+func narrower(p, q pattern) (pattern, bool) {
+	if p.covers(q) {
+		return q, true
+	}
+	if q.covers(p) {
+		return p, true
+	}
+	return p, false
+}
+
+func (m match) String() string {
+	s := m.scope.String() + "." + m.namePrefix.String()
+	if m.entity.String() != "*" {
+		s += " entity=" + m.entity.String()
+	}
+	if m.operation != anyOperation {
+		s += " operations=" + m.operation.String()
+	}
+	return s
+}
+
+func sameDestinations(r, other *rule) bool {
+	if len(r.split) != len(other.split) {
+		return false
+	}
+	if len(r.split) == 0 {
+		return r.connector == other.connector
+	}
+	for i := range r.split {
+		if r.split[i] != other.split[i] {
+			return false
+		}
+	}
+	return true
+}
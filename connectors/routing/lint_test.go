@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMatch(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(splitYAML), &cfg))
+
+	i, err := cfg.Match("production", "map", "orders", "write")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, i)
+	i, err = cfg.Match("production", "map", "orders", "reads")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, i)
+	i, err = cfg.Match("production", "map", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, i)
+	i, err = cfg.Match("development", "map", "orders", "read")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, i)
+
+	_, err = cfg.Match("production", "map", "orders", "")
+	assert.Error(t, err)
+	_, err = cfg.Match("production", "map", "orders", "delete")
+	assert.Error(t, err)
+	_, err = (&Config{}).Match("production", "map", "", "")
+	assert.Error(t, err)
+}
+
+func TestLint(t *testing.T) {
+	assert.Empty(t, cfg.Lint())
+	var splitCfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(splitYAML), &splitCfg))
+	assert.Empty(t, splitCfg.Lint())
+
+	// "ebook*" sorts after "ebook", so ebook.foo is not routed to ebook-foo.
+	var basicCfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(yamlFile), &basicCfg))
+	assert.Contains(t, basicCfg.Lint(),
+		"rule #9 {ebook*.foo -> ebook-foo} is shadowed for ebook.foo: rule #8 {ebook.* -> ebook1} takes precedence")
+
+	var cfg Config
+
+	require.NoError(t, yaml.Unmarshal([]byte(`
+routers:
+- production:
+    serviceA: cassandra
+- "*":
+    serviceA: schemaless
+    serviceB: schemaless
+- production:
+    serviceA: other
+    "*":
+    - connector: dosa
+    - entity: orders
+      connector: schemaless
+- default:
+    default: dosa
+`), &cfg))
+	assert.Equal(t, []string{
+		"rule #2 {production.serviceA -> other} is unreachable: rule #1 {production.serviceA -> cassandra} matches everything it does",
+		"rule #4 {production.* entity=orders -> schemaless} is unreachable: rule #3 {production.* -> dosa} matches everything it does",
+		"rule #6 {*.serviceB -> schemaless} is shadowed for production.serviceB: rule #3 {production.* -> dosa} takes precedence",
+	}, cfg.Lint())
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// Options configure the routing connector. They cannot fail: configs are validated by Reload.
+type Options func(*Connector)
+
+// WithStats sets the scope the connector reports its metrics to: a "requests" counter tagged
+// with the method, the rule serving it and its destination, and a "reload" counter tagged with
+// the result of each reload.
+func WithStats(scope metrics.Scope) Options {
+	return func(rc *Connector) {
+		rc.stats = metrics.CheckIfNilStats(scope)
+	}
+}
+
+// Reload validates the config and, if it is valid, switches the connector over to it. Requests
+// in flight keep the config they started with, later requests use the new one. An invalid config
+// is rejected with an error and the current one stays in use.
+func (rc *Connector) Reload(cfg Config) error {
+	err := rc.reload(cfg)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	rc.stats.SubScope("routing").Tagged(map[string]string{"result": result}).Counter("reload").Inc(1)
+	return err
+}
+
+func (rc *Connector) reload(cfg Config) error {
+	if err := rc.validate(&cfg); err != nil {
+		return errors.Wrap(err, "invalid routing config")
+	}
+	rc.config.Store(&cfg)
+	return nil
+}
+
+// validate checks that the config has a default rule and only routes to known connectors. It
+// sorts a copy of the rules when they are not sorted yet, e.g. for configs built in code.
+func (rc *Connector) validate(cfg *Config) error {
+	if len(cfg.Routers) == 0 {
+		return errors.New("no rules defined in the 'routers' config")
+	}
+	if !sort.IsSorted(cfg.Routers) {
+		sorted := make(routers, len(cfg.Routers))
+		copy(sorted, cfg.Routers)
+		sort.Stable(sorted)
+		cfg.Routers = sorted
+	}
+	lastRule := cfg.Routers[len(cfg.Routers)-1]
+	if lastRule.Scope() != "*" || lastRule.NamePrefix() != "*" || lastRule.isConditional() {
+		return errors.New("no default rule defined in the 'routers' config")
+	}
+	var unknown []string
+	for _, r := range cfg.Routers {
+		for _, destination := range r.Destinations() {
+			if _, ok := rc.connectors[destination]; !ok {
+				unknown = append(unknown, r.String())
+				break
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return errors.Errorf("rules routing to unknown connectors: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// LoadConfigFile reads a routing config from a YAML file.
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read routing config %s", path)
+	}
+	return parseConfig(path, data)
+}
+
+func parseConfig(path string, data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse routing config %s", path)
+	}
+	return cfg, nil
+}
+
+// WatchConfigFile loads the routing config from a YAML file and then checks the file every
+// interval, reloading the config whenever its content changes. Errors reading, parsing or
+// validating a changed file are passed to onError, when it is not nil, and leave the current
+// config in use. The returned function stops watching the file.
+func (rc *Connector) WatchConfigFile(path string, interval time.Duration, onError func(error)) (stop func(), err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read routing config %s", path)
+	}
+	if err := rc.reloadData(path, content); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				err = errors.Wrapf(err, "cannot read routing config %s", path)
+			} else if bytes.Equal(data, content) {
+				continue
+			} else {
+				// remember the content even when it's invalid so that it's only reported once
+				content = data
+				err = rc.reloadData(path, data)
+			}
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, nil
+}
+
+// reloadData parses the content of the config file and reloads the connector with it.
+func (rc *Connector) reloadData(path string, data []byte) error {
+	cfg, err := parseConfig(path, data)
+	if err != nil {
+		rc.stats.SubScope("routing").Tagged(map[string]string{"result": "failure"}).Counter("reload").Inc(1)
+		return err
+	}
+	return rc.Reload(*cfg)
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/devnull"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/metrics"
+)
+
+// recordingScope counts the increments of the counters by name and tags, as in
+// "routing.reload{result=success}"
+type recordingScope struct {
+	name   string
+	tags   map[string]string
+	mux    *sync.Mutex
+	counts map[string]int64
+}
+
+func newRecordingScope() *recordingScope {
+	return &recordingScope{tags: map[string]string{}, mux: &sync.Mutex{}, counts: map[string]int64{}}
+}
+
+func (s *recordingScope) Counter(name string) metrics.Counter {
+	var tags []string
+	for k, v := range s.tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return &recordingCounter{scope: s, name: s.name + "." + name + "{" + strings.Join(tags, ",") + "}"}
+}
+
+func (s *recordingScope) Tagged(tags map[string]string) metrics.Scope {
+	merged := map[string]string{}
+	for k, v := range s.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return &recordingScope{name: s.name, tags: merged, mux: s.mux, counts: s.counts}
+}
+
+func (s *recordingScope) SubScope(name string) metrics.Scope {
+	return &recordingScope{name: strings.TrimPrefix(s.name+"."+name, "."), tags: s.tags, mux: s.mux, counts: s.counts}
+}
+
+func (s *recordingScope) Timer(name string) metrics.Timer {
+	return (&metrics.NoopScope{}).Timer(name)
+}
+
+func (s *recordingScope) get(name string) int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.counts[name]
+}
+
+type recordingCounter struct {
+	scope *recordingScope
+	name  string
+}
+
+func (c *recordingCounter) Inc(delta int64) {
+	c.scope.mux.Lock()
+	defer c.scope.mux.Unlock()
+	c.scope.counts[c.name] += delta
+}
+
+func reloadConnectors() map[string]dosa.Connector {
+	return map[string]dosa.Connector{
+		"memory":  memory.NewConnector(),
+		"devnull": devnull.NewConnector(),
+	}
+}
+
+func TestReload(t *testing.T) {
+	stats := newRecordingScope()
+	rc := NewConnector(Config{Routers: routers{
+		buildRule("ebook", "*", "memory"),
+		buildRule("default", "default", "devnull"),
+	}}, reloadConnectors(), WithStats(stats))
+	assert.Equal(t, "memory", rc.Resolve("ebook", "apple"))
+
+	ei := &dosa.EntityInfo{Ref: &dosa.SchemaRef{Scope: "ebook", NamePrefix: "apple"}, Def: testInfo.Def}
+	values := map[string]dosa.FieldValue{"p1": "key"}
+	assert.NoError(t, rc.Upsert(context.TODO(), ei, values))
+	_, err := rc.Read(context.TODO(), ei, values, dosa.All())
+	assert.NoError(t, err)
+
+	err = rc.Reload(Config{Routers: routers{
+		// rules built in code don't need to be sorted
+		buildRule("default", "default", "memory"),
+		buildRule("ebook", "*", "devnull"),
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "devnull", rc.Resolve("ebook", "apple"))
+	assert.Equal(t, "[Routing [{ebook.* -> devnull},{*.* -> memory}]]", rc.String())
+	_, err = rc.Read(context.TODO(), ei, values, dosa.All())
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	assert.Equal(t, int64(1), stats.get("routing.requests{destination=memory,method=Upsert,rule=ebook.*}"))
+	assert.Equal(t, int64(1), stats.get("routing.requests{destination=memory,method=Read,rule=ebook.*}"))
+	assert.Equal(t, int64(1), stats.get("routing.requests{destination=devnull,method=Read,rule=ebook.*}"))
+	assert.Equal(t, int64(1), stats.get("routing.reload{result=success}"))
+}
+
+func TestReloadValidation(t *testing.T) {
+	stats := newRecordingScope()
+	rc := NewConnector(Config{Routers: routers{buildRule("default", "default", "memory")}}, reloadConnectors(), WithStats(stats))
+
+	err := rc.Reload(Config{})
+	assert.Contains(t, err.Error(), "no rules defined")
+	err = rc.Reload(Config{Routers: routers{buildRule("ebook", "*", "memory")}})
+	assert.Contains(t, err.Error(), "no default rule")
+	err = rc.Reload(Config{Routers: routers{
+		buildRule("ebook", "apple", "cassandra"),
+		buildRule("ebook", "*", "memory"),
+		buildRule("default", "default", "schemaless"),
+	}})
+	assert.Contains(t, err.Error(), "rules routing to unknown connectors: {ebook.apple -> cassandra}, {*.* -> schemaless}")
+
+	// the config in use is unchanged
+	assert.Equal(t, "[Routing [{*.* -> memory}]]", rc.String())
+	assert.Equal(t, int64(3), stats.get("routing.reload{result=failure}"))
+}
+
+func TestReloadConcurrently(t *testing.T) {
+	rc := NewConnector(Config{Routers: routers{buildRule("default", "default", "memory")}}, reloadConnectors())
+	configs := []Config{
+		{Routers: routers{buildRule("default", "default", "memory")}},
+		{Routers: routers{buildRule("default", "default", "devnull")}},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					assert.NoError(t, rc.Reload(configs[j%2]))
+					continue
+				}
+				assert.Contains(t, []string{"memory", "devnull"}, rc.Resolve("scope", "prefix"))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routing")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	_, err = LoadConfigFile(filepath.Join(dir, "missing.yaml"))
+	assert.Contains(t, err.Error(), "cannot read routing config")
+
+	path := filepath.Join(dir, "routing.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("routers: []"), 0644))
+	_, err = LoadConfigFile(path)
+	assert.Contains(t, err.Error(), "no rules defined")
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(yamlFile), 0644))
+	cfg, err := LoadConfigFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "cassandra2", cfg.getEngineName("production", "serviceA"))
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routing")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "routing.yaml")
+	writeConfig := func(destination string) {
+		content := fmt.Sprintf("routers:\n- ebook:\n    '*': %s\n- default:\n    default: memory\n", destination)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	rc := NewConnector(Config{Routers: routers{buildRule("default", "default", "memory")}}, reloadConnectors())
+
+	_, err = rc.WatchConfigFile(path, time.Millisecond, nil)
+	assert.Error(t, err)
+
+	writeConfig("devnull")
+	errs := make(chan error, 10)
+	stop, err := rc.WatchConfigFile(path, time.Millisecond, func(err error) { errs <- err })
+	assert.NoError(t, err)
+	defer stop()
+	assert.Equal(t, "devnull", rc.Resolve("ebook", "apple"))
+
+	writeConfig("memory")
+	assert.True(t, eventually(func() bool { return rc.Resolve("ebook", "apple") == "memory" }))
+
+	// invalid configs are reported, once, and leave the current config in use
+	writeConfig("cassandra")
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "unknown connectors")
+	case <-time.After(time.Second):
+		assert.Fail(t, "the invalid config was not reported")
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, errs, 0)
+	assert.Equal(t, "memory", rc.Resolve("ebook", "apple"))
+
+	writeConfig("devnull")
+	assert.True(t, eventually(func() bool { return rc.Resolve("ebook", "apple") == "devnull" }))
+
+	// stopping more than once is harmless, and changes are no longer picked up
+	stop()
+	stop()
+	time.Sleep(10 * time.Millisecond)
+	writeConfig("memory")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, "devnull", rc.Resolve("ebook", "apple"))
+}
+
+func eventually(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
@@ -23,13 +23,61 @@ package routing
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/uber-go/dosa"
 )
 
-// rule is an assignment from scope.prefixPattern to a connector name.
+// operation is the kind of entity operation a rule applies to.
+type operation int
+
+const (
+	// anyOperation matches reads and writes alike.
+	anyOperation operation = iota
+	// readOperation matches Read, MultiRead, Range and Scan.
+	readOperation
+	// writeOperation matches CreateIfNotExists, Upsert, MultiUpsert, Remove, MultiRemove and RemoveRange.
+	writeOperation
+)
+
+// parseOperation parses the "operations" setting of a rule.
+func parseOperation(s string) (operation, error) {
+	switch strings.ToLower(s) {
+	case "", "all":
+		return anyOperation, nil
+	case "read", "reads":
+		return readOperation, nil
+	case "write", "writes":
+		return writeOperation, nil
+	}
+	return anyOperation, fmt.Errorf("invalid operations %q, expected one of read, write or all", s)
+}
+
+func (o operation) String() string {
+	switch o {
+	case readOperation:
+		return "read"
+	case writeOperation:
+		return "write"
+	}
+	return "all"
+}
+
+// share is a destination of a rule that splits traffic by partition key. Partition keys hashing to
+// a bucket below upTo, and not below the upTo of the previous share, are sent to the connector.
+type share struct {
+	connector string
+	percent   int
+	upTo      int
+}
+
+// numBuckets is the number of buckets partition keys are hashed into, one per percent.
+const numBuckets = 100
+
+// rule is an assignment from scope.prefixPattern to a connector name. A rule may also be restricted to
+// some entities and to reads or writes, and may split the traffic it matches between several connectors.
 type rule struct {
 	scope         string
 	namePrefix    string
@@ -39,6 +87,22 @@ type rule struct {
 	// The canonical representations of scope and prefix, chosen so that they sort in the required orrder.
 	canonScope string
 	canonPfx   string
+	// The entity name the rule is restricted to, "" for all entities, and the pattern when it is a glob.
+	entity        string
+	entityPattern *regexp.Regexp
+	operation     operation
+	// split is empty unless traffic is divided by partition key; connector is then the destination with
+	// the largest share, used when there is no partition key to look at.
+	split []share
+}
+
+// ruleSpec is the long form of a rule's destination in the YAML config, which can restrict the
+// rule to some entities or operations, and split traffic between connectors.
+type ruleSpec struct {
+	Entity     string         `yaml:"entity"`
+	Operations string         `yaml:"operations"`
+	Connector  string         `yaml:"connector"`
+	Split      map[string]int `yaml:"split"`
 }
 
 // newRule creates a rule.
@@ -79,6 +143,73 @@ func newRule(scope, namePrefix, connector string) (*rule, error) {
 	}, nil
 }
 
+// newRuleFromSpec creates a rule from the long form of its destination.
+func newRuleFromSpec(scope, namePrefix string, spec ruleSpec) (*rule, error) {
+	if (spec.Connector == "") == (len(spec.Split) == 0) {
+		return nil, fmt.Errorf("could not parse routing rule for %s.%s: exactly one of connector and split must be set", scope, namePrefix)
+	}
+	op, err := parseOperation(spec.Operations)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse routing rule")
+	}
+	split, err := parseSplit(spec.Split)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse routing rule")
+	}
+	connector, largest := spec.Connector, 0
+	for _, s := range split {
+		if s.percent > largest {
+			connector, largest = s.connector, s.percent
+		}
+	}
+
+	r, err := newRule(scope, namePrefix, connector)
+	if err != nil {
+		return nil, err
+	}
+	r.operation = op
+	r.split = split
+	if spec.Entity != "" && spec.Entity != "*" && spec.Entity != defaultName {
+		entity, _, entityPat, err := parseName(spec.Entity, true)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse routing rule: invalid entity %s", spec.Entity))
+		}
+		r.entity = strings.ToLower(entity)
+		if entityPat != nil {
+			r.entityPattern = makePrefixRegexp(r.entity)
+		}
+	}
+	return r, nil
+}
+
+// parseSplit checks that the percentages of a split are positive and add up to 100, and returns the
+// shares ordered by connector name so that the same config always assigns the same buckets.
+func parseSplit(percentages map[string]int) ([]share, error) {
+	if len(percentages) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(percentages))
+	for name := range percentages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	split := make([]share, 0, len(names))
+	total := 0
+	for _, name := range names {
+		percent := percentages[name]
+		if name == "" || percent <= 0 {
+			return nil, fmt.Errorf("invalid split %v: every connector needs a positive percentage", percentages)
+		}
+		total += percent
+		split = append(split, share{connector: name, percent: percent, upTo: total})
+	}
+	if total != numBuckets {
+		return nil, fmt.Errorf("invalid split %v: percentages add up to %d instead of 100", percentages, total)
+	}
+	return split, nil
+}
+
 // Parse a name (scope or prefix) optionaly with a "*" suffix. Return the underlying name, a canonical representation
 // of the name, and if the name is a glob, a regular expression recognizing it.
 func parseName(name string, isScope bool) (string, string, *regexp.Regexp, error) {
@@ -127,20 +258,91 @@ func (r *rule) NamePrefix() string {
 	return r.namePrefix + "*"
 }
 
-// Destination returns the rule's destination.
+// Entity returns the entity name the rule is restricted to, "*" when it applies to all entities.
+func (r *rule) Entity() string {
+	if r.entity == "" && r.entityPattern == nil {
+		return "*"
+	}
+	if r.entityPattern == nil {
+		return r.entity
+	}
+	return r.entity + "*"
+}
+
+// Operations returns the kind of operations the rule applies to: "read", "write" or "all".
+func (r *rule) Operations() string {
+	return r.operation.String()
+}
+
+// Destination returns the rule's destination. For a rule splitting traffic, that is the connector
+// with the largest share, which serves the operations that are not specific to a partition.
 func (r *rule) Destination() string {
 	return r.connector
 }
 
+// Destinations returns all the connectors the rule sends traffic to.
+func (r *rule) Destinations() []string {
+	if len(r.split) == 0 {
+		return []string{r.connector}
+	}
+	names := make([]string, len(r.split))
+	for i, s := range r.split {
+		names[i] = s.connector
+	}
+	return names
+}
+
+// destinationFor returns the connector serving the partition keys hashing to the given bucket.
+func (r *rule) destinationFor(bucket int) string {
+	for _, s := range r.split {
+		if bucket < s.upTo {
+			return s.connector
+		}
+	}
+	return r.connector
+}
+
+// isConditional says whether the rule is restricted to some entities or operations. Only
+// unconditional rules serve the operations that are not about a single entity, e.g. schema ones.
+func (r *rule) isConditional() bool {
+	return r.Entity() != "*" || r.operation != anyOperation
+}
+
+// name identifies the rule in metrics.
+func (r *rule) name() string {
+	name := r.Scope() + "." + r.NamePrefix()
+	if r.Entity() != "*" {
+		name += "/" + r.Entity()
+	}
+	if r.operation != anyOperation {
+		name += ":" + r.Operations()
+	}
+	return name
+}
+
 func (r *rule) String() string {
-	var prefixPat, scopePat string
+	var prefixPat, scopePat, conditions string
 	if r.scopePattern != nil {
 		scopePat = "*"
 	}
 	if r.prefixPattern != nil {
 		prefixPat = "*"
 	}
-	return fmt.Sprintf("{%s%s.%s%s -> %s}", r.scope, scopePat, r.namePrefix, prefixPat, r.connector)
+	if r.Entity() != "*" {
+		conditions += " entity=" + r.Entity()
+	}
+	if r.operation != anyOperation {
+		conditions += " operations=" + r.Operations()
+	}
+	destination := r.connector
+	if len(r.split) > 0 {
+		shares := make([]string, len(r.split))
+		for i, s := range r.split {
+			shares[i] = fmt.Sprintf("%s:%d%%", s.connector, s.percent)
+		}
+		destination = strings.Join(shares, ",")
+	}
+	return fmt.Sprintf("{%s%s.%s%s%s -> %s}", r.scope, scopePat, r.namePrefix, prefixPat, conditions, destination)
 }
 
 // canHandle says whether or not this rule can handle the given scope:prefix.
@@ -170,6 +372,21 @@ func (r *rule) canHandle(scope string, namePrefix string) bool {
 	return false
 }
 
+// matches says whether this rule handles the given operation on an entity in scope:prefix.
+func (r *rule) matches(scope, namePrefix, entity string, op operation) bool {
+	if !r.canHandle(scope, namePrefix) {
+		return false
+	}
+	if r.operation != anyOperation && r.operation != op {
+		return false
+	}
+	entity = strings.ToLower(entity)
+	if r.entityPattern != nil {
+		return r.entityPattern.MatchString(entity)
+	}
+	return r.entity == "" || r.entity == entity
+}
+
 // Make a regexp from the "glob" pattern used in routing rules.
 func makePrefixRegexp(pat string) *regexp.Regexp {
 	if pat == "" {
@@ -0,0 +1,295 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// destinationOf returns the connector serving the row with the given partition key values. When
+// some partition key values are missing or nil, the rule's primary destination is used.
+func (r *rule) destinationOf(ei *dosa.EntityInfo, values map[string]dosa.FieldValue) string {
+	if len(r.split) == 0 {
+		return r.connector
+	}
+	bucket, ok := partitionBucket(ei.Def, values)
+	if !ok {
+		return r.connector
+	}
+	return r.destinationFor(bucket)
+}
+
+// partitionBucket hashes the partition key values of a row into one of numBuckets buckets. The
+// hash only depends on the values, so every client sends a partition to the same connector, and
+// all the entities sharing a partition key are split the same way.
+func partitionBucket(ed *dosa.EntityDefinition, values map[string]dosa.FieldValue) (int, bool) {
+	if ed == nil || ed.Key == nil || len(ed.Key.PartitionKeys) == 0 {
+		return 0, false
+	}
+	h := fnv.New32a()
+	for _, pk := range ed.Key.PartitionKeys {
+		value, ok := values[pk]
+		if !ok || isNil(value) {
+			return 0, false
+		}
+		writeValue(h, value)
+	}
+	return int(h.Sum32() % numBuckets), true
+}
+
+// isNil returns whether a value is nil or a nil pointer, such as a (*string)(nil) value
+// of an unset pointer column.
+func isNil(value dosa.FieldValue) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// writeValue writes a length-prefixed encoding of a value to the hash, so that consecutive
+// values cannot be confused with each other.
+func writeValue(h hash.Hash32, value dosa.FieldValue) {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case *string:
+		data = []byte(*v)
+	case []byte:
+		data = v
+	case int32:
+		data = strconv.AppendInt(nil, int64(v), 10)
+	case *int32:
+		data = strconv.AppendInt(nil, int64(*v), 10)
+	case int64:
+		data = strconv.AppendInt(nil, v, 10)
+	case *int64:
+		data = strconv.AppendInt(nil, *v, 10)
+	case float64:
+		data = strconv.AppendUint(nil, math.Float64bits(v), 10)
+	case *float64:
+		data = strconv.AppendUint(nil, math.Float64bits(*v), 10)
+	case bool:
+		data = strconv.AppendBool(nil, v)
+	case *bool:
+		data = strconv.AppendBool(nil, *v)
+	case time.Time:
+		data = strconv.AppendInt(nil, v.UnixNano(), 10)
+	case *time.Time:
+		data = strconv.AppendInt(nil, v.UnixNano(), 10)
+	case dosa.UUID:
+		data = []byte(v)
+	case *dosa.UUID:
+		data = []byte(*v)
+	default:
+		data = []byte(fmt.Sprint(v))
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	_, _ = h.Write(length[:])
+	_, _ = h.Write(data)
+}
+
+// partitionValues returns the partition key values fixed by the conditions of a range operation.
+func partitionValues(ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) map[string]dosa.FieldValue {
+	values := make(map[string]dosa.FieldValue)
+	if ei.Def == nil || ei.Def.Key == nil {
+		return values
+	}
+	for _, pk := range ei.Def.Key.PartitionKeys {
+		for _, cond := range columnConditions[pk] {
+			if cond.Op == dosa.Eq {
+				values[pk] = cond.Value
+			}
+		}
+	}
+	return values
+}
+
+// scanSplit scans the connectors of a split rule one after the other. Only the rows of the partitions
+// a connector serves are returned from it, so that the rows left behind in another connector while
+// migrating are not returned twice. The continuation token is the index of the connector being
+// scanned, followed by ':' and the continuation token of that connector.
+func (rc *Connector) scanSplit(ctx context.Context, r *rule, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	destinations := uniqueDestinations(r)
+	idx, inner, err := parseSplitToken(token, len(destinations))
+	if err != nil {
+		return nil, "", err
+	}
+	// the partition keys are needed to tell which connector serves a row
+	fields, added := withPartitionKeys(ei, minimumFields)
+	for {
+		connector, err := rc.connectorFor("Scan", r, destinations[idx])
+		if err != nil {
+			return nil, "", err
+		}
+		rows, next, err := connector.Scan(ctx, ei, fields, inner, limit)
+		if err != nil && !dosa.ErrorIsNotFound(err) {
+			return nil, "", err
+		}
+		var served []map[string]dosa.FieldValue
+		for _, row := range rows {
+			if r.destinationOf(ei, row) != destinations[idx] {
+				continue
+			}
+			for _, field := range added {
+				delete(row, field)
+			}
+			served = append(served, row)
+		}
+		switch {
+		case next != "":
+			inner = next
+		case idx+1 < len(destinations):
+			idx, inner = idx+1, ""
+		default:
+			return served, "", nil
+		}
+		if len(served) != 0 {
+			return served, fmt.Sprintf("%d:%s", idx, inner), nil
+		}
+	}
+}
+
+// uniqueDestinations returns the connectors of a rule, without duplicates
+func uniqueDestinations(r *rule) []string {
+	var destinations []string
+	seen := make(map[string]bool)
+	for _, d := range r.Destinations() {
+		if !seen[d] {
+			seen[d] = true
+			destinations = append(destinations, d)
+		}
+	}
+	return destinations
+}
+
+// parseSplitToken returns the index of the connector and its continuation token from the
+// continuation token of a split scan
+func parseSplitToken(token string, destinations int) (int, string, error) {
+	if token == "" {
+		return 0, "", nil
+	}
+	sep := strings.IndexByte(token, ':')
+	if sep < 0 {
+		return 0, "", fmt.Errorf("invalid scan token %q", token)
+	}
+	idx, err := strconv.Atoi(token[:sep])
+	if err != nil || idx < 0 || idx >= destinations {
+		return 0, "", fmt.Errorf("invalid scan token %q", token)
+	}
+	return idx, token[sep+1:], nil
+}
+
+// withPartitionKeys adds the partition keys to the fields to read, and returns the ones it added.
+// All the fields are read when none are given.
+func withPartitionKeys(ei *dosa.EntityInfo, fields []string) ([]string, []string) {
+	if fields == nil || ei.Def == nil || ei.Def.Key == nil {
+		return fields, nil
+	}
+	present := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		present[f] = true
+	}
+	var added []string
+	for _, pk := range ei.Def.Key.PartitionKeys {
+		if !present[pk] {
+			added = append(added, pk)
+		}
+	}
+	return append(append([]string{}, fields...), added...), added
+}
+
+// batch is the part of a multi-row operation sent to one connector: the rows, and their
+// positions in the whole operation.
+type batch struct {
+	connector dosa.Connector
+	indexes   []int
+	values    []map[string]dosa.FieldValue
+}
+
+// splitBatch divides the rows of a multi-row operation between the connectors serving them, in
+// the order the connectors first appear.
+func (rc *Connector) splitBatch(method string, op operation, ei *dosa.EntityInfo, values []map[string]dosa.FieldValue) ([]*batch, error) {
+	r, err := rc.findEntityRule(ei, op)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.split) == 0 || len(values) == 0 {
+		c, err := rc.connectorFor(method, r, r.Destination())
+		if err != nil {
+			return nil, err
+		}
+		return []*batch{{connector: c, values: values}}, nil
+	}
+
+	var batches []*batch
+	byDestination := make(map[string]*batch)
+	for i, v := range values {
+		destination := r.destinationOf(ei, v)
+		b, ok := byDestination[destination]
+		if !ok {
+			c, err := rc.connectorFor(method, r, destination)
+			if err != nil {
+				return nil, err
+			}
+			b = &batch{connector: c}
+			byDestination[destination] = b
+			batches = append(batches, b)
+		}
+		b.indexes = append(b.indexes, i)
+		b.values = append(b.values, v)
+	}
+	return batches, nil
+}
+
+// mergeErrors runs a multi-row write on each batch and puts the per-row results back in the
+// order of the whole operation. It stops at the first batch failing as a whole.
+func mergeErrors(batches []*batch, n int, write func(b *batch) ([]error, error)) ([]error, error) {
+	if len(batches) == 1 {
+		return write(batches[0])
+	}
+	results := make([]error, n)
+	for _, b := range batches {
+		errs, err := write(b)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range b.indexes {
+			if j < len(errs) {
+				results[i] = errs[j]
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"gopkg.in/yaml.v2"
+)
+
+var splitYAML = `
+routers:
+- production:
+    map:
+    - entity: orders
+      operations: write
+      split:
+        old: 70
+        new: 30
+    - entity: order_*
+      connector: new
+    - operations: read
+      connector: old
+    - connector: old
+- default:
+    default: old
+`
+
+func entityInfo(name string) *dosa.EntityInfo {
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "production", NamePrefix: "map", EntityName: name},
+		Def: &dosa.EntityDefinition{
+			Name: name,
+			Columns: []*dosa.ColumnDefinition{
+				{Name: "id", Type: dosa.String},
+				{Name: "v", Type: dosa.Int64},
+			},
+			Key: &dosa.PrimaryKey{PartitionKeys: []string{"id"}},
+		},
+	}
+}
+
+func splitConnector(t *testing.T) (*Connector, dosa.Connector, dosa.Connector) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(splitYAML), &cfg))
+	old, new := memory.NewConnector(), memory.NewConnector()
+	rc := NewConnector(cfg, map[string]dosa.Connector{"old": old, "new": new})
+	require.NoError(t, rc.Reload(cfg))
+	return rc, old, new
+}
+
+func TestParseRuleSpecs(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(splitYAML), &cfg))
+	assert.Equal(t, "[{production.map entity=orders operations=write -> new:30%,old:70%},"+
+		"{production.map entity=order_* -> new},"+
+		"{production.map operations=read -> old},"+
+		"{production.map -> old},"+
+		"{*.* -> old}]", cfg.String())
+
+	split := cfg.Routers[0]
+	assert.Equal(t, "old", split.Destination())
+	assert.Equal(t, []string{"new", "old"}, split.Destinations())
+	assert.Equal(t, "production.map/orders:write", split.name())
+	assert.Equal(t, "orders", split.Entity())
+	assert.Equal(t, "write", split.Operations())
+	assert.True(t, split.isConditional())
+	assert.False(t, cfg.Routers[3].isConditional())
+	// Schema operations skip the conditional rules.
+	assert.Equal(t, cfg.Routers[3], cfg.findRule("production", "map"))
+
+	// A single rule can be given as a map.
+	require.NoError(t, yaml.Unmarshal([]byte(`
+routers:
+- default:
+    default:
+      split: {a: 50, b: 50}
+`), &cfg))
+	assert.Equal(t, "[{*.* -> a:50%,b:50%}]", cfg.String())
+	assert.Equal(t, "a", cfg.Routers[0].Destination())
+}
+
+func TestParseRuleSpecErrors(t *testing.T) {
+	cases := map[string]string{
+		"a: {connector: x, split: {y: 100}}":      "exactly one of connector and split",
+		"a: {entity: orders}":                     "exactly one of connector and split",
+		"a: {connector: x, operations: delete}":   "invalid operations",
+		"a: {split: {x: 60, y: 60}}":              "add up to 120",
+		"a: {split: {x: 100, y: 0}}":              "positive percentage",
+		"a: {connector: x, entity: 9orders}":      "invalid entity",
+		"a: {connector: x, tables: orders}":       "invalid rule for production.a",
+		"a: [{connector: x}, cassandra]":          "invalid rule for production.a",
+		"a: 42":                                   "invalid destination",
+		"default: {connector: x, entity: orders}": "no default rule",
+	}
+	for spec, msg := range cases {
+		var cfg Config
+		err := yaml.Unmarshal([]byte(fmt.Sprintf("routers:\n- production:\n    %s\n- default:\n    %s\n", spec, spec)), &cfg)
+		if assert.Error(t, err, spec) {
+			assert.Contains(t, err.Error(), msg, spec)
+		}
+	}
+}
+
+func TestEntityAndOperationRouting(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, yaml.Unmarshal([]byte(splitYAML), cfg))
+	cases := []struct {
+		entity string
+		op     operation
+		rule   int
+	}{
+		{"orders", writeOperation, 0},
+		{"Orders", writeOperation, 0},
+		{"orders", readOperation, 2},
+		{"order_items", writeOperation, 1},
+		{"order_items", readOperation, 1},
+		{"users", readOperation, 2},
+		{"users", writeOperation, 3},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, cfg.Routers[tc.rule], cfg.findEntityRule("production", "map", tc.entity, tc.op), "%s %s", tc.entity, tc.op)
+	}
+	assert.Equal(t, cfg.Routers[4], cfg.findEntityRule("development", "map", "orders", writeOperation))
+
+	rc, old, new := splitConnector(t)
+	ctx := context.Background()
+	items := entityInfo("order_items")
+	require.NoError(t, rc.Upsert(ctx, items, map[string]dosa.FieldValue{"id": "a", "v": int64(1)}))
+	_, err := new.Read(ctx, items, map[string]dosa.FieldValue{"id": "a"}, nil)
+	assert.NoError(t, err)
+	_, err = old.Read(ctx, items, map[string]dosa.FieldValue{"id": "a"}, nil)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+}
+
+func TestSplitRouting(t *testing.T) {
+	rc, old, new := splitConnector(t)
+	ctx := context.Background()
+	orders := entityInfo("orders")
+
+	var rows []map[string]dosa.FieldValue
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, map[string]dosa.FieldValue{"id": fmt.Sprintf("order-%d", i), "v": int64(i)})
+	}
+	errs, err := rc.MultiUpsert(ctx, orders, rows)
+	require.NoError(t, err)
+	assert.Len(t, errs, len(rows))
+	for _, e := range errs {
+		assert.NoError(t, e)
+	}
+
+	// Every row is written to the connector its partition key hashes to, about 30% to "new".
+	onNew := 0
+	for _, row := range rows {
+		key := map[string]dosa.FieldValue{"id": row["id"]}
+		bucket, ok := partitionBucket(orders.Def, key)
+		require.True(t, ok)
+		owner, other := old, new
+		if bucket < 30 {
+			owner, other = new, old
+			onNew++
+		}
+		_, err := owner.Read(ctx, orders, key, nil)
+		assert.NoError(t, err)
+		_, err = other.Read(ctx, orders, key, nil)
+		assert.True(t, dosa.ErrorIsNotFound(err))
+	}
+	assert.InDelta(t, 300, onNew, 60)
+
+	// Single row writes follow the same split.
+	require.NoError(t, rc.Remove(ctx, orders, map[string]dosa.FieldValue{"id": "order-1"}))
+	errs, err = rc.MultiRemove(ctx, orders, []map[string]dosa.FieldValue{{"id": "order-2"}, {"id": "order-3"}})
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+
+	// Reads of orders are not split, they all go to "old".
+	results, err := rc.MultiRead(ctx, orders, []map[string]dosa.FieldValue{{"id": "order-4"}, {"id": "order-5"}}, nil)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestSplitMultiReadKeepsOrder(t *testing.T) {
+	old, new := memory.NewConnector(), memory.NewConnector()
+	rule, err := newRuleFromSpec("default", "default", ruleSpec{Split: map[string]int{"old": 50, "new": 50}})
+	require.NoError(t, err)
+	rc := NewConnector(Config{Routers: routers{rule}}, map[string]dosa.Connector{"old": old, "new": new})
+	ctx := context.Background()
+	ei := entityInfo("orders")
+
+	var keys []map[string]dosa.FieldValue
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("k%d", i)
+		require.NoError(t, rc.Upsert(ctx, ei, map[string]dosa.FieldValue{"id": id, "v": int64(i)}))
+		keys = append(keys, map[string]dosa.FieldValue{"id": id})
+	}
+	keys = append(keys, map[string]dosa.FieldValue{"id": "missing"})
+
+	results, err := rc.MultiRead(ctx, ei, keys, nil)
+	require.NoError(t, err)
+	require.Len(t, results, len(keys))
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, results[i].Error)
+		assert.Equal(t, int64(i), results[i].Values["v"])
+	}
+	assert.True(t, dosa.ErrorIsNotFound(results[20].Error))
+
+	// A range on one partition goes where the partition lives.
+	for i := 0; i < 20; i++ {
+		rows, _, err := rc.Range(ctx, ei, map[string][]*dosa.Condition{
+			"id": {{Op: dosa.Eq, Value: fmt.Sprintf("k%d", i)}},
+		}, nil, "", 10)
+		require.NoError(t, err)
+		assert.Len(t, rows, 1)
+	}
+}
+
+func TestSplitScan(t *testing.T) {
+	old, new := memory.NewConnector(), memory.NewConnector()
+	rule, err := newRuleFromSpec("default", "default", ruleSpec{Split: map[string]int{"old": 50, "new": 50}})
+	require.NoError(t, err)
+	rc := NewConnector(Config{Routers: routers{rule}}, map[string]dosa.Connector{"old": old, "new": new})
+	ctx := context.Background()
+	ei := entityInfo("orders")
+
+	for i := 0; i < 20; i++ {
+		row := map[string]dosa.FieldValue{"id": fmt.Sprintf("k%d", i), "v": int64(i)}
+		require.NoError(t, rc.Upsert(ctx, ei, row))
+		// rows not migrated yet are still in the old connector, and must not be returned twice
+		require.NoError(t, old.Upsert(ctx, ei, row))
+	}
+
+	for _, fields := range [][]string{dosa.All(), {"v"}} {
+		seen := make(map[int64]bool)
+		var token string
+		for {
+			rows, next, err := rc.Scan(ctx, ei, fields, token, 3)
+			require.NoError(t, err)
+			for _, row := range rows {
+				v := row["v"].(int64)
+				assert.False(t, seen[v], "row %d is returned twice", v)
+				seen[v] = true
+				if fields != nil {
+					assert.NotContains(t, row, "id")
+				}
+			}
+			if next == "" {
+				break
+			}
+			token = next
+		}
+		assert.Len(t, seen, 20)
+	}
+
+	for _, token := range []string{"nope", "2:", "x:"} {
+		_, _, err := rc.Scan(ctx, ei, nil, token, 3)
+		assert.Error(t, err, token)
+	}
+}
+
+func TestPartitionBucket(t *testing.T) {
+	ed := &dosa.EntityDefinition{Key: &dosa.PrimaryKey{PartitionKeys: []string{"a", "b"}}}
+	b1, ok := partitionBucket(ed, map[string]dosa.FieldValue{"a": "x", "b": int64(1)})
+	assert.True(t, ok)
+	b2, _ := partitionBucket(ed, map[string]dosa.FieldValue{"a": "x", "b": int64(1), "c": "ignored"})
+	assert.Equal(t, b1, b2)
+	assert.True(t, b1 >= 0 && b1 < numBuckets)
+
+	_, ok = partitionBucket(ed, map[string]dosa.FieldValue{"a": "x"})
+	assert.False(t, ok)
+
+	// Pointers hash like the values they point to.
+	s, i := "x", int64(1)
+	b3, _ := partitionBucket(ed, map[string]dosa.FieldValue{"a": &s, "b": &i})
+	assert.Equal(t, b1, b3)
+
+	// Nil pointers are treated as missing values.
+	_, ok = partitionBucket(ed, map[string]dosa.FieldValue{"a": (*string)(nil), "b": &i})
+	assert.False(t, ok)
+	r := &rule{connector: "primary", split: []share{{connector: "primary", percent: 50}, {connector: "other", percent: 50}}}
+	ei := &dosa.EntityInfo{Def: ed}
+	assert.Equal(t, "primary", r.destinationOf(ei, map[string]dosa.FieldValue{"a": &s, "b": (*int64)(nil)}))
+}
@@ -0,0 +1,627 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package shadow
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/base"
+	"github.com/uber-go/dosa/metrics"
+)
+
+// The shadow connector writes to a primary and a secondary connector and serves reads from the primary.
+// Reads can optionally be replayed against the secondary in the background and compared with the
+// primary results, which makes it possible to migrate a scope from one engine to another without downtime.
+
+const (
+	defaultShadowReadTimeout = 5 * time.Second
+	defaultMaxShadowReads    = 64
+)
+
+// MismatchKind describes how a shadow read differed from the primary read
+type MismatchKind string
+
+// Kinds of mismatches reported by shadow reads
+const (
+	// MissingRow means the row was returned by the primary but not by the secondary
+	MissingRow MismatchKind = "missing_row"
+	// UnexpectedRow means the row was returned by the secondary but not by the primary
+	UnexpectedRow MismatchKind = "unexpected_row"
+	// DifferentValue means a column of the row has different values in the primary and the secondary
+	DifferentValue MismatchKind = "different_value"
+	// SecondaryError means the secondary failed where the primary succeeded
+	SecondaryError MismatchKind = "secondary_error"
+)
+
+// Mismatch describes a difference between the primary and the secondary found by a shadow read
+type Mismatch struct {
+	Method     string
+	Scope      string
+	NamePrefix string
+	Entity     string
+	Kind       MismatchKind
+	// Keys holds the primary key values of the row, if known
+	Keys map[string]dosa.FieldValue
+	// Column, Primary and Secondary are only set for DifferentValue mismatches
+	Column    string
+	Primary   dosa.FieldValue
+	Secondary dosa.FieldValue
+	// Err is only set for SecondaryError mismatches
+	Err error
+}
+
+func (m *Mismatch) String() string {
+	s := fmt.Sprintf("<%s %s.%s.%s: %s", m.Method, m.Scope, m.NamePrefix, m.Entity, m.Kind)
+	if m.Keys != nil {
+		s += fmt.Sprintf(", keys=%v", m.Keys)
+	}
+	if m.Kind == DifferentValue {
+		s += fmt.Sprintf(", %s: %v != %v", m.Column, m.Primary, m.Secondary)
+	}
+	if m.Err != nil {
+		s += fmt.Sprintf(", err=%v", m.Err)
+	}
+	return s + ">"
+}
+
+// Options returns a function that's being used for connector initialization
+type Options func(*Connector) error
+
+// WithShadowReads enables comparing reads against the secondary for the given fraction
+// (between 0 and 1) of read requests
+func WithShadowReads(rate float64) Options {
+	return func(c *Connector) error {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("invalid shadow read rate %v", rate)
+		}
+		c.shadowReadRate = rate
+		return nil
+	}
+}
+
+// WithMismatchHandler sets a callback that is invoked for every mismatch found by shadow reads.
+// The callback is called from a background goroutine.
+func WithMismatchHandler(handler func(*Mismatch)) Options {
+	return func(c *Connector) error {
+		c.onMismatch = handler
+		return nil
+	}
+}
+
+// WithShadowReadTimeout sets the timeout of the reads sent to the secondary
+func WithShadowReadTimeout(timeout time.Duration) Options {
+	return func(c *Connector) error {
+		c.shadowReadTimeout = timeout
+		return nil
+	}
+}
+
+// WithMaxShadowReads limits the number of shadow reads running in the background at the same time.
+// Shadow reads started while the limit is reached are skipped rather than queued.
+func WithMaxShadowReads(n int) Options {
+	return func(c *Connector) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max shadow reads %d", n)
+		}
+		c.shadowReads = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// Connector is a dual-write connector: writes go to the primary and then to the secondary,
+// reads are served from the primary.
+type Connector struct {
+	base.Connector
+	secondary         dosa.Connector
+	stats             metrics.Scope
+	shadowReadRate    float64
+	shadowReadTimeout time.Duration
+	onMismatch        func(*Mismatch)
+	// shadowReads is a semaphore bounding the number of background shadow reads
+	shadowReads chan struct{}
+	// Used primarily for testing so that nothing is called in a goroutine
+	synchronous bool
+}
+
+// NewConnector creates a shadow connector writing to both primary and secondary.
+// It fails if any of the options is invalid.
+func NewConnector(primary, secondary dosa.Connector, scope metrics.Scope, options ...Options) (*Connector, error) {
+	c := &Connector{
+		Connector:         base.Connector{Next: primary},
+		secondary:         secondary,
+		stats:             metrics.CheckIfNilStats(scope),
+		shadowReadTimeout: defaultShadowReadTimeout,
+		shadowReads:       make(chan struct{}, defaultMaxShadowReads),
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// CreateIfNotExists creates the row in the primary, and then in the secondary
+func (c *Connector) CreateIfNotExists(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.Next.CreateIfNotExists(ctx, ei, values); err != nil {
+		return err
+	}
+	// The row may already exist in the secondary if it was backfilled, so upsert it instead.
+	c.logSecondaryWrite("CreateIfNotExists", ei, c.secondary.Upsert(ctx, ei, values))
+	return nil
+}
+
+// Upsert writes to the primary, and then to the secondary
+func (c *Connector) Upsert(ctx context.Context, ei *dosa.EntityInfo, values map[string]dosa.FieldValue) error {
+	if err := c.Next.Upsert(ctx, ei, values); err != nil {
+		return err
+	}
+	c.logSecondaryWrite("Upsert", ei, c.secondary.Upsert(ctx, ei, values))
+	return nil
+}
+
+// MultiUpsert writes to the primary, and then writes the rows that succeeded to the secondary
+func (c *Connector) MultiUpsert(ctx context.Context, ei *dosa.EntityInfo, multiValues []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Next.MultiUpsert(ctx, ei, multiValues)
+	if err != nil {
+		return results, err
+	}
+	succeeded := succeededRows(multiValues, results)
+	if len(succeeded) > 0 {
+		secondaryResults, err := c.secondary.MultiUpsert(ctx, ei, succeeded)
+		c.logSecondaryWrite("MultiUpsert", ei, firstError(secondaryResults, err))
+	}
+	return results, nil
+}
+
+// Remove removes the row from the primary, and then from the secondary
+func (c *Connector) Remove(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue) error {
+	if err := c.Next.Remove(ctx, ei, keys); err != nil {
+		return err
+	}
+	c.logSecondaryWrite("Remove", ei, c.secondary.Remove(ctx, ei, keys))
+	return nil
+}
+
+// MultiRemove removes the rows from the primary, and then the rows that succeeded from the secondary
+func (c *Connector) MultiRemove(ctx context.Context, ei *dosa.EntityInfo, multiKeys []map[string]dosa.FieldValue) ([]error, error) {
+	results, err := c.Next.MultiRemove(ctx, ei, multiKeys)
+	if err != nil {
+		return results, err
+	}
+	succeeded := succeededRows(multiKeys, results)
+	if len(succeeded) > 0 {
+		secondaryResults, err := c.secondary.MultiRemove(ctx, ei, succeeded)
+		c.logSecondaryWrite("MultiRemove", ei, firstError(secondaryResults, err))
+	}
+	return results, nil
+}
+
+// RemoveRange removes the range from the primary, and then from the secondary
+func (c *Connector) RemoveRange(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition) error {
+	if err := c.Next.RemoveRange(ctx, ei, columnConditions); err != nil {
+		return err
+	}
+	c.logSecondaryWrite("RemoveRange", ei, c.secondary.RemoveRange(ctx, ei, columnConditions))
+	return nil
+}
+
+// Read reads from the primary, and optionally compares the result with the secondary
+func (c *Connector) Read(ctx context.Context, ei *dosa.EntityInfo, keys map[string]dosa.FieldValue, minimumFields []string) (map[string]dosa.FieldValue, error) {
+	values, err := c.Next.Read(ctx, ei, keys, minimumFields)
+	if err != nil && !dosa.ErrorIsNotFound(err) {
+		return values, err
+	}
+	if c.shouldShadowRead() {
+		// The caller owns the maps and may modify them once Read returns
+		keys, pvalues := copyRow(keys), copyRow(values)
+		c.shadowRead(ei, "Read", func(sctx context.Context) {
+			svalues, serr := c.secondary.Read(sctx, ei, keys, minimumFields)
+			c.compareRow(ei, "Read", keys, minimumFields, pvalues, err, svalues, serr)
+		})
+	}
+	return values, err
+}
+
+// MultiRead reads from the primary, and optionally compares the results with the secondary
+func (c *Connector) MultiRead(ctx context.Context, ei *dosa.EntityInfo, keys []map[string]dosa.FieldValue, minimumFields []string) ([]*dosa.FieldValuesOrError, error) {
+	results, err := c.Next.MultiRead(ctx, ei, keys, minimumFields)
+	if err != nil {
+		return results, err
+	}
+	if c.shouldShadowRead() {
+		keys, presults := copyRows(keys), copyResults(results)
+		c.shadowRead(ei, "MultiRead", func(sctx context.Context) {
+			sresults, serr := c.secondary.MultiRead(sctx, ei, keys, minimumFields)
+			if serr != nil {
+				c.report(&Mismatch{Kind: SecondaryError, Err: serr}, ei, "MultiRead")
+				return
+			}
+			for idx, result := range presults {
+				if result.Error != nil && !dosa.ErrorIsNotFound(result.Error) {
+					continue
+				}
+				sresult := &dosa.FieldValuesOrError{Error: &dosa.ErrNotFound{}}
+				if idx < len(sresults) {
+					sresult = sresults[idx]
+				}
+				c.compareRow(ei, "MultiRead", keys[idx], minimumFields, result.Values, result.Error, sresult.Values, sresult.Error)
+			}
+		})
+	}
+	return results, err
+}
+
+// Range reads from the primary, and optionally compares the page with the secondary
+func (c *Connector) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	rows, nextToken, err := c.Next.Range(ctx, ei, columnConditions, minimumFields, token, limit)
+	if err != nil && !dosa.ErrorIsNotFound(err) {
+		return rows, nextToken, err
+	}
+	// Continuation tokens are specific to a connector, so only the first page can be compared.
+	// The engines may end their first pages at different rows: rows of the primary missing from
+	// the page of the secondary are looked up by primary key when the secondary has more pages,
+	// and rows only in the page of the secondary are reported when the primary has no more pages.
+	if token == "" && c.shouldShadowRead() {
+		prows := copyRows(rows)
+		complete := nextToken == ""
+		c.shadowRead(ei, "Range", func(sctx context.Context) {
+			srows, snextToken, serr := c.secondary.Range(sctx, ei, columnConditions, minimumFields, token, limit)
+			if serr != nil && !dosa.ErrorIsNotFound(serr) {
+				c.report(&Mismatch{Kind: SecondaryError, Err: serr}, ei, "Range")
+				return
+			}
+			missing := c.compareRows(ei, "Range", minimumFields, prows, srows, complete)
+			if snextToken != "" {
+				c.lookupRows(sctx, ei, "Range", minimumFields, missing)
+				return
+			}
+			for _, row := range missing {
+				c.report(&Mismatch{Kind: MissingRow, Keys: primaryKeyValues(ei, row)}, ei, "Range")
+			}
+		})
+	}
+	return rows, nextToken, err
+}
+
+// Scan reads from the primary, and optionally looks up the rows of the page in the secondary.
+// The order of a scan is specific to each engine, so the pages themselves cannot be compared;
+// instead every row returned by the primary is read from the secondary by its primary key.
+// Rows only present in the secondary are therefore not reported by Scan.
+func (c *Connector) Scan(ctx context.Context, ei *dosa.EntityInfo, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	rows, nextToken, err := c.Next.Scan(ctx, ei, minimumFields, token, limit)
+	if err != nil && !dosa.ErrorIsNotFound(err) {
+		return rows, nextToken, err
+	}
+	if len(rows) > 0 && c.shouldShadowRead() {
+		prows := copyRows(rows)
+		c.shadowRead(ei, "Scan", func(sctx context.Context) {
+			c.lookupRows(sctx, ei, "Scan", minimumFields, prows)
+		})
+	}
+	return rows, nextToken, err
+}
+
+// lookupRows reads the rows of the primary from the secondary by primary key, and compares them
+func (c *Connector) lookupRows(ctx context.Context, ei *dosa.EntityInfo, method string, minimumFields []string, rows []map[string]dosa.FieldValue) {
+	if len(rows) == 0 {
+		return
+	}
+	keys := make([]map[string]dosa.FieldValue, len(rows))
+	for idx, row := range rows {
+		keys[idx] = primaryKeyValues(ei, row)
+	}
+	sresults, serr := c.secondary.MultiRead(ctx, ei, keys, minimumFields)
+	if serr != nil {
+		c.report(&Mismatch{Kind: SecondaryError, Err: serr}, ei, method)
+		return
+	}
+	for idx, row := range rows {
+		sresult := &dosa.FieldValuesOrError{Error: &dosa.ErrNotFound{}}
+		if idx < len(sresults) {
+			sresult = sresults[idx]
+		}
+		c.compareRow(ei, method, keys[idx], minimumFields, row, nil, sresult.Values, sresult.Error)
+	}
+}
+
+// UpsertSchema upserts the schema in the primary, and then in the secondary
+func (c *Connector) UpsertSchema(ctx context.Context, scope, namePrefix string, ed []*dosa.EntityDefinition) (*dosa.SchemaStatus, error) {
+	status, err := c.Next.UpsertSchema(ctx, scope, namePrefix, ed)
+	if err != nil {
+		return status, err
+	}
+	_, serr := c.secondary.UpsertSchema(ctx, scope, namePrefix, ed)
+	c.logSecondary("UpsertSchema", "", serr)
+	return status, nil
+}
+
+// CreateScope creates the scope in the primary, and then in the secondary
+func (c *Connector) CreateScope(ctx context.Context, md *dosa.ScopeMetadata) error {
+	if err := c.Next.CreateScope(ctx, md); err != nil {
+		return err
+	}
+	c.logSecondary("CreateScope", "", c.secondary.CreateScope(ctx, md))
+	return nil
+}
+
+// TruncateScope truncates the scope in the primary, and then in the secondary
+func (c *Connector) TruncateScope(ctx context.Context, scope string) error {
+	if err := c.Next.TruncateScope(ctx, scope); err != nil {
+		return err
+	}
+	c.logSecondary("TruncateScope", "", c.secondary.TruncateScope(ctx, scope))
+	return nil
+}
+
+// DropScope drops the scope in the primary, and then in the secondary
+func (c *Connector) DropScope(ctx context.Context, scope string) error {
+	if err := c.Next.DropScope(ctx, scope); err != nil {
+		return err
+	}
+	c.logSecondary("DropScope", "", c.secondary.DropScope(ctx, scope))
+	return nil
+}
+
+// Shutdown shuts down both connectors
+func (c *Connector) Shutdown() error {
+	perr := c.Next.Shutdown()
+	serr := c.secondary.Shutdown()
+	if perr != nil {
+		return perr
+	}
+	return serr
+}
+
+func (c *Connector) shouldShadowRead() bool {
+	return c.shadowReadRate > 0 && (c.shadowReadRate >= 1 || rand.Float64() < c.shadowReadRate)
+}
+
+// shadowRead runs r in the background, unless the maximum number of shadow reads are
+// already running, in which case the shadow read is skipped so the request is never slowed down.
+func (c *Connector) shadowRead(ei *dosa.EntityInfo, method string, r func(context.Context)) {
+	run := func() {
+		// The request context may be done by the time the shadow read runs, so do not inherit it.
+		ctx, cancel := context.WithTimeout(context.Background(), c.shadowReadTimeout)
+		defer cancel()
+		r(ctx)
+	}
+	if c.synchronous {
+		run()
+		return
+	}
+	select {
+	case c.shadowReads <- struct{}{}:
+	default:
+		c.stats.SubScope("shadow").Tagged(map[string]string{"method": method, "entityName": ei.Def.Name}).Counter("skipped").Inc(1)
+		return
+	}
+	go func() {
+		defer func() { <-c.shadowReads }()
+		run()
+	}()
+}
+
+func (c *Connector) compareRow(ei *dosa.EntityInfo, method string, keys map[string]dosa.FieldValue, minimumFields []string,
+	values map[string]dosa.FieldValue, err error, svalues map[string]dosa.FieldValue, serr error) {
+	primaryFound := err == nil
+	secondaryFound := serr == nil
+	switch {
+	case serr != nil && !dosa.ErrorIsNotFound(serr):
+		c.report(&Mismatch{Kind: SecondaryError, Keys: keys, Err: serr}, ei, method)
+	case primaryFound && !secondaryFound:
+		c.report(&Mismatch{Kind: MissingRow, Keys: keys}, ei, method)
+	case !primaryFound && secondaryFound:
+		c.report(&Mismatch{Kind: UnexpectedRow, Keys: keys}, ei, method)
+	case primaryFound && secondaryFound:
+		c.compareValues(ei, method, keys, minimumFields, values, svalues)
+	default:
+		c.logMatch(ei, method)
+	}
+}
+
+// compareRows compares the rows found in both pages, and returns the rows of the primary that are
+// missing from the page of the secondary. Rows only in the page of the secondary are reported when
+// the page of the primary is complete, otherwise they may be on its next pages.
+func (c *Connector) compareRows(ei *dosa.EntityInfo, method string, minimumFields []string,
+	rows []map[string]dosa.FieldValue, srows []map[string]dosa.FieldValue, complete bool) []map[string]dosa.FieldValue {
+	var missing []map[string]dosa.FieldValue
+	secondary := map[string]map[string]dosa.FieldValue{}
+	for _, srow := range srows {
+		secondary[primaryKeyString(ei, srow)] = srow
+	}
+	for _, row := range rows {
+		pk := primaryKeyString(ei, row)
+		srow, ok := secondary[pk]
+		if !ok {
+			missing = append(missing, row)
+			continue
+		}
+		delete(secondary, pk)
+		c.compareValues(ei, method, primaryKeyValues(ei, row), minimumFields, row, srow)
+	}
+	if complete {
+		for _, srow := range secondary {
+			c.report(&Mismatch{Kind: UnexpectedRow, Keys: primaryKeyValues(ei, srow)}, ei, method)
+		}
+	}
+	return missing
+}
+
+func (c *Connector) compareValues(ei *dosa.EntityInfo, method string, keys map[string]dosa.FieldValue, minimumFields []string,
+	values, svalues map[string]dosa.FieldValue) {
+	columns := minimumFields
+	if len(columns) == 0 {
+		for name := range values {
+			columns = append(columns, name)
+		}
+		// report mismatches in a deterministic order
+		sort.Strings(columns)
+	}
+	matched := true
+	for _, name := range columns {
+		pv, sv := values[name], svalues[name]
+		if !valuesEqual(pv, sv) {
+			matched = false
+			c.report(&Mismatch{Kind: DifferentValue, Keys: keys, Column: name, Primary: pv, Secondary: sv}, ei, method)
+		}
+	}
+	if matched {
+		c.logMatch(ei, method)
+	}
+}
+
+func (c *Connector) report(m *Mismatch, ei *dosa.EntityInfo, method string) {
+	m.Method = method
+	m.Scope = ei.Ref.Scope
+	m.NamePrefix = ei.Ref.NamePrefix
+	m.Entity = ei.Def.Name
+	c.stats.SubScope("shadow").Tagged(map[string]string{"method": method, "entityName": ei.Def.Name, "kind": string(m.Kind)}).Counter("mismatch").Inc(1)
+	if c.onMismatch != nil {
+		c.onMismatch(m)
+	}
+}
+
+func (c *Connector) logMatch(ei *dosa.EntityInfo, method string) {
+	c.stats.SubScope("shadow").Tagged(map[string]string{"method": method, "entityName": ei.Def.Name}).Counter("match").Inc(1)
+}
+
+func (c *Connector) logSecondaryWrite(method string, ei *dosa.EntityInfo, err error) {
+	c.logSecondary(method, ei.Def.Name, err)
+}
+
+// logSecondary counts the outcome of a call to the secondary. Failures of the secondary never
+// fail the request since the primary is the source of truth.
+func (c *Connector) logSecondary(method, entityName string, err error) {
+	s := c.stats.SubScope("secondary").Tagged(map[string]string{"method": method, "entityName": entityName})
+	if err != nil {
+		s.Counter("failure").Inc(1)
+	} else {
+		s.Counter("success").Inc(1)
+	}
+}
+
+func (c *Connector) setSynchronousMode(sync bool) {
+	c.synchronous = sync
+}
+
+// succeededRows returns the rows whose individual result is nil
+func succeededRows(rows []map[string]dosa.FieldValue, results []error) []map[string]dosa.FieldValue {
+	var succeeded []map[string]dosa.FieldValue
+	for idx, row := range rows {
+		if idx < len(results) && results[idx] != nil {
+			continue
+		}
+		succeeded = append(succeeded, row)
+	}
+	return succeeded
+}
+
+func copyRow(row map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	if row == nil {
+		return nil
+	}
+	cp := make(map[string]dosa.FieldValue, len(row))
+	for name, value := range row {
+		cp[name] = value
+	}
+	return cp
+}
+
+func copyRows(rows []map[string]dosa.FieldValue) []map[string]dosa.FieldValue {
+	cp := make([]map[string]dosa.FieldValue, len(rows))
+	for idx, row := range rows {
+		cp[idx] = copyRow(row)
+	}
+	return cp
+}
+
+func copyResults(results []*dosa.FieldValuesOrError) []*dosa.FieldValuesOrError {
+	cp := make([]*dosa.FieldValuesOrError, len(results))
+	for idx, result := range results {
+		if result != nil {
+			cp[idx] = &dosa.FieldValuesOrError{Values: copyRow(result.Values), Error: result.Error}
+		}
+	}
+	return cp
+}
+
+func firstError(results []error, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, e := range results {
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func primaryKeyValues(ei *dosa.EntityInfo, row map[string]dosa.FieldValue) map[string]dosa.FieldValue {
+	keys := map[string]dosa.FieldValue{}
+	for name := range ei.Def.KeySet() {
+		if v, ok := row[name]; ok {
+			keys[name] = v
+		}
+	}
+	return keys
+}
+
+func primaryKeyString(ei *dosa.EntityInfo, row map[string]dosa.FieldValue) string {
+	var names []string
+	for name := range ei.Def.KeySet() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := ""
+	for _, name := range names {
+		s += fmt.Sprintf("%s=%v;", name, indirect(row[name]))
+	}
+	return s
+}
+
+// valuesEqual compares two field values, treating a pointer and the value it points to as equal
+func valuesEqual(a, b dosa.FieldValue) bool {
+	a, b = indirect(a), indirect(b)
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func indirect(v dosa.FieldValue) dosa.FieldValue {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return v
+	}
+	if rv.IsNil() {
+		return nil
+	}
+	return rv.Elem().Interface()
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package shadow
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/connectors/memory"
+	"github.com/uber-go/dosa/mocks"
+	"github.com/uber-go/dosa/testentity"
+)
+
+var (
+	ctx    = context.Background()
+	testEi = createTestEi()
+	uuid1  = dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9")
+)
+
+func createTestEi() *dosa.EntityInfo {
+	table, _ := dosa.TableFromInstance(&testentity.TestEntity{})
+	return &dosa.EntityInfo{
+		Ref: &dosa.SchemaRef{Scope: "testing", NamePrefix: "example", EntityName: table.EntityDefinition.Name},
+		Def: &table.EntityDefinition,
+	}
+}
+
+func row(strKey string, int32v int32) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"an_uuid_key": uuid1,
+		"strkey":      strKey,
+		"int64key":    int64(1),
+		"int32v":      int32v,
+	}
+}
+
+func keys(strKey string) map[string]dosa.FieldValue {
+	return map[string]dosa.FieldValue{
+		"an_uuid_key": uuid1,
+		"strkey":      strKey,
+		"int64key":    int64(1),
+	}
+}
+
+func newTestConnector(primary, secondary dosa.Connector, mismatches *[]*Mismatch) *Connector {
+	c, err := NewConnector(primary, secondary, nil, WithShadowReads(1), WithMismatchHandler(func(m *Mismatch) {
+		*mismatches = append(*mismatches, m)
+	}))
+	if err != nil {
+		panic(err)
+	}
+	c.setSynchronousMode(true)
+	return c
+}
+
+func TestWritesGoToBoth(t *testing.T) {
+	primary, secondary := memory.NewConnector(), memory.NewConnector()
+	c, err := NewConnector(primary, secondary, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	assert.NoError(t, c.CreateIfNotExists(ctx, testEi, row("b", 2)))
+	_, err = c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("c", 3), row("d", 4)})
+	assert.NoError(t, err)
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_, err := secondary.Read(ctx, testEi, keys(k), dosa.All())
+		assert.NoError(t, err, k)
+	}
+
+	assert.NoError(t, c.Remove(ctx, testEi, keys("a")))
+	_, err = c.MultiRemove(ctx, testEi, []map[string]dosa.FieldValue{keys("b")})
+	assert.NoError(t, err)
+	assert.NoError(t, c.RemoveRange(ctx, testEi, map[string][]*dosa.Condition{
+		"an_uuid_key": {{Op: dosa.Eq, Value: uuid1}},
+		"strkey":      {{Op: dosa.Eq, Value: "c"}},
+	}))
+
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := secondary.Read(ctx, testEi, keys(k), dosa.All())
+		assert.True(t, dosa.ErrorIsNotFound(err), k)
+	}
+	_, err = secondary.Read(ctx, testEi, keys("d"), dosa.All())
+	assert.NoError(t, err)
+}
+
+func TestPrimaryFailureSkipsSecondary(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := mocks.NewMockConnector(ctrl)
+	secondary := mocks.NewMockConnector(ctrl)
+	primaryErr := errors.New("primary failed")
+
+	primary.EXPECT().Upsert(ctx, testEi, row("a", 1)).Return(primaryErr)
+	primary.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a", 1), row("b", 2)}).
+		Return([]error{primaryErr, nil}, nil)
+	// only the row that succeeded in the primary is written to the secondary
+	secondary.EXPECT().MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("b", 2)}).Return([]error{nil}, nil)
+
+	c, err := NewConnector(primary, secondary, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, primaryErr, c.Upsert(ctx, testEi, row("a", 1)))
+	results, err := c.MultiUpsert(ctx, testEi, []map[string]dosa.FieldValue{row("a", 1), row("b", 2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []error{primaryErr, nil}, results)
+}
+
+func TestSecondaryFailureDoesNotFailWrite(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	secondary := mocks.NewMockConnector(ctrl)
+	secondary.EXPECT().Upsert(ctx, testEi, row("a", 1)).Return(errors.New("secondary failed"))
+
+	c, err := NewConnector(memory.NewConnector(), secondary, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+}
+
+func TestShadowRead(t *testing.T) {
+	primary, secondary := memory.NewConnector(), memory.NewConnector()
+	var mismatches []*Mismatch
+	c := newTestConnector(primary, secondary, &mismatches)
+
+	// same in both
+	assert.NoError(t, c.Upsert(ctx, testEi, row("a", 1)))
+	// missing from the secondary
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("b", 2)))
+	// different value in the secondary
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("c", 3)))
+	assert.NoError(t, secondary.Upsert(ctx, testEi, row("c", 4)))
+	// only in the secondary
+	assert.NoError(t, secondary.Upsert(ctx, testEi, row("d", 5)))
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_, _ = c.Read(ctx, testEi, keys(k), []string{"int32v"})
+	}
+	assert.Len(t, mismatches, 3)
+	assert.Equal(t, MissingRow, mismatches[0].Kind)
+	assert.Equal(t, "b", mismatches[0].Keys["strkey"])
+	assert.Equal(t, DifferentValue, mismatches[1].Kind)
+	assert.Equal(t, "int32v", mismatches[1].Column)
+	assert.Equal(t, int32(3), mismatches[1].Primary)
+	assert.Equal(t, int32(4), mismatches[1].Secondary)
+	assert.Equal(t, UnexpectedRow, mismatches[2].Kind)
+	assert.Equal(t, "Read", mismatches[2].Method)
+	assert.Equal(t, "awesome_test_entity", mismatches[2].Entity)
+	assert.Contains(t, mismatches[1].String(), "int32v: 3 != 4")
+
+	mismatches = nil
+	results, err := c.MultiRead(ctx, testEi, []map[string]dosa.FieldValue{keys("a"), keys("b")}, []string{"int32v"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Len(t, mismatches, 1)
+	assert.Equal(t, MissingRow, mismatches[0].Kind)
+
+	mismatches = nil
+	rows, _, err := c.Range(ctx, testEi, map[string][]*dosa.Condition{"an_uuid_key": {{Op: dosa.Eq, Value: uuid1}}}, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Len(t, mismatches, 3)
+
+	// scans look up the primary rows in the secondary, so rows only in the secondary are not reported
+	mismatches = nil
+	_, _, err = c.Scan(ctx, testEi, nil, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, mismatches, 2)
+	assert.Equal(t, MissingRow, mismatches[0].Kind)
+	assert.Equal(t, "b", mismatches[0].Keys["strkey"])
+	assert.Equal(t, DifferentValue, mismatches[1].Kind)
+	assert.Equal(t, "Scan", mismatches[1].Method)
+}
+
+func TestShadowScanAcrossPages(t *testing.T) {
+	primary, secondary := memory.NewConnector(), memory.NewConnector()
+	var mismatches []*Mismatch
+	c := newTestConnector(primary, secondary, &mismatches)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, c.Upsert(ctx, testEi, row(k, 1)))
+	}
+
+	var token string
+	for {
+		rows, next, err := c.Scan(ctx, testEi, []string{"int32v"}, token, 2)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, rows)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Empty(t, mismatches)
+}
+
+// smallPages serves ranges in pages one row smaller than requested, like an engine adapting its range limit
+type smallPages struct {
+	dosa.Connector
+}
+
+func (c smallPages) Range(ctx context.Context, ei *dosa.EntityInfo, columnConditions map[string][]*dosa.Condition, minimumFields []string, token string, limit int) ([]map[string]dosa.FieldValue, string, error) {
+	return c.Connector.Range(ctx, ei, columnConditions, minimumFields, token, limit-1)
+}
+
+func TestShadowRangeWithDifferentPages(t *testing.T) {
+	conditions := map[string][]*dosa.Condition{"an_uuid_key": {{Op: dosa.Eq, Value: uuid1}}}
+	populate := func(primary, secondary dosa.Connector, skip string) {
+		for _, k := range []string{"a", "b", "c", "d", "e"} {
+			assert.NoError(t, primary.Upsert(ctx, testEi, row(k, 1)))
+			if k != skip {
+				assert.NoError(t, secondary.Upsert(ctx, testEi, row(k, 1)))
+			}
+		}
+	}
+
+	// the secondary ends its page before the primary
+	primary, secondary := memory.NewConnector(), memory.NewConnector()
+	populate(primary, secondary, "")
+	var mismatches []*Mismatch
+	c := newTestConnector(primary, smallPages{secondary}, &mismatches)
+	_, next, err := c.Range(ctx, testEi, conditions, nil, "", 3)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, next)
+	assert.Empty(t, mismatches)
+
+	// the primary ends its page before the secondary
+	c = newTestConnector(smallPages{primary}, secondary, &mismatches)
+	_, _, err = c.Range(ctx, testEi, conditions, nil, "", 3)
+	assert.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	// a row missing from the secondary is still reported
+	primary, secondary = memory.NewConnector(), memory.NewConnector()
+	populate(primary, secondary, "b")
+	c = newTestConnector(primary, smallPages{secondary}, &mismatches)
+	_, _, err = c.Range(ctx, testEi, conditions, nil, "", 3)
+	assert.NoError(t, err)
+	if assert.Len(t, mismatches, 1) {
+		assert.Equal(t, MissingRow, mismatches[0].Kind)
+		assert.Equal(t, "b", mismatches[0].Keys["strkey"])
+	}
+}
+
+func TestShadowReadCopiesResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := memory.NewConnector()
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("a", 1)))
+	secondary := mocks.NewMockConnector(ctrl)
+
+	mismatches := make(chan *Mismatch, 1)
+	c, err := NewConnector(primary, secondary, nil, WithShadowReads(1), WithMismatchHandler(func(m *Mismatch) {
+		mismatches <- m
+	}))
+	assert.NoError(t, err)
+
+	// the secondary only answers once the caller has modified the result of the primary read
+	modified := make(chan struct{})
+	secondary.EXPECT().Read(gomock.Any(), testEi, keys("a"), []string{"int32v"}).DoAndReturn(
+		func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue, []string) (map[string]dosa.FieldValue, error) {
+			<-modified
+			return map[string]dosa.FieldValue{"int32v": int32(3)}, nil
+		})
+
+	values, err := c.Read(ctx, testEi, keys("a"), []string{"int32v"})
+	assert.NoError(t, err)
+	values["int32v"] = int32(2)
+	close(modified)
+	m := <-mismatches
+	assert.Equal(t, DifferentValue, m.Kind)
+	assert.Equal(t, int32(1), m.Primary)
+}
+
+func TestMaxShadowReads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := memory.NewConnector()
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("a", 1)))
+	secondary := mocks.NewMockConnector(ctrl)
+
+	c, err := NewConnector(primary, secondary, nil, WithShadowReads(1), WithMaxShadowReads(1))
+	assert.NoError(t, err)
+
+	// the first shadow read blocks, so the second one is skipped
+	release, done := make(chan struct{}), make(chan struct{})
+	secondary.EXPECT().Read(gomock.Any(), testEi, keys("a"), dosa.All()).DoAndReturn(
+		func(context.Context, *dosa.EntityInfo, map[string]dosa.FieldValue, []string) (map[string]dosa.FieldValue, error) {
+			<-release
+			close(done)
+			return nil, &dosa.ErrNotFound{}
+		}).Times(1)
+
+	_, err = c.Read(ctx, testEi, keys("a"), dosa.All())
+	assert.NoError(t, err)
+	_, err = c.Read(ctx, testEi, keys("a"), dosa.All())
+	assert.NoError(t, err)
+	close(release)
+	<-done
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, option := range []Options{WithShadowReads(2), WithMaxShadowReads(0)} {
+		c, err := NewConnector(memory.NewConnector(), memory.NewConnector(), nil, option)
+		assert.Error(t, err)
+		assert.Nil(t, c)
+	}
+}
+
+func TestShadowReadSecondaryError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	secondary := mocks.NewMockConnector(ctrl)
+	secondary.EXPECT().Read(gomock.Any(), testEi, keys("a"), dosa.All()).Return(nil, errors.New("secondary failed"))
+
+	primary := memory.NewConnector()
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("a", 1)))
+	var mismatches []*Mismatch
+	c := newTestConnector(primary, secondary, &mismatches)
+
+	_, err := c.Read(ctx, testEi, keys("a"), dosa.All())
+	assert.NoError(t, err)
+	assert.Len(t, mismatches, 1)
+	assert.Equal(t, SecondaryError, mismatches[0].Kind)
+}
+
+func TestNoShadowReadsByDefault(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// any call on the secondary fails the test
+	secondary := mocks.NewMockConnector(ctrl)
+	primary := memory.NewConnector()
+	assert.NoError(t, primary.Upsert(ctx, testEi, row("a", 1)))
+
+	c, err := NewConnector(primary, secondary, nil)
+	assert.NoError(t, err)
+	c.setSynchronousMode(true)
+	_, err = c.Read(ctx, testEi, keys("a"), dosa.All())
+	assert.NoError(t, err)
+}
+
+func TestValuesEqual(t *testing.T) {
+	s := "str"
+	assert.True(t, valuesEqual(&s, "str"))
+	assert.True(t, valuesEqual(nil, (*string)(nil)))
+	assert.False(t, valuesEqual(int32(1), int64(1)))
+}
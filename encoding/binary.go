@@ -0,0 +1,448 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/uber-go/dosa"
+)
+
+// Layout of the values encoded by the schema encoder. Every value starts with the format version, the
+// kind of value and the fingerprint of the columns of the entity definition.
+const (
+	binaryFormatVersion byte = 1
+
+	// a single row
+	binaryKindRow byte = 'r'
+	// a slice of rows
+	binaryKindRows byte = 'R'
+	// any other value, encoded with gob
+	binaryKindGob byte = 'g'
+
+	binaryHeaderSize = 6
+)
+
+// Flags of the header byte of a column value. The low bits hold the dosa.Type of the value.
+const (
+	binaryTypeMask byte = 0x0f
+	binaryPointer  byte = 1 << 4
+	binaryNil      byte = 1 << 5
+	// the uuid is not in canonical form, and is encoded as a string
+	binaryUUIDString byte = 1 << 6
+)
+
+// ErrSchemaMismatch is returned when decoding a value that was encoded with another version of the
+// entity definition, or another version of the encoding format
+var ErrSchemaMismatch = errors.New("value was encoded with another schema or format version")
+
+// NewSchemaEncoder returns a compact binary encoder for the rows of an entity. Rows are encoded as a list
+// of column index and typed value, following the column order of the entity definition, instead of
+// repeating the column names and type information like gob does. Slices of rows are supported as well,
+// and other values are encoded with gob.
+//
+// Encoded values carry a fingerprint of the columns of the entity definition. Decoding a value encoded
+// with another definition returns ErrSchemaMismatch, so that stale values get discarded instead of being
+// decoded into the wrong columns.
+func NewSchemaEncoder(ed *dosa.EntityDefinition) Encoder {
+	e := &schemaEncoder{
+		columns: make([]string, len(ed.Columns)),
+		indexes: make(map[string]uint64, len(ed.Columns)),
+		gob:     NewGobEncoder(),
+	}
+	h := fnv.New32a()
+	for i, col := range ed.Columns {
+		e.columns[i] = col.Name
+		e.indexes[col.Name] = uint64(i)
+		fmt.Fprintf(h, "%s:%d,", col.Name, col.Type)
+	}
+	e.fingerprint = h.Sum32()
+	return e
+}
+
+type schemaEncoder struct {
+	columns     []string
+	indexes     map[string]uint64
+	fingerprint uint32
+	gob         GobEncoder
+}
+
+// Encode serializes a row, a slice of rows, or any other value with gob
+func (e *schemaEncoder) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch rows := v.(type) {
+	case map[string]dosa.FieldValue:
+		e.writeHeader(&buf, binaryKindRow)
+		if err := e.writeRow(&buf, rows); err != nil {
+			return nil, err
+		}
+	case []map[string]dosa.FieldValue:
+		e.writeHeader(&buf, binaryKindRows)
+		writeUvarint(&buf, uint64(len(rows)))
+		for _, row := range rows {
+			if err := e.writeRow(&buf, row); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		e.writeHeader(&buf, binaryKindGob)
+		encoded, err := e.gob.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode deserializes into a *map[string]dosa.FieldValue, a *[]map[string]dosa.FieldValue, or any value
+// that was encoded with gob
+func (e *schemaEncoder) Decode(data []byte, v interface{}) error {
+	if len(data) < binaryHeaderSize || data[0] != binaryFormatVersion ||
+		binary.BigEndian.Uint32(data[2:binaryHeaderSize]) != e.fingerprint {
+		return ErrSchemaMismatch
+	}
+	kind := data[1]
+	r := bytes.NewReader(data[binaryHeaderSize:])
+	switch kind {
+	case binaryKindRow:
+		target, ok := v.(*map[string]dosa.FieldValue)
+		if !ok {
+			return fmt.Errorf("cannot decode a row into %T", v)
+		}
+		row, err := e.readRow(r)
+		if err != nil {
+			return err
+		}
+		*target = row
+	case binaryKindRows:
+		target, ok := v.(*[]map[string]dosa.FieldValue)
+		if !ok {
+			return fmt.Errorf("cannot decode rows into %T", v)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if n > uint64(r.Len()) {
+			return errors.New("invalid number of rows")
+		}
+		rows := make([]map[string]dosa.FieldValue, n)
+		for i := range rows {
+			if rows[i], err = e.readRow(r); err != nil {
+				return err
+			}
+		}
+		*target = rows
+	case binaryKindGob:
+		return e.gob.Decode(data[binaryHeaderSize:], v)
+	default:
+		return fmt.Errorf("invalid kind of value %q", kind)
+	}
+	if r.Len() != 0 {
+		return errors.New("unexpected trailing bytes")
+	}
+	return nil
+}
+
+func (e *schemaEncoder) writeHeader(buf *bytes.Buffer, kind byte) {
+	var header [binaryHeaderSize]byte
+	header[0] = binaryFormatVersion
+	header[1] = kind
+	binary.BigEndian.PutUint32(header[2:], e.fingerprint)
+	buf.Write(header[:])
+}
+
+func (e *schemaEncoder) writeRow(buf *bytes.Buffer, row map[string]dosa.FieldValue) error {
+	if row == nil {
+		// distinguish a nil row from an empty one
+		writeUvarint(buf, 0)
+		return nil
+	}
+	writeUvarint(buf, uint64(len(row))+1)
+	for name, value := range row {
+		index, ok := e.indexes[name]
+		if !ok {
+			return fmt.Errorf("unknown column %q", name)
+		}
+		writeUvarint(buf, index)
+		if err := writeValue(buf, value); err != nil {
+			return fmt.Errorf("invalid value for column %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (e *schemaEncoder) readRow(r *bytes.Reader) (map[string]dosa.FieldValue, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	n--
+	if n > uint64(r.Len()) {
+		return nil, errors.New("invalid number of columns")
+	}
+	row := make(map[string]dosa.FieldValue, n)
+	for i := uint64(0); i < n; i++ {
+		index, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if index >= uint64(len(e.columns)) {
+			return nil, fmt.Errorf("invalid column index %d", index)
+		}
+		value, err := readValue(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for column %q: %v", e.columns[index], err)
+		}
+		row[e.columns[index]] = value
+	}
+	return row, nil
+}
+
+// writeValue writes the header byte of a value, followed by the value
+func writeValue(buf *bytes.Buffer, value dosa.FieldValue) error {
+	if value == nil {
+		buf.WriteByte(binaryNil)
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	var flags byte
+	if v.Kind() == reflect.Ptr {
+		flags |= binaryPointer
+		if v.IsNil() {
+			t, ok := dosaTypeOf(v.Type().Elem())
+			if !ok {
+				return fmt.Errorf("unsupported type %T", value)
+			}
+			buf.WriteByte(flags | binaryNil | byte(t))
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch x := v.Interface().(type) {
+	case dosa.UUID:
+		// only the canonical form can be rebuilt from the 16 bytes of the uuid
+		id, err := x.Bytes()
+		if err == nil {
+			if canonical, err := dosa.BytesToUUID(id); err != nil || canonical != x {
+				id = nil
+			}
+		}
+		if id == nil {
+			buf.WriteByte(flags | binaryUUIDString | byte(dosa.TUUID))
+			writeBytes(buf, []byte(x))
+			return nil
+		}
+		buf.WriteByte(flags | byte(dosa.TUUID))
+		buf.Write(id)
+	case string:
+		buf.WriteByte(flags | byte(dosa.String))
+		writeBytes(buf, []byte(x))
+	case int32:
+		buf.WriteByte(flags | byte(dosa.Int32))
+		writeVarint(buf, int64(x))
+	case int64:
+		buf.WriteByte(flags | byte(dosa.Int64))
+		writeVarint(buf, x)
+	case int:
+		buf.WriteByte(flags | byte(dosa.Int64))
+		writeVarint(buf, int64(x))
+	case float64:
+		buf.WriteByte(flags | byte(dosa.Double))
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+		buf.Write(b[:])
+	case []byte:
+		if x == nil {
+			buf.WriteByte(flags | binaryNil | byte(dosa.Blob))
+			return nil
+		}
+		buf.WriteByte(flags | byte(dosa.Blob))
+		writeBytes(buf, x)
+	case time.Time:
+		buf.WriteByte(flags | byte(dosa.Timestamp))
+		_, offset := x.Zone()
+		writeVarint(buf, x.Unix())
+		writeUvarint(buf, uint64(x.Nanosecond()))
+		writeVarint(buf, int64(offset))
+	case bool:
+		buf.WriteByte(flags | byte(dosa.Bool))
+		if x {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	default:
+		return fmt.Errorf("unsupported type %T", value)
+	}
+	return nil
+}
+
+func readValue(r *bytes.Reader) (dosa.FieldValue, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	t := dosa.Type(header & binaryTypeMask)
+	pointer := header&binaryPointer != 0
+	if header&binaryNil != 0 {
+		if header == binaryNil {
+			return nil, nil
+		}
+		goType, ok := goTypes[t]
+		if !ok {
+			return nil, fmt.Errorf("invalid type %d", t)
+		}
+		if pointer {
+			return reflect.Zero(reflect.PtrTo(goType)).Interface(), nil
+		}
+		return reflect.Zero(goType).Interface(), nil
+	}
+
+	var value interface{}
+	switch t {
+	case dosa.TUUID:
+		if header&binaryUUIDString != 0 {
+			b, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			value = dosa.UUID(b)
+			break
+		}
+		var b [16]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		id, err := dosa.BytesToUUID(b[:])
+		if err != nil {
+			return nil, err
+		}
+		value = id
+	case dosa.String:
+		b, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value = string(b)
+	case dosa.Int32:
+		i, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, errors.New("int32 out of range")
+		}
+		value = int32(i)
+	case dosa.Int64:
+		i, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		value = i
+	case dosa.Double:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		value = math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+	case dosa.Blob:
+		b, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value = b
+	case dosa.Timestamp:
+		sec, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		nsec, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		ts := time.Unix(sec, int64(nsec)).UTC()
+		if offset != 0 {
+			ts = ts.In(time.FixedZone("", int(offset)))
+		}
+		value = ts
+	case dosa.Bool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		value = b != 0
+	default:
+		return nil, fmt.Errorf("invalid type %d", t)
+	}
+
+	if pointer {
+		p := reflect.New(reflect.TypeOf(value))
+		p.Elem().Set(reflect.ValueOf(value))
+		return p.Interface(), nil
+	}
+	return value, nil
+}
+
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], x)])
+}
+
+func writeVarint(buf *bytes.Buffer, x int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], x)])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, errors.New("invalid length")
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
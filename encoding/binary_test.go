@@ -0,0 +1,248 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoding_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/encoding"
+)
+
+var binaryTestDef = &dosa.EntityDefinition{
+	Name: "binary_test_entity",
+	Key: &dosa.PrimaryKey{
+		PartitionKeys: []string{"id"},
+	},
+	Columns: []*dosa.ColumnDefinition{
+		{Name: "id", Type: dosa.TUUID},
+		{Name: "name", Type: dosa.String},
+		{Name: "count", Type: dosa.Int32},
+		{Name: "total", Type: dosa.Int64},
+		{Name: "ratio", Type: dosa.Double},
+		{Name: "data", Type: dosa.Blob},
+		{Name: "updated", Type: dosa.Timestamp},
+		{Name: "active", Type: dosa.Bool},
+		{Name: "idp", Type: dosa.TUUID, IsPointer: true},
+		{Name: "namep", Type: dosa.String, IsPointer: true},
+		{Name: "countp", Type: dosa.Int32, IsPointer: true},
+		{Name: "totalp", Type: dosa.Int64, IsPointer: true},
+		{Name: "ratiop", Type: dosa.Double, IsPointer: true},
+		{Name: "updatedp", Type: dosa.Timestamp, IsPointer: true},
+		{Name: "activep", Type: dosa.Bool, IsPointer: true},
+	},
+}
+
+func binaryTestRow() map[string]dosa.FieldValue {
+	id := dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9")
+	name := "some name"
+	count := int32(math.MinInt32)
+	total := int64(math.MaxInt64)
+	ratio := math.Inf(-1)
+	updated := time.Date(2020, 5, 29, 12, 30, 15, 123456789, time.UTC)
+	active := true
+	return map[string]dosa.FieldValue{
+		"id":       id,
+		"name":     "",
+		"count":    int32(math.MaxInt32),
+		"total":    int64(math.MinInt64),
+		"ratio":    0.1,
+		"data":     []byte{0, 1, 255},
+		"updated":  updated,
+		"active":   false,
+		"idp":      &id,
+		"namep":    &name,
+		"countp":   &count,
+		"totalp":   &total,
+		"ratiop":   &ratio,
+		"updatedp": &updated,
+		"activep":  &active,
+	}
+}
+
+func TestSchemaEncoder_Row(t *testing.T) {
+	e := encoding.NewSchemaEncoder(binaryTestDef)
+	row := binaryTestRow()
+	data, err := e.Encode(row)
+	assert.NoError(t, err)
+
+	decoded := map[string]dosa.FieldValue{}
+	assert.NoError(t, e.Decode(data, &decoded))
+	assert.Equal(t, row, decoded)
+
+	// nil values and pointers
+	row = map[string]dosa.FieldValue{"name": nil, "namep": (*string)(nil), "data": []byte(nil), "updatedp": (*time.Time)(nil)}
+	data, err = e.Encode(row)
+	assert.NoError(t, err)
+	assert.NoError(t, e.Decode(data, &decoded))
+	assert.Equal(t, row, decoded)
+
+	// uuids that are not in canonical form, ints and timestamps with an offset
+	ts := time.Date(2020, 5, 29, 12, 30, 15, 1, time.FixedZone("PDT", -7*3600))
+	data, err = e.Encode(map[string]dosa.FieldValue{"id": dosa.UUID("not-a-uuid"), "total": 42, "updated": ts})
+	assert.NoError(t, err)
+	assert.NoError(t, e.Decode(data, &decoded))
+	assert.Equal(t, dosa.UUID("not-a-uuid"), decoded["id"])
+	assert.Equal(t, int64(42), decoded["total"])
+	assert.True(t, ts.Equal(decoded["updated"].(time.Time)))
+	_, offset := decoded["updated"].(time.Time).Zone()
+	assert.Equal(t, -7*3600, offset)
+}
+
+func TestSchemaEncoderUUIDs(t *testing.T) {
+	e := encoding.NewSchemaEncoder(binaryTestDef)
+	uuids := []dosa.UUID{
+		"d1449c93-25b8-4032-920b-60471d91acc9",
+		"D1449C93-25B8-4032-920B-60471D91ACC9",
+		"d1449c9325b84032920b60471d91acc9",
+		"{d1449c93-25b8-4032-920b-60471d91acc9}",
+		"urn:uuid:d1449c93-25b8-4032-920b-60471d91acc9",
+	}
+	var canonicalSize int
+	for _, id := range uuids {
+		data, err := e.Encode(map[string]dosa.FieldValue{"id": id})
+		assert.NoError(t, err)
+		decoded := map[string]dosa.FieldValue{}
+		assert.NoError(t, e.Decode(data, &decoded))
+		assert.Equal(t, id, decoded["id"])
+		if canonicalSize == 0 {
+			canonicalSize = len(data)
+		} else {
+			// only the canonical form is encoded as 16 bytes
+			assert.True(t, len(data) > canonicalSize, "%s", id)
+		}
+	}
+}
+
+func TestSchemaEncoder_Rows(t *testing.T) {
+	e := encoding.NewSchemaEncoder(binaryTestDef)
+	rows := []map[string]dosa.FieldValue{binaryTestRow(), {}, nil, {"name": "last"}}
+	data, err := e.Encode(rows)
+	assert.NoError(t, err)
+
+	var decoded []map[string]dosa.FieldValue
+	assert.NoError(t, e.Decode(data, &decoded))
+	assert.Equal(t, rows, decoded)
+}
+
+func TestSchemaEncoder_Gob(t *testing.T) {
+	type page struct {
+		Rows      []map[string]dosa.FieldValue
+		TokenNext string
+	}
+	e := encoding.NewSchemaEncoder(binaryTestDef)
+	p := page{Rows: []map[string]dosa.FieldValue{{"name": "a"}}, TokenNext: "next"}
+	data, err := e.Encode(p)
+	assert.NoError(t, err)
+
+	var decoded page
+	assert.NoError(t, e.Decode(data, &decoded))
+	assert.Equal(t, p, decoded)
+}
+
+func TestSchemaEncoder_SchemaMismatch(t *testing.T) {
+	data, err := encoding.NewSchemaEncoder(binaryTestDef).Encode(binaryTestRow())
+	assert.NoError(t, err)
+
+	changed := binaryTestDef.Clone()
+	changed.Columns = append(changed.Columns, &dosa.ColumnDefinition{Name: "added", Type: dosa.String})
+	decoded := map[string]dosa.FieldValue{}
+	assert.Equal(t, encoding.ErrSchemaMismatch, encoding.NewSchemaEncoder(changed).Decode(data, &decoded))
+
+	// another format version
+	data[0]++
+	assert.Equal(t, encoding.ErrSchemaMismatch, encoding.NewSchemaEncoder(binaryTestDef).Decode(data, &decoded))
+}
+
+func TestSchemaEncoder_Errors(t *testing.T) {
+	e := encoding.NewSchemaEncoder(binaryTestDef)
+	_, err := e.Encode(map[string]dosa.FieldValue{"unknown": "a"})
+	assert.Error(t, err)
+	_, err = e.Encode(map[string]dosa.FieldValue{"name": struct{}{}})
+	assert.Error(t, err)
+
+	data, err := e.Encode(binaryTestRow())
+	assert.NoError(t, err)
+	var rows []map[string]dosa.FieldValue
+	assert.Error(t, e.Decode(data, &rows))
+	decoded := map[string]dosa.FieldValue{}
+	for i := 0; i < len(data); i++ {
+		assert.Error(t, e.Decode(data[:i], &decoded), "truncated at %d", i)
+	}
+	assert.Error(t, e.Decode(append(data, 0), &decoded))
+}
+
+var benchmarkEncoders = []struct {
+	name    string
+	encoder encoding.Encoder
+}{
+	{"Schema", encoding.NewSchemaEncoder(binaryTestDef)},
+	{"Gob", encoding.NewGobEncoder()},
+	{"JSON", encoding.NewJSONEncoder()},
+	{"TypedJSON", encoding.NewTypedJSONEncoder()},
+}
+
+// benchmarkRow returns a row that every encoder supports, json has no representation of infinities
+func benchmarkRow() map[string]dosa.FieldValue {
+	row := binaryTestRow()
+	ratio := 0.5
+	row["ratiop"] = &ratio
+	return row
+}
+
+func BenchmarkEncode(b *testing.B) {
+	row := benchmarkRow()
+	for _, bm := range benchmarkEncoders {
+		b.Run(bm.name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				data, err := bm.encoder.Encode(row)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.Logf("encoded row size: %d bytes", size)
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	row := benchmarkRow()
+	for _, bm := range benchmarkEncoders {
+		b.Run(bm.name, func(b *testing.B) {
+			data, err := bm.encoder.Encode(row)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				decoded := map[string]dosa.FieldValue{}
+				if err := bm.encoder.Decode(data, &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
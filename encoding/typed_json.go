@@ -0,0 +1,402 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte{})
+
+	// goTypes maps the dosa types to the go type of their values
+	goTypes = map[dosa.Type]reflect.Type{
+		dosa.TUUID:     reflect.TypeOf(dosa.UUID("")),
+		dosa.String:    reflect.TypeOf(""),
+		dosa.Int32:     reflect.TypeOf(int32(0)),
+		dosa.Int64:     reflect.TypeOf(int64(0)),
+		dosa.Double:    reflect.TypeOf(float64(0)),
+		dosa.Blob:      bytesType,
+		dosa.Timestamp: timeType,
+		dosa.Bool:      reflect.TypeOf(false),
+	}
+)
+
+// NewTypedJSONEncoder returns a json encoder that preserves the types of dosa.FieldValue values.
+// Every value held by an interface, such as the values of a row, is encoded along with its dosa type:
+//
+//	{"int32v": {"type": "Int32", "value": 7}, "strvp": {"type": "String", "pointer": true, "value": "a"}}
+//
+// so that decoding restores the exact go type of the value, including pointers. Timestamps are encoded
+// in RFC 3339 format with nanoseconds, and blobs in base64. Other values are encoded as with encoding/json,
+// except that struct fields are always named after the go field.
+func NewTypedJSONEncoder() Encoder {
+	return typedJSONEncoder{}
+}
+
+type typedJSONEncoder struct{}
+
+// typedValue is the json representation of a value held by an interface
+type typedValue struct {
+	Type    string      `json:"type"`
+	Pointer bool        `json:"pointer,omitempty"`
+	Value   interface{} `json:"value"`
+}
+
+// Encode marshals an object to json, tagging the values held by interfaces with their dosa type
+func (e typedJSONEncoder) Encode(v interface{}) ([]byte, error) {
+	tree, err := toJSONTree(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+// Decode unmarshals json produced by Encode into v, which must be a non-nil pointer
+func (e typedJSONEncoder) Decode(data []byte, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("cannot decode into non-pointer %T", v)
+	}
+	var tree interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&tree); err != nil {
+		return err
+	}
+	return fromJSONTree(tree, target.Elem())
+}
+
+// toJSONTree converts a value to a tree of values that encoding/json marshals as expected
+func toJSONTree(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toTypedValue(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toJSONTree(v.Elem())
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %v", v.Type().Key())
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			elem, err := toJSONTree(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			m[k.String()] = elem
+		}
+		return m, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+		fallthrough
+	case reflect.Array:
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			elem, err := toJSONTree(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			s[i] = elem
+		}
+		return s, nil
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+		}
+		m := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+			elem, err := toJSONTree(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			m[field.Name] = elem
+		}
+		return m, nil
+	case reflect.Float32, reflect.Float64:
+		// json has no representation of NaN and infinities
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64), nil
+		}
+		return v.Interface(), nil
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Interface(), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %v", v.Type())
+	}
+}
+
+// toTypedValue tags a value held by an interface with its dosa type
+func toTypedValue(v reflect.Value) (*typedValue, error) {
+	tv := &typedValue{}
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		tv.Pointer = true
+		t = t.Elem()
+	}
+	dosaType, ok := dosaTypeOf(t)
+	if !ok {
+		return nil, fmt.Errorf("unsupported field value type %v", v.Type())
+	}
+	tv.Type = dosaType.String()
+	value, err := toJSONTree(v)
+	if err != nil {
+		return nil, err
+	}
+	tv.Value = value
+	return tv, nil
+}
+
+func dosaTypeOf(t reflect.Type) (dosa.Type, bool) {
+	for dosaType, goType := range goTypes {
+		if t == goType {
+			return dosaType, true
+		}
+	}
+	// ints are stored as Int64 by dosa
+	if t.Kind() == reflect.Int {
+		return dosa.Int64, true
+	}
+	return dosa.Invalid, false
+}
+
+// fromJSONTree sets target from a tree of values decoded by encoding/json
+func fromJSONTree(tree interface{}, target reflect.Value) error {
+	if tree == nil {
+		switch target.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+	}
+	switch target.Kind() {
+	case reflect.Interface:
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected a typed value, got %T", tree)
+		}
+		value, err := fromTypedValue(m)
+		if err != nil {
+			return err
+		}
+		target.Set(value)
+		return nil
+	case reflect.Ptr:
+		elem := reflect.New(target.Type().Elem())
+		if err := fromJSONTree(tree, elem.Elem()); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	case reflect.Map:
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object for %v, got %T", target.Type(), tree)
+		}
+		result := reflect.MakeMapWithSize(target.Type(), len(m))
+		for k, v := range m {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			if err := fromJSONTree(v, elem); err != nil {
+				return errors.Wrapf(err, "invalid value for key %q", k)
+			}
+			result.SetMapIndex(reflect.ValueOf(k).Convert(target.Type().Key()), elem)
+		}
+		target.Set(result)
+		return nil
+	case reflect.Slice:
+		if target.Type().Elem().Kind() == reflect.Uint8 {
+			s, ok := tree.(string)
+			if !ok {
+				return fmt.Errorf("expected a base64 string for %v, got %T", target.Type(), tree)
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return err
+			}
+			target.SetBytes(b)
+			return nil
+		}
+		s, ok := tree.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array for %v, got %T", target.Type(), tree)
+		}
+		result := reflect.MakeSlice(target.Type(), len(s), len(s))
+		for i, v := range s {
+			if err := fromJSONTree(v, result.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(result)
+		return nil
+	case reflect.Array:
+		s, ok := tree.([]interface{})
+		if !ok || len(s) != target.Len() {
+			return fmt.Errorf("expected an array of %d elements for %v", target.Len(), target.Type())
+		}
+		for i, v := range s {
+			if err := fromJSONTree(v, target.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		if target.Type() == timeType {
+			s, ok := tree.(string)
+			if !ok {
+				return fmt.Errorf("expected a timestamp, got %T", tree)
+			}
+			ts, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return err
+			}
+			target.Set(reflect.ValueOf(ts))
+			return nil
+		}
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object for %v, got %T", target.Type(), tree)
+		}
+		for i := 0; i < target.NumField(); i++ {
+			field := target.Type().Field(i)
+			v, ok := m[field.Name]
+			if !ok || field.PkgPath != "" {
+				continue
+			}
+			if err := fromJSONTree(v, target.Field(i)); err != nil {
+				return errors.Wrapf(err, "invalid value for field %s", field.Name)
+			}
+		}
+		return nil
+	case reflect.String:
+		s, ok := tree.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %T", tree)
+		}
+		target.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, ok := tree.(bool)
+		if !ok {
+			return fmt.Errorf("expected a bool, got %T", tree)
+		}
+		target.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := tree.(json.Number)
+		if !ok {
+			return fmt.Errorf("expected a number, got %T", tree)
+		}
+		i, err := strconv.ParseInt(string(n), 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := tree.(json.Number)
+		if !ok {
+			return fmt.Errorf("expected a number, got %T", tree)
+		}
+		u, err := strconv.ParseUint(string(n), 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var s string
+		switch n := tree.(type) {
+		case json.Number:
+			s = string(n)
+		case string:
+			// NaN and infinities
+			s = n
+		default:
+			return fmt.Errorf("expected a number, got %T", tree)
+		}
+		f, err := strconv.ParseFloat(s, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+		return nil
+	default:
+		return fmt.Errorf("unsupported type %v", target.Type())
+	}
+}
+
+// fromTypedValue returns the value of a typed value, with its original go type
+func fromTypedValue(m map[string]interface{}) (reflect.Value, error) {
+	typeName, _ := m["type"].(string)
+	goType, ok := goTypes[dosa.FromString(typeName)]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("invalid dosa type %q", typeName)
+	}
+	pointer, _ := m["pointer"].(bool)
+	value := m["value"]
+	if value == nil && pointer {
+		return reflect.Zero(reflect.PtrTo(goType)), nil
+	}
+
+	elem := reflect.New(goType)
+	if err := fromJSONTree(value, elem.Elem()); err != nil {
+		return reflect.Value{}, errors.Wrapf(err, "invalid %s value", typeName)
+	}
+	if pointer {
+		return elem, nil
+	}
+	return elem.Elem(), nil
+}
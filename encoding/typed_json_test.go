@@ -0,0 +1,155 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package encoding_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/encoding"
+)
+
+var tj = encoding.NewTypedJSONEncoder()
+
+func TestTypedJSONEncoder_Row(t *testing.T) {
+	u := dosa.NewUUID()
+	s := "some string"
+	i32 := int32(math.MinInt32)
+	i64 := int64(math.MaxInt64)
+	f := 0.1
+	b := true
+	ts := time.Date(2020, 5, 29, 12, 30, 15, 123456789, time.UTC)
+	row := map[string]dosa.FieldValue{
+		"uuid":       u,
+		"string":     "a string",
+		"int32":      int32(math.MaxInt32),
+		"int64":      int64(math.MinInt64),
+		"double":     math.Pi,
+		"blob":       []byte{0, 1, 2, 255},
+		"timestamp":  ts,
+		"bool":       false,
+		"uuidp":      &u,
+		"stringp":    &s,
+		"int32p":     &i32,
+		"int64p":     &i64,
+		"doublep":    &f,
+		"boolp":      &b,
+		"timestampp": &ts,
+		"nilp":       (*string)(nil),
+		"nil":        nil,
+	}
+
+	data, err := tj.Encode(row)
+	assert.NoError(t, err)
+	decoded := map[string]dosa.FieldValue{}
+	assert.NoError(t, tj.Decode(data, &decoded))
+	assert.Equal(t, row, decoded)
+}
+
+func TestTypedJSONEncoder_Format(t *testing.T) {
+	s := "a"
+	data, err := tj.Encode(map[string]dosa.FieldValue{"int32v": int32(7), "strvp": &s})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"int32v": {"type": "Int32", "value": 7}, "strvp": {"type": "String", "pointer": true, "value": "a"}}`, string(data))
+}
+
+func TestTypedJSONEncoder_SpecialValues(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		data, err := tj.Encode(map[string]dosa.FieldValue{"d": f})
+		assert.NoError(t, err)
+		decoded := map[string]dosa.FieldValue{}
+		assert.NoError(t, tj.Decode(data, &decoded))
+		if math.IsNaN(f) {
+			assert.True(t, math.IsNaN(decoded["d"].(float64)))
+		} else {
+			assert.Equal(t, f, decoded["d"])
+		}
+	}
+
+	// ints are stored as Int64
+	data, err := tj.Encode(map[string]dosa.FieldValue{"i": 42})
+	assert.NoError(t, err)
+	decoded := map[string]dosa.FieldValue{}
+	assert.NoError(t, tj.Decode(data, &decoded))
+	assert.Equal(t, int64(42), decoded["i"])
+
+	// timestamps keep their offset
+	ts := time.Date(2020, 5, 29, 12, 30, 15, 1, time.FixedZone("PDT", -7*3600))
+	data, err = tj.Encode(map[string]dosa.FieldValue{"ts": ts})
+	assert.NoError(t, err)
+	assert.NoError(t, tj.Decode(data, &decoded))
+	assert.True(t, ts.Equal(decoded["ts"].(time.Time)))
+	_, offset := decoded["ts"].(time.Time).Zone()
+	assert.Equal(t, -7*3600, offset)
+}
+
+type typedPage struct {
+	Rows       []map[string]dosa.FieldValue
+	Conditions []*dosa.ColumnCondition
+	Token      string
+	Limit      int
+	Keys       [][]byte
+}
+
+func TestTypedJSONEncoder_Nested(t *testing.T) {
+	page := typedPage{
+		Rows: []map[string]dosa.FieldValue{
+			{"id": dosa.UUID("d1449c93-25b8-4032-920b-60471d91acc9"), "n": int64(1)},
+			{"id": dosa.UUID("a9a1b1c2-25b8-4032-920b-60471d91acc9"), "n": int64(2)},
+		},
+		Conditions: []*dosa.ColumnCondition{
+			{Name: "n", Condition: &dosa.Condition{Op: dosa.GtOrEq, Value: int64(1)}},
+		},
+		Token: "token",
+		Limit: 10,
+		Keys:  [][]byte{[]byte("a"), []byte("b")},
+	}
+	data, err := tj.Encode(page)
+	assert.NoError(t, err)
+	var decoded typedPage
+	assert.NoError(t, tj.Decode(data, &decoded))
+	assert.Equal(t, page, decoded)
+
+	// a pointer to a struct
+	data, err = tj.Encode(&page)
+	assert.NoError(t, err)
+	decodedp := &typedPage{}
+	assert.NoError(t, tj.Decode(data, decodedp))
+	assert.Equal(t, page, *decodedp)
+}
+
+func TestTypedJSONEncoder_Errors(t *testing.T) {
+	_, err := tj.Encode(map[string]dosa.FieldValue{"unsupported": struct{}{}})
+	assert.Error(t, err)
+	_, err = tj.Encode(map[int]string{1: "a"})
+	assert.Error(t, err)
+
+	decoded := map[string]dosa.FieldValue{}
+	assert.Error(t, tj.Decode([]byte(`{"a": {"type": "Int32", "value": 7}}`), decoded))
+	assert.Error(t, tj.Decode([]byte(`{"a": {"type": "Unknown", "value": 7}}`), &decoded))
+	assert.Error(t, tj.Decode([]byte(`{"a": {"type": "Int32", "value": 3000000000}}`), &decoded))
+	assert.Error(t, tj.Decode([]byte(`{"a": {"type": "Timestamp", "value": "yesterday"}}`), &decoded))
+	assert.Error(t, tj.Decode([]byte(`{"a": 7}`), &decoded))
+	assert.Error(t, tj.Decode([]byte(`not json`), &decoded))
+}
@@ -28,6 +28,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -104,6 +105,34 @@ func FindEntityByName(path string, structName string) (*Table, error) {
 	return entitiesFilteredByName[0], nil
 }
 
+// FindEntities returns the entities in the path, sorted by struct name.
+func FindEntities(path string) ([]*Table, error) {
+	entities, _, err := findEntities([]string{path}, []string{})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		return entities[i].StructName < entities[j].StructName
+	})
+	return entities, nil
+}
+
+// FindEntityNames returns the struct names of the entities in the path, sorted.
+func FindEntityNames(path string) ([]string, error) {
+	entities, err := FindEntities(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, table := range entities {
+		if len(names) == 0 || names[len(names)-1] != table.StructName {
+			names = append(names, table.StructName)
+		}
+	}
+	return names, nil
+}
+
 // dosaPackageName is the name of the dosa package, fully qualified and quoted
 const dosaPackageName = `"github.com/uber-go/dosa"`
 
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -141,6 +142,29 @@ func TestFindEntityByName(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestFindEntities(t *testing.T) {
+	entities, err := FindEntities("testentity")
+	assert.NoError(t, err)
+	assert.True(t, len(entities) >= 6)
+	assert.True(t, sort.SliceIsSorted(entities, func(i, j int) bool {
+		return entities[i].StructName < entities[j].StructName
+	}))
+
+	_, err = FindEntities("nonexistent")
+	assert.Error(t, err)
+}
+
+func TestFindEntityNames(t *testing.T) {
+	names, err := FindEntityNames("testentity")
+	assert.NoError(t, err)
+	assert.Len(t, names, 6)
+	assert.Contains(t, names, "TestEntity")
+	assert.True(t, sort.StringsAreSorted(names))
+
+	_, err = FindEntityNames("nonexistent")
+	assert.Error(t, err)
+}
+
 func TestStringToDosaType(t *testing.T) {
 	data := []struct {
 		inType    string
@@ -0,0 +1,334 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package mockhelpers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+)
+
+// Statuses of the schema operations of the fake admin client
+const (
+	StatusOK        = "OK"
+	StatusCompleted = "COMPLETED"
+)
+
+// AdminClient is an in-memory fake of dosa.AdminClient. It keeps scopes and versioned schemas
+// the way the gateway does:
+//   - schema operations fail with dosa.ErrNotFound until the scope is created
+//   - a schema upsert creates a new version, unless the schema did not change
+//   - a schema can only change compatibly: entities keep their primary keys and their columns,
+//     with the same types, but may get new columns, and new entities may be added
+//
+// The schema is made of the entities given to NewAdminClient or SetSchema, or, when there are
+// none, of the entities found in the directories of the client, like dosa.NewAdminClient.
+type AdminClient struct {
+	mu       sync.Mutex
+	scope    string
+	dirs     []string
+	excludes []string
+	defs     []*dosa.EntityDefinition
+	scopes   map[string]*dosa.ScopeMetadata
+	// schemas holds the versions of the schemas of each scope and name prefix, version n at n-1
+	schemas     map[string][][]*dosa.EntityDefinition
+	truncations map[string]int
+	shutdown    bool
+}
+
+// NewAdminClient creates a fake admin client whose schema is made of the entities, if any
+func NewAdminClient(entities ...dosa.DomainObject) (*AdminClient, error) {
+	c := &AdminClient{
+		scope:       "unset_value",
+		dirs:        []string{"."},
+		excludes:    []string{"_test.go"},
+		scopes:      map[string]*dosa.ScopeMetadata{},
+		schemas:     map[string][][]*dosa.EntityDefinition{},
+		truncations: map[string]int{},
+	}
+	for _, e := range entities {
+		table, err := dosa.TableFromInstance(e)
+		if err != nil {
+			return nil, err
+		}
+		c.defs = append(c.defs, &table.EntityDefinition)
+	}
+	return c, nil
+}
+
+// SetSchema replaces the schema of the client, e.g. to test a schema change
+func (c *AdminClient) SetSchema(defs ...*dosa.EntityDefinition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defs = defs
+}
+
+// Directories sets the directories searched for entities, when no schema is set
+func (c *AdminClient) Directories(dirs []string) dosa.AdminClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirs = dirs
+	return c
+}
+
+// Excludes sets the patterns of the files excluded when searching for entities
+func (c *AdminClient) Excludes(excludes []string) dosa.AdminClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.excludes = excludes
+	return c
+}
+
+// Scope sets the scope of schema operations
+func (c *AdminClient) Scope(scope string) dosa.AdminClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scope = scope
+	return c
+}
+
+// GetSchema returns the schema of the client
+func (c *AdminClient) GetSchema() ([]*dosa.EntityDefinition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getSchema()
+}
+
+func (c *AdminClient) getSchema() ([]*dosa.EntityDefinition, error) {
+	if err := dosa.IsValidName(c.scope); err != nil {
+		return nil, errors.Wrapf(err, "invalid scope name %q", c.scope)
+	}
+	if len(c.defs) == 0 {
+		return dosa.NewAdminClient(nil).Scope(c.scope).Directories(c.dirs).Excludes(c.excludes).GetSchema()
+	}
+	defs := make([]*dosa.EntityDefinition, len(c.defs))
+	for i, def := range c.defs {
+		defs[i] = def.Clone()
+	}
+	return defs, nil
+}
+
+// CanUpsertSchema checks that the schema can be upserted, and returns the version it would get
+func (c *AdminClient) CanUpsertSchema(ctx context.Context, namePrefix string) (*dosa.SchemaStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, version, err := c.nextSchema(namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &dosa.SchemaStatus{Version: version, Status: StatusOK}, nil
+}
+
+// UpsertSchema upserts the schema, creating a new version when it changed
+func (c *AdminClient) UpsertSchema(ctx context.Context, namePrefix string) (*dosa.SchemaStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defs, version, err := c.nextSchema(namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	key := schemaKey(c.scope, namePrefix)
+	if int(version) > len(c.schemas[key]) {
+		c.schemas[key] = append(c.schemas[key], defs)
+	}
+	return &dosa.SchemaStatus{Version: version, Status: StatusCompleted}, nil
+}
+
+// CheckSchemaStatus returns the status of a version of the schema
+func (c *AdminClient) CheckSchemaStatus(ctx context.Context, namePrefix string, version int32) (*dosa.SchemaStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkScope(c.scope); err != nil {
+		return nil, err
+	}
+	if version < 1 || int(version) > len(c.schemas[schemaKey(c.scope, namePrefix)]) {
+		return nil, errors.Wrapf(&dosa.ErrNotFound{}, "version %d of schema %s.%s", version, c.scope, namePrefix)
+	}
+	return &dosa.SchemaStatus{Version: version, Status: StatusCompleted}, nil
+}
+
+// CreateScope creates a scope
+func (c *AdminClient) CreateScope(ctx context.Context, md *dosa.ScopeMetadata) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := dosa.IsValidName(md.Name); err != nil {
+		return errors.Wrapf(err, "invalid scope name %q", md.Name)
+	}
+	if _, ok := c.scopes[md.Name]; ok {
+		return errors.Wrapf(&dosa.ErrAlreadyExists{}, "scope %s", md.Name)
+	}
+	copied := *md
+	c.scopes[md.Name] = &copied
+	return nil
+}
+
+// TruncateScope truncates a scope, which keeps its schemas
+func (c *AdminClient) TruncateScope(ctx context.Context, scope string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkScope(scope); err != nil {
+		return err
+	}
+	c.truncations[scope]++
+	return nil
+}
+
+// DropScope drops a scope and its schemas
+func (c *AdminClient) DropScope(ctx context.Context, scope string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkScope(scope); err != nil {
+		return err
+	}
+	delete(c.scopes, scope)
+	delete(c.truncations, scope)
+	for key := range c.schemas {
+		if strings.HasPrefix(key, scope+".") {
+			delete(c.schemas, key)
+		}
+	}
+	return nil
+}
+
+// Shutdown shuts the client down
+func (c *AdminClient) Shutdown() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shutdown = true
+	return nil
+}
+
+// ScopeMetadata returns the metadata of a scope, nil if it does not exist
+func (c *AdminClient) ScopeMetadata(scope string) *dosa.ScopeMetadata {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scopes[scope]
+}
+
+// Schema returns the latest version of the schema of a scope and name prefix, 0 if there is none
+func (c *AdminClient) Schema(scope, namePrefix string) (int32, []*dosa.EntityDefinition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := c.schemas[schemaKey(scope, namePrefix)]
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return int32(len(versions)), versions[len(versions)-1]
+}
+
+// Truncations returns the number of times a scope was truncated
+func (c *AdminClient) Truncations(scope string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.truncations[scope]
+}
+
+// IsShutdown returns whether the client was shut down
+func (c *AdminClient) IsShutdown() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shutdown
+}
+
+func schemaKey(scope, namePrefix string) string {
+	return scope + "." + namePrefix
+}
+
+func (c *AdminClient) checkScope(scope string) error {
+	if _, ok := c.scopes[scope]; !ok {
+		return errors.Wrapf(&dosa.ErrNotFound{}, "scope %s", scope)
+	}
+	return nil
+}
+
+// nextSchema returns the schema of the client and the version it gets when upserted
+func (c *AdminClient) nextSchema(namePrefix string) ([]*dosa.EntityDefinition, int32, error) {
+	defs, err := c.getSchema()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "GetSchema failed")
+	}
+	if err := dosa.IsValidNamePrefix(namePrefix); err != nil {
+		return nil, 0, err
+	}
+	if err := c.checkScope(c.scope); err != nil {
+		return nil, 0, err
+	}
+	versions := c.schemas[schemaKey(c.scope, namePrefix)]
+	if len(versions) == 0 {
+		return defs, 1, nil
+	}
+	latest := versions[len(versions)-1]
+	if err := checkCompatible(latest, defs); err != nil {
+		return nil, 0, errors.Wrapf(err, "schema incompatible: scope: %s, name prefix: %s", c.scope, namePrefix)
+	}
+	if sameSchema(latest, defs) {
+		return defs, int32(len(versions)), nil
+	}
+	return defs, int32(len(versions) + 1), nil
+}
+
+// checkCompatible checks that the entities of the new schema keep their keys and columns
+func checkCompatible(old, new []*dosa.EntityDefinition) error {
+	byName := map[string]*dosa.EntityDefinition{}
+	for _, def := range new {
+		byName[def.Name] = def
+	}
+	for _, before := range old {
+		after, ok := byName[before.Name]
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(before.Key, after.Key) {
+			return fmt.Errorf("primary key of %s changed from %s to %s", before.Name, before.Key, after.Key)
+		}
+		for _, col := range before.Columns {
+			newCol := after.FindColumnDefinition(col.Name)
+			if newCol == nil {
+				return fmt.Errorf("column %s of %s was removed", col.Name, before.Name)
+			}
+			if newCol.Type != col.Type {
+				return fmt.Errorf("column %s of %s changed from %s to %s", col.Name, before.Name, col.Type, newCol.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func sameSchema(a, b []*dosa.EntityDefinition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byName := map[string]*dosa.EntityDefinition{}
+	for _, def := range a {
+		byName[def.Name] = def
+	}
+	for _, def := range b {
+		if !reflect.DeepEqual(byName[def.Name], def) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package mockhelpers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/testentity"
+)
+
+var _ dosa.AdminClient = &AdminClient{}
+
+func TestAdminClientSchemas(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewAdminClient(&testentity.TestEntity{})
+	require.NoError(t, err)
+	c.Scope("team")
+
+	// the scope must be created first
+	_, err = c.UpsertSchema(ctx, "service")
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	require.NoError(t, c.CreateScope(ctx, &dosa.ScopeMetadata{Name: "team", Owner: "owners"}))
+	assert.Equal(t, "owners", c.ScopeMetadata("team").Owner)
+	assert.True(t, dosa.ErrorIsAlreadyExists(c.CreateScope(ctx, &dosa.ScopeMetadata{Name: "team"})))
+
+	status, err := c.CanUpsertSchema(ctx, "service")
+	require.NoError(t, err)
+	assert.Equal(t, &dosa.SchemaStatus{Version: 1, Status: StatusOK}, status)
+	status, err = c.UpsertSchema(ctx, "service")
+	require.NoError(t, err)
+	assert.Equal(t, &dosa.SchemaStatus{Version: 1, Status: StatusCompleted}, status)
+	// the same schema keeps its version
+	status, err = c.UpsertSchema(ctx, "service")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), status.Version)
+
+	// a new column makes a new version
+	defs, err := c.GetSchema()
+	require.NoError(t, err)
+	require.Len(t, defs, 1)
+	assert.Equal(t, "awesome_test_entity", defs[0].Name)
+	defs[0].Columns = append(defs[0].Columns, &dosa.ColumnDefinition{Name: "extra", Type: dosa.String})
+	c.SetSchema(defs...)
+	status, err = c.CanUpsertSchema(ctx, "service")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), status.Version)
+	status, err = c.UpsertSchema(ctx, "service")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), status.Version)
+	version, latest := c.Schema("team", "service")
+	assert.Equal(t, int32(2), version)
+	assert.Equal(t, defs, latest)
+
+	status, err = c.CheckSchemaStatus(ctx, "service", 1)
+	require.NoError(t, err)
+	assert.Equal(t, &dosa.SchemaStatus{Version: 1, Status: StatusCompleted}, status)
+	_, err = c.CheckSchemaStatus(ctx, "service", 3)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+	_, err = c.CheckSchemaStatus(ctx, "other", 1)
+	assert.True(t, dosa.ErrorIsNotFound(err))
+
+	// incompatible changes
+	removed := defs[0].Clone()
+	removed.Columns = removed.Columns[:len(removed.Columns)-1]
+	c.SetSchema(removed)
+	_, err = c.UpsertSchema(ctx, "service")
+	assert.EqualError(t, err, "schema incompatible: scope: team, name prefix: service: column extra of awesome_test_entity was removed")
+	retyped := defs[0].Clone()
+	retyped.Columns[len(retyped.Columns)-1].Type = dosa.Int64
+	c.SetSchema(retyped)
+	_, err = c.CanUpsertSchema(ctx, "service")
+	assert.EqualError(t, err, "schema incompatible: scope: team, name prefix: service: column extra of awesome_test_entity changed from String to Int64")
+	rekeyed := defs[0].Clone()
+	rekeyed.Key.PartitionKeys = []string{"strkey"}
+	c.SetSchema(rekeyed)
+	_, err = c.UpsertSchema(ctx, "service")
+	assert.Contains(t, err.Error(), "primary key of awesome_test_entity changed")
+
+	// other name prefixes have their own versions
+	c.SetSchema(rekeyed)
+	status, err = c.UpsertSchema(ctx, "other")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), status.Version)
+	_, err = c.UpsertSchema(ctx, "invalid prefix")
+	assert.Error(t, err)
+}
+
+func TestAdminClientScopes(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewAdminClient(&testentity.TestEntity{})
+	require.NoError(t, err)
+
+	assert.Error(t, c.CreateScope(ctx, &dosa.ScopeMetadata{Name: "not a name"}))
+	assert.True(t, dosa.ErrorIsNotFound(c.TruncateScope(ctx, "team")))
+	assert.True(t, dosa.ErrorIsNotFound(c.DropScope(ctx, "team")))
+
+	require.NoError(t, c.CreateScope(ctx, &dosa.ScopeMetadata{Name: "team"}))
+	_, err = c.Scope("team").UpsertSchema(ctx, "service")
+	require.NoError(t, err)
+	require.NoError(t, c.TruncateScope(ctx, "team"))
+	assert.Equal(t, 1, c.Truncations("team"))
+	version, _ := c.Schema("team", "service")
+	assert.Equal(t, int32(1), version)
+
+	require.NoError(t, c.DropScope(ctx, "team"))
+	assert.Nil(t, c.ScopeMetadata("team"))
+	version, _ = c.Schema("team", "service")
+	assert.Equal(t, int32(0), version)
+	assert.Equal(t, 0, c.Truncations("team"))
+
+	assert.False(t, c.IsShutdown())
+	assert.NoError(t, c.Shutdown())
+	assert.True(t, c.IsShutdown())
+}
+
+func TestAdminClientDirectories(t *testing.T) {
+	c, err := NewAdminClient()
+	require.NoError(t, err)
+	// without entities, the schema is found in the directories
+	defs, err := c.Scope("team").Directories([]string{"../../testentity"}).Excludes([]string{"_test.go", "keyvalue.go"}).GetSchema()
+	require.NoError(t, err)
+	var names []string
+	for _, def := range defs {
+		names = append(names, def.Name)
+	}
+	assert.Contains(t, names, "awesome_test_entity")
+
+	_, err = c.Scope("not a scope").GetSchema()
+	assert.Contains(t, err.Error(), `invalid scope name "not a scope"`)
+	_, err = NewAdminClient(&struct{ dosa.Entity }{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package mockhelpers helps writing tests with the mocks: gomock matchers for domain objects and
+// for range, scan and remove range operations, and an in-memory fake of dosa.AdminClient.
+//
+// For example, to expect the upsert of a MenuItem named "X" and a range query on a menu:
+//
+//	client.EXPECT().Upsert(gomock.Any(), gomock.Any(), mockhelpers.DomainObject(&MenuItem{Name: "X"}, "Name"))
+//	client.EXPECT().Range(gomock.Any(), mockhelpers.RangeOp().Eq("MenuUUID", u).Limit(10))
+package mockhelpers
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/uber-go/dosa"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// equal compares values like reflect.DeepEqual, except that timestamps are equal when they are
+// the same instant
+func equal(a, b interface{}) bool {
+	return equalValues(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func equalValues(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch {
+	case a.Type() == timeType:
+		return a.Interface().(time.Time).Equal(b.Interface().(time.Time))
+	case a.Kind() == reflect.Ptr && a.Type().Elem() == timeType:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalValues(a.Elem(), b.Elem())
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+type domainObjectMatcher struct {
+	expected reflect.Value
+	fields   []string
+}
+
+// DomainObject matches a domain object of the same type as expected, whose fields have the same
+// values as those of expected. Only the given fields are compared, or every exported field when
+// none is given. Timestamps are compared as instants.
+func DomainObject(expected dosa.DomainObject, fields ...string) gomock.Matcher {
+	v := reflect.Indirect(reflect.ValueOf(expected))
+	if len(fields) == 0 {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath == "" && !field.Anonymous {
+				fields = append(fields, field.Name)
+			}
+		}
+	}
+	for _, name := range fields {
+		if !v.FieldByName(name).IsValid() {
+			panic(fmt.Sprintf("%s has no field %s", v.Type(), name))
+		}
+	}
+	return &domainObjectMatcher{expected: v, fields: fields}
+}
+
+// Matches returns whether x is a matching domain object
+func (m *domainObjectMatcher) Matches(x interface{}) bool {
+	if x == nil {
+		return false
+	}
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Type() != m.expected.Type() {
+		return false
+	}
+	v = v.Elem()
+	for _, name := range m.fields {
+		if !equalValues(m.expected.FieldByName(name), v.FieldByName(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the expected domain object
+func (m *domainObjectMatcher) String() string {
+	values := make([]string, len(m.fields))
+	for i, name := range m.fields {
+		values[i] = fmt.Sprintf("%s: %#v", name, m.expected.FieldByName(name).Interface())
+	}
+	return fmt.Sprintf("is a *%s with {%s}", m.expected.Type(), strings.Join(values, ", "))
+}
+
+// conditions are the expected conditions of an operation
+type conditions struct {
+	conditions map[string][]*dosa.Condition
+}
+
+func (c *conditions) add(op dosa.Operator, field string, value interface{}) {
+	if c.conditions == nil {
+		c.conditions = map[string][]*dosa.Condition{}
+	}
+	c.conditions[field] = append(c.conditions[field], &dosa.Condition{Op: op, Value: value})
+}
+
+// match checks if the actual conditions are the expected ones, in any order
+func (c *conditions) match(actual map[string][]*dosa.Condition) bool {
+	if c.conditions == nil {
+		return true
+	}
+	if len(actual) != len(c.conditions) {
+		return false
+	}
+	for field, expected := range c.conditions {
+		conds := actual[field]
+		if len(conds) != len(expected) {
+			return false
+		}
+		used := make([]bool, len(conds))
+	expectedLoop:
+		for _, e := range expected {
+			for i, cond := range conds {
+				if !used[i] && cond.Op == e.Op && equal(cond.Value, e.Value) {
+					used[i] = true
+					continue expectedLoop
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (c *conditions) describe(buf *bytes.Buffer) {
+	if c.conditions != nil {
+		fmt.Fprintf(buf, " with conditions %s", dosa.ConditionsString(c.conditions))
+	}
+}
+
+// paging holds the expected limit, token and fields of an operation
+type paging struct {
+	limit     *int
+	token     *string
+	fields    []string
+	hasFields bool
+}
+
+func (p *paging) match(limit int, token string, fields []string) bool {
+	if p.limit != nil && *p.limit != limit {
+		return false
+	}
+	if p.token != nil && *p.token != token {
+		return false
+	}
+	if p.hasFields {
+		expected := append([]string(nil), p.fields...)
+		actual := append([]string(nil), fields...)
+		sort.Strings(expected)
+		sort.Strings(actual)
+		return len(expected) == len(actual) && (len(expected) == 0 || reflect.DeepEqual(expected, actual))
+	}
+	return true
+}
+
+func (p *paging) describe(buf *bytes.Buffer) {
+	if p.limit != nil {
+		fmt.Fprintf(buf, " with limit %d", *p.limit)
+	}
+	if p.token != nil {
+		fmt.Fprintf(buf, " with token %q", *p.token)
+	}
+	if p.hasFields {
+		fmt.Fprintf(buf, " with fields %v", p.fields)
+	}
+}
+
+// RangeOpMatcher matches a *dosa.RangeOp. Only what is set on the matcher is checked: when any
+// condition is set, the conditions of the operation must be exactly those, in any order.
+type RangeOpMatcher struct {
+	conditions
+	paging
+}
+
+// RangeOp returns a matcher of range operations
+func RangeOp() *RangeOpMatcher {
+	return &RangeOpMatcher{}
+}
+
+// Eq expects an equality condition
+func (m *RangeOpMatcher) Eq(field string, value interface{}) *RangeOpMatcher {
+	m.add(dosa.Eq, field, value)
+	return m
+}
+
+// Gt expects a "greater than" condition
+func (m *RangeOpMatcher) Gt(field string, value interface{}) *RangeOpMatcher {
+	m.add(dosa.Gt, field, value)
+	return m
+}
+
+// GtOrEq expects a "greater than or equal" condition
+func (m *RangeOpMatcher) GtOrEq(field string, value interface{}) *RangeOpMatcher {
+	m.add(dosa.GtOrEq, field, value)
+	return m
+}
+
+// Lt expects a "less than" condition
+func (m *RangeOpMatcher) Lt(field string, value interface{}) *RangeOpMatcher {
+	m.add(dosa.Lt, field, value)
+	return m
+}
+
+// LtOrEq expects a "less than or equal" condition
+func (m *RangeOpMatcher) LtOrEq(field string, value interface{}) *RangeOpMatcher {
+	m.add(dosa.LtOrEq, field, value)
+	return m
+}
+
+// Limit expects a limit
+func (m *RangeOpMatcher) Limit(n int) *RangeOpMatcher {
+	m.limit = &n
+	return m
+}
+
+// Offset expects a pagination token
+func (m *RangeOpMatcher) Offset(token string) *RangeOpMatcher {
+	m.token = &token
+	return m
+}
+
+// Fields expects the fields to read, in any order
+func (m *RangeOpMatcher) Fields(fields []string) *RangeOpMatcher {
+	m.fields, m.hasFields = fields, true
+	return m
+}
+
+// Matches returns whether x is a matching *dosa.RangeOp
+func (m *RangeOpMatcher) Matches(x interface{}) bool {
+	op, ok := x.(*dosa.RangeOp)
+	if !ok || op == nil {
+		return false
+	}
+	return m.conditions.match(op.Conditions()) && m.paging.match(op.LimitRows(), op.Token(), op.FieldsToRead())
+}
+
+// String describes the expected range operation
+func (m *RangeOpMatcher) String() string {
+	buf := bytes.NewBufferString("is a RangeOp")
+	m.conditions.describe(buf)
+	m.paging.describe(buf)
+	return buf.String()
+}
+
+// ScanOpMatcher matches a *dosa.ScanOp. Only what is set on the matcher is checked.
+type ScanOpMatcher struct {
+	paging
+}
+
+// ScanOp returns a matcher of scan operations
+func ScanOp() *ScanOpMatcher {
+	return &ScanOpMatcher{}
+}
+
+// Limit expects a limit
+func (m *ScanOpMatcher) Limit(n int) *ScanOpMatcher {
+	m.limit = &n
+	return m
+}
+
+// Offset expects a pagination token
+func (m *ScanOpMatcher) Offset(token string) *ScanOpMatcher {
+	m.token = &token
+	return m
+}
+
+// Fields expects the fields to read, in any order
+func (m *ScanOpMatcher) Fields(fields []string) *ScanOpMatcher {
+	m.fields, m.hasFields = fields, true
+	return m
+}
+
+// Matches returns whether x is a matching *dosa.ScanOp
+func (m *ScanOpMatcher) Matches(x interface{}) bool {
+	op, ok := x.(*dosa.ScanOp)
+	if !ok || op == nil {
+		return false
+	}
+	return m.paging.match(op.LimitRows(), op.Token(), op.FieldsToRead())
+}
+
+// String describes the expected scan operation
+func (m *ScanOpMatcher) String() string {
+	buf := bytes.NewBufferString("is a ScanOp")
+	m.paging.describe(buf)
+	return buf.String()
+}
+
+// RemoveRangeOpMatcher matches a *dosa.RemoveRangeOp. When any condition is set, the conditions
+// of the operation must be exactly those, in any order.
+type RemoveRangeOpMatcher struct {
+	conditions
+}
+
+// RemoveRangeOp returns a matcher of remove range operations
+func RemoveRangeOp() *RemoveRangeOpMatcher {
+	return &RemoveRangeOpMatcher{}
+}
+
+// Eq expects an equality condition
+func (m *RemoveRangeOpMatcher) Eq(field string, value interface{}) *RemoveRangeOpMatcher {
+	m.add(dosa.Eq, field, value)
+	return m
+}
+
+// Gt expects a "greater than" condition
+func (m *RemoveRangeOpMatcher) Gt(field string, value interface{}) *RemoveRangeOpMatcher {
+	m.add(dosa.Gt, field, value)
+	return m
+}
+
+// GtOrEq expects a "greater than or equal" condition
+func (m *RemoveRangeOpMatcher) GtOrEq(field string, value interface{}) *RemoveRangeOpMatcher {
+	m.add(dosa.GtOrEq, field, value)
+	return m
+}
+
+// Lt expects a "less than" condition
+func (m *RemoveRangeOpMatcher) Lt(field string, value interface{}) *RemoveRangeOpMatcher {
+	m.add(dosa.Lt, field, value)
+	return m
+}
+
+// LtOrEq expects a "less than or equal" condition
+func (m *RemoveRangeOpMatcher) LtOrEq(field string, value interface{}) *RemoveRangeOpMatcher {
+	m.add(dosa.LtOrEq, field, value)
+	return m
+}
+
+// Matches returns whether x is a matching *dosa.RemoveRangeOp
+func (m *RemoveRangeOpMatcher) Matches(x interface{}) bool {
+	op, ok := x.(*dosa.RemoveRangeOp)
+	if !ok || op == nil {
+		return false
+	}
+	return m.conditions.match(op.Conditions())
+}
+
+// String describes the expected remove range operation
+func (m *RemoveRangeOpMatcher) String() string {
+	buf := bytes.NewBufferString("is a RemoveRangeOp")
+	m.conditions.describe(buf)
+	return buf.String()
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package mockhelpers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/mocks"
+	"github.com/uber-go/dosa/testentity"
+)
+
+func TestDomainObject(t *testing.T) {
+	ts := time.Unix(100, 0)
+	str := "pointer"
+	e := &testentity.TestEntity{StrKey: "key", Int64Key: 1, StrV: "hello", TSV: ts, StrVP: &str}
+
+	m := DomainObject(&testentity.TestEntity{StrV: "hello"}, "StrV")
+	assert.True(t, m.Matches(e))
+	assert.False(t, m.Matches(&testentity.TestEntity{StrV: "bye"}))
+	assert.False(t, m.Matches(&testentity.KeyValue{}))
+	assert.False(t, m.Matches(nil))
+	assert.False(t, m.Matches((*testentity.TestEntity)(nil)))
+	assert.False(t, m.Matches(*e))
+	assert.Equal(t, `is a *testentity.TestEntity with {StrV: "hello"}`, m.String())
+
+	// timestamps are compared as instants, pointers by the values they point to
+	other := "pointer"
+	m = DomainObject(&testentity.TestEntity{TSV: ts.UTC(), StrVP: &other}, "TSV", "StrVP")
+	assert.True(t, m.Matches(e))
+	m = DomainObject(&testentity.TestEntity{TSV: ts.Add(time.Second)}, "TSV")
+	assert.False(t, m.Matches(e))
+
+	// every field
+	copied := *e
+	assert.True(t, DomainObject(&copied).Matches(e))
+	copied.Int32V = 3
+	assert.False(t, DomainObject(&copied).Matches(e))
+
+	assert.Panics(t, func() { DomainObject(&testentity.TestEntity{}, "Missing") })
+}
+
+func TestRangeOpMatcher(t *testing.T) {
+	u := dosa.UUID("3e4befa0-69d9-11e8-9ba9-0ed5f89f718b")
+	op := dosa.NewRangeOp(&testentity.TestEntity{}).
+		Eq("UUIDKey", u).Gt("Int64Key", int64(1)).Lt("Int64Key", int64(9)).
+		Limit(10).Offset("token").Fields([]string{"StrV", "Int32V"})
+
+	assert.True(t, RangeOp().Matches(op))
+	assert.True(t, RangeOp().Eq("UUIDKey", u).Lt("Int64Key", int64(9)).Gt("Int64Key", int64(1)).Matches(op))
+	assert.True(t, RangeOp().Limit(10).Offset("token").Fields([]string{"Int32V", "StrV"}).Matches(op))
+	// the conditions must be exactly those
+	assert.False(t, RangeOp().Eq("UUIDKey", u).Matches(op))
+	assert.False(t, RangeOp().Eq("UUIDKey", u).Gt("Int64Key", int64(1)).Lt("Int64Key", int64(8)).Matches(op))
+	assert.False(t, RangeOp().Eq("UUIDKey", u).GtOrEq("Int64Key", int64(1)).Lt("Int64Key", int64(9)).Matches(op))
+	assert.False(t, RangeOp().Eq("UUIDKey", u).Gt("Int64Key", int64(1)).LtOrEq("Int64Key", int64(9)).Matches(op))
+	assert.False(t, RangeOp().Limit(5).Matches(op))
+	assert.False(t, RangeOp().Offset("").Matches(op))
+	assert.False(t, RangeOp().Fields(nil).Matches(op))
+	assert.False(t, RangeOp().Matches(dosa.NewScanOp(&testentity.TestEntity{})))
+	assert.True(t, RangeOp().Fields(nil).Matches(dosa.NewRangeOp(&testentity.TestEntity{})))
+
+	assert.Equal(t, `is a RangeOp with conditions (Int64Key < 9) with limit 10 with token "t" with fields [a]`,
+		RangeOp().Lt("Int64Key", 9).Limit(10).Offset("t").Fields([]string{"a"}).String())
+}
+
+func TestScanOpMatcher(t *testing.T) {
+	op := dosa.NewScanOp(&testentity.TestEntity{}).Limit(10).Offset("token").Fields([]string{"StrV"})
+	assert.True(t, ScanOp().Matches(op))
+	assert.True(t, ScanOp().Limit(10).Offset("token").Fields([]string{"StrV"}).Matches(op))
+	assert.False(t, ScanOp().Limit(1).Matches(op))
+	assert.False(t, ScanOp().Offset("other").Matches(op))
+	assert.False(t, ScanOp().Fields([]string{"StrV", "Int32V"}).Matches(op))
+	assert.False(t, ScanOp().Matches(dosa.NewRangeOp(&testentity.TestEntity{})))
+	assert.Equal(t, "is a ScanOp with limit 10", ScanOp().Limit(10).String())
+}
+
+func TestRemoveRangeOpMatcher(t *testing.T) {
+	op := dosa.NewRemoveRangeOp(&testentity.TestEntity{}).Eq("StrKey", "a").GtOrEq("Int64Key", int64(2)).LtOrEq("Int64Key", int64(3))
+	assert.True(t, RemoveRangeOp().Matches(op))
+	assert.True(t, RemoveRangeOp().Eq("StrKey", "a").LtOrEq("Int64Key", int64(3)).GtOrEq("Int64Key", int64(2)).Matches(op))
+	assert.False(t, RemoveRangeOp().Eq("StrKey", "b").LtOrEq("Int64Key", int64(3)).GtOrEq("Int64Key", int64(2)).Matches(op))
+	assert.False(t, RemoveRangeOp().Eq("StrKey", "a").Lt("Int64Key", int64(3)).Gt("Int64Key", int64(2)).Matches(op))
+	assert.False(t, RemoveRangeOp().Matches(dosa.NewRangeOp(&testentity.TestEntity{})))
+	assert.Equal(t, "is a RemoveRangeOp with conditions (StrKey == a)", RemoveRangeOp().Eq("StrKey", "a").String())
+}
+
+// The matchers are meant to set expectations on the mocks
+func TestWithMockClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+	u := dosa.UUID("3e4befa0-69d9-11e8-9ba9-0ed5f89f718b")
+	client := mocks.NewMockClient(ctrl)
+	client.EXPECT().Upsert(ctx, gomock.Any(), DomainObject(&testentity.TestEntity{StrV: "X"}, "StrV")).Return(nil)
+	client.EXPECT().Range(ctx, RangeOp().Eq("UUIDKey", u).Limit(10)).Return(nil, "", nil)
+	client.EXPECT().ScanEverything(ctx, ScanOp().Limit(5)).Return(nil, "", nil)
+	client.EXPECT().RemoveRange(ctx, RemoveRangeOp().Eq("UUIDKey", u)).Return(nil)
+
+	assert.NoError(t, client.Upsert(ctx, nil, &testentity.TestEntity{StrKey: "k", StrV: "X"}))
+	_, _, err := client.Range(ctx, dosa.NewRangeOp(&testentity.TestEntity{}).Eq("UUIDKey", u).Limit(10))
+	assert.NoError(t, err)
+	_, _, err = client.ScanEverything(ctx, dosa.NewScanOp(&testentity.TestEntity{}).Limit(5))
+	assert.NoError(t, err)
+	assert.NoError(t, client.RemoveRange(ctx, dosa.NewRemoveRangeOp(&testentity.TestEntity{}).Eq("UUIDKey", u)))
+}
@@ -31,6 +31,21 @@ type pager struct {
 	fieldsToRead []string
 }
 
+// LimitRows returns number of rows to return per call
+func (p pager) LimitRows() int {
+	return p.limit
+}
+
+// Token returns the pagination token, empty for the first page
+func (p pager) Token() string {
+	return p.token
+}
+
+// FieldsToRead returns the fields to read, nil for all of them
+func (p pager) FieldsToRead() []string {
+	return p.fieldsToRead
+}
+
 func addLimitTokenString(w io.Writer, limit int, token string) {
 	if limit == AdaptiveRangeLimit || limit > 0 {
 		_, _ = fmt.Fprintf(w, " limit %d", limit)
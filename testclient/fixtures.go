@@ -0,0 +1,468 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package testclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/uber-go/dosa"
+	"gopkg.in/yaml.v2"
+)
+
+// Format is the format of fixtures
+type Format int
+
+// Formats of fixtures
+const (
+	YAML Format = iota
+	JSON
+)
+
+// FormatOf returns the format of a fixture file from its extension: JSON for .json, YAML otherwise
+func FormatOf(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return JSON
+	}
+	return YAML
+}
+
+// scanLimit is the number of rows read at once when dumping
+const scanLimit = 1000
+
+// LoadFixtures upserts the rows of fixture files, in YAML or JSON. A fixture maps the struct
+// names of entities to their rows, and each row maps field names, or column names, to values:
+//
+//	TestEntity:
+//	  - UUIDKey: 3e4befa0-69d9-11e8-9ba9-0ed5f89f718b
+//	    StrKey: a key
+//	    Int64Key: 1
+//	    TSV: 2018-06-11T16:26:01Z
+//	    StrVP: null
+//
+// Values are converted to the type of their column: UUIDs and timestamps, in RFC 3339 format,
+// are strings and blobs are base64 strings. Key columns are required, the other columns are
+// set to their zero value, or null, when missing, as when upserting an entity with the client.
+func (c *Client) LoadFixtures(paths ...string) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "cannot open fixture")
+		}
+		err = c.LoadFixture(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "invalid fixture %s", path)
+		}
+	}
+	return nil
+}
+
+// LoadFixture upserts the rows of a fixture in YAML or JSON, see LoadFixtures
+func (c *Client) LoadFixture(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// JSON is YAML too
+	var fixture map[string][]map[string]interface{}
+	if err := yaml.Unmarshal(data, &fixture); err != nil {
+		return err
+	}
+	// load entities in a stable order, so that errors are too
+	names := make([]string, 0, len(fixture))
+	for name := range fixture {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		re := c.findEntity(name)
+		if re == nil {
+			return fmt.Errorf("unknown entity %q", name)
+		}
+		for i, row := range fixture[name] {
+			values, err := rowValues(re, row)
+			if err != nil {
+				return errors.Wrapf(err, "invalid row #%d of %s", i+1, name)
+			}
+			if err := c.connector.Upsert(context.Background(), re.EntityInfo(), values); err != nil {
+				return errors.Wrapf(err, "cannot upsert row #%d of %s", i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Dump writes every row of every entity to w in a fixture, which LoadFixture loads back.
+// Entities and fields are sorted by name, and rows are sorted by key, so dumps can be compared
+// with golden files.
+func (c *Client) Dump(w io.Writer, format Format) error {
+	fixture := map[string][]map[string]interface{}{}
+	for _, re := range c.registrar.FindAll() {
+		rows, err := c.dumpEntity(re)
+		if err != nil {
+			return errors.Wrapf(err, "cannot dump %s", re.Table().StructName)
+		}
+		if len(rows) > 0 {
+			fixture[re.Table().StructName] = rows
+		}
+	}
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case JSON:
+		data, err = json.MarshalIndent(fixture, "", "  ")
+		data = append(data, '\n')
+	default:
+		data, err = yaml.Marshal(fixture)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// DumpFile dumps the rows to a file, in the format of its extension, see Dump
+func (c *Client) DumpFile(path string) error {
+	var buf bytes.Buffer
+	if err := c.Dump(&buf, FormatOf(path)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// findEntity finds an entity by struct name, or by entity name
+func (c *Client) findEntity(name string) *dosa.RegisteredEntity {
+	for _, re := range c.registrar.FindAll() {
+		if re.Table().StructName == name {
+			return re
+		}
+	}
+	for _, re := range c.registrar.FindAll() {
+		if re.Table().Name == name {
+			return re
+		}
+	}
+	return nil
+}
+
+func (c *Client) dumpEntity(re *dosa.RegisteredEntity) ([]map[string]interface{}, error) {
+	table := re.Table()
+	var dumped []map[string]interface{}
+	token := ""
+	for {
+		rows, next, err := c.connector.Scan(context.Background(), re.EntityInfo(), nil, token, scanLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			fields := map[string]interface{}{}
+			for _, col := range table.Columns {
+				fields[table.ColToField[col.Name]] = fixtureValue(row[col.Name])
+			}
+			dumped = append(dumped, fields)
+		}
+		if next == "" {
+			return dumped, nil
+		}
+		token = next
+	}
+}
+
+// rowValues converts the values of a fixture row to the values of the columns of the entity
+func rowValues(re *dosa.RegisteredEntity, row map[string]interface{}) (map[string]dosa.FieldValue, error) {
+	table := re.Table()
+	values := map[string]dosa.FieldValue{}
+	// visit the fields in a deterministic order, so that the same fixture always fails the same way
+	names := make([]string, 0, len(row))
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := row[name]
+		column, ok := table.FieldToCol[name]
+		if !ok {
+			if _, ok = table.ColToField[name]; !ok {
+				return nil, fmt.Errorf("unknown field %q", name)
+			}
+			column = name
+		}
+		if _, ok := values[column]; ok {
+			return nil, fmt.Errorf("field %s is set twice", table.ColToField[column])
+		}
+		col := table.FindColumnDefinition(column)
+		v, err := fieldValue(col, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for %s", table.ColToField[column])
+		}
+		values[column] = v
+	}
+	keys := table.KeySet()
+	for _, col := range table.Columns {
+		if _, ok := values[col.Name]; ok {
+			continue
+		}
+		if _, ok := keys[col.Name]; ok {
+			return nil, fmt.Errorf("missing key field %s", table.ColToField[col.Name])
+		}
+		values[col.Name] = zeroValue(col)
+	}
+	return values, nil
+}
+
+// fieldValue converts a value decoded from a fixture to the type of a column
+func fieldValue(col *dosa.ColumnDefinition, value interface{}) (dosa.FieldValue, error) {
+	if value == nil {
+		if !col.IsPointer && col.Type != dosa.Blob {
+			return nil, errors.New("cannot be null")
+		}
+		return zeroValue(col), nil
+	}
+	var (
+		v   interface{}
+		err error
+	)
+	switch col.Type {
+	case dosa.String:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T %v (quote the value)", value, value)
+		}
+		v = s
+	case dosa.TUUID:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a UUID, got %T %v", value, value)
+		}
+		// the zero value of a UUID is empty
+		if _, err := uuid.FromString(s); err != nil && s != "" {
+			return nil, err
+		}
+		v = dosa.UUID(s)
+	case dosa.Int32:
+		var i int64
+		i, err = toInt(value)
+		if err == nil && (i < math.MinInt32 || i > math.MaxInt32) {
+			err = fmt.Errorf("%d overflows an int32", i)
+		}
+		v = int32(i)
+	case dosa.Int64:
+		v, err = toInt(value)
+	case dosa.Double:
+		v, err = toFloat(value)
+	case dosa.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean, got %T %v", value, value)
+		}
+		v = b
+	case dosa.Blob:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a base64 string, got %T %v", value, value)
+		}
+		v, err = base64.StdEncoding.DecodeString(s)
+	case dosa.Timestamp:
+		switch t := value.(type) {
+		case time.Time:
+			v = t
+		case string:
+			v, err = time.Parse(time.RFC3339Nano, t)
+		default:
+			err = fmt.Errorf("expected a timestamp, got %T %v", value, value)
+		}
+	default:
+		err = fmt.Errorf("unsupported type %v", col.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if col.IsPointer {
+		return pointerTo(v), nil
+	}
+	return v, nil
+}
+
+func toInt(value interface{}) (int64, error) {
+	switch n := value.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows an int64", n)
+		}
+		return int64(n), nil
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+			return 0, fmt.Errorf("expected an integer, got %v", n)
+		}
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("expected an integer, got %T %v", value, value)
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %T %v", value, value)
+}
+
+// pointerTo returns a pointer to a copy of v, as held by nullable fields
+func pointerTo(v interface{}) dosa.FieldValue {
+	switch v := v.(type) {
+	case string:
+		return &v
+	case dosa.UUID:
+		return &v
+	case int32:
+		return &v
+	case int64:
+		return &v
+	case float64:
+		return &v
+	case bool:
+		return &v
+	case time.Time:
+		return &v
+	}
+	// blobs are nullable without a pointer
+	return v
+}
+
+// zeroValue returns the value of a column missing from a row
+func zeroValue(col *dosa.ColumnDefinition) dosa.FieldValue {
+	switch col.Type {
+	case dosa.String:
+		if col.IsPointer {
+			return (*string)(nil)
+		}
+		return ""
+	case dosa.TUUID:
+		if col.IsPointer {
+			return (*dosa.UUID)(nil)
+		}
+		return dosa.UUID("")
+	case dosa.Int32:
+		if col.IsPointer {
+			return (*int32)(nil)
+		}
+		return int32(0)
+	case dosa.Int64:
+		if col.IsPointer {
+			return (*int64)(nil)
+		}
+		return int64(0)
+	case dosa.Double:
+		if col.IsPointer {
+			return (*float64)(nil)
+		}
+		return float64(0)
+	case dosa.Bool:
+		if col.IsPointer {
+			return (*bool)(nil)
+		}
+		return false
+	case dosa.Timestamp:
+		if col.IsPointer {
+			return (*time.Time)(nil)
+		}
+		return time.Time{}
+	}
+	return []byte(nil)
+}
+
+// fixtureValue converts a column value to its representation in a fixture
+func fixtureValue(value dosa.FieldValue) interface{} {
+	switch v := value.(type) {
+	case dosa.UUID:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		if v == nil {
+			return nil
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	case *string:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *dosa.UUID:
+		if v == nil {
+			return nil
+		}
+		return string(*v)
+	case *int32:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *int64:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *float64:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *bool:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return value
+}
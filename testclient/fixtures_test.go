@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package testclient
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/dosa"
+	"github.com/uber-go/dosa/testentity"
+)
+
+type user struct {
+	dosa.Entity `dosa:"primaryKey=(ID)"`
+	ID          int64
+	Name        string
+	Avatar      []byte
+}
+
+const fixtureYAML = `
+TestEntity:
+  - UUIDKey: 3e4befa0-69d9-11e8-9ba9-0ed5f89f718b
+    StrKey: key
+    Int64Key: 1
+    StrV: hello
+    an_int64_value: 12
+    Int32V: 7
+    DoubleV: 2
+    BoolV: true
+    BlobV: AQI=
+    TSV: 2018-06-11T16:26:01.5Z
+    StrVP: pointer
+    Int64VP: null
+user:
+  - ID: 2
+    Name: "123"
+  - ID: 1
+    Name: alice
+`
+
+const dumpYAML = `TestEntity:
+- BlobV: AQI=
+  BoolV: true
+  BoolVP: null
+  DoubleV: 2
+  DoubleVP: null
+  Int32V: 7
+  Int32VP: null
+  Int64Key: 1
+  Int64V: 12
+  Int64VP: null
+  StrKey: key
+  StrV: hello
+  StrVP: pointer
+  TSV: "2018-06-11T16:26:01.5Z"
+  TSVP: null
+  UUIDKey: 3e4befa0-69d9-11e8-9ba9-0ed5f89f718b
+  UUIDV: ""
+  UUIDVP: null
+user:
+- Avatar: null
+  ID: 1
+  Name: alice
+- Avatar: null
+  ID: 2
+  Name: "123"
+`
+
+func newClient(t *testing.T) *Client {
+	client, err := New("testscope", "testprefix", &testentity.TestEntity{}, &user{})
+	require.NoError(t, err)
+	require.NoError(t, client.Initialize(context.Background()))
+	return client
+}
+
+func TestLoadFixture(t *testing.T) {
+	client := newClient(t)
+	require.NoError(t, client.LoadFixture(strings.NewReader(fixtureYAML)))
+
+	e := &testentity.TestEntity{UUIDKey: "3e4befa0-69d9-11e8-9ba9-0ed5f89f718b", StrKey: "key", Int64Key: 1}
+	require.NoError(t, client.Read(context.Background(), nil, e))
+	assert.Equal(t, "hello", e.StrV)
+	assert.Equal(t, int64(12), e.Int64V)
+	assert.Equal(t, int32(7), e.Int32V)
+	assert.Equal(t, 2.0, e.DoubleV)
+	assert.True(t, e.BoolV)
+	assert.Equal(t, []byte{1, 2}, e.BlobV)
+	assert.True(t, time.Unix(1528734361, 500000000).Equal(e.TSV))
+	require.NotNil(t, e.StrVP)
+	assert.Equal(t, "pointer", *e.StrVP)
+	assert.Nil(t, e.Int64VP)
+
+	u := &user{ID: 2}
+	require.NoError(t, client.Read(context.Background(), nil, u))
+	assert.Equal(t, "123", u.Name)
+}
+
+func TestDump(t *testing.T) {
+	client := newClient(t)
+	require.NoError(t, client.LoadFixture(strings.NewReader(fixtureYAML)))
+	var dump bytes.Buffer
+	require.NoError(t, client.Dump(&dump, YAML))
+	assert.Equal(t, dumpYAML, dump.String())
+
+	// writes through the client are dumped too
+	require.NoError(t, client.Upsert(context.Background(), nil, &user{ID: 3, Name: "bob", Avatar: []byte("x")}))
+	dump.Reset()
+	require.NoError(t, client.Dump(&dump, JSON))
+	assert.Contains(t, dump.String(), `"Avatar": "eA=="`)
+
+	// dumps load back
+	loaded := newClient(t)
+	require.NoError(t, loaded.LoadFixture(bytes.NewReader(dump.Bytes())))
+	var again bytes.Buffer
+	require.NoError(t, loaded.Dump(&again, JSON))
+	assert.Equal(t, dump.String(), again.String())
+
+	empty := newClient(t)
+	dump.Reset()
+	require.NoError(t, empty.Dump(&dump, JSON))
+	assert.Equal(t, "{}\n", dump.String())
+}
+
+func TestFixtureFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	yamlPath := filepath.Join(dir, "users.yaml")
+	jsonPath := filepath.Join(dir, "entities.json")
+	require.NoError(t, ioutil.WriteFile(yamlPath, []byte("user:\n  - {ID: 1, Name: alice}\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(jsonPath, []byte(`{"user": [{"ID": 2, "Name": "bob"}]}`), 0644))
+
+	client, err := NewTestClientWithFixtures("testscope", "testprefix", []string{yamlPath, jsonPath}, &user{})
+	require.NoError(t, err)
+	require.NoError(t, client.Initialize(context.Background()))
+	u := &user{ID: 2}
+	require.NoError(t, client.Read(context.Background(), nil, u))
+	assert.Equal(t, "bob", u.Name)
+
+	dumpPath := filepath.Join(dir, "dump.json")
+	require.NoError(t, client.DumpFile(dumpPath))
+	data, err := ioutil.ReadFile(dumpPath)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "user": [
+    {
+      "Avatar": null,
+      "ID": 1,
+      "Name": "alice"
+    },
+    {
+      "Avatar": null,
+      "ID": 2,
+      "Name": "bob"
+    }
+  ]
+}
+`, string(data))
+
+	_, err = NewTestClientWithFixtures("testscope", "testprefix", []string{filepath.Join(dir, "missing.yaml")}, &user{})
+	assert.Contains(t, err.Error(), "cannot open fixture")
+	_, err = NewTestClientWithFixtures("testscope", "testprefix", []string{yamlPath}, &testentity.TestEntity{})
+	assert.EqualError(t, err, "invalid fixture "+yamlPath+`: unknown entity "user"`)
+	assert.Equal(t, JSON, FormatOf("a/b.JSON"))
+	assert.Equal(t, YAML, FormatOf("a/b.yml"))
+}
+
+func TestInvalidFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		err     string
+	}{
+		{"user: [{ID: 1, Email: x}]", `invalid row #1 of user: unknown field "Email"`},
+		{"user: [{ID: 1, id: 1}]", "invalid row #1 of user: field ID is set twice"},
+		{"user: [{Name: x}]", "invalid row #1 of user: missing key field ID"},
+		{"user: [{ID: 1}, {ID: 1.5}]", "invalid row #2 of user: invalid value for ID: expected an integer, got 1.5"},
+		{"user: [{ID: 1, Name: 12}]", "invalid row #1 of user: invalid value for Name: expected a string, got int 12 (quote the value)"},
+		{"user: [{ID: 1, Name: null}]", "invalid row #1 of user: invalid value for Name: cannot be null"},
+		{"user: [{ID: 1, Avatar: '!'}]", "invalid row #1 of user: invalid value for Avatar: illegal base64 data at input byte 0"},
+		{"TestEntity: [{UUIDKey: nope, StrKey: a, Int64Key: 1}]", "invalid row #1 of TestEntity: invalid value for UUIDKey: uuid: incorrect UUID length: nope"},
+		{"TestEntity: [{UUIDKey: 3e4befa0-69d9-11e8-9ba9-0ed5f89f718b, StrKey: a, Int64Key: 1, Int32V: 3000000000}]", "invalid row #1 of TestEntity: invalid value for Int32V: 3000000000 overflows an int32"},
+		{"TestEntity: [{UUIDKey: 3e4befa0-69d9-11e8-9ba9-0ed5f89f718b, StrKey: a, Int64Key: 1, TSV: yesterday}]", `invalid row #1 of TestEntity: invalid value for TSV: parsing time "yesterday" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "yesterday" as "2006"`},
+		{"TestEntity: [{UUIDKey: 3e4befa0-69d9-11e8-9ba9-0ed5f89f718b, StrKey: a, Int64Key: 1, BoolV: 1}]", "invalid row #1 of TestEntity: invalid value for BoolV: expected a boolean, got int 1"},
+		{"user: {ID: 1}", "yaml: unmarshal errors:\n  line 1: cannot unmarshal !!map into []map[string]interface {}"},
+	}
+	for _, test := range tests {
+		client := newClient(t)
+		err := client.LoadFixture(strings.NewReader(test.fixture))
+		assert.EqualError(t, err, test.err, test.fixture)
+	}
+}
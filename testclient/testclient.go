@@ -25,12 +25,46 @@ import (
 	"github.com/uber-go/dosa/connectors/memory"
 )
 
-// NewTestClient creates a DOSA client useful for testing.
+// Client is a DOSA client storing its entities in memory, which can be seeded from fixtures
+// and dumped back to fixtures, see LoadFixtures and Dump.
+type Client struct {
+	dosa.Client
+	registrar dosa.Registrar
+	connector *memory.Connector
+}
+
+// NewTestClient creates a DOSA client useful for testing. The client is a *Client.
 func NewTestClient(scope, prefix string, entities ...dosa.DomainObject) (dosa.Client, error) {
+	client, err := New(scope, prefix, entities...)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// New creates a test client for the entities
+func New(scope, prefix string, entities ...dosa.DomainObject) (*Client, error) {
 	reg, err := dosa.NewRegistrar(scope, prefix, entities...)
 	if err != nil {
 		return nil, err
 	}
 	connector := memory.NewConnector()
-	return dosa.NewClient(reg, connector), nil
+	return &Client{
+		Client:    dosa.NewClient(reg, connector),
+		registrar: reg,
+		connector: connector,
+	}, nil
+}
+
+// NewTestClientWithFixtures creates a test client seeded with the rows of fixture files,
+// see LoadFixtures.
+func NewTestClientWithFixtures(scope, prefix string, fixtures []string, entities ...dosa.DomainObject) (*Client, error) {
+	client, err := New(scope, prefix, entities...)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.LoadFixtures(fixtures...); err != nil {
+		return nil, err
+	}
+	return client, nil
 }